@@ -18,12 +18,17 @@ func (m *MockClient) Chat(ctx context.Context, model, system, user string) (stri
 	return m.respond(system, user)
 }
 
-func (m *MockClient) ChatWithRetry(ctx context.Context, model, system, user string, retries int, backoff time.Duration) (string, error) {
-	return m.respond(system, user)
-}
-
 func (m *MockClient) respond(system, user string) (string, error) {
 	s := strings.ToLower(system + "\n" + user)
+	if strings.Contains(s, "维护专有名词表") {
+		return `[{"term":"紫霄宫","category":"sect","definition":"城北古观遗址"},{"term":"紫宵宫","category":"sect"}]`, nil
+	}
+	if strings.Contains(s, "整理故事时间线") {
+		return `[{"when":"三天后","delta":3,"event":"陈巽赶往省城","characters":["陈巽"],"travel":{"from":"城南旧宅","to":"省城"}}]`, nil
+	}
+	if strings.Contains(s, "请按以下结构仅输出json") {
+		return `{"protagonist":{"personality":"冷静","background":"法医","goal":"查清旧案"},"signature_elements":{"devices":"罗盘","constraints":"每日一卦","progression":"由术入道"},"world":{"relations":"风水世家暗斗","start_location":"城南旧宅","initial_crisis":"旧宅命案"}}`, nil
+	}
 	if strings.Contains(s, "人物") && strings.Contains(s, "[{name,role,traits,background}]") {
 		chars := []novel.Character{
 			{Name: "陈巽", Role: "主角", Traits: novel.StringList{"冷静", "理智"}, Background: "法医转风水师"},
@@ -52,6 +57,16 @@ func (m *MockClient) respond(system, user string) (string, error) {
 		b, _ := json.Marshal(outline)
 		return string(b), nil
 	}
+	if strings.Contains(s, "管理伏笔") {
+		return `{"opened":[{"kind":"mystery","summary":"旧宅地下的铜镜来历不明"}],"resolved":[{"id":"T1","resolution":"铜镜来历揭晓"}]}`, nil
+	}
+	if strings.Contains(s, "连续性编辑") {
+		states := []novel.CharacterState{
+			{Name: "陈巽", Location: "城南旧宅", Status: "左臂轻伤", Relationships: map[string]string{"苏晚晴": "合作"}},
+		}
+		b, _ := json.Marshal(states)
+		return string(b), nil
+	}
 	// chapter content
 	return "这是章节正文示例，包含若干段落与细节。", nil
 }
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/ibreez3/ai-reader/novel"
@@ -19,27 +20,43 @@ func main() {
     out := flag.String("out", "output", "输出目录")
     baseURL := flag.String("base-url", "https://api.openai.com/v1", "API Base URL")
     chapters := flag.Int("chapters", 10, "章节数量")
-    words := flag.Int("words", 1500, "每章字数")
+    volumes := flag.Int("volumes", 0, "分卷数量（0 表示超过60章时自动分卷）")
+    scenes := flag.Bool("scenes", false, "按场景规划并逐场景生成章节")
+    words := flag.Int("words", 1500, "每章字数（英文按词计）")
+    lang := flag.String("lang", "zh", "写作语言：zh、en 或 ja")
     preset := flag.String("preset", "xiyou_shuangwen", "预设风格")
+    presetsDir := flag.String("presets", "presets", "预设文件目录")
     outlineFile := flag.String("outline-file", "", "使用指定的大纲JSON文件")
     instructionFile := flag.String("instruction-file", "", "章节附加指令文件")
+    epub := flag.Bool("epub", false, "同时导出EPUB电子书")
+    temperature := flag.Float64("temperature", openai.DefaultTemperature, "采样温度")
     flag.Parse()
     if *topic == "" {
         log.Fatal("必须提供 --topic")
     }
+    language, err := novel.NormalizeLanguage(*lang)
+    if err != nil {
+        log.Fatal(err)
+    }
+    if err := novel.LoadPresets(*presetsDir); err != nil {
+        log.Fatal(err)
+    }
+    if _, ok := novel.LookupPreset(*preset); !ok {
+        log.Fatalf("未知预设 %s", *preset)
+    }
 
 	apiKey := os.Getenv("OPENAI_API_KEY")
 	if apiKey == "" {
 		log.Fatal("缺少环境变量 OPENAI_API_KEY")
 	}
 
-	cli := openai.NewClient(apiKey, *baseURL)
+	cli := openai.NewClient(apiKey, *baseURL).WithTemperature(*temperature)
     gen := novel.NewGenerator(cli)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
 
-    spec := novel.Spec{Topic: *topic, Language: "zh", Model: *model, Chapters: *chapters, Words: *words, Preset: *preset}
+    spec := novel.Spec{Topic: *topic, Language: language, Model: *model, Chapters: *chapters, Volumes: *volumes, Scenes: *scenes, Words: *words, Preset: *preset, Temperature: temperature}
     if *instructionFile != "" {
         b, e := os.ReadFile(*instructionFile)
         if e == nil { spec.Instruction = string(b) }
@@ -48,7 +65,6 @@ func main() {
     var outline novel.Outline
     var characters []novel.Character
     var contents []novel.ChapterContent
-    var err error
     if *outlineFile != "" {
         data, e := os.ReadFile(*outlineFile)
         if e != nil { log.Fatal(e) }
@@ -68,6 +84,25 @@ func main() {
         log.Fatal(err)
     }
     fmt.Println("已生成：", dir)
+    if *epub {
+        book := novel.Book{ID: outline.Title, Outline: outline, Characters: characters, Chapters: contents, Language: spec.Language, Created: time.Now(), Modified: time.Now()}
+        if len(outline.Chapters) > 0 {
+            book.Summary = outline.Chapters[0].Summary
+        }
+        p := filepath.Join(dir, filepath.Base(dir)+".epub")
+        f, err := os.Create(p)
+        if err != nil {
+            log.Fatal(err)
+        }
+        if err := novel.WriteEPUB(f, book); err != nil {
+            f.Close()
+            log.Fatal(err)
+        }
+        if err := f.Close(); err != nil {
+            log.Fatal(err)
+        }
+        fmt.Println("EPUB：", p)
+    }
     fmt.Println("章节数：", len(contents))
     fmt.Println("标题：", outline.Title)
     fmt.Println("人物数：", len(characters))
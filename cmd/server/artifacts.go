@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+	"github.com/ibreez3/ai-reader/service"
+)
+
+func registerArtifactRoutes(r *gin.Engine, cfg config.Config, mgr *service.Manager) {
+	for _, name := range service.ArtifactNames() {
+		name := name
+		path := "/api/jobs/:id/" + name
+		r.GET(path, func(c *gin.Context) {
+			b, etag, err := mgr.ReadArtifact(cfg, c.Param("id"), name)
+			if err != nil {
+				writeJobError(c, err)
+				return
+			}
+			c.Header("ETag", etag)
+			if c.GetHeader("If-None-Match") == etag {
+				c.Status(http.StatusNotModified)
+				return
+			}
+			format := c.DefaultQuery("format", "json")
+			if format == "json" {
+				c.Data(http.StatusOK, "application/json; charset=utf-8", b)
+				return
+			}
+			lang, err := mgr.JobLanguage(cfg, c.Param("id"))
+			if err != nil {
+				writeJobError(c, err)
+				return
+			}
+			md, err := service.RenderArtifact(lang, name, b)
+			if err != nil {
+				writeJobError(c, err)
+				return
+			}
+			switch format {
+			case "markdown", "md":
+				c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(md))
+			case "text", "txt":
+				c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(novel.PlainText(md)))
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json, markdown or text"})
+			}
+		})
+		edit := func(apply func(cfg config.Config, id, name string, body []byte, ifMatch string) ([]byte, string, error)) gin.HandlerFunc {
+			return func(c *gin.Context) {
+				body, err := io.ReadAll(c.Request.Body)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				b, etag, err := apply(cfg, c.Param("id"), name, body, c.GetHeader("If-Match"))
+				if err != nil {
+					writeJobError(c, err)
+					return
+				}
+				c.Header("ETag", etag)
+				c.Data(http.StatusOK, "application/json; charset=utf-8", b)
+			}
+		}
+		r.PUT(path, edit(mgr.PutArtifact))
+		r.PATCH(path, edit(mgr.PatchArtifact))
+	}
+
+	r.GET("/api/jobs/:id/manifest", func(c *gin.Context) {
+		man, err := mgr.Manifest(cfg, c.Param("id"))
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, man)
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+	"github.com/ibreez3/ai-reader/service"
+)
+
+func registerChapterRoutes(r *gin.Engine, cfg config.Config, mgr *service.Manager) {
+	r.GET("/api/jobs/:id/chapters", func(c *gin.Context) {
+		list, err := mgr.ListChapters(cfg, c.Param("id"))
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "chapters": list})
+	})
+
+	r.GET("/api/jobs/:id/chapters/:n", func(c *gin.Context) {
+		n, ok := chapterParam(c)
+		if !ok {
+			return
+		}
+		doc, err := mgr.ReadChapter(cfg, c.Param("id"), n)
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.Header("ETag", doc.ETag)
+		switch c.DefaultQuery("format", "markdown") {
+		case "markdown", "md":
+			c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte("# "+doc.Title+"\n\n"+doc.Content))
+		case "text", "txt":
+			c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(doc.Title+"\n\n"+novel.PlainText(doc.Content)))
+		case "json":
+			c.JSON(http.StatusOK, doc)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be markdown, text or json"})
+		}
+	})
+	r.POST("/api/jobs/:id/chapters/:n/scenes", func(c *gin.Context) {
+		n, ok := chapterParam(c)
+		if !ok {
+			return
+		}
+		plan, err := mgr.PlanScenes(cfg, c.Param("id"), n)
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "plan": plan})
+	})
+
+	r.PUT("/api/jobs/:id/chapters/:n", func(c *gin.Context) {
+		n, ok := chapterParam(c)
+		if !ok {
+			return
+		}
+		var req struct {
+			Title   string `json:"title"`
+			Content string `json:"content"`
+		}
+		if strings.HasPrefix(c.ContentType(), "application/json") {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		} else {
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			req.Title, req.Content = novel.ParseChapterFile(body)
+		}
+		doc, err := mgr.PutChapter(cfg, c.Param("id"), n, req.Title, req.Content, c.GetHeader("If-Match"))
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.Header("ETag", doc.ETag)
+		c.JSON(http.StatusOK, doc)
+	})
+
+	r.GET("/api/jobs/:id/chapters/:n/versions", func(c *gin.Context) {
+		n, ok := chapterParam(c)
+		if !ok {
+			return
+		}
+		h, err := mgr.ChapterHistory(cfg, c.Param("id"), n)
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, h)
+	})
+
+	r.GET("/api/jobs/:id/chapters/:n/versions/:v", func(c *gin.Context) {
+		n, ok := chapterParam(c)
+		if !ok {
+			return
+		}
+		v, err := strconv.Atoi(c.Param("v"))
+		if err != nil || v <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+			return
+		}
+		doc, err := mgr.ChapterVersion(cfg, c.Param("id"), n, v)
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		switch c.DefaultQuery("format", "json") {
+		case "markdown", "md":
+			c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte("# "+doc.Title+"\n\n"+doc.Content))
+		case "text", "txt":
+			c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(doc.Title+"\n\n"+novel.PlainText(doc.Content)))
+		case "json":
+			c.JSON(http.StatusOK, doc)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be markdown, text or json"})
+		}
+	})
+
+	r.GET("/api/jobs/:id/chapters/:n/diff", func(c *gin.Context) {
+		n, ok := chapterParam(c)
+		if !ok {
+			return
+		}
+		from, err1 := queryInt(c, "from")
+		to, err2 := queryInt(c, "to")
+		if err1 != nil || err2 != nil || from < 0 || to < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be version numbers"})
+			return
+		}
+		d, err := mgr.DiffChapter(cfg, c.Param("id"), n, from, to)
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, d)
+	})
+
+	r.POST("/api/jobs/:id/chapters/:n/rollback", func(c *gin.Context) {
+		n, ok := chapterParam(c)
+		if !ok {
+			return
+		}
+		var req struct {
+			Version int `json:"version"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Version <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "version is required"})
+			return
+		}
+		v, err := mgr.RollbackChapter(cfg, c.Param("id"), n, req.Version)
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"chapter": n, "current": v.Version, "version": v})
+	})
+}
+
+func chapterParam(c *gin.Context) (int, bool) {
+	n, err := strconv.Atoi(c.Param("n"))
+	if err != nil || n <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chapter index"})
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/service"
+)
+
+func registerCoherenceRoutes(r *gin.Engine, cfg config.Config, mgr *service.Manager) {
+	r.GET("/api/jobs/:id/coherence", func(c *gin.Context) {
+		issues, err := mgr.Coherence(cfg, c.Param("id"))
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "issues": issues})
+	})
+}
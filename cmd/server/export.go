@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+	"github.com/ibreez3/ai-reader/service"
+)
+
+func registerExportRoutes(r *gin.Engine, cfg config.Config, mgr *service.Manager) {
+	r.GET("/api/jobs/:id/export", func(c *gin.Context) {
+		opts := service.ExportOptions{
+			Format:   c.DefaultQuery("format", "epub"),
+			PageSize: c.Query("page_size"),
+			TXT: novel.TXTOptions{
+				Numbering: c.Query("numbering"),
+				Indent:    c.Query("indent"),
+				Punct:     c.Query("punct"),
+				Encoding:  c.Query("encoding"),
+			},
+		}
+		var err error
+		if opts.TXT.From, err = queryInt(c, "from"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+			return
+		}
+		if opts.TXT.To, err = queryInt(c, "to"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+			return
+		}
+		f, err := mgr.Export(cfg, c.Param("id"), opts)
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.Header("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(f.Name))
+		c.Data(http.StatusOK, f.ContentType, f.Data)
+	})
+}
+
+func queryInt(c *gin.Context, key string) (int, error) {
+	v := c.Query(key)
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(v)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/service"
+)
+
+func registerJobRoutes(r *gin.Engine, cfg config.Config, mgr *service.Manager) {
+	r.GET("/api/jobs", func(c *gin.Context) {
+		f := service.ListFilter{Status: service.JobStatus(c.Query("status")), Title: c.Query("title")}
+		var err error
+		if f.Since, err = parseDate(c.Query("since"), false); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since: " + err.Error()})
+			return
+		}
+		if f.Until, err = parseDate(c.Query("until"), true); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid until: " + err.Error()})
+			return
+		}
+		f.Page, _ = strconv.Atoi(c.Query("page"))
+		f.PageSize, _ = strconv.Atoi(c.Query("page_size"))
+		c.JSON(http.StatusOK, mgr.List(cfg, f))
+	})
+
+	r.DELETE("/api/jobs/:id", func(c *gin.Context) {
+		if err := mgr.Delete(cfg, c.Param("id")); err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "deleted": true})
+	})
+
+	r.POST("/api/jobs/:id/fork", func(c *gin.Context) {
+		at, err := strconv.Atoi(c.Query("at"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at must be a chapter index"})
+			return
+		}
+		j, err := mgr.Fork(cfg, c.Param("id"), at)
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"id": j.ID, "parent": c.Param("id"), "at": at, "job": j.Snapshot()})
+	})
+
+	r.POST("/api/jobs/:id/extend", func(c *gin.Context) {
+		var req struct {
+			Count     int    `json:"count"`
+			Direction string `json:"direction"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		outline, ext, err := mgr.Extend(cfg, c.Param("id"), req.Count, req.Direction)
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		from, to := ext.Chapters[0].Index, ext.Chapters[len(ext.Chapters)-1].Index
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "from": from, "to": to, "threads": ext.Threads, "chapters": ext.Chapters, "plans": ext.Plans, "total": outline.TotalChapters()})
+	})
+
+	r.POST("/api/jobs/:id/archive", func(c *gin.Context) {
+		p, err := mgr.Archive(cfg, c.Param("id"))
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "archive": p})
+	})
+}
+
+// parseDate accepts RFC3339 or YYYY-MM-DD; a date-only upper bound covers the whole day
+func parseDate(s string, upper bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func writeJobError(c *gin.Context, err error) {
+	if errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	var ve *service.ValidationError
+	var te *service.TransitionError
+	switch {
+	case errors.Is(err, service.ErrInvalidJobID), errors.As(err, &ve):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrUnknownArtifact):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrPreconditionFailed):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrPreconditionRequired):
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrJobBusy), errors.Is(err, service.ErrJobNotFinished), errors.Is(err, service.ErrAwaitingApproval), errors.As(err, &te):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -25,6 +26,12 @@ type GenerateReq struct {
 	Gender      string   `json:"gender"`
 	Categories  []string `json:"categories"`
 	Tags        []string `json:"tags"`
+	Language    string   `json:"language"`
+	Gates       []string `json:"gates"`
+	Project     string   `json:"project"`
+	Volumes     int      `json:"volumes"`
+	Scenes      bool     `json:"scenes"`
+	Temperature *float64 `json:"temperature"`
 }
 
 type ChapterReq struct {
@@ -39,6 +46,10 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if _, err := service.ReloadPresets(cfg); err != nil {
+		log.Fatal(err)
+	}
+	go service.WatchPresets(context.Background(), cfg, func(s string) { log.Print(s) })
 	mgr := service.NewManager()
 
 	r := gin.Default()
@@ -49,9 +60,14 @@ func main() {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		spec := novel.Spec{Topic: req.Topic, Chapters: req.Chapters, Words: req.Words, Preset: req.Preset, Instruction: req.Instruction, Language: "zh", Model: req.Model, System: req.System, Gender: req.Gender, Categories: req.Categories, Tags: req.Tags}
+		lang, err := novel.NormalizeLanguage(req.Language)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		spec := novel.Spec{Topic: req.Topic, Chapters: req.Chapters, Words: req.Words, Preset: req.Preset, Instruction: req.Instruction, Language: lang, Model: req.Model, System: req.System, Gender: req.Gender, Categories: req.Categories, Tags: req.Tags, Gates: req.Gates, Project: req.Project, Volumes: req.Volumes, Scenes: req.Scenes, Temperature: req.Temperature}
 		if spec.System == "" && (len(spec.Categories) > 0 || len(spec.Tags) > 0 || spec.Gender != "") {
-			spec.System = novel.BuildSystemFromCategories(spec.Gender, spec.Categories, spec.Tags)
+			spec.System = novel.BuildSystemFromCategories(spec.Language, spec.Gender, spec.Categories, spec.Tags)
 		}
 		var job *service.Job
 		if req.SourceText != "" || req.SourcePath != "" {
@@ -66,14 +82,14 @@ func main() {
 			}
 			j, e := mgr.StartFromSource(cfg, spec, src)
 			if e != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+				writeJobError(c, e)
 				return
 			}
 			job = j
 		} else {
 			j, e := mgr.Start(cfg, spec)
 			if e != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+				writeJobError(c, e)
 				return
 			}
 			job = j
@@ -89,6 +105,10 @@ func main() {
 		c.JSON(http.StatusOK, service.GetCategories())
 	})
 
+	r.GET("/api/languages", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"languages": novel.Languages()})
+	})
+
 	r.POST("/api/chapter", func(c *gin.Context) {
 		var req ChapterReq
 		if err := c.BindJSON(&req); err != nil {
@@ -110,7 +130,7 @@ func main() {
 		}
 		t, err := mgr.StartChapterTask(cfg, j, req.Chapter, req.Words, req.Instruction)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeJobError(c, err)
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"task_id": t.ID})
@@ -127,7 +147,12 @@ func main() {
 			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"status": t.Status, "path": t.Path, "error": t.Error})
+		snap := t.Snapshot()
+		resp := gin.H{"status": snap.Status, "path": snap.Path, "error": snap.Error}
+		if snap.Status == service.ChapterDone {
+			resp["url"] = service.ChapterURL(snap.JobID, snap.Chapter)
+		}
+		c.JSON(http.StatusOK, resp)
 	})
 
 	r.GET("/api/progress", func(c *gin.Context) {
@@ -141,27 +166,95 @@ func main() {
 			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"status": j.Status, "completed": j.Completed, "total": j.Total, "dir": j.Dir, "error": j.Error, "log": j.LogPath})
+		snap := j.Snapshot()
+		c.JSON(http.StatusOK, gin.H{"status": snap.Status, "completed": snap.Completed, "total": snap.Total, "dir": snap.Dir, "error": snap.Error, "log": snap.LogPath, "chapters_url": "/api/jobs/" + id + "/chapters", "log_url": "/api/log?id=" + id})
+	})
+
+	r.GET("/api/job", func(c *gin.Context) {
+		id := c.Query("id")
+		if id == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing id"})
+			return
+		}
+		j := mgr.Get(id)
+		if j == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusOK, j.Snapshot())
+	})
+
+	r.POST("/api/cancel", func(c *gin.Context) {
+		id := c.Query("id")
+		if id == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing id"})
+			return
+		}
+		if err := mgr.Cancel(id, c.Query("reason")); err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, mgr.Get(id).Snapshot())
+	})
+
+	r.POST("/api/approve", func(c *gin.Context) {
+		id, stage := c.Query("id"), c.Query("stage")
+		if id == "" || stage == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing id or stage"})
+			return
+		}
+		if err := mgr.Approve(cfg, id, stage); err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, mgr.Get(id).Snapshot())
+	})
+
+	r.POST("/api/pause", func(c *gin.Context) {
+		id := c.Query("id")
+		if id == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing id"})
+			return
+		}
+		if err := mgr.Pause(id); err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, mgr.Get(id).Snapshot())
+	})
+
+	r.POST("/api/resume", func(c *gin.Context) {
+		id := c.Query("id")
+		if id == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing id"})
+			return
+		}
+		if err := mgr.Resume(cfg, id); err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, mgr.Get(id).Snapshot())
 	})
 
 	r.GET("/api/result", func(c *gin.Context) {
 		id := c.Query("id")
 		j := mgr.Get(id)
-		if j == nil || j.Status != service.JobDone {
+		if j == nil || j.Status() != service.JobDone {
 			c.JSON(http.StatusNotFound, gin.H{"error": "not ready"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"dir": j.Dir, "log": j.LogPath})
+		snap := j.Snapshot()
+		c.JSON(http.StatusOK, gin.H{"dir": snap.Dir, "log": snap.LogPath, "chapters_url": "/api/jobs/" + id + "/chapters", "outline_url": "/api/jobs/" + id + "/outline", "characters_url": "/api/jobs/" + id + "/characters", "plans_url": "/api/jobs/" + id + "/plans"})
 	})
 
 	r.GET("/api/log", func(c *gin.Context) {
 		id := c.Query("id")
 		j := mgr.Get(id)
-		if j == nil || j.LogPath == "" {
+		if j == nil || j.Snapshot().LogPath == "" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 			return
 		}
-		b, err := os.ReadFile(j.LogPath)
+		b, err := os.ReadFile(j.Snapshot().LogPath)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -169,6 +262,19 @@ func main() {
 		c.Data(http.StatusOK, "text/plain; charset=utf-8", b)
 	})
 
+	registerJobRoutes(r, cfg, mgr)
+	registerArtifactRoutes(r, cfg, mgr)
+	registerChapterRoutes(r, cfg, mgr)
+	registerExportRoutes(r, cfg, mgr)
+	registerOPDSRoutes(r, cfg, mgr)
+	registerProjectRoutes(r, cfg, mgr)
+	registerVolumeRoutes(r, cfg, mgr)
+	registerThreadRoutes(r, cfg, mgr)
+	registerCoherenceRoutes(r, cfg, mgr)
+	registerPromptRoutes(r, cfg, mgr)
+	registerPresetRoutes(r, cfg)
+	registerWebRoutes(r)
+
 	addr := fmt.Sprintf(":%d", cfg.Server.Port)
 	if err := r.Run(addr); err != nil {
 		log.Fatal(err)
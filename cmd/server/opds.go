@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/service"
+)
+
+func registerOPDSRoutes(r *gin.Engine, cfg config.Config, mgr *service.Manager) {
+	r.GET("/opds", func(c *gin.Context) {
+		page, _ := strconv.Atoi(c.Query("page"))
+		b, err := service.RenderOPDS(mgr.Catalog(cfg, page))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, service.OPDSAcquisitionType, b)
+	})
+
+	r.GET("/opds/v2", func(c *gin.Context) {
+		page, _ := strconv.Atoi(c.Query("page"))
+		b, err := service.RenderOPDS2(mgr.Catalog(cfg, page))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, service.OPDS2Type, b)
+	})
+
+	r.GET("/api/jobs/:id/cover.svg", func(c *gin.Context) {
+		b, err := mgr.Cover(cfg, c.Param("id"))
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.Data(http.StatusOK, "image/svg+xml", b)
+	})
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+	"github.com/ibreez3/ai-reader/service"
+)
+
+func registerPresetRoutes(r *gin.Engine, cfg config.Config) {
+	r.GET("/api/presets", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"presets": novel.Presets()})
+	})
+
+	r.POST("/api/presets/reload", func(c *gin.Context) {
+		presets, err := service.ReloadPresets(cfg)
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"presets": presets})
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/service"
+)
+
+func registerProjectRoutes(r *gin.Engine, cfg config.Config, mgr *service.Manager) {
+	r.GET("/api/projects", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"projects": mgr.ListProjects(cfg)})
+	})
+
+	r.POST("/api/projects", func(c *gin.Context) {
+		var req service.Project
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		p, etag, err := mgr.CreateProject(cfg, req)
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.Header("ETag", etag)
+		c.JSON(http.StatusCreated, p)
+	})
+
+	r.GET("/api/projects/:id", func(c *gin.Context) {
+		p, etag, err := mgr.GetProject(cfg, c.Param("id"))
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.Header("ETag", etag)
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.JSON(http.StatusOK, p)
+	})
+
+	r.PUT("/api/projects/:id", func(c *gin.Context) {
+		var req service.Project
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		p, etag, err := mgr.PutProject(cfg, c.Param("id"), req, c.GetHeader("If-Match"))
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.Header("ETag", etag)
+		c.JSON(http.StatusOK, p)
+	})
+
+	r.POST("/api/projects/:id/books/:job/conclude", func(c *gin.Context) {
+		p, err := mgr.ConcludeBook(cfg, c.Param("id"), c.Param("job"))
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, p)
+	})
+}
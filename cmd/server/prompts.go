@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/service"
+)
+
+func registerPromptRoutes(r *gin.Engine, cfg config.Config, mgr *service.Manager) {
+	r.GET("/api/jobs/:id/prompts", func(c *gin.Context) {
+		prompts, err := mgr.Prompts(cfg, c.Param("id"))
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "prompts": prompts})
+	})
+
+	// the body is the template text itself
+	r.PUT("/api/jobs/:id/prompts/:name", func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		t, err := mgr.SavePrompt(cfg, c.Param("id"), c.Param("name"), string(body))
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, t)
+	})
+
+	r.DELETE("/api/jobs/:id/prompts/:name", func(c *gin.Context) {
+		t, err := mgr.DeletePrompt(cfg, c.Param("id"), c.Param("name"))
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, t)
+	})
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/service"
+)
+
+func registerThreadRoutes(r *gin.Engine, cfg config.Config, mgr *service.Manager) {
+	r.GET("/api/jobs/:id/threads", func(c *gin.Context) {
+		rep, err := mgr.Threads(cfg, c.Param("id"), c.Query("all") == "true")
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "written": rep.Written, "total": rep.Total, "open": rep.Open, "stale": rep.Stale, "threads": rep.Threads})
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/service"
+)
+
+func registerVolumeRoutes(r *gin.Engine, cfg config.Config, mgr *service.Manager) {
+	r.GET("/api/jobs/:id/volumes", func(c *gin.Context) {
+		vols, err := mgr.ListVolumes(cfg, c.Param("id"))
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "volumes": vols})
+	})
+
+	r.POST("/api/jobs/:id/volumes/:v/plan", func(c *gin.Context) {
+		v, err := strconv.Atoi(c.Param("v"))
+		if err != nil || v < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "volume must be a positive integer"})
+			return
+		}
+		outline, plans, err := mgr.PlanVolume(cfg, c.Param("id"), v)
+		if err != nil {
+			writeJobError(c, err)
+			return
+		}
+		vol := outline.Volumes[v-1]
+		chapters := plans[:0:0]
+		for _, p := range plans {
+			if p.Index >= vol.Start && p.Index <= vol.End {
+				chapters = append(chapters, p)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "volume": vol, "plans": chapters, "total": outline.TotalChapters()})
+	})
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// registerWebRoutes serves the single-page UI; it only talks to the JSON API
+func registerWebRoutes(r *gin.Engine) {
+	assets, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	index, err := fs.ReadFile(assets, "index.html")
+	if err != nil {
+		panic(err)
+	}
+	r.GET("/", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", index)
+	})
+	r.StaticFS("/assets", http.FS(assets))
+}
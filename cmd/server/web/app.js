@@ -0,0 +1,544 @@
+(function () {
+  'use strict';
+
+  var app = document.getElementById('app');
+  var timers = [];
+  var cleanups = [];
+
+  // ---- helpers ----
+
+  function esc(s) {
+    return String(s == null ? '' : s).replace(/[&<>"']/g, function (c) {
+      return { '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' }[c];
+    });
+  }
+
+  function toast(msg) {
+    var t = document.getElementById('toast');
+    t.textContent = msg;
+    t.hidden = false;
+    clearTimeout(toast.timer);
+    toast.timer = setTimeout(function () { t.hidden = true; }, 3000);
+  }
+
+  function api(method, url, body, headers) {
+    var opts = { method: method, headers: headers || {} };
+    if (body !== undefined) {
+      opts.body = typeof body === 'string' ? body : JSON.stringify(body);
+      opts.headers['Content-Type'] = 'application/json';
+    }
+    return fetch(url, opts).then(function (res) {
+      var ct = res.headers.get('Content-Type') || '';
+      var p = ct.indexOf('json') >= 0 ? res.json() : res.text();
+      return p.then(function (data) {
+        if (!res.ok) {
+          var err = new Error((data && data.error) || res.statusText);
+          err.status = res.status;
+          throw err;
+        }
+        return { data: data, etag: res.headers.get('ETag') };
+      });
+    });
+  }
+
+  function every(ms, fn) {
+    fn();
+    timers.push(setInterval(fn, ms));
+  }
+
+  function badge(status) {
+    var names = {
+      pending: '等待', queued: '排队', running: '生成中', paused: '暂停', completed: '完成',
+      failed: '失败', cancelled: '已取消', awaiting_approval: '待审核'
+    };
+    return '<span class="badge ' + esc(status) + '">' + esc(names[status] || status) + '</span>';
+  }
+
+  function fmtTime(s) {
+    if (!s) return '';
+    var d = new Date(s);
+    return isNaN(d) ? '' : d.toLocaleString();
+  }
+
+  function active(status) {
+    return status === 'pending' || status === 'queued' || status === 'running' || status === 'paused';
+  }
+
+  // ---- settings: theme and reader font size persist in localStorage ----
+
+  var prefs = JSON.parse(localStorage.getItem('ai-reader') || '{}');
+  function savePrefs() { localStorage.setItem('ai-reader', JSON.stringify(prefs)); }
+  function applyTheme() { document.body.classList.toggle('dark', !!prefs.dark); }
+  applyTheme();
+  document.getElementById('theme').onclick = function () {
+    prefs.dark = !prefs.dark;
+    savePrefs();
+    applyTheme();
+  };
+
+  // ---- router ----
+
+  var routes = [
+    [/^#?\/?$/, jobsView],
+    [/^#\/new$/, newView],
+    [/^#\/job\/([^/]+)(?:\/(\w+))?$/, jobView],
+    [/^#\/read\/([^/]+)\/(\d+)$/, readerView]
+  ];
+
+  function route() {
+    timers.forEach(clearInterval);
+    timers = [];
+    cleanups.forEach(function (f) { f(); });
+    cleanups = [];
+    document.body.classList.remove('reading');
+    var h = location.hash || '#/';
+    for (var i = 0; i < routes.length; i++) {
+      var m = h.match(routes[i][0]);
+      if (m) {
+        routes[i][1].apply(null, m.slice(1).map(function (x) { return x && decodeURIComponent(x); }));
+        return;
+      }
+    }
+    app.innerHTML = '<p class="muted">页面不存在</p>';
+  }
+  window.addEventListener('hashchange', route);
+
+  // ---- jobs list ----
+
+  function jobsView() {
+    app.innerHTML = '<div class="row"><h2>作品</h2><div class="fit"><input id="q" placeholder="按标题搜索"></div><a class="btn fit" href="#/new">新建作品</a></div><div class="card"><table><thead><tr><th>标题</th><th>状态</th><th>章节</th><th>字数</th><th>创建时间</th><th></th></tr></thead><tbody id="rows"></tbody></table></div>';
+    var q = document.getElementById('q');
+    function load() {
+      api('GET', '/api/jobs?page_size=100&title=' + encodeURIComponent(q.value)).then(function (r) {
+        var rows = r.data.items.map(function (j) {
+          return '<tr><td><a href="#/job/' + esc(j.id) + '">' + esc(j.title || j.id) + '</a></td><td>' + badge(j.status) +
+            '</td><td>' + j.written_chapters + ' / ' + j.planned_chapters + '</td><td>' + j.words + '</td><td class="muted">' + esc(fmtTime(j.created_at)) +
+            '</td><td>' + (j.written_chapters ? '<a href="#/read/' + esc(j.id) + '/1">阅读</a>' : '') + '</td></tr>';
+        });
+        document.getElementById('rows').innerHTML = rows.join('') || '<tr><td colspan="6" class="muted">还没有作品</td></tr>';
+      }).catch(function (e) { toast(e.message); });
+    }
+    q.oninput = function () { clearTimeout(q.timer); q.timer = setTimeout(load, 300); };
+    every(5000, load);
+  }
+
+  // ---- create job ----
+
+  function newView() {
+    app.innerHTML = '<h2>新建作品</h2><form id="f" class="card">' +
+      '<label>主题</label><input name="topic" required placeholder="一句话描述故事">' +
+      '<div class="row"><div><label>章节数</label><input name="chapters" type="number" min="1" value="10"></div>' +
+      '<div><label>每章字数</label><input name="words" type="number" min="200" step="100" value="2000"></div>' +
+      '<div><label>模型（可选）</label><input name="model"></div></div>' +
+      '<label>频道</label><div class="chips"><label><input type="radio" name="gender" value="male" checked>男频</label><label><input type="radio" name="gender" value="female">女频</label></div>' +
+      '<label>分类</label><div id="cats" class="chips"></div>' +
+      '<label>标签</label><div id="tags" class="chips"></div>' +
+      '<label>附加指令（可选）</label><textarea name="instruction"></textarea>' +
+      '<label>原文（可选，按原文改编时填写）</label><textarea name="source_text" rows="4"></textarea>' +
+      '<label>人工审核节点</label><div class="chips">' +
+      ['outline:大纲', 'characters:人物', 'plans:章节规划'].map(function (s) {
+        var p = s.split(':');
+        return '<label><input type="checkbox" name="gates" value="' + p[0] + '">' + p[1] + '</label>';
+      }).join('') + '</div>' +
+      '<p><button type="submit">开始生成</button></p></form><p id="notes" class="muted"></p>';
+    var form = document.getElementById('f');
+    var cats = {};
+    function renderCats() {
+      var g = form.querySelector('input[name=gender]:checked').value;
+      document.getElementById('cats').innerHTML = (cats[g] || []).map(function (c) {
+        return '<label><input type="checkbox" name="categories" value="' + esc(c) + '">' + esc(c) + '</label>';
+      }).join('');
+    }
+    api('GET', '/api/categories').then(function (r) {
+      cats = r.data;
+      renderCats();
+      document.getElementById('tags').innerHTML = (cats.tags || []).map(function (c) {
+        return '<label><input type="checkbox" name="tags" value="' + esc(c) + '">' + esc(c) + '</label>';
+      }).join('');
+      document.getElementById('notes').innerHTML = Object.keys(cats.notes || {}).map(function (k) {
+        return esc(k) + '：' + esc(cats.notes[k]);
+      }).join('<br>');
+    }).catch(function (e) { toast(e.message); });
+    form.querySelectorAll('input[name=gender]').forEach(function (el) { el.onchange = renderCats; });
+    form.onsubmit = function (ev) {
+      ev.preventDefault();
+      var fd = new FormData(form);
+      var req = {
+        topic: fd.get('topic'),
+        chapters: parseInt(fd.get('chapters'), 10) || 0,
+        words: parseInt(fd.get('words'), 10) || 0,
+        model: fd.get('model'),
+        gender: fd.get('gender'),
+        categories: fd.getAll('categories'),
+        tags: fd.getAll('tags'),
+        instruction: fd.get('instruction'),
+        source_text: fd.get('source_text'),
+        gates: fd.getAll('gates')
+      };
+      form.querySelector('button').disabled = true;
+      api('POST', '/api/generate', req).then(function (r) {
+        location.hash = '#/job/' + encodeURIComponent(r.data.id);
+      }).catch(function (e) {
+        form.querySelector('button').disabled = false;
+        toast(e.message);
+      });
+    };
+  }
+
+  // ---- job workspace ----
+
+  function jobView(id, tab) {
+    tab = tab || 'chapters';
+    var base = '#/job/' + encodeURIComponent(id);
+    app.innerHTML = '<div class="card" id="status"><span class="muted">加载中…</span></div>' +
+      '<div class="tabs">' + [['chapters', '章节'], ['outline', '大纲'], ['characters', '人物'], ['export', '导出'], ['log', '日志']].map(function (t) {
+        return '<a href="' + base + '/' + t[0] + '" class="' + (t[0] === tab ? 'active' : '') + '">' + t[1] + '</a>';
+      }).join('') + '</div><div id="pane"></div>';
+    every(2000, function () { loadStatus(id); });
+    var pane = document.getElementById('pane');
+    ({ chapters: chaptersTab, outline: outlineTab, characters: charactersTab, export: exportTab, log: logTab }[tab] || chaptersTab)(id, pane);
+  }
+
+  var lastStatus = {};
+
+  function loadStatus(id) {
+    var el = document.getElementById('status');
+    if (!el) return;
+    api('GET', '/api/job?id=' + encodeURIComponent(id)).then(function (r) {
+      renderStatus(el, id, r.data);
+    }).catch(function (e) {
+      if (e.status !== 404) { toast(e.message); return; }
+      // not in memory: a job from an earlier run, summarise it from the listing
+      api('GET', '/api/jobs?page_size=100').then(function (r) {
+        var j = r.data.items.filter(function (x) { return x.id === id; })[0];
+        if (!j) { el.innerHTML = '<span class="muted">作品不存在</span>'; return; }
+        renderStatus(el, id, { status: j.status, completed: j.written_chapters, total: j.planned_chapters, title: j.title });
+      });
+    });
+  }
+
+  function renderStatus(el, id, s) {
+    var pct = s.total ? Math.round(100 * s.completed / s.total) : 0;
+    var html = '<div class="row"><div><strong>' + esc(s.title || id) + '</strong> ' + badge(s.status) +
+      ' <span class="muted">' + (s.completed || 0) + ' / ' + (s.total || 0) + '</span></div><div class="fit">';
+    if (s.status === 'awaiting_approval') {
+      html += '<button id="approve">通过「' + esc(s.awaiting_stage) + '」审核并继续</button> ';
+    }
+    if (s.status === 'running' && !s.pausing) {
+      html += '<button id="pause">暂停</button> ';
+    }
+    if (s.status === 'paused') {
+      html += '<button id="resume">继续生成</button> ';
+    }
+    if (active(s.status) || s.status === 'awaiting_approval') {
+      html += '<button id="cancel" class="danger">取消</button>';
+    }
+    html += '</div></div><div class="progress"><div style="width:' + pct + '%"></div></div>';
+    if (s.error) html += '<p class="muted">错误：' + esc(s.error) + '</p>';
+    el.innerHTML = html;
+    var a = document.getElementById('approve');
+    if (a) a.onclick = function () {
+      api('POST', '/api/approve?id=' + encodeURIComponent(id) + '&stage=' + encodeURIComponent(s.awaiting_stage)).then(function () {
+        toast('已通过，继续生成');
+      }).catch(function (e) { toast(e.message); });
+    };
+    var p = document.getElementById('pause');
+    if (p) p.onclick = function () {
+      api('POST', '/api/pause?id=' + encodeURIComponent(id)).then(function () {
+        toast('当前阶段完成后暂停');
+      }).catch(function (e) { toast(e.message); });
+    };
+    var r = document.getElementById('resume');
+    if (r) r.onclick = function () {
+      api('POST', '/api/resume?id=' + encodeURIComponent(id)).then(function () {
+        toast('已继续生成');
+      }).catch(function (e) { toast(e.message); });
+    };
+    var c = document.getElementById('cancel');
+    if (c) c.onclick = function () {
+      if (!confirm('确定取消这个任务？')) return;
+      api('POST', '/api/cancel?id=' + encodeURIComponent(id)).catch(function (e) { toast(e.message); });
+    };
+    // refresh the open pane when the job moves on
+    if (lastStatus[id] && lastStatus[id] !== s.status && document.querySelector('.tabs a.active[href$="/chapters"]')) {
+      chaptersTab(id, document.getElementById('pane'));
+    }
+    lastStatus[id] = s.status;
+  }
+
+  function chaptersTab(id, pane) {
+    api('GET', '/api/jobs/' + encodeURIComponent(id) + '/chapters').then(function (r) {
+      var rows = r.data.chapters.map(function (c) {
+        var done = c.status === 'completed';
+        return '<tr><td>' + c.index + '</td><td>' + (done ? '<a href="#/read/' + esc(id) + '/' + c.index + '">' + esc(c.title) + '</a>' : esc(c.title)) +
+          '</td><td>' + badge(c.status) + '</td><td>' + (c.words || '') + '</td><td class="muted">' + esc(fmtTime(c.updated_at)) +
+          '</td><td><button class="ghost gen" data-n="' + c.index + '"' + (c.status === 'running' || c.status === 'queued' ? ' disabled' : '') + '>' + (done ? '重写' : '生成') + '</button></td></tr>';
+      });
+      pane.innerHTML = '<div class="card"><label>生成附加指令（可选）</label><textarea id="instr" rows="2"></textarea></div>' +
+        '<div class="card"><table><thead><tr><th>#</th><th>标题</th><th>状态</th><th>字数</th><th>更新时间</th><th></th></tr></thead><tbody>' +
+        (rows.join('') || '<tr><td colspan="6" class="muted">还没有章节规划</td></tr>') + '</tbody></table></div>';
+      pane.querySelectorAll('button.gen').forEach(function (b) {
+        b.onclick = function () {
+          var n = parseInt(b.dataset.n, 10);
+          b.disabled = true;
+          api('POST', '/api/chapter', { id: id, chapter: n, instruction: document.getElementById('instr').value }).then(function (r) {
+            toast('第 ' + n + ' 章开始生成');
+            watchTask(r.data.task_id, function () { chaptersTab(id, pane); });
+          }).catch(function (e) { b.disabled = false; toast(e.message); });
+        };
+      });
+    }).catch(function (e) { pane.innerHTML = '<p class="muted">' + esc(e.message) + '</p>'; });
+  }
+
+  function watchTask(taskID, done) {
+    var t = setInterval(function () {
+      api('GET', '/api/chapter_status?id=' + encodeURIComponent(taskID)).then(function (r) {
+        if (r.data.status === 'completed' || r.data.status === 'failed' || r.data.status === 'cancelled') {
+          clearInterval(t);
+          if (r.data.status === 'failed') toast('生成失败：' + r.data.error);
+          done();
+        }
+      }).catch(function () { clearInterval(t); });
+    }, 2000);
+    timers.push(t);
+  }
+
+  // editable artifacts are saved with the ETag they were loaded with so
+  // concurrent edits are rejected instead of overwritten; collect builds the
+  // body from the loaded document so fields the form does not show survive
+  function artifactEditor(id, name, pane, render, collect) {
+    var url = '/api/jobs/' + encodeURIComponent(id) + '/' + name;
+    var etag = null;
+    var data = null;
+    // the form is drawn again after a save since chapters are renumbered
+    function show(r) {
+      etag = r.etag;
+      data = r.data;
+      pane.innerHTML = '<form class="card" id="ed">' + render(r.data) + '<p><button type="submit">保存</button> <button type="button" class="ghost" id="reload">重新加载</button></p></form>';
+      bind();
+    }
+    function load() {
+      api('GET', url).then(show).catch(function (e) { pane.innerHTML = '<p class="muted">' + esc(e.message) + '</p>'; });
+    }
+    function bind() {
+      var form = document.getElementById('ed');
+      document.getElementById('reload').onclick = load;
+      form.onsubmit = function (ev) {
+        ev.preventDefault();
+        api('PUT', url, collect(form, data), { 'If-Match': etag || '*' }).then(function (r) {
+          show(r);
+          toast('已保存');
+        }).catch(function (e) {
+          toast(e.status === 412 ? '内容已被其他人修改，请重新加载' : e.message);
+        });
+      };
+      form.addEventListener('click', function (ev) {
+        var t = ev.target;
+        if (t.dataset.add) {
+          t.parentNode.insertAdjacentHTML('beforebegin', render.item({}, form.querySelectorAll('.editor-item').length));
+        } else if (t.dataset.remove) {
+          t.closest('.editor-item').remove();
+        }
+      });
+    }
+    load();
+  }
+
+  // rows loaded from the outline carry their chapter index so the server
+  // keeps their files, plans and history; added rows have none
+  function outlineTab(id, pane) {
+    function item(c, i) {
+      return '<div class="editor-item"' + (c.index ? ' data-index="' + c.index + '"' : '') + '><div class="row"><div class="fit muted">第 <span class="idx">' + (i + 1) + '</span> 章</div><div><input class="ct" value="' + esc(c.title) + '" placeholder="章节标题"></div>' +
+        '<div class="fit"><button type="button" class="ghost" data-remove="1">删除</button></div></div><textarea class="cs" placeholder="梗概">' + esc(c.summary) + '</textarea></div>';
+    }
+    function render(o) {
+      return '<label>书名</label><input id="ot" value="' + esc(o.title) + '">' + (o.chapters || []).map(item).join('') +
+        '<p><button type="button" class="ghost" data-add="1">添加章节</button></p>';
+    }
+    render.item = item;
+    artifactEditor(id, 'outline', pane, render, function (form, o) {
+      var byIndex = {};
+      (o.chapters || []).forEach(function (c) { byIndex[c.index] = c; });
+      var chapters = [];
+      form.querySelectorAll('.editor-item').forEach(function (el) {
+        var c = Object.assign({}, byIndex[el.dataset.index] || {});
+        c.title = el.querySelector('.ct').value;
+        c.summary = el.querySelector('.cs').value;
+        chapters.push(c);
+      });
+      return Object.assign({}, o, { title: document.getElementById('ot').value, chapters: chapters });
+    });
+  }
+
+  function charactersTab(id, pane) {
+    function item(c, i) {
+      return '<div class="editor-item"' + (c.name ? ' data-i="' + i + '"' : '') + '><div class="row"><div><input class="cn" value="' + esc(c.name) + '" placeholder="姓名"></div><div><input class="cr" value="' + esc(c.role) + '" placeholder="身份"></div>' +
+        '<div class="fit"><button type="button" class="ghost" data-remove="1">删除</button></div></div>' +
+        '<input class="ctr" value="' + esc((c.traits || []).join('、')) + '" placeholder="性格特点，用顿号分隔">' +
+        '<textarea class="cb" placeholder="背景">' + esc(c.background) + '</textarea></div>';
+    }
+    function render(list) {
+      return (list || []).map(item).join('') + '<p><button type="button" class="ghost" data-add="1">添加人物</button></p>';
+    }
+    render.item = item;
+    artifactEditor(id, 'characters', pane, render, function (form, list) {
+      var out = [];
+      form.querySelectorAll('.editor-item').forEach(function (el) {
+        var name = el.querySelector('.cn').value.trim();
+        if (!name) return;
+        // state and any other field the form does not edit are kept
+        out.push(Object.assign({}, (list || [])[el.dataset.i] || {}, {
+          name: name,
+          role: el.querySelector('.cr').value,
+          traits: el.querySelector('.ctr').value.split(/[、,，]/).map(function (s) { return s.trim(); }).filter(Boolean),
+          background: el.querySelector('.cb').value
+        }));
+      });
+      return out;
+    });
+  }
+
+  function exportTab(id, pane) {
+    var u = '/api/jobs/' + encodeURIComponent(id) + '/export?format=';
+    pane.innerHTML = '<div class="card"><p>下载已写完的章节：</p><p class="row">' +
+      '<a class="btn fit" href="' + u + 'epub">EPUB</a><a class="btn fit" href="' + u + 'txt">TXT</a>' +
+      '<a class="btn fit" href="' + u + 'txt&encoding=gb18030">TXT (GB18030)</a><a class="btn fit" href="' + u + 'docx">DOCX</a>' +
+      '<a class="btn fit" href="' + u + 'pdf">PDF</a></p></div>';
+  }
+
+  function logTab(id, pane) {
+    pane.innerHTML = '<pre class="log card" id="log"></pre>';
+    every(3000, function () {
+      api('GET', '/api/log?id=' + encodeURIComponent(id)).then(function (r) {
+        var el = document.getElementById('log');
+        if (!el) return;
+        var bottom = el.scrollTop + el.clientHeight >= el.scrollHeight - 4;
+        el.textContent = r.data;
+        if (bottom) el.scrollTop = el.scrollHeight;
+      }).catch(function () {
+        var el = document.getElementById('log');
+        if (el) el.textContent = '没有日志（任务不在本次运行的内存中）';
+      });
+    });
+  }
+
+  // ---- reader ----
+
+  function readerView(id, n) {
+    n = parseInt(n, 10);
+    document.body.classList.add('reading');
+    prefs.fontSize = prefs.fontSize || 19;
+    app.innerHTML = '<div class="reader"><aside id="toc"' + (prefs.toc === false ? ' class="hidden"' : '') + '></aside><div class="stage">' +
+      '<div class="toolbar"><button class="ghost" id="toggle">目录</button><a href="#/job/' + esc(id) + '">返回作品</a><span class="spacer"></span>' +
+      '<button class="ghost" id="smaller">A-</button><span id="size"></span><button class="ghost" id="bigger">A+</button></div>' +
+      '<div class="viewport" id="vp"><div class="pages" id="pages"></div></div>' +
+      '<div class="pager"><button class="ghost" id="prev">上一页</button><span id="pageno"></span><button class="ghost" id="next">下一页</button></div></div></div>';
+    var vp = document.getElementById('vp');
+    var pages = document.getElementById('pages');
+    var page = 0;
+    var count = 1;
+    var chapters = [];
+
+    function applySize() {
+      document.documentElement.style.setProperty('--font-size', prefs.fontSize + 'px');
+      document.getElementById('size').textContent = prefs.fontSize + 'px';
+    }
+
+    // the chapter is laid out in CSS columns one viewport wide; turning a
+    // page shifts the column strip by one viewport
+    function layout(keepRatio) {
+      var ratio = count > 1 ? page / (count - 1) : 0;
+      pages.style.columnWidth = vp.clientWidth + 'px';
+      pages.style.transform = 'none';
+      var gap = parseFloat(getComputedStyle(pages).columnGap) || 0;
+      count = Math.max(1, Math.round((pages.scrollWidth + gap) / (vp.clientWidth + gap)));
+      if (keepRatio) page = Math.round(ratio * (count - 1));
+      show();
+    }
+
+    function show() {
+      page = Math.max(0, Math.min(page, count - 1));
+      var gap = parseFloat(getComputedStyle(pages).columnGap) || 0;
+      pages.style.transform = 'translateX(' + (-page * (vp.clientWidth + gap)) + 'px)';
+      document.getElementById('pageno').textContent = (page + 1) + ' / ' + count;
+    }
+
+    function neighbour(step) {
+      var idx = -1;
+      for (var i = 0; i < chapters.length; i++) if (chapters[i].index === n) idx = i;
+      for (var k = idx + step; k >= 0 && k < chapters.length; k += step) {
+        if (chapters[k].status === 'completed') return chapters[k].index;
+      }
+      return 0;
+    }
+
+    function turn(step) {
+      if (page + step >= 0 && page + step < count) {
+        page += step;
+        show();
+        return;
+      }
+      var target = neighbour(step);
+      if (!target) { toast(step > 0 ? '已是最后一章' : '已是第一章'); return; }
+      sessionStorage.setItem('ai-reader-end', step < 0 ? '1' : '');
+      location.hash = '#/read/' + encodeURIComponent(id) + '/' + target;
+    }
+
+    api('GET', '/api/jobs/' + encodeURIComponent(id) + '/chapters').then(function (r) {
+      chapters = r.data.chapters;
+      document.getElementById('toc').innerHTML = chapters.map(function (c) {
+        var cls = c.index === n ? 'current' : (c.status === 'completed' ? '' : 'pending');
+        return '<a class="' + cls + '" href="#/read/' + esc(id) + '/' + c.index + '" title="' + esc(c.title) + '">' + c.index + '. ' + esc(c.title) + '</a>';
+      }).join('');
+      var cur = document.querySelector('#toc a.current');
+      if (cur) cur.scrollIntoView({ block: 'center' });
+    });
+
+    api('GET', '/api/jobs/' + encodeURIComponent(id) + '/chapters/' + n + '?format=json').then(function (r) {
+      var d = r.data;
+      pages.innerHTML = '<h1>' + esc(d.title) + '</h1>' + String(d.content || '').split(/\n+/).map(function (p) {
+        p = p.replace(/^\s*#+\s*/, '').trim();
+        return p ? '<p>' + esc(p) + '</p>' : '';
+      }).join('');
+      document.title = d.title + ' - ai-reader';
+      layout(false);
+      if (sessionStorage.getItem('ai-reader-end')) {
+        page = count - 1;
+        show();
+        sessionStorage.removeItem('ai-reader-end');
+      }
+    }).catch(function (e) { pages.innerHTML = '<p>' + esc(e.message) + '</p>'; });
+
+    applySize();
+    document.getElementById('prev').onclick = function () { turn(-1); };
+    document.getElementById('next').onclick = function () { turn(1); };
+    document.getElementById('smaller').onclick = function () { prefs.fontSize = Math.max(12, prefs.fontSize - 1); savePrefs(); applySize(); layout(true); };
+    document.getElementById('bigger').onclick = function () { prefs.fontSize = Math.min(32, prefs.fontSize + 1); savePrefs(); applySize(); layout(true); };
+    document.getElementById('toggle').onclick = function () {
+      var toc = document.getElementById('toc');
+      toc.classList.toggle('hidden');
+      prefs.toc = !toc.classList.contains('hidden');
+      savePrefs();
+      layout(true);
+    };
+    vp.onclick = function (ev) {
+      var x = ev.clientX - vp.getBoundingClientRect().left;
+      turn(x < vp.clientWidth / 3 ? -1 : 1);
+    };
+    function onKey(ev) {
+      if (ev.target.tagName === 'INPUT' || ev.target.tagName === 'TEXTAREA') return;
+      if (ev.key === 'ArrowRight' || ev.key === 'PageDown' || ev.key === ' ') { ev.preventDefault(); turn(1); }
+      if (ev.key === 'ArrowLeft' || ev.key === 'PageUp') { ev.preventDefault(); turn(-1); }
+    }
+    function onResize() { layout(true); }
+    document.addEventListener('keydown', onKey);
+    window.addEventListener('resize', onResize);
+    cleanups.push(function () {
+      document.removeEventListener('keydown', onKey);
+      window.removeEventListener('resize', onResize);
+      document.title = 'ai-reader';
+    });
+  }
+
+  route();
+})();
@@ -21,10 +21,20 @@ type Config struct {
         RequestTimeoutSec int `yaml:"request_timeout_sec"`
         MaxRetries        int `yaml:"max_retries"`
         RetryBackoffMs    int `yaml:"retry_backoff_ms"`
+        Temperature       float64 `yaml:"temperature"`
     } `yaml:"openai"`
     Output struct {
         Dir string `yaml:"dir"`
     } `yaml:"output"`
+    Export struct {
+        Font     string  `yaml:"font"`
+        PageSize string  `yaml:"page_size"`
+        FontSize float64 `yaml:"font_size"`
+    } `yaml:"export"`
+    Presets struct {
+        Dir       string `yaml:"dir"`
+        ReloadSec int    `yaml:"reload_sec"`
+    } `yaml:"presets"`
 }
 
 func Load(path string) (Config, error) {
@@ -33,6 +43,8 @@ func Load(path string) (Config, error) {
 	if err != nil {
 		return cfg, err
 	}
+	// set before parsing so that a temperature of 0 can be configured
+	cfg.OpenAI.Temperature = 0.9
 	if err := parseYAMLConfig(&cfg, string(b)); err != nil {
 		return cfg, err
 	}
@@ -54,6 +66,10 @@ func Load(path string) (Config, error) {
     if cfg.OpenAI.RequestTimeoutSec == 0 { cfg.OpenAI.RequestTimeoutSec = 120 }
     if cfg.OpenAI.MaxRetries == 0 { cfg.OpenAI.MaxRetries = 3 }
     if cfg.OpenAI.RetryBackoffMs == 0 { cfg.OpenAI.RetryBackoffMs = 1500 }
+    if cfg.Export.PageSize == "" { cfg.Export.PageSize = "A4" }
+    if cfg.Export.FontSize == 0 { cfg.Export.FontSize = 12 }
+    if cfg.Presets.Dir == "" { cfg.Presets.Dir = "presets" }
+    if cfg.Presets.ReloadSec == 0 { cfg.Presets.ReloadSec = 5 }
     return cfg, nil
 }
 
@@ -99,11 +115,29 @@ func parseYAMLConfig(cfg *Config, s string) error {
                 if p, err := strconv.Atoi(val); err == nil { cfg.OpenAI.MaxRetries = p }
             case "retry_backoff_ms":
                 if p, err := strconv.Atoi(val); err == nil { cfg.OpenAI.RetryBackoffMs = p }
+            case "temperature":
+                if p, err := strconv.ParseFloat(val, 64); err == nil { cfg.OpenAI.Temperature = p }
             }
         case "output":
             if key == "dir" {
                 cfg.Output.Dir = val
             }
+        case "export":
+            switch key {
+            case "font":
+                cfg.Export.Font = val
+            case "page_size":
+                cfg.Export.PageSize = val
+            case "font_size":
+                if p, err := strconv.ParseFloat(val, 64); err == nil { cfg.Export.FontSize = p }
+            }
+        case "presets":
+            switch key {
+            case "dir":
+                cfg.Presets.Dir = val
+            case "reload_sec":
+                if p, err := strconv.Atoi(val); err == nil { cfg.Presets.ReloadSec = p }
+            }
         }
     }
     return nil
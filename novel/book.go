@@ -0,0 +1,93 @@
+package novel
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Book is everything an exporter needs from a generated novel
+type Book struct {
+	ID         string
+	Outline    Outline
+	Characters []Character
+	Chapters   []ChapterContent
+	Language   string
+	Summary    string
+	Created    time.Time
+	Modified   time.Time
+}
+
+// LoadBook reads a persisted work dir (outline.json, characters.json,
+// spec.json and chapters/) back into a Book; only written chapters are included
+func LoadBook(dir string) (Book, error) {
+	var b Book
+	data, err := os.ReadFile(filepath.Join(dir, "outline.json"))
+	if err != nil {
+		return b, err
+	}
+	if err := json.Unmarshal(data, &b.Outline); err != nil {
+		return b, err
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "characters.json")); err == nil {
+		_ = json.Unmarshal(data, &b.Characters)
+	}
+	var spec Spec
+	if data, err := os.ReadFile(filepath.Join(dir, "spec.json")); err == nil {
+		_ = json.Unmarshal(data, &spec)
+	}
+	b.Language = spec.Language
+	if b.Language == "" {
+		b.Language = "zh"
+	}
+	b.ID = filepath.Base(dir)
+	if fi, err := os.Stat(dir); err == nil {
+		b.Created = fi.ModTime()
+		b.Modified = fi.ModTime()
+	}
+	if len(b.Outline.Chapters) > 0 {
+		b.Summary = b.Outline.Chapters[0].Summary
+	}
+	chapDir := filepath.Join(dir, "chapters")
+	for idx, name := range ChapterFiles(chapDir) {
+		path := filepath.Join(chapDir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		title, body := ParseChapterFile(data)
+		b.Chapters = append(b.Chapters, ChapterContent{Index: idx, Title: title, Content: body})
+		if fi, err := os.Stat(path); err == nil && fi.ModTime().After(b.Modified) {
+			b.Modified = fi.ModTime()
+		}
+	}
+	sort.Slice(b.Chapters, func(i, j int) bool { return b.Chapters[i].Index < b.Chapters[j].Index })
+	return b, nil
+}
+
+// Paragraphs splits chapter text into trimmed, non-empty paragraphs
+func Paragraphs(s string) []string {
+	var out []string
+	for _, l := range splitLines(PlainText(s)) {
+		if l != "" {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// Range returns a copy of the book holding only chapters from..to
+// (inclusive); zero bounds are open
+func (b Book) Range(from, to int) Book {
+	var chapters []ChapterContent
+	for _, c := range b.Chapters {
+		if c.Index < from || (to > 0 && c.Index > to) {
+			continue
+		}
+		chapters = append(chapters, c)
+	}
+	b.Chapters = chapters
+	return b
+}
@@ -0,0 +1,280 @@
+package novel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CharacterStateFile is the per-job ledger of how characters change from
+// chapter to chapter
+const CharacterStateFile = "character_state.json"
+
+const StageCharacterState = "character_state"
+
+// CharacterState is where a character stands after a chapter
+type CharacterState struct {
+	Name          string            `json:"name"`
+	Location      string            `json:"location,omitempty"`
+	Status        string            `json:"status,omitempty"`
+	Realm         string            `json:"realm,omitempty"`
+	Possessions   []string          `json:"possessions,omitempty"`
+	Relationships map[string]string `json:"relationships,omitempty"`
+	Knowledge     []string          `json:"knowledge,omitempty"`
+	// Chapter is the chapter the state was last changed in
+	Chapter int `json:"chapter,omitempty"`
+}
+
+// StateUpdate holds the characters whose state changed in one chapter
+type StateUpdate struct {
+	Chapter    int              `json:"chapter"`
+	Characters []CharacterState `json:"characters"`
+}
+
+// CharacterStates is the content of character_state.json; updates are kept
+// per chapter so a regenerated chapter replaces its own update only and the
+// state before any chapter can be rebuilt
+type CharacterStates struct {
+	Updates []StateUpdate `json:"updates"`
+}
+
+// stateMu serialises read-modify-write of the ledger across chapter tasks
+var stateMu sync.Mutex
+
+func LoadCharacterStates(dir string) (CharacterStates, error) {
+	var s CharacterStates
+	b, err := os.ReadFile(filepath.Join(dir, CharacterStateFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return CharacterStates{Updates: []StateUpdate{}}, nil
+		}
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, err
+	}
+	if s.Updates == nil {
+		s.Updates = []StateUpdate{}
+	}
+	return s, nil
+}
+
+// Before folds the updates of the chapters before chapter into the state
+// each character is in when chapter starts
+func (s CharacterStates) Before(chapter int) map[string]CharacterState {
+	updates := append([]StateUpdate(nil), s.Updates...)
+	sort.SliceStable(updates, func(a, b int) bool { return updates[a].Chapter < updates[b].Chapter })
+	out := map[string]CharacterState{}
+	for _, u := range updates {
+		if u.Chapter >= chapter {
+			break
+		}
+		for _, c := range u.Characters {
+			c.Chapter = u.Chapter
+			out[c.Name] = c
+		}
+	}
+	return out
+}
+
+// Latest is the state every character is in after the last tracked chapter
+func (s CharacterStates) Latest() []CharacterState {
+	cur := s.Before(math.MaxInt)
+	out := make([]CharacterState, 0, len(cur))
+	for _, c := range cur {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].Name < out[b].Name })
+	return out
+}
+
+// Set replaces the update of one chapter
+func (s *CharacterStates) Set(u StateUpdate) {
+	for i := range s.Updates {
+		if s.Updates[i].Chapter == u.Chapter {
+			s.Updates[i] = u
+			return
+		}
+	}
+	s.Updates = append(s.Updates, u)
+	sort.SliceStable(s.Updates, func(a, b int) bool { return s.Updates[a].Chapter < s.Updates[b].Chapter })
+}
+
+// Until keeps the updates of the chapters before chapter, for forks
+func (s CharacterStates) Until(chapter int) CharacterStates {
+	out := CharacterStates{Updates: []StateUpdate{}}
+	for _, u := range s.Updates {
+		if u.Chapter < chapter {
+			out.Updates = append(out.Updates, u)
+		}
+	}
+	return out
+}
+
+// CarriedStates is the ledger a sequel starts from: the states recurring
+// characters ended the previous book in, held as the update of chapter 0
+func CarriedStates(states []CharacterState) CharacterStates {
+	out := CharacterStates{Updates: []StateUpdate{}}
+	if len(states) > 0 {
+		out.Updates = append(out.Updates, StateUpdate{Chapter: 0, Characters: states})
+	}
+	return out
+}
+
+func persistCharacterStates(dir string, s CharacterStates, p Provenance) error {
+	b, _ := json.MarshalIndent(s, "", "  ")
+	if err := os.WriteFile(filepath.Join(dir, CharacterStateFile), b, 0o644); err != nil {
+		return err
+	}
+	return RecordArtifact(dir, CharacterStateFile, p)
+}
+
+// String renders the state as one line in Chinese
+func (c CharacterState) String() string {
+	return c.Text(LangChinese)
+}
+
+// Text renders the state as one line in language lang
+func (c CharacterState) Text(lang string) string {
+	t := ArtifactLabels(lang)
+	var parts []string
+	add := func(i int, v string) {
+		parts = append(parts, t.State[i]+t.Colon+v)
+	}
+	if c.Location != "" {
+		add(0, c.Location)
+	}
+	if c.Status != "" {
+		add(1, c.Status)
+	}
+	if c.Realm != "" {
+		add(2, c.Realm)
+	}
+	if len(c.Possessions) > 0 {
+		add(3, strings.Join(c.Possessions, t.List))
+	}
+	if len(c.Relationships) > 0 {
+		names := make([]string, 0, len(c.Relationships))
+		for n := range c.Relationships {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		rel := make([]string, 0, len(names))
+		for _, n := range names {
+			rel = append(rel, n+t.Parens[0]+c.Relationships[n]+t.Parens[1])
+		}
+		add(4, strings.Join(rel, t.List))
+	}
+	if len(c.Knowledge) > 0 {
+		add(5, strings.Join(c.Knowledge, t.Semi))
+	}
+	return strings.Join(parts, " | ")
+}
+
+// withStates gives the canon the character states chapter starts from
+func (g *Generator) withStates(c Canon, chapter int) Canon {
+	if g.PersistDir == "" {
+		return c
+	}
+	s, err := LoadCharacterStates(g.PersistDir)
+	if err != nil {
+		return c
+	}
+	c.States = s.Before(chapter)
+	return c
+}
+
+// chapterStates is the tracked state of the chapter's characters, so the
+// text does not contradict what earlier chapters established
+func chapterStates(c Canon, relevant []Character) []CharacterState {
+	var out []CharacterState
+	for _, r := range relevant {
+		st, ok := c.States[r.Name]
+		if !ok || st.String() == "" {
+			continue
+		}
+		st.Name = r.Name
+		out = append(out, st)
+	}
+	return out
+}
+
+// TrackCharacterStates reads a written chapter and records in
+// character_state.json how its characters changed: where they are, injuries
+// and conditions, realm, possessions, relationships and what they know
+func (g *Generator) TrackCharacterStates(ctx context.Context, spec Spec, canon Canon, c ChapterContent) error {
+	if g.PersistDir == "" {
+		return nil
+	}
+	stateMu.Lock()
+	s, err := LoadCharacterStates(g.PersistDir)
+	stateMu.Unlock()
+	if err != nil {
+		return err
+	}
+	prior := s.Before(c.Index)
+	known := make([]CharacterState, 0, len(prior))
+	for _, name := range sortedStateNames(prior) {
+		st := prior[name]
+		st.Name = name
+		known = append(known, st)
+	}
+	sys, user, err := g.prompt(spec, "track_states", PromptData{Canon: canon, States: known, Chapter: c})
+	if err != nil {
+		return err
+	}
+	out, err := g.chatTimed(ctx, spec.Model, sys, user)
+	if err != nil {
+		return err
+	}
+	var states []CharacterState
+	if err := json.Unmarshal([]byte(extractJSON(out)), &states); err != nil {
+		return err
+	}
+	kept := make([]CharacterState, 0, len(states))
+	for _, st := range states {
+		st.Name = strings.TrimSpace(st.Name)
+		if st.Name == "" {
+			continue
+		}
+		st.Chapter = 0
+		kept = append(kept, st)
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	// re-read: another chapter may have been tracked while the model was busy
+	if s, err = LoadCharacterStates(g.PersistDir); err != nil {
+		return err
+	}
+	s.Set(StateUpdate{Chapter: c.Index, Characters: kept})
+	if err := persistCharacterStates(g.PersistDir, s, g.provenance(StageCharacterState)); err != nil {
+		return err
+	}
+	if g.Log != nil {
+		g.Log(fmt.Sprintf("[人物状态] 第%d章 更新人物=%d", c.Index, len(kept)))
+	}
+	return nil
+}
+
+// trackStates runs the tracker after a chapter; a failed extraction only
+// leaves the ledger behind by one chapter, so it is logged and not fatal
+func (g *Generator) trackStates(ctx context.Context, spec Spec, canon Canon, c ChapterContent) {
+	if err := g.TrackCharacterStates(ctx, spec, canon, c); err != nil && g.Log != nil {
+		g.Log(fmt.Sprintf("[人物状态] 第%d章 提取失败：%s", c.Index, err.Error()))
+	}
+}
+
+func sortedStateNames(m map[string]CharacterState) []string {
+	out := make([]string, 0, len(m))
+	for n := range m {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out
+}
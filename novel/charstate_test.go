@@ -0,0 +1,66 @@
+package novel
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCharacterStatesSet(t *testing.T) {
+	var s CharacterStates
+	s.Set(StateUpdate{Chapter: 3, Characters: []CharacterState{{Name: "林", Location: "山门"}}})
+	s.Set(StateUpdate{Chapter: 1, Characters: []CharacterState{{Name: "林", Location: "村口"}}})
+	s.Set(StateUpdate{Chapter: 2, Characters: []CharacterState{{Name: "苏", Status: "重伤"}}})
+	// a regenerated chapter replaces its own update only
+	s.Set(StateUpdate{Chapter: 3, Characters: []CharacterState{{Name: "林", Location: "后山"}}})
+	var chapters []int
+	for _, u := range s.Updates {
+		chapters = append(chapters, u.Chapter)
+	}
+	if !reflect.DeepEqual(chapters, []int{1, 2, 3}) {
+		t.Fatalf("update chapters = %v, want [1 2 3]", chapters)
+	}
+	if loc := s.Updates[2].Characters[0].Location; loc != "后山" {
+		t.Fatalf("chapter 3 location = %s, want 后山", loc)
+	}
+}
+
+func TestCharacterStatesBefore(t *testing.T) {
+	s := CharacterStates{Updates: []StateUpdate{
+		{Chapter: 4, Characters: []CharacterState{{Name: "林", Location: "京城", Realm: "金丹"}}},
+		{Chapter: 1, Characters: []CharacterState{{Name: "林", Location: "村口"}, {Name: "苏", Status: "昏迷"}}},
+		{Chapter: 2, Characters: []CharacterState{{Name: "苏", Status: "苏醒"}}},
+	}}
+	cases := []struct {
+		chapter int
+		want    map[string]CharacterState
+	}{
+		{1, map[string]CharacterState{}},
+		{2, map[string]CharacterState{
+			"林": {Name: "林", Location: "村口", Chapter: 1},
+			"苏": {Name: "苏", Status: "昏迷", Chapter: 1},
+		}},
+		{4, map[string]CharacterState{
+			"林": {Name: "林", Location: "村口", Chapter: 1},
+			"苏": {Name: "苏", Status: "苏醒", Chapter: 2},
+		}},
+		{5, map[string]CharacterState{
+			"林": {Name: "林", Location: "京城", Realm: "金丹", Chapter: 4},
+			"苏": {Name: "苏", Status: "苏醒", Chapter: 2},
+		}},
+	}
+	for _, c := range cases {
+		if got := s.Before(c.chapter); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Before(%d) = %+v, want %+v", c.chapter, got, c.want)
+		}
+	}
+	if s.Updates[0].Chapter != 4 {
+		t.Fatal("Before reordered the ledger")
+	}
+	latest := s.Latest()
+	if len(latest) != 2 || latest[0].Name != "林" || latest[0].Chapter != 4 {
+		t.Fatalf("Latest = %+v", latest)
+	}
+	if until := s.Until(2); len(until.Updates) != 1 || until.Updates[0].Chapter != 1 {
+		t.Fatalf("Until(2) = %+v", until)
+	}
+}
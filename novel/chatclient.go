@@ -2,10 +2,9 @@ package novel
 
 import (
     "context"
-    "time"
 )
 
+// ChatClient sends one prompt to a model; the generator retries failed calls
 type ChatClient interface {
     Chat(ctx context.Context, model string, system string, user string) (string, error)
-    ChatWithRetry(ctx context.Context, model string, system string, user string, retries int, backoff time.Duration) (string, error)
 }
@@ -0,0 +1,221 @@
+package novel
+
+import (
+	"strings"
+	"unicode"
+)
+
+// diff operations
+const (
+	DiffEqual   = "equal"
+	DiffInsert  = "insert"
+	DiffDelete  = "delete"
+	DiffReplace = "replace"
+)
+
+// maxDiffCells bounds the LCS table; larger inputs degrade to a whole replace
+const maxDiffCells = 4 << 20
+
+// TextDiff is one change inside a replaced paragraph
+type TextDiff struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// ParagraphDiff is one paragraph-level change; replaced paragraphs carry a
+// character-level breakdown in Chars
+type ParagraphDiff struct {
+	Op    string     `json:"op"`
+	Old   string     `json:"old,omitempty"`
+	New   string     `json:"new,omitempty"`
+	Chars []TextDiff `json:"chars,omitempty"`
+}
+
+// DiffStats summarises a diff in words as counted by CountWords
+type DiffStats struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// DiffText compares two chapter bodies paragraph by paragraph; adjacent
+// deleted and inserted paragraphs are paired up and diffed per character
+// for CJK text and per word for other scripts
+func DiffText(a, b string) ([]ParagraphDiff, DiffStats) {
+	pa, pb := bodyParagraphs(a), bodyParagraphs(b)
+	ops := lcsDiff(len(pa), len(pb), func(i, j int) bool { return pa[i] == pb[j] })
+	var out []ParagraphDiff
+	var st DiffStats
+	var dels, ins []string
+	flush := func() {
+		n := len(dels)
+		if len(ins) < n {
+			n = len(ins)
+		}
+		for k := 0; k < n; k++ {
+			chars := diffTokens(dels[k], ins[k])
+			for _, c := range chars {
+				switch c.Op {
+				case DiffInsert:
+					st.Added += CountWords(c.Text)
+				case DiffDelete:
+					st.Removed += CountWords(c.Text)
+				}
+			}
+			out = append(out, ParagraphDiff{Op: DiffReplace, Old: dels[k], New: ins[k], Chars: chars})
+		}
+		for _, p := range dels[n:] {
+			st.Removed += CountWords(p)
+			out = append(out, ParagraphDiff{Op: DiffDelete, Old: p})
+		}
+		for _, p := range ins[n:] {
+			st.Added += CountWords(p)
+			out = append(out, ParagraphDiff{Op: DiffInsert, New: p})
+		}
+		dels, ins = nil, nil
+	}
+	i, j := 0, 0
+	for _, op := range ops {
+		switch op {
+		case DiffEqual:
+			flush()
+			out = append(out, ParagraphDiff{Op: DiffEqual, Old: pa[i], New: pb[j]})
+			i++
+			j++
+		case DiffDelete:
+			dels = append(dels, pa[i])
+			i++
+		case DiffInsert:
+			ins = append(ins, pb[j])
+			j++
+		}
+	}
+	flush()
+	return out, st
+}
+
+func bodyParagraphs(s string) []string {
+	var out []string
+	for _, l := range splitLines(s) {
+		if l != "" {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// diffTokens diffs two paragraphs over CJK characters and Latin words and
+// merges runs of the same op
+func diffTokens(a, b string) []TextDiff {
+	ta, tb := diffTokenize(a), diffTokenize(b)
+	if len(ta)*len(tb) > maxDiffCells {
+		return []TextDiff{{Op: DiffDelete, Text: a}, {Op: DiffInsert, Text: b}}
+	}
+	ops := lcsDiff(len(ta), len(tb), func(i, j int) bool { return ta[i] == tb[j] })
+	var out []TextDiff
+	push := func(op, text string) {
+		if n := len(out); n > 0 && out[n-1].Op == op {
+			out[n-1].Text += text
+			return
+		}
+		out = append(out, TextDiff{Op: op, Text: text})
+	}
+	i, j := 0, 0
+	for _, op := range ops {
+		switch op {
+		case DiffEqual:
+			push(DiffEqual, ta[i])
+			i++
+			j++
+		case DiffDelete:
+			push(DiffDelete, ta[i])
+			i++
+		case DiffInsert:
+			push(DiffInsert, tb[j])
+			j++
+		}
+	}
+	return out
+}
+
+func diffTokenize(s string) []string {
+	var out []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			out = append(out, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range s {
+		if !isCJK(r) && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			word.WriteRune(r)
+			continue
+		}
+		flush()
+		out = append(out, string(r))
+	}
+	flush()
+	return out
+}
+
+// lcsDiff returns the shortest edit script turning n items into m items
+func lcsDiff(n, m int, eq func(i, j int) bool) []string {
+	// trim the common prefix and suffix so the table only covers the changed middle
+	pre := 0
+	for pre < n && pre < m && eq(pre, pre) {
+		pre++
+	}
+	suf := 0
+	for suf < n-pre && suf < m-pre && eq(n-1-suf, m-1-suf) {
+		suf++
+	}
+	out := make([]string, 0, n+m)
+	for k := 0; k < pre; k++ {
+		out = append(out, DiffEqual)
+	}
+	a, b := n-pre-suf, m-pre-suf
+	if a*b > maxDiffCells {
+		for k := 0; k < a; k++ {
+			out = append(out, DiffDelete)
+		}
+		for k := 0; k < b; k++ {
+			out = append(out, DiffInsert)
+		}
+	} else {
+		// table[i][j] is the LCS length of the suffixes starting at i and j
+		table := make([][]int32, a+1)
+		for i := range table {
+			table[i] = make([]int32, b+1)
+		}
+		for i := a - 1; i >= 0; i-- {
+			for j := b - 1; j >= 0; j-- {
+				if eq(pre+i, pre+j) {
+					table[i][j] = table[i+1][j+1] + 1
+				} else if table[i+1][j] >= table[i][j+1] {
+					table[i][j] = table[i+1][j]
+				} else {
+					table[i][j] = table[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < a || j < b {
+			switch {
+			case i < a && j < b && eq(pre+i, pre+j):
+				out = append(out, DiffEqual)
+				i++
+				j++
+			case j == b || (i < a && table[i+1][j] >= table[i][j+1]):
+				out = append(out, DiffDelete)
+				i++
+			default:
+				out = append(out, DiffInsert)
+				j++
+			}
+		}
+	}
+	for k := 0; k < suf; k++ {
+		out = append(out, DiffEqual)
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+package novel
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLCSDiff(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want string
+	}{
+		{"", "", ""},
+		{"abc", "abc", "==="},
+		{"", "ab", "++"},
+		{"ab", "", "--"},
+		{"abc", "axc", "=-+="},
+		{"abcd", "acd", "=-=="},
+		{"acd", "abcd", "=+=="},
+		{"abc", "cab", "+==-"},
+		{"kitten", "sitting", "-+===-+=+"},
+	}
+	sym := map[string]string{DiffEqual: "=", DiffDelete: "-", DiffInsert: "+"}
+	for _, c := range cases {
+		ops := lcsDiff(len(c.a), len(c.b), func(i, j int) bool { return c.a[i] == c.b[j] })
+		var got strings.Builder
+		i, j := 0, 0
+		for _, op := range ops {
+			got.WriteString(sym[op])
+			switch op {
+			case DiffEqual:
+				if c.a[i] != c.b[j] {
+					t.Fatalf("%q -> %q: equal op pairs %c and %c", c.a, c.b, c.a[i], c.b[j])
+				}
+				i++
+				j++
+			case DiffDelete:
+				i++
+			case DiffInsert:
+				j++
+			}
+		}
+		if i != len(c.a) || j != len(c.b) {
+			t.Fatalf("%q -> %q: script covers %d/%d items", c.a, c.b, i, j)
+		}
+		if got.String() != c.want {
+			t.Errorf("%q -> %q = %s, want %s", c.a, c.b, got.String(), c.want)
+		}
+	}
+}
+
+func TestDiffTokens(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want []TextDiff
+	}{
+		{"他走了", "他跑了", []TextDiff{{DiffEqual, "他"}, {DiffDelete, "走"}, {DiffInsert, "跑"}, {DiffEqual, "了"}}},
+		{"the red fox", "the blue fox", []TextDiff{{DiffEqual, "the "}, {DiffDelete, "red"}, {DiffInsert, "blue"}, {DiffEqual, " fox"}}},
+		{"甲乙", "甲乙丙丁", []TextDiff{{DiffEqual, "甲乙"}, {DiffInsert, "丙丁"}}},
+	}
+	for _, c := range cases {
+		if got := diffTokens(c.a, c.b); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("diffTokens(%q, %q) = %+v, want %+v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestDiffText(t *testing.T) {
+	cases := []struct {
+		name  string
+		a, b  string
+		ops   []string
+		stats DiffStats
+	}{
+		{"same", "第一段\n\n第二段", "第一段\n第二段", []string{DiffEqual, DiffEqual}, DiffStats{}},
+		{"inserted paragraph", "第一段", "第一段\n新的一段", []string{DiffEqual, DiffInsert}, DiffStats{Added: 4}},
+		{"deleted paragraph", "第一段\n多余的\n第三段", "第一段\n第三段", []string{DiffEqual, DiffDelete, DiffEqual}, DiffStats{Removed: 3}},
+		{"replaced paragraph", "他走了。", "他慢慢走了。", []string{DiffReplace}, DiffStats{Added: 2}},
+		{"replace then insert", "旧的", "新的\n另起", []string{DiffReplace, DiffInsert}, DiffStats{Added: 3, Removed: 1}},
+		{"english words", "The cat sat.", "The dog sat down.", []string{DiffReplace}, DiffStats{Added: 2, Removed: 1}},
+	}
+	for _, c := range cases {
+		paras, st := DiffText(c.a, c.b)
+		var ops []string
+		for _, p := range paras {
+			ops = append(ops, p.Op)
+			if p.Op == DiffReplace && len(p.Chars) == 0 {
+				t.Errorf("%s: replaced paragraph without a breakdown", c.name)
+			}
+		}
+		if !reflect.DeepEqual(ops, c.ops) {
+			t.Errorf("%s: ops = %v, want %v", c.name, ops, c.ops)
+		}
+		if st != c.stats {
+			t.Errorf("%s: stats = %+v, want %+v", c.name, st, c.stats)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package novel
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const docxFallbackFont = "SimSun"
+
+type docxPart struct {
+	name string
+	data []byte
+}
+
+// WriteDOCX writes a WordprocessingML manuscript: a title page, a table of
+// contents linked to the chapter headings and each chapter starting on a
+// new page; when opts.Font is set it is embedded (obfuscated, as Word
+// expects) and used for all text
+func WriteDOCX(w io.Writer, b Book, opts ManuscriptOptions) error {
+	opts = opts.withDefaults()
+	lang := b.Language
+	if lang == "" {
+		lang = "zh"
+	}
+	fontName := docxFallbackFont
+	var fontKey string
+	if opts.Font != nil {
+		fontName = opts.Font.Name
+		fontKey = docxFontKey(b.ID + "\x00" + fontName)
+	}
+	created := b.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+
+	files := []docxPart{
+		{"[Content_Types].xml", []byte(docxContentTypes)},
+		{"_rels/.rels", []byte(docxRootRels)},
+		{"docProps/core.xml", []byte(docxCore(b.Outline.Title, lang, created))},
+		{"word/_rels/document.xml.rels", []byte(docxDocumentRels)},
+		{"word/styles.xml", []byte(docxStyles(fontName, lang, opts.FontSize))},
+		{"word/settings.xml", []byte(docxSettings(opts.Font != nil))},
+		{"word/fontTable.xml", []byte(docxFontTable(fontName, fontKey))},
+		{"word/document.xml", []byte(docxDocument(b, lang, opts))},
+	}
+	if opts.Font != nil {
+		files = append(files,
+			docxPart{"word/_rels/fontTable.xml.rels", []byte(docxFontRels)},
+			docxPart{"word/fonts/font1.odttf", obfuscateFont(opts.Font.Data, fontKey)},
+		)
+	}
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func docxDocument(b Book, lang string, opts ManuscriptOptions) string {
+	var s strings.Builder
+	s.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<w:body>
+`)
+	s.WriteString(`<w:p><w:pPr><w:pStyle w:val="Title"/><w:spacing w:before="3600"/></w:pPr>` + docxRun(b.Outline.Title) + "</w:p>\n")
+	if b.Summary != "" {
+		s.WriteString(`<w:p><w:pPr><w:pStyle w:val="Subtitle"/></w:pPr>` + docxRun(b.Summary) + "</w:p>\n")
+	}
+	s.WriteString(`<w:p><w:pPr><w:pStyle w:val="Subtitle"/></w:pPr>` + docxRun(GeneratorName) + "</w:p>\n")
+
+	// the TOC field lets Word refresh page numbers; the cached result lists
+	// linked chapter titles so the contents show up before any update
+	s.WriteString(`<w:p><w:pPr><w:pStyle w:val="TOCHeading"/><w:pageBreakBefore/></w:pPr>` + docxRun(tocTitle(lang)) + "</w:p>\n")
+	for i, c := range b.Chapters {
+		s.WriteString(`<w:p><w:pPr><w:pStyle w:val="TOC1"/></w:pPr>`)
+		if i == 0 {
+			s.WriteString(`<w:r><w:fldChar w:fldCharType="begin"/></w:r><w:r><w:instrText xml:space="preserve"> TOC \o "1-1" \h \z \u </w:instrText></w:r><w:r><w:fldChar w:fldCharType="separate"/></w:r>`)
+		}
+		fmt.Fprintf(&s, `<w:hyperlink w:anchor="%s" w:history="1">%s</w:hyperlink>`, docxBookmark(c.Index), docxRun(c.Title))
+		if i == len(b.Chapters)-1 {
+			s.WriteString(`<w:r><w:fldChar w:fldCharType="end"/></w:r>`)
+		}
+		s.WriteString("</w:p>\n")
+	}
+
+	for _, c := range b.Chapters {
+		fmt.Fprintf(&s, `<w:p><w:pPr><w:pStyle w:val="Heading1"/></w:pPr><w:bookmarkStart w:id="%d" w:name="%s"/>%s<w:bookmarkEnd w:id="%d"/></w:p>`+"\n",
+			c.Index, docxBookmark(c.Index), docxRun(c.Title), c.Index)
+		for _, p := range Paragraphs(c.Content) {
+			s.WriteString("<w:p>" + docxRun(p) + "</w:p>\n")
+		}
+	}
+
+	twips := func(pt float64) int { return int(pt*20 + 0.5) }
+	margin := 1440
+	if opts.PageSize.Width < 500 {
+		margin = 1080
+	}
+	fmt.Fprintf(&s, `<w:sectPr><w:pgSz w:w="%d" w:h="%d"/><w:pgMar w:top="%d" w:right="%d" w:bottom="%d" w:left="%d" w:header="720" w:footer="720" w:gutter="0"/><w:docGrid w:linePitch="312"/></w:sectPr>
+</w:body>
+</w:document>
+`, twips(opts.PageSize.Width), twips(opts.PageSize.Height), margin, margin, margin, margin)
+	return s.String()
+}
+
+func docxRun(text string) string {
+	return `<w:r><w:t xml:space="preserve">` + esc(text) + `</w:t></w:r>`
+}
+
+func docxBookmark(index int) string {
+	return fmt.Sprintf("_Chapter%04d", index)
+}
+
+func docxStyles(font, lang string, size float64) string {
+	halfPts := func(pt float64) int { return int(pt*2 + 0.5) }
+	f := esc(font)
+	eastAsia := "zh-CN"
+	if strings.HasPrefix(lang, "ja") {
+		eastAsia = "ja-JP"
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:docDefaults>
+<w:rPrDefault><w:rPr><w:rFonts w:ascii="%[1]s" w:hAnsi="%[1]s" w:eastAsia="%[1]s" w:cs="%[1]s"/><w:sz w:val="%[2]d"/><w:szCs w:val="%[2]d"/><w:lang w:val="%[3]s" w:eastAsia="%[4]s"/></w:rPr></w:rPrDefault>
+<w:pPrDefault><w:pPr><w:spacing w:after="0" w:line="360" w:lineRule="auto"/><w:jc w:val="both"/></w:pPr></w:pPrDefault>
+</w:docDefaults>
+<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/><w:pPr><w:ind w:firstLineChars="200" w:firstLine="%[5]d"/></w:pPr></w:style>
+<w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:ind w:firstLineChars="0" w:firstLine="0"/><w:jc w:val="center"/><w:spacing w:after="480"/></w:pPr><w:rPr><w:b/><w:sz w:val="%[6]d"/><w:szCs w:val="%[6]d"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Subtitle"><w:name w:val="Subtitle"/><w:basedOn w:val="Normal"/><w:pPr><w:ind w:firstLineChars="0" w:firstLine="0"/><w:jc w:val="center"/><w:spacing w:after="240"/></w:pPr><w:rPr><w:color w:val="666666"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:pageBreakBefore/><w:ind w:firstLineChars="0" w:firstLine="0"/><w:jc w:val="center"/><w:spacing w:before="480" w:after="480"/><w:outlineLvl w:val="0"/></w:pPr><w:rPr><w:b/><w:sz w:val="%[7]d"/><w:szCs w:val="%[7]d"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="TOCHeading"><w:name w:val="TOC Heading"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:ind w:firstLineChars="0" w:firstLine="0"/><w:jc w:val="center"/><w:spacing w:after="480"/></w:pPr><w:rPr><w:b/><w:sz w:val="%[7]d"/><w:szCs w:val="%[7]d"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="TOC1"><w:name w:val="toc 1"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:ind w:firstLineChars="0" w:firstLine="0"/><w:jc w:val="left"/></w:pPr></w:style>
+<w:style w:type="character" w:styleId="Hyperlink"><w:name w:val="Hyperlink"/></w:style>
+</w:styles>
+`, f, halfPts(size), "en-US", eastAsia, int(size*40+0.5), halfPts(size*2.2), halfPts(size*1.5))
+}
+
+func docxSettings(embed bool) string {
+	e := ""
+	if embed {
+		e = "<w:embedTrueTypeFonts/>"
+	}
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:settings xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` + e + `<w:defaultTabStop w:val="420"/><w:characterSpacingControl w:val="compressPunctuation"/></w:settings>
+`
+}
+
+func docxFontTable(font, key string) string {
+	embed := ""
+	if key != "" {
+		embed = `<w:embedRegular r:id="rIdFont1" w:fontKey="` + key + `"/>`
+	}
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:fonts xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<w:font w:name="` + esc(font) + `"><w:charset w:val="86"/><w:family w:val="auto"/><w:pitch w:val="variable"/>` + embed + `</w:font>
+</w:fonts>
+`
+}
+
+func docxCore(title, lang string, created time.Time) string {
+	ts := created.UTC().Format("2006-01-02T15:04:05Z")
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+<dc:title>` + esc(title) + `</dc:title>
+<dc:creator>` + esc(GeneratorName) + `</dc:creator>
+<dc:language>` + esc(lang) + `</dc:language>
+<dcterms:created xsi:type="dcterms:W3CDTF">` + ts + `</dcterms:created>
+<dcterms:modified xsi:type="dcterms:W3CDTF">` + ts + `</dcterms:modified>
+</cp:coreProperties>
+`
+}
+
+// docxFontKey derives a stable GUID used to obfuscate the embedded font
+func docxFontKey(seed string) string {
+	h := sha1.Sum([]byte(seed))
+	return strings.ToUpper(fmt.Sprintf("{%x-%x-%x-%x-%x}", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16]))
+}
+
+// obfuscateFont applies the ECMA-376 font obfuscation: the first 32 bytes
+// are XORed with the GUID key bytes in reverse order
+func obfuscateFont(data []byte, key string) []byte {
+	hex := strings.NewReplacer("{", "", "}", "", "-", "").Replace(key)
+	var k [16]byte
+	for i := 0; i < 16; i++ {
+		fmt.Sscanf(hex[30-2*i:32-2*i], "%02x", &k[i])
+	}
+	out := make([]byte, len(data))
+	copy(out, data)
+	for i := 0; i < 32 && i < len(out); i++ {
+		out[i] ^= k[i%16]
+	}
+	return out
+}
+
+const docxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Default Extension="odttf" ContentType="application/vnd.openxmlformats-officedocument.obfuscatedFont"/>
+<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>
+<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>
+<Override PartName="/word/settings.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.settings+xml"/>
+<Override PartName="/word/fontTable.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.fontTable+xml"/>
+<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>
+</Types>
+`
+
+const docxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties" Target="docProps/core.xml"/>
+</Relationships>
+`
+
+const docxDocumentRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/settings" Target="settings.xml"/>
+<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/fontTable" Target="fontTable.xml"/>
+</Relationships>
+`
+
+const docxFontRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rIdFont1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/font" Target="fonts/font1.odttf"/>
+</Relationships>
+`
@@ -0,0 +1,221 @@
+package novel
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+)
+
+const GeneratorName = "ai-reader"
+
+const epubCSS = `@charset "utf-8";
+body { margin: 0 5%; font-family: "Source Han Serif SC", "Noto Serif CJK SC", "Songti SC", "SimSun", serif; line-height: 1.8; text-align: justify; line-break: strict; word-break: normal; }
+h1 { font-size: 1.4em; text-align: center; margin: 2em 0 1.5em; font-weight: bold; }
+h2 { font-size: 1.15em; margin: 1.2em 0 .6em; }
+p { text-indent: 2em; margin: 0 0 .6em; }
+p.noindent { text-indent: 0; }
+.title-page { text-align: center; margin-top: 30%; }
+.title-page h1 { font-size: 2em; }
+.meta { text-indent: 0; text-align: center; color: #666; font-size: .9em; }
+nav ol { list-style: none; padding-left: 0; }
+nav li { margin: .3em 0; }
+`
+
+type epubItem struct {
+	id, href, title string
+}
+
+// WriteEPUB writes a self-contained EPUB 3 book with a nav document, an
+// NCX for EPUB 2 readers and a character appendix when characters are present
+func WriteEPUB(w io.Writer, b Book) error {
+	lang := b.Language
+	if lang == "" {
+		lang = "zh"
+	}
+	zw := zip.NewWriter(w)
+	mt, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mt, "application/epub+zip"); err != nil {
+		return err
+	}
+	files := map[string]string{}
+	order := []string{}
+	put := func(name, body string) {
+		files[name] = body
+		order = append(order, name)
+	}
+	put("META-INF/container.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`)
+	put("OEBPS/style.css", epubCSS)
+
+	var items []epubItem
+	title := b.Outline.Title
+	var tp strings.Builder
+	tp.WriteString(`<div class="title-page"><h1>` + esc(title) + `</h1>`)
+	if b.Summary != "" {
+		tp.WriteString(`<p class="meta">` + esc(b.Summary) + `</p>`)
+	}
+	tp.WriteString(`<p class="meta">` + esc(GeneratorName) + `</p></div>`)
+	put("OEBPS/title.xhtml", xhtmlPage(lang, title, tp.String()))
+	items = append(items, epubItem{id: "title", href: "title.xhtml", title: title})
+
+	for _, c := range b.Chapters {
+		var body strings.Builder
+		body.WriteString("<h1>" + esc(c.Title) + "</h1>\n")
+		for _, p := range Paragraphs(c.Content) {
+			body.WriteString("<p>" + esc(p) + "</p>\n")
+		}
+		href := fmt.Sprintf("chapter-%04d.xhtml", c.Index)
+		put("OEBPS/"+href, xhtmlPage(lang, c.Title, body.String()))
+		items = append(items, epubItem{id: fmt.Sprintf("ch%04d", c.Index), href: href, title: c.Title})
+	}
+
+	if len(b.Characters) > 0 {
+		var body strings.Builder
+		l := languageFor(lang)
+		heading := l.cast
+		body.WriteString("<h1>" + esc(heading) + "</h1>\n")
+		for _, c := range b.Characters {
+			body.WriteString("<h2>" + esc(c.Name))
+			if c.Role != "" {
+				body.WriteString(esc(l.parens[0] + c.Role + l.parens[1]))
+			}
+			body.WriteString("</h2>\n")
+			if len(c.Traits) > 0 {
+				body.WriteString(`<p class="noindent">` + esc(strings.Join([]string(c.Traits), l.list)) + "</p>\n")
+			}
+			if c.Background != "" {
+				body.WriteString("<p>" + esc(c.Background) + "</p>\n")
+			}
+		}
+		put("OEBPS/characters.xhtml", xhtmlPage(lang, heading, body.String()))
+		items = append(items, epubItem{id: "characters", href: "characters.xhtml", title: heading})
+	}
+
+	var nav strings.Builder
+	nav.WriteString(`<nav epub:type="toc" id="toc"><h1>` + esc(tocTitle(lang)) + "</h1>\n<ol>\n")
+	for _, it := range items[1:] {
+		nav.WriteString(`<li><a href="` + it.href + `">` + esc(it.title) + "</a></li>\n")
+	}
+	nav.WriteString("</ol>\n</nav>\n")
+	put("OEBPS/nav.xhtml", xhtmlPage(lang, tocTitle(lang), nav.String()))
+
+	uid := bookUUID(b.ID + "\x00" + title)
+	put("OEBPS/toc.ncx", ncx(uid, title, items))
+	put("OEBPS/content.opf", opf(b, uid, lang, items))
+
+	for _, name := range order {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, files[name]); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func opf(b Book, uid, lang string, items []epubItem) string {
+	modified := b.Modified
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+	var s strings.Builder
+	s.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid" xml:lang="` + esc(lang) + `">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="bookid">` + uid + `</dc:identifier>
+    <dc:title>` + esc(b.Outline.Title) + `</dc:title>
+    <dc:language>` + esc(lang) + `</dc:language>
+    <dc:creator>` + esc(GeneratorName) + `</dc:creator>
+`)
+	if b.Summary != "" {
+		s.WriteString("    <dc:description>" + esc(b.Summary) + "</dc:description>\n")
+	}
+	if !b.Created.IsZero() {
+		s.WriteString("    <dc:date>" + b.Created.UTC().Format("2006-01-02") + "</dc:date>\n")
+	}
+	s.WriteString(`    <meta property="dcterms:modified">` + modified.UTC().Format("2006-01-02T15:04:05Z") + `</meta>
+    <meta name="generator" content="` + esc(GeneratorName) + `"/>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="css" href="style.css" media-type="text/css"/>
+`)
+	for _, it := range items {
+		s.WriteString(`    <item id="` + it.id + `" href="` + it.href + `" media-type="application/xhtml+xml"/>` + "\n")
+	}
+	s.WriteString("  </manifest>\n  <spine toc=\"ncx\">\n")
+	for _, it := range items {
+		s.WriteString(`    <itemref idref="` + it.id + `"/>` + "\n")
+	}
+	s.WriteString("  </spine>\n</package>\n")
+	return s.String()
+}
+
+func ncx(uid, title string, items []epubItem) string {
+	var s strings.Builder
+	s.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head>
+    <meta name="dtb:uid" content="` + uid + `"/>
+    <meta name="dtb:depth" content="1"/>
+    <meta name="dtb:totalPageCount" content="0"/>
+    <meta name="dtb:maxPageNumber" content="0"/>
+  </head>
+  <docTitle><text>` + esc(title) + `</text></docTitle>
+  <navMap>
+`)
+	for i, it := range items {
+		s.WriteString(fmt.Sprintf(`    <navPoint id="np%d" playOrder="%d"><navLabel><text>%s</text></navLabel><content src="%s"/></navPoint>`+"\n", i+1, i+1, esc(it.title), it.href))
+	}
+	s.WriteString("  </navMap>\n</ncx>\n")
+	return s.String()
+}
+
+func xhtmlPage(lang, title, body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="` + esc(lang) + `" lang="` + esc(lang) + `">
+<head>
+<meta charset="utf-8"/>
+<title>` + esc(title) + `</title>
+<link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+` + body + `</body>
+</html>
+`
+}
+
+func bookUUID(seed string) string {
+	h := sha1.Sum([]byte(seed))
+	h[6] = (h[6] & 0x0f) | 0x50
+	h[8] = (h[8] & 0x3f) | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+func tocTitle(lang string) string {
+	return languageFor(lang).contents
+}
+
+func appendixTitle(lang string) string {
+	return languageFor(lang).cast
+}
+
+func esc(s string) string {
+	return html.EscapeString(s)
+}
@@ -0,0 +1,155 @@
+package novel
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testBook() Book {
+	return Book{
+		ID:      "job-1",
+		Outline: Outline{Title: `Tom & "Jerry" <1>`},
+		Characters: []Character{
+			{Name: "Tom", Role: "cat", Traits: []string{"quick", "vain"}, Background: "A house cat."},
+		},
+		Chapters: []ChapterContent{
+			{Index: 1, Title: "The Chase", Content: "He ran.\n\nShe <laughed>."},
+			{Index: 2, Title: "Truce", Content: "They rested."},
+		},
+		Language: LangEnglish,
+		Summary:  "A cat and a mouse.",
+		Created:  time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func readZip(t *testing.T, b []byte) (*zip.Reader, map[string]string) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+	return zr, files
+}
+
+func TestWriteEPUB(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteEPUB(&buf, testBook()); err != nil {
+		t.Fatal(err)
+	}
+	zr, files := readZip(t, buf.Bytes())
+	if first := zr.File[0]; first.Name != "mimetype" || first.Method != zip.Store || files["mimetype"] != "application/epub+zip" {
+		t.Fatalf("first entry = %s (method %d)", first.Name, first.Method)
+	}
+	for _, name := range []string{"META-INF/container.xml", "OEBPS/content.opf", "OEBPS/nav.xhtml", "OEBPS/toc.ncx", "OEBPS/style.css", "OEBPS/title.xhtml", "OEBPS/chapter-0001.xhtml", "OEBPS/chapter-0002.xhtml", "OEBPS/characters.xhtml"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("missing %s", name)
+		}
+	}
+	for name, body := range files {
+		if !strings.HasSuffix(name, ".xhtml") && !strings.HasSuffix(name, ".opf") && !strings.HasSuffix(name, ".ncx") && !strings.HasSuffix(name, ".xml") {
+			continue
+		}
+		dec := xml.NewDecoder(strings.NewReader(body))
+		for {
+			if _, err := dec.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s is not well-formed: %v", name, err)
+			}
+		}
+	}
+	opf := files["OEBPS/content.opf"]
+	for _, want := range []string{"<dc:language>en</dc:language>", "<dc:title>Tom &amp; &#34;Jerry&#34; &lt;1&gt;</dc:title>", "<dc:date>2024-05-01</dc:date>", "<dc:description>A cat and a mouse.</dc:description>", `properties="nav"`} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("content.opf lacks %s", want)
+		}
+	}
+	spine := regexp.MustCompile(`idref="([^"]+)"`).FindAllStringSubmatch(opf, -1)
+	var order []string
+	for _, m := range spine {
+		order = append(order, m[1])
+	}
+	if want := []string{"title", "ch0001", "ch0002", "characters"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("spine = %v, want %v", order, want)
+	}
+	nav := files["OEBPS/nav.xhtml"]
+	if !strings.Contains(nav, "<h1>Contents</h1>") || strings.Index(nav, "The Chase") > strings.Index(nav, "Truce") || !strings.Contains(nav, "Characters") {
+		t.Errorf("nav = %s", nav)
+	}
+	if ch := files["OEBPS/chapter-0001.xhtml"]; !strings.Contains(ch, "<p>She &lt;laughed&gt;.</p>") || !strings.Contains(ch, `xml:lang="en"`) {
+		t.Errorf("chapter 1 = %s", ch)
+	}
+	if cast := files["OEBPS/characters.xhtml"]; !strings.Contains(cast, "<h2>Tom (cat)</h2>") || !strings.Contains(cast, "quick, vain") {
+		t.Errorf("characters = %s", cast)
+	}
+	// the identifier is stable for the same book
+	var again bytes.Buffer
+	_ = WriteEPUB(&again, testBook())
+	_, files2 := readZip(t, again.Bytes())
+	id := regexp.MustCompile(`urn:uuid:[0-9a-f-]+`)
+	if id.FindString(opf) == "" || id.FindString(opf) != id.FindString(files2["OEBPS/content.opf"]) {
+		t.Errorf("book identifier changed between exports")
+	}
+}
+
+func TestWriteEPUBDefaults(t *testing.T) {
+	b := testBook()
+	b.Language, b.Characters = "", nil
+	var buf bytes.Buffer
+	if err := WriteEPUB(&buf, b); err != nil {
+		t.Fatal(err)
+	}
+	_, files := readZip(t, buf.Bytes())
+	if !strings.Contains(files["OEBPS/content.opf"], "<dc:language>zh</dc:language>") {
+		t.Error("language does not default to zh")
+	}
+	if _, ok := files["OEBPS/characters.xhtml"]; ok {
+		t.Error("appendix written without characters")
+	}
+	if !strings.Contains(files["OEBPS/nav.xhtml"], "<h1>目录</h1>") {
+		t.Error("chinese contents title missing")
+	}
+}
+
+func TestBookRange(t *testing.T) {
+	b := Book{Chapters: []ChapterContent{{Index: 1}, {Index: 2}, {Index: 3}, {Index: 4}}}
+	cases := []struct {
+		from, to int
+		want     []int
+	}{
+		{0, 0, []int{1, 2, 3, 4}},
+		{2, 3, []int{2, 3}},
+		{3, 0, []int{3, 4}},
+		{0, 1, []int{1}},
+		{5, 0, nil},
+	}
+	for _, c := range cases {
+		var got []int
+		for _, ch := range b.Range(c.from, c.to).Chapters {
+			got = append(got, ch.Index)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Range(%d, %d) = %v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+	if len(b.Chapters) != 4 {
+		t.Fatal("Range changed the book")
+	}
+}
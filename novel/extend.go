@@ -0,0 +1,122 @@
+package novel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// extendContextChapters is how many of the latest chapter plans an
+// extension continues from
+const extendContextChapters = 10
+
+// Extension is the result of appending chapters to a book
+type Extension struct {
+	Threads  []string  `json:"threads"`
+	Chapters []Chapter `json:"chapters"`
+	Plans    []Chapter `json:"plans"`
+}
+
+// ExtendOutline appends count chapters to a book: the model continues from
+// the latest chapter plans, the end of the latest written chapter and the
+// plot threads still open, and direction steers where the story goes next.
+// Existing outline entries and plans are kept as they are; in a volume
+// outline the new chapters join the last volume
+func (g *Generator) ExtendOutline(ctx context.Context, spec Spec, outline Outline, plans []Chapter, latest *ChapterContent, count int, direction string) (Outline, []Chapter, Extension, error) {
+	if count <= 0 {
+		return Outline{}, nil, Extension{}, fmt.Errorf("count must be positive")
+	}
+	SyncVolumes(&outline)
+	next := 1
+	for _, ch := range outline.Chapters {
+		if ch.Index >= next {
+			next = ch.Index + 1
+		}
+	}
+	for _, p := range plans {
+		if p.Index >= next {
+			next = p.Index + 1
+		}
+	}
+	recent := plans
+	if len(recent) == 0 {
+		recent = outline.Chapters
+	}
+	if len(recent) > extendContextChapters {
+		recent = recent[len(recent)-extendContextChapters:]
+	}
+
+	d := g.seriesData(spec)
+	d.Outline, d.Recent, d.Count, d.Next, d.Direction = outline, recent, count, next, strings.TrimSpace(direction)
+	if n := len(outline.Volumes); n > 0 {
+		d.Volume = outline.Volumes[n-1]
+	}
+	if latest != nil {
+		d.Chapter = *latest
+	}
+	if g.PersistDir != "" {
+		if l, err := LoadThreads(g.PersistDir); err == nil {
+			d.Threads = l.OpenAt(next)
+		}
+	}
+	sys, user, err := g.prompt(spec, "extend", d)
+	if err != nil {
+		return Outline{}, nil, Extension{}, err
+	}
+	reqCtx := ctx
+	var cancel func()
+	if g.RequestTimeoutSec > 0 {
+		reqCtx, cancel = context.WithTimeout(ctx, time.Duration(g.RequestTimeoutSec)*time.Second)
+	}
+	out, err := g.chatWithRetry(reqCtx, spec.Model, sys, user)
+	if cancel != nil {
+		cancel()
+	}
+	if err != nil {
+		return Outline{}, nil, Extension{}, err
+	}
+	var ext Extension
+	if err := json.Unmarshal([]byte(extractJSON(out)), &ext); err != nil {
+		return Outline{}, nil, Extension{}, err
+	}
+	if len(ext.Chapters) == 0 {
+		return Outline{}, nil, Extension{}, fmt.Errorf("extension has no chapters")
+	}
+	if ext.Threads == nil {
+		ext.Threads = []string{}
+	}
+	if len(ext.Chapters) > count {
+		ext.Chapters = ext.Chapters[:count]
+	}
+	vol := 0
+	if n := len(outline.Volumes); n > 0 {
+		vol = outline.Volumes[n-1].Index
+	}
+	for i := range ext.Chapters {
+		ext.Chapters[i].Index = next + i
+		ext.Chapters[i].Volume = vol
+	}
+	outline.Chapters = append(outline.Chapters, ext.Chapters...)
+	SyncVolumes(&outline)
+	op := g.provenance(StageOutline)
+
+	ext.Plans, err = g.generateChapterPlans(ctx, spec, Outline{Title: outline.Title, Chapters: ext.Chapters})
+	if err != nil {
+		return Outline{}, nil, Extension{}, err
+	}
+	plans = append(append([]Chapter(nil), plans...), ext.Plans...)
+	if g.PersistDir != "" {
+		if err := persistOutline(g.PersistDir, outline, op); err != nil {
+			return Outline{}, nil, Extension{}, err
+		}
+		if err := persistPlans(g.PersistDir, plans, g.provenance(StagePlans)); err != nil {
+			return Outline{}, nil, Extension{}, err
+		}
+	}
+	if g.Log != nil {
+		g.Log(fmt.Sprintf("[续写大纲] 新增第%d-%d章 线索=%s", next, next+len(ext.Chapters)-1, strings.Join(ext.Threads, "；")))
+	}
+	return outline, plans, ext, nil
+}
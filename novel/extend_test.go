@@ -0,0 +1,151 @@
+package novel
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// scriptClient answers calls with its replies in order and records the user
+// prompts it was sent
+type scriptClient struct {
+	replies []string
+	users   []string
+}
+
+func (c *scriptClient) Chat(ctx context.Context, model, system, user string) (string, error) {
+	c.users = append(c.users, user)
+	if len(c.users) > len(c.replies) {
+		return "", context.DeadlineExceeded
+	}
+	return c.replies[len(c.users)-1], nil
+}
+
+func readChapters(t *testing.T, dir, name string) []Chapter {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name == "outline.json" {
+		var o Outline
+		if err := json.Unmarshal(b, &o); err != nil {
+			t.Fatal(err)
+		}
+		return o.Chapters
+	}
+	var plans []Chapter
+	if err := json.Unmarshal(b, &plans); err != nil {
+		t.Fatal(err)
+	}
+	return plans
+}
+
+func chapterIndexes(chapters []Chapter) (idx, vol []int) {
+	for _, ch := range chapters {
+		idx = append(idx, ch.Index)
+		vol = append(vol, ch.Volume)
+	}
+	return idx, vol
+}
+
+func TestExtendOutline(t *testing.T) {
+	flat := Outline{Title: "t", Chapters: []Chapter{{Index: 1, Title: "a"}, {Index: 2, Title: "b"}}}
+	volumes := Outline{Title: "t",
+		Volumes:  []Volume{{Title: "一", Chapters: 1}, {Title: "二", Chapters: 1}},
+		Chapters: []Chapter{{Index: 1, Title: "a", Volume: 1}, {Index: 2, Title: "b", Volume: 2}},
+	}
+	extension := `{"threads":["旧约"],"chapters":[{"title":"c","goal":"g"},{"title":"d"},{"title":"e"}]}`
+	plans := `[{"title":"c"},{"title":"d"}]`
+	cases := []struct {
+		name    string
+		outline Outline
+		plans   []Chapter
+		count   int
+		replies []string
+		idx     []int
+		vol     []int
+		planIdx []int
+		err     string
+	}{
+		{"flat outline", flat, []Chapter{{Index: 1}, {Index: 2}}, 2, []string{extension, plans},
+			[]int{1, 2, 3, 4}, []int{0, 0, 0, 0}, []int{1, 2, 3, 4}, ""},
+		{"joins the last volume", volumes, []Chapter{{Index: 1}, {Index: 2}}, 2, []string{extension, plans},
+			[]int{1, 2, 3, 4}, []int{1, 2, 2, 2}, []int{1, 2, 3, 4}, ""},
+		{"continues after the last plan", flat, []Chapter{{Index: 1}, {Index: 2}, {Index: 3}}, 1, []string{extension, `[{"title":"c"}]`},
+			[]int{1, 2, 4}, []int{0, 0, 0}, []int{1, 2, 3, 4}, ""},
+		{"no chapters", flat, nil, 2, []string{`{"threads":[],"chapters":[]}`}, nil, nil, nil, "no chapters"},
+		{"count must be positive", flat, nil, 0, nil, nil, nil, nil, "positive"},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		client := &scriptClient{replies: c.replies}
+		g := NewGenerator(client).WithPersistDir(dir)
+		g.RetryCount = 1
+		latest := &ChapterContent{Index: 2, Title: "b", Content: "最后一句。"}
+		outline, all, ext, err := g.ExtendOutline(context.Background(), Spec{}, c.outline, c.plans, latest, c.count, " 向北 ")
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: err = %v, want %q", c.name, err, c.err)
+			}
+			if _, serr := os.Stat(filepath.Join(dir, "outline.json")); serr == nil {
+				t.Errorf("%s: failed extension wrote the outline", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		idx, vol := chapterIndexes(outline.Chapters)
+		if !reflect.DeepEqual(idx, c.idx) || !reflect.DeepEqual(vol, c.vol) {
+			t.Errorf("%s: chapters %v in volumes %v, want %v in %v", c.name, idx, vol, c.idx, c.vol)
+		}
+		if len(ext.Chapters) != c.count || !reflect.DeepEqual(ext.Threads, []string{"旧约"}) {
+			t.Errorf("%s: extension = %+v, want %d chapters", c.name, ext, c.count)
+		}
+		if got, _ := chapterIndexes(all); !reflect.DeepEqual(got, c.planIdx) {
+			t.Errorf("%s: plans = %v, want %v", c.name, got, c.planIdx)
+		}
+		if got, _ := chapterIndexes(readChapters(t, dir, "outline.json")); !reflect.DeepEqual(got, c.idx) {
+			t.Errorf("%s: outline.json chapters = %v, want %v", c.name, got, c.idx)
+		}
+		if got, _ := chapterIndexes(readChapters(t, dir, "plans.json")); !reflect.DeepEqual(got, c.planIdx) {
+			t.Errorf("%s: plans.json = %v, want %v", c.name, got, c.planIdx)
+		}
+		if ext.Plans[0].Goal != "g" {
+			t.Errorf("%s: plan of chapter %d lost its goal", c.name, ext.Plans[0].Index)
+		}
+		if p := client.users[0]; !strings.Contains(p, "最后一句。") || !strings.Contains(p, "向北") {
+			t.Errorf("%s: prompt misses the latest chapter or the direction:\n%s", c.name, p)
+		}
+	}
+}
+
+func TestExtendOutlinePassesOpenThreads(t *testing.T) {
+	dir := t.TempDir()
+	l := ThreadLedger{Threads: []PlotThread{
+		{ID: "T1", Summary: "失踪的玉佩", Opened: 1},
+		{ID: "T2", Summary: "已了结的婚约", Opened: 1, Resolved: 2},
+	}}
+	if err := persistThreads(dir, l, Provenance{}); err != nil {
+		t.Fatal(err)
+	}
+	client := &scriptClient{replies: []string{`{"chapters":[{"title":"c"}]}`, `[{"title":"c"}]`}}
+	g := NewGenerator(client).WithPersistDir(dir)
+	outline := Outline{Title: "t", Chapters: []Chapter{{Index: 1}, {Index: 2}}}
+	_, _, ext, err := g.ExtendOutline(context.Background(), Spec{}, outline, nil, nil, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext.Threads == nil {
+		t.Error("missing threads should decode as an empty list")
+	}
+	if p := client.users[0]; !strings.Contains(p, "T1") || strings.Contains(p, "T2") {
+		t.Errorf("prompt should list only the open thread:\n%s", p)
+	}
+}
@@ -0,0 +1,118 @@
+package novel
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ChapterFileName is the on-disk name of a chapter markdown file
+func ChapterFileName(index int, title string) string {
+	return chapterPrefix(index) + "_" + safeFileName(title) + ".md"
+}
+
+// RenumberChapterFile keeps the title part of a chapter file name and
+// replaces its index
+func RenumberChapterFile(name string, index int) string {
+	i := strings.IndexByte(name, '_')
+	if i < 0 {
+		return chapterPrefix(index) + "_" + name
+	}
+	return chapterPrefix(index) + name[i:]
+}
+
+// chapterPrefix zero-pads chapter indexes to four digits so that file
+// names of serials past chapter 99 still sort in reading order
+func chapterPrefix(index int) string {
+	return fmt.Sprintf("%04d", index)
+}
+
+// legacyChapterPrefix is the two-digit prefix of jobs written before
+// chapter numbers were widened
+func legacyChapterPrefix(index int) string {
+	return fmt.Sprintf("%02d", index)
+}
+
+// ChapterIndexFromFile parses the leading index of a chapter file name
+func ChapterIndexFromFile(name string) (int, bool) {
+	if !strings.HasSuffix(name, ".md") {
+		return 0, false
+	}
+	i := strings.IndexByte(name, '_')
+	if i <= 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(name[:i])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// ChapterFiles maps chapter index to file name for every chapter file in dir
+func ChapterFiles(dir string) map[int]string {
+	out := map[int]string{}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return out
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if n, ok := ChapterIndexFromFile(f.Name()); ok {
+			out[n] = f.Name()
+		}
+	}
+	return out
+}
+
+// FindChapterFile returns the path of chapter index inside dir
+func FindChapterFile(dir string, index int) (string, bool) {
+	name, ok := ChapterFiles(dir)[index]
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(dir, name), true
+}
+
+// ParseChapterFile splits a persisted chapter into its "# title" heading and body
+func ParseChapterFile(b []byte) (string, string) {
+	s := strings.TrimPrefix(string(b), "\ufeff")
+	if !strings.HasPrefix(s, "# ") {
+		return "", s
+	}
+	nl := strings.IndexByte(s, '\n')
+	if nl < 0 {
+		return strings.TrimSpace(s[2:]), ""
+	}
+	return strings.TrimSpace(s[2:nl]), strings.TrimLeft(s[nl+1:], "\n")
+}
+
+// PlainText drops the markdown markup the models tend to emit in chapter bodies
+func PlainText(s string) string {
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	for _, l := range lines {
+		t := strings.TrimSpace(l)
+		if t == "---" || t == "***" || strings.HasPrefix(t, "```") {
+			continue
+		}
+		t = strings.TrimLeft(t, "#>")
+		t = strings.ReplaceAll(t, "**", "")
+		t = strings.ReplaceAll(t, "__", "")
+		out = append(out, strings.TrimSpace(t))
+	}
+	return strings.Join(out, "\n")
+}
+
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return lines
+}
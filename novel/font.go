@@ -0,0 +1,352 @@
+package novel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// Font is the subset of a TrueType font the manuscript exporters need:
+// metrics for layout and the raw file for embedding
+type Font struct {
+	Name        string // family name, used by DOCX
+	PSName      string // PostScript name, used by PDF
+	Data        []byte // standalone sfnt (collections are unpacked)
+	UnitsPerEm  int
+	Ascent      int
+	Descent     int
+	CapHeight   int
+	BBox        [4]int
+	ItalicAngle float64
+	Fixed       bool
+
+	cmap   map[rune]uint16
+	widths []uint16
+}
+
+// LoadFont reads a .ttf, or the first font of a .ttc collection; only
+// TrueType outlines are supported because PDF embeds them directly
+func LoadFont(path string) (*Font, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) >= 12 && string(data[:4]) == "ttcf" {
+		if data, err = unpackCollection(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	f, err := parseFont(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// GlyphID returns the glyph for r, 0 (.notdef) when the font lacks it
+func (f *Font) GlyphID(r rune) uint16 {
+	return f.cmap[r]
+}
+
+// Advance returns the advance width of r in font units
+func (f *Font) Advance(r rune) int {
+	return f.glyphAdvance(f.GlyphID(r))
+}
+
+func (f *Font) glyphAdvance(g uint16) int {
+	if len(f.widths) == 0 {
+		return f.UnitsPerEm
+	}
+	if int(g) < len(f.widths) {
+		return int(f.widths[g])
+	}
+	return int(f.widths[len(f.widths)-1])
+}
+
+// Width returns the width of s in points at the given size
+func (f *Font) Width(s string, size float64) float64 {
+	w := 0
+	for _, r := range s {
+		w += f.Advance(r)
+	}
+	return float64(w) * size / float64(f.UnitsPerEm)
+}
+
+type sfntTable struct {
+	tag      string
+	checksum uint32
+	data     []byte
+}
+
+func readTables(data []byte, off int) ([]sfntTable, error) {
+	if off+12 > len(data) {
+		return nil, errors.New("truncated font header")
+	}
+	n := int(binary.BigEndian.Uint16(data[off+4:]))
+	if off+12+16*n > len(data) {
+		return nil, errors.New("truncated table directory")
+	}
+	tables := make([]sfntTable, 0, n)
+	for i := 0; i < n; i++ {
+		rec := data[off+12+16*i:]
+		start := int(binary.BigEndian.Uint32(rec[8:]))
+		length := int(binary.BigEndian.Uint32(rec[12:]))
+		if start < 0 || length < 0 || start+length > len(data) {
+			return nil, fmt.Errorf("table %q out of range", rec[:4])
+		}
+		tables = append(tables, sfntTable{tag: string(rec[:4]), checksum: binary.BigEndian.Uint32(rec[4:]), data: data[start : start+length]})
+	}
+	return tables, nil
+}
+
+// unpackCollection rebuilds the first font of a TrueType collection as a
+// standalone sfnt so it can be embedded on its own
+func unpackCollection(data []byte) ([]byte, error) {
+	if binary.BigEndian.Uint32(data[8:]) == 0 {
+		return nil, errors.New("empty font collection")
+	}
+	off := int(binary.BigEndian.Uint32(data[12:]))
+	if off+12 > len(data) {
+		return nil, errors.New("truncated font collection")
+	}
+	tables, err := readTables(data, off)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].tag < tables[j].tag })
+	n := len(tables)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= n {
+		entrySelector++
+	}
+	searchRange := (1 << entrySelector) * 16
+	out := make([]byte, 12+16*n)
+	copy(out, data[off:off+4])
+	binary.BigEndian.PutUint16(out[4:], uint16(n))
+	binary.BigEndian.PutUint16(out[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(out[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(out[10:], uint16(n*16-searchRange))
+	for i, t := range tables {
+		rec := out[12+16*i:]
+		copy(rec, t.tag)
+		binary.BigEndian.PutUint32(rec[4:], t.checksum)
+		binary.BigEndian.PutUint32(rec[8:], uint32(len(out)))
+		binary.BigEndian.PutUint32(rec[12:], uint32(len(t.data)))
+		out = append(out, t.data...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	return out, nil
+}
+
+func parseFont(data []byte) (*Font, error) {
+	tables, err := readTables(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	byTag := map[string][]byte{}
+	for _, t := range tables {
+		byTag[t.tag] = t.data
+	}
+	if _, ok := byTag["glyf"]; !ok {
+		if _, cff := byTag["CFF "]; cff {
+			return nil, errors.New("CFF-based OpenType fonts are not supported, use a TrueType (.ttf/.ttc) font")
+		}
+		return nil, errors.New("no glyf table")
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "cmap", "maxp"} {
+		if _, ok := byTag[tag]; !ok {
+			return nil, fmt.Errorf("missing %s table", tag)
+		}
+	}
+	f := &Font{Data: data}
+	head := byTag["head"]
+	if len(head) < 54 {
+		return nil, errors.New("short head table")
+	}
+	f.UnitsPerEm = int(binary.BigEndian.Uint16(head[18:]))
+	if f.UnitsPerEm == 0 {
+		f.UnitsPerEm = 1000
+	}
+	for i := 0; i < 4; i++ {
+		f.BBox[i] = int(int16(binary.BigEndian.Uint16(head[36+2*i:])))
+	}
+	hhea := byTag["hhea"]
+	if len(hhea) < 36 {
+		return nil, errors.New("short hhea table")
+	}
+	f.Ascent = int(int16(binary.BigEndian.Uint16(hhea[4:])))
+	f.Descent = int(int16(binary.BigEndian.Uint16(hhea[6:])))
+	f.CapHeight = f.Ascent
+	if os2 := byTag["OS/2"]; len(os2) >= 10 {
+		if binary.BigEndian.Uint16(os2[8:])&0x000f == 0x0002 {
+			return nil, errors.New("font license does not allow embedding")
+		}
+		if len(os2) >= 90 && binary.BigEndian.Uint16(os2) >= 2 {
+			f.CapHeight = int(int16(binary.BigEndian.Uint16(os2[88:])))
+		}
+	}
+	if post := byTag["post"]; len(post) >= 16 {
+		f.ItalicAngle = float64(int32(binary.BigEndian.Uint32(post[4:]))) / 65536
+		f.Fixed = binary.BigEndian.Uint32(post[12:]) != 0
+	}
+	if len(byTag["maxp"]) < 6 {
+		return nil, errors.New("short maxp table")
+	}
+	numGlyphs := int(binary.BigEndian.Uint16(byTag["maxp"][4:]))
+	numH := int(binary.BigEndian.Uint16(hhea[34:]))
+	hmtx := byTag["hmtx"]
+	if numH == 0 || numH > numGlyphs || 4*numH > len(hmtx) {
+		return nil, errors.New("bad hmtx table")
+	}
+	f.widths = make([]uint16, numH)
+	for i := range f.widths {
+		f.widths[i] = binary.BigEndian.Uint16(hmtx[4*i:])
+	}
+	if f.cmap, err = parseCmap(byTag["cmap"]); err != nil {
+		return nil, err
+	}
+	f.Name, f.PSName = parseNames(byTag["name"])
+	if f.PSName == "" {
+		f.PSName = strings.ReplaceAll(f.Name, " ", "")
+	}
+	if f.PSName == "" {
+		f.PSName = "EmbeddedFont"
+	}
+	if f.Name == "" {
+		f.Name = f.PSName
+	}
+	return f, nil
+}
+
+// parseCmap reads the Unicode subtable, preferring the full-repertoire
+// format 12 over the BMP-only format 4
+func parseCmap(b []byte) (map[rune]uint16, error) {
+	if len(b) < 4 {
+		return nil, errors.New("short cmap table")
+	}
+	best, bestOff := 0, -1
+	n := int(binary.BigEndian.Uint16(b[2:]))
+	for i := 0; i < n && 4+8*i+8 <= len(b); i++ {
+		rec := b[4+8*i:]
+		pid, eid := binary.BigEndian.Uint16(rec), binary.BigEndian.Uint16(rec[2:])
+		off := int(binary.BigEndian.Uint32(rec[4:]))
+		if off+2 > len(b) {
+			continue
+		}
+		rank := 0
+		switch format := binary.BigEndian.Uint16(b[off:]); {
+		case format == 12 && (pid == 3 && eid == 10 || pid == 0):
+			rank = 3
+		case format == 4 && (pid == 3 && eid == 1 || pid == 0):
+			rank = 2
+		}
+		if rank > best {
+			best, bestOff = rank, off
+		}
+	}
+	if bestOff < 0 {
+		return nil, errors.New("no Unicode cmap subtable")
+	}
+	m := map[rune]uint16{}
+	t := b[bestOff:]
+	if best == 3 {
+		if len(t) < 16 {
+			return nil, errors.New("short cmap format 12")
+		}
+		groups := int(binary.BigEndian.Uint32(t[12:]))
+		for i := 0; i < groups && 16+12*i+12 <= len(t); i++ {
+			g := t[16+12*i:]
+			start, end, gid := binary.BigEndian.Uint32(g), binary.BigEndian.Uint32(g[4:]), binary.BigEndian.Uint32(g[8:])
+			for c := start; c <= end && c <= 0x10ffff; c++ {
+				m[rune(c)] = uint16(gid + c - start)
+			}
+		}
+		return m, nil
+	}
+	if len(t) < 14 {
+		return nil, errors.New("short cmap format 4")
+	}
+	segs := int(binary.BigEndian.Uint16(t[6:])) / 2
+	if 16+8*segs > len(t) {
+		return nil, errors.New("truncated cmap format 4")
+	}
+	ends, starts := t[14:], t[16+2*segs:]
+	deltas, ranges := t[16+4*segs:], t[16+6*segs:]
+	for i := 0; i < segs; i++ {
+		end := int(binary.BigEndian.Uint16(ends[2*i:]))
+		start := int(binary.BigEndian.Uint16(starts[2*i:]))
+		delta := binary.BigEndian.Uint16(deltas[2*i:])
+		ro := int(binary.BigEndian.Uint16(ranges[2*i:]))
+		for c := start; c <= end && c != 0xffff; c++ {
+			var g uint16
+			if ro == 0 {
+				g = uint16(c) + delta
+			} else {
+				p := 16 + 6*segs + 2*i + ro + 2*(c-start)
+				if p+2 > len(t) {
+					continue
+				}
+				if g = binary.BigEndian.Uint16(t[p:]); g != 0 {
+					g += delta
+				}
+			}
+			if g != 0 {
+				m[rune(c)] = g
+			}
+		}
+	}
+	return m, nil
+}
+
+// parseNames returns the family and PostScript names, preferring Windows
+// English records
+func parseNames(b []byte) (family, ps string) {
+	if len(b) < 6 {
+		return "", ""
+	}
+	n := int(binary.BigEndian.Uint16(b[2:]))
+	strOff := int(binary.BigEndian.Uint16(b[4:]))
+	score := map[uint16]int{}
+	for i := 0; i < n && 6+12*i+12 <= len(b); i++ {
+		rec := b[6+12*i:]
+		pid, lang, id := binary.BigEndian.Uint16(rec), binary.BigEndian.Uint16(rec[4:]), binary.BigEndian.Uint16(rec[6:])
+		length, off := int(binary.BigEndian.Uint16(rec[8:])), int(binary.BigEndian.Uint16(rec[10:]))
+		if id != 1 && id != 6 || strOff+off+length > len(b) {
+			continue
+		}
+		raw := b[strOff+off : strOff+off+length]
+		var s string
+		rank := 0
+		switch pid {
+		case 3, 0:
+			u := make([]uint16, len(raw)/2)
+			for k := range u {
+				u[k] = binary.BigEndian.Uint16(raw[2*k:])
+			}
+			s = string(utf16.Decode(u))
+			rank = 2
+			if pid == 3 && lang == 0x409 {
+				rank = 3
+			}
+		case 1:
+			s = string(raw)
+			rank = 1
+		}
+		if s == "" || rank <= score[id] {
+			continue
+		}
+		score[id] = rank
+		if id == 1 {
+			family = s
+		} else {
+			ps = s
+		}
+	}
+	return family, ps
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,6 +20,23 @@ type Generator struct {
 	RequestTimeoutSec int
 	RetryCount        int
 	RetryBackoffMs    int
+	// Series is the shared canon of the project the book belongs to
+	Series *Series
+	// Paused is polled after every artifact stage; the pipeline stops with
+	// a *PauseError once it reports true
+	Paused func() bool
+
+	traceMu sync.Mutex
+	trace   promptTrace
+}
+
+// promptTrace accumulates the model calls behind the next persisted artifact
+type promptTrace struct {
+	model     string
+	hash      string
+	calls     int
+	attempts  int
+	templates []string
 }
 
 func NewGenerator(cli ChatClient) *Generator {
@@ -47,18 +66,111 @@ func (g *Generator) WithRequestPolicy(timeoutSec, retries, backoffMs int) *Gener
 	return g
 }
 
+func (g *Generator) WithPause(paused func() bool) *Generator {
+	g.Paused = paused
+	return g
+}
+
 func (g *Generator) WithFinalBaseDir(dir string) *Generator {
 	g.FinalBaseDir = dir
 	return g
 }
 
+func (g *Generator) chat(ctx context.Context, model, sys, user string) (string, error) {
+	g.tracePrompt(model, sys, user)
+	return g.Client.Chat(ctx, model, sys, user)
+}
+
+// chatWithRetry makes up to RetryCount attempts, RetryBackoffMs apart, and
+// traces each of them
+func (g *Generator) chatWithRetry(ctx context.Context, model, sys, user string) (string, error) {
+	g.tracePrompt(model, sys, user)
+	attempts := g.RetryCount
+	if attempts <= 0 {
+		attempts = 1
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(time.Duration(g.RetryBackoffMs) * time.Millisecond):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			g.traceRetry()
+		}
+		var out string
+		if out, err = g.Client.Chat(ctx, model, sys, user); err == nil {
+			return out, nil
+		}
+	}
+	return "", err
+}
+
+func (g *Generator) resetTrace() {
+	g.traceMu.Lock()
+	g.trace = promptTrace{}
+	g.traceMu.Unlock()
+}
+
+// traceTemplate records a prompt template version the next artifact is
+// generated with
+func (g *Generator) traceTemplate(version string) {
+	g.traceMu.Lock()
+	if !containsString(g.trace.templates, version) {
+		g.trace.templates = append(g.trace.templates, version)
+	}
+	g.traceMu.Unlock()
+}
+
+func (g *Generator) tracePrompt(model, sys, user string) {
+	g.traceMu.Lock()
+	g.trace.model = model
+	g.trace.hash = promptHash(sys, user)
+	g.trace.calls++
+	g.trace.attempts++
+	g.traceMu.Unlock()
+}
+
+func (g *Generator) traceRetry() {
+	g.traceMu.Lock()
+	g.trace.attempts++
+	g.traceMu.Unlock()
+}
+
+// temperature is the sampling temperature of clients that report it
+func (g *Generator) temperature() *float64 {
+	c, ok := g.Client.(interface{ Temperature() float64 })
+	if !ok {
+		return nil
+	}
+	t := c.Temperature()
+	return &t
+}
+
+// provenance hands the calls traced since the last persisted artifact to stage
+func (g *Generator) provenance(stage string) Provenance {
+	g.traceMu.Lock()
+	t := g.trace
+	g.trace = promptTrace{}
+	g.traceMu.Unlock()
+	sort.Strings(t.templates)
+	return Provenance{Stage: stage, Origin: OriginGenerated, Model: t.model, PromptHash: t.hash, Templates: t.templates, Calls: t.calls, Attempts: t.attempts, Temperature: g.temperature()}
+}
+
+func (g *Generator) chapterProvenance(spec Spec) Provenance {
+	p := g.provenance(StageChapter)
+	p.Instruction = spec.Instruction
+	return p
+}
+
 func (g *Generator) Generate(ctx context.Context, spec Spec) (Outline, []Character, []ChapterContent, error) {
 	outline, err := g.generateOutline(ctx, spec)
 	if err != nil {
 		return Outline{}, nil, nil, err
 	}
 	if g.PersistDir != "" {
-		_ = persistOutline(g.PersistDir, outline)
+		_ = persistOutline(g.PersistDir, outline, g.provenance(StageOutline))
 	}
 	// prepare final output dir once outline is known
 	finalDir := ""
@@ -71,7 +183,7 @@ func (g *Generator) Generate(ctx context.Context, spec Spec) (Outline, []Charact
 		return Outline{}, nil, nil, err
 	}
 	if g.PersistDir != "" {
-		_ = persistCharacters(g.PersistDir, characters)
+		_ = persistCharacters(g.PersistDir, characters, g.provenance(StageCharacters))
 	}
 	if g.Log != nil {
 		for _, c := range characters {
@@ -83,18 +195,26 @@ func (g *Generator) Generate(ctx context.Context, spec Spec) (Outline, []Charact
 		return Outline{}, nil, nil, err
 	}
 	if g.PersistDir != "" {
-		_ = persistPlans(g.PersistDir, plans)
+		_ = persistPlans(g.PersistDir, plans, g.provenance(StagePlans))
 	}
 
 	settings, _ := g.generateSettings(ctx, spec)
-	canon := BuildCanon(spec, outline, characters, settings)
+	if g.PersistDir != "" {
+		_ = persistSettings(g.PersistDir, settings, g.provenance(StageSettings))
+	}
+	canon := g.canon(spec, outline, characters, settings)
 	contents, err := g.generateChapterContentsParallel(ctx, spec, canon, plans)
 	if err != nil {
 		return Outline{}, nil, nil, err
 	}
 
 	issues, err := g.coherenceAudit(ctx, spec, canon, contents)
-	if err == nil && len(issues) > 0 {
+	if err != nil {
+		issues = nil
+	}
+	issues = append(issues, g.timelineIssues(canon.Language)...)
+	issues = append(issues, g.glossaryIssues(canon, contents)...)
+	if len(issues) > 0 {
 		revised, e := g.applyCoherenceFixes(ctx, spec, canon, contents, issues)
 		if e == nil && len(revised) == len(contents) {
 			contents = revised
@@ -110,7 +230,7 @@ func (g *Generator) GenerateWithProgress(ctx context.Context, spec Spec, onChapt
 		return Outline{}, nil, nil, err
 	}
 	if g.PersistDir != "" {
-		_ = persistOutline(g.PersistDir, outline)
+		_ = persistOutline(g.PersistDir, outline, g.provenance(StageOutline))
 	}
 	// prepare final dir
 	finalDir := ""
@@ -123,7 +243,7 @@ func (g *Generator) GenerateWithProgress(ctx context.Context, spec Spec, onChapt
 		return Outline{}, nil, nil, err
 	}
 	if g.PersistDir != "" {
-		_ = persistCharacters(g.PersistDir, characters)
+		_ = persistCharacters(g.PersistDir, characters, g.provenance(StageCharacters))
 	}
 	if g.Log != nil {
 		for _, c := range characters {
@@ -135,16 +255,19 @@ func (g *Generator) GenerateWithProgress(ctx context.Context, spec Spec, onChapt
 		return Outline{}, nil, nil, err
 	}
 	if g.PersistDir != "" {
-		_ = persistPlans(g.PersistDir, plans)
+		_ = persistPlans(g.PersistDir, plans, g.provenance(StagePlans))
 	}
 	settings, _ := g.generateSettings(ctx, spec)
-	canon := BuildCanon(spec, outline, characters, settings)
+	if g.PersistDir != "" {
+		_ = persistSettings(g.PersistDir, settings, g.provenance(StageSettings))
+	}
+	canon := g.canon(spec, outline, characters, settings)
 	contents, err := g.generateChapterContentsParallelWithCallback(ctx, spec, canon, plans, func(c ChapterContent) {
 		if onChapter != nil {
 			onChapter(c.Index, c)
 		}
 		if g.PersistDir != "" {
-			_ = persistChapter(g.PersistDir, outline.Title, c)
+			_ = persistChapter(g.PersistDir, outline.Title, c, VersionDraft, g.chapterProvenance(spec))
 		}
 		if finalDir != "" {
 			_ = writeChapterToDir(finalDir, c)
@@ -154,7 +277,12 @@ func (g *Generator) GenerateWithProgress(ctx context.Context, spec Spec, onChapt
 		return Outline{}, nil, nil, err
 	}
 	issues, err := g.coherenceAudit(ctx, spec, canon, contents)
-	if err == nil && len(issues) > 0 {
+	if err != nil {
+		issues = nil
+	}
+	issues = append(issues, g.timelineIssues(canon.Language)...)
+	issues = append(issues, g.glossaryIssues(canon, contents)...)
+	if len(issues) > 0 {
 		revised, e := g.applyCoherenceFixes(ctx, spec, canon, contents, issues)
 		if e == nil && len(revised) == len(contents) {
 			contents = revised
@@ -165,7 +293,7 @@ func (g *Generator) GenerateWithProgress(ctx context.Context, spec Spec, onChapt
 
 func (g *Generator) GenerateFromOutline(ctx context.Context, spec Spec, outline Outline) (Outline, []Character, []ChapterContent, error) {
 	if g.PersistDir != "" {
-		_ = persistOutline(g.PersistDir, outline)
+		_ = persistOutline(g.PersistDir, outline, Provenance{Stage: StageOutline, Origin: OriginInput})
 	}
 	finalDir := ""
 	if g.FinalBaseDir != "" {
@@ -177,7 +305,7 @@ func (g *Generator) GenerateFromOutline(ctx context.Context, spec Spec, outline
 		return Outline{}, nil, nil, err
 	}
 	if g.PersistDir != "" {
-		_ = persistCharacters(g.PersistDir, characters)
+		_ = persistCharacters(g.PersistDir, characters, g.provenance(StageCharacters))
 	}
 	if g.Log != nil {
 		for _, c := range characters {
@@ -189,16 +317,24 @@ func (g *Generator) GenerateFromOutline(ctx context.Context, spec Spec, outline
 		return Outline{}, nil, nil, err
 	}
 	if g.PersistDir != "" {
-		_ = persistPlans(g.PersistDir, plans)
+		_ = persistPlans(g.PersistDir, plans, g.provenance(StagePlans))
 	}
 	settings, _ := g.generateSettings(ctx, spec)
-	canon := BuildCanon(spec, outline, characters, settings)
+	if g.PersistDir != "" {
+		_ = persistSettings(g.PersistDir, settings, g.provenance(StageSettings))
+	}
+	canon := g.canon(spec, outline, characters, settings)
 	contents, err := g.generateChapterContentsParallel(ctx, spec, canon, plans)
 	if err != nil {
 		return Outline{}, nil, nil, err
 	}
 	issues, err := g.coherenceAudit(ctx, spec, canon, contents)
-	if err == nil && len(issues) > 0 {
+	if err != nil {
+		issues = nil
+	}
+	issues = append(issues, g.timelineIssues(canon.Language)...)
+	issues = append(issues, g.glossaryIssues(canon, contents)...)
+	if len(issues) > 0 {
 		revised, e := g.applyCoherenceFixes(ctx, spec, canon, contents, issues)
 		if e == nil && len(revised) == len(contents) {
 			contents = revised
@@ -213,7 +349,7 @@ func (g *Generator) GenerateFromSource(ctx context.Context, spec Spec, source st
 		return Outline{}, nil, nil, err
 	}
 	if g.PersistDir != "" {
-		_ = persistOutline(g.PersistDir, outline)
+		_ = persistOutline(g.PersistDir, outline, g.provenance(StageOutline))
 	}
 	finalDir := ""
 	if g.FinalBaseDir != "" {
@@ -225,23 +361,31 @@ func (g *Generator) GenerateFromSource(ctx context.Context, spec Spec, source st
 		return Outline{}, nil, nil, err
 	}
 	if g.PersistDir != "" {
-		_ = persistCharacters(g.PersistDir, characters)
+		_ = persistCharacters(g.PersistDir, characters, g.provenance(StageCharacters))
 	}
 	settings, _ := g.generateSettings(ctx, spec)
-	canon := BuildCanon(spec, outline, characters, settings)
+	if g.PersistDir != "" {
+		_ = persistSettings(g.PersistDir, settings, g.provenance(StageSettings))
+	}
+	canon := g.canon(spec, outline, characters, settings)
 	plans, err := g.generateChapterPlans(ctx, spec, outline)
 	if err != nil {
 		return Outline{}, nil, nil, err
 	}
 	if g.PersistDir != "" {
-		_ = persistPlans(g.PersistDir, plans)
+		_ = persistPlans(g.PersistDir, plans, g.provenance(StagePlans))
 	}
 	contents, err := g.generateChapterContentsParallel(ctx, spec, canon, plans)
 	if err != nil {
 		return Outline{}, nil, nil, err
 	}
 	issues, err := g.coherenceAudit(ctx, spec, canon, contents)
-	if err == nil && len(issues) > 0 {
+	if err != nil {
+		issues = nil
+	}
+	issues = append(issues, g.timelineIssues(canon.Language)...)
+	issues = append(issues, g.glossaryIssues(canon, contents)...)
+	if len(issues) > 0 {
 		revised, e := g.applyCoherenceFixes(ctx, spec, canon, contents, issues)
 		if e == nil && len(revised) == len(contents) {
 			contents = revised
@@ -250,43 +394,29 @@ func (g *Generator) GenerateFromSource(ctx context.Context, spec Spec, source st
 	return outline, characters, contents, nil
 }
 
-// GenerateArtifacts produces and persists outline, characters and chapter plans only (no chapter contents)
+// GenerateArtifacts produces and persists outline, characters and chapter plans only (no chapter contents).
+// It stops with a *GateError at the first stage listed in spec.Gates, or
+// with a *PauseError after the stage during which Paused turned true
 func (g *Generator) GenerateArtifacts(ctx context.Context, spec Spec) (Outline, []Character, []Chapter, error) {
-    outline, err := g.generateOutline(ctx, spec)
-    if err != nil { return Outline{}, nil, nil, err }
-    if g.PersistDir != "" { _ = persistOutline(g.PersistDir, outline) }
-    characters, err := g.generateCharacters(ctx, spec, outline)
-    if err != nil { return Outline{}, nil, nil, err }
-    if g.PersistDir != "" { _ = persistCharacters(g.PersistDir, characters) }
-    plans, err := g.generateChapterPlans(ctx, spec, outline)
-    if err != nil { return Outline{}, nil, nil, err }
-    if g.PersistDir != "" { _ = persistPlans(g.PersistDir, plans) }
-    return outline, characters, plans, nil
+	return g.runArtifactStages(ctx, spec, "", "")
 }
 
 // GenerateArtifactsFromSource produces and persists outline, characters and chapter plans from source text only
 func (g *Generator) GenerateArtifactsFromSource(ctx context.Context, spec Spec, source string) (Outline, []Character, []Chapter, error) {
-    outline, err := g.parseOutlineFromText(ctx, spec, source)
-    if err != nil { return Outline{}, nil, nil, err }
-    if g.PersistDir != "" { _ = persistOutline(g.PersistDir, outline) }
-    characters, err := g.parseCharactersFromText(ctx, spec, source, outline)
-    if err != nil { return Outline{}, nil, nil, err }
-    if g.PersistDir != "" { _ = persistCharacters(g.PersistDir, characters) }
-    plans, err := g.generateChapterPlans(ctx, spec, outline)
-    if err != nil { return Outline{}, nil, nil, err }
-    if g.PersistDir != "" { _ = persistPlans(g.PersistDir, plans) }
-    return outline, characters, plans, nil
+	return g.runArtifactStages(ctx, spec, source, "")
 }
 
 func (g *Generator) parseOutlineFromText(ctx context.Context, spec Spec, source string) (Outline, error) {
-	sys := "你是资深小说大纲抽取专家，仅输出JSON"
-	user := "从以下文本抽取小说大纲，返回JSON：{title, chapters:[{index,title,summary}]}；仅输出JSON。要求：每个chapter仅代表单独一章；index严格为单个数字，不得包含范围表达（如1-30章）；不得卷级汇总，每条仅一章。\n" + source
+	sys, user, err := g.prompt(spec, "extract_outline", PromptData{Source: source})
+	if err != nil {
+		return Outline{}, err
+	}
 	reqCtx := ctx
 	var cancel func()
 	if g.RequestTimeoutSec > 0 {
 		reqCtx, cancel = context.WithTimeout(ctx, time.Duration(g.RequestTimeoutSec)*time.Second)
 	}
-	out, err := g.Client.ChatWithRetry(reqCtx, spec.Model, sys, user, g.RetryCount, time.Duration(g.RetryBackoffMs)*time.Millisecond)
+	out, err := g.chatWithRetry(reqCtx, spec.Model, sys, user)
 	if cancel != nil {
 		cancel()
 	}
@@ -300,8 +430,11 @@ func (g *Generator) parseOutlineFromText(ctx context.Context, spec Spec, source
 			g.Log("[抽取大纲失败] " + e.Error())
 		}
 		// 强制要求代码块JSON重试
-		user = "```json\n仅输出完整JSON，无额外文本。结构：{\"title\":...,\"chapters\":[{\"index\":1,\"title\":...,\"summary\":...}]}\n```\n文本：\n" + source
-		out2, err2 := g.Client.Chat(ctx, spec.Model, sys, user)
+		sys, user, err := g.prompt(spec, "extract_outline", PromptData{Source: source, Retry: true})
+		if err != nil {
+			return Outline{}, err
+		}
+		out2, err2 := g.chat(ctx, spec.Model, sys, user)
 		if err2 != nil {
 			// 大文本分片增量抽取
 			chOutline, e2 := g.extractOutlineChunked(ctx, spec, source)
@@ -334,19 +467,16 @@ func (g *Generator) parseOutlineFromText(ctx context.Context, spec Spec, source
 }
 
 func (g *Generator) parseCharactersFromText(ctx context.Context, spec Spec, source string, outline Outline) ([]Character, error) {
-	sys := "你是资深人物设定抽取专家，仅输出JSON数组"
-	b := strings.Builder{}
-	b.WriteString("从以下文本抽取主要人物，返回JSON数组[{name,role,traits,background}]；仅输出JSON数组。\n")
-	b.WriteString("标题：")
-	b.WriteString(outline.Title)
-	b.WriteString("\n文本：\n")
-	b.WriteString(source)
+	sys, user, err := g.prompt(spec, "extract_characters", PromptData{Outline: outline, Source: source})
+	if err != nil {
+		return nil, err
+	}
 	reqCtx := ctx
 	var cancel func()
 	if g.RequestTimeoutSec > 0 {
 		reqCtx, cancel = context.WithTimeout(ctx, time.Duration(g.RequestTimeoutSec)*time.Second)
 	}
-	out, err := g.Client.ChatWithRetry(reqCtx, spec.Model, sys, b.String(), g.RetryCount, time.Duration(g.RetryBackoffMs)*time.Millisecond)
+	out, err := g.chatWithRetry(reqCtx, spec.Model, sys, user)
 	if cancel != nil {
 		cancel()
 	}
@@ -360,12 +490,11 @@ func (g *Generator) parseCharactersFromText(ctx context.Context, spec Spec, sour
 			g.Log("[抽取人物失败] " + e.Error())
 		}
 		// 代码块JSON重试
-		b2 := strings.Builder{}
-		b2.WriteString("```json\n仅输出JSON数组，无额外文本。结构：[{\"name\":...,\"role\":...,\"traits\":[...],\"background\":...}]\n```\n标题：")
-		b2.WriteString(outline.Title)
-		b2.WriteString("\n文本：\n")
-		b2.WriteString(source)
-		out2, err2 := g.Client.Chat(ctx, spec.Model, sys, b2.String())
+		sys, user, err := g.prompt(spec, "extract_characters", PromptData{Outline: outline, Source: source, Retry: true})
+		if err != nil {
+			return nil, err
+		}
+		out2, err2 := g.chat(ctx, spec.Model, sys, user)
 		if err2 != nil {
 			// 分片增量抽取
 			chChars, e2 := g.extractCharactersChunked(ctx, spec, source, outline)
@@ -389,13 +518,14 @@ func (g *Generator) parseCharactersFromText(ctx context.Context, spec Spec, sour
 }
 
 func (g *Generator) generateOutline(ctx context.Context, spec Spec) (Outline, error) {
-	sys := "你是资深中文小说策划，输出结构化结果"
-	chCount := spec.Chapters
-	if chCount <= 0 {
-		chCount = 10
+	if n := spec.volumeCount(); n > 0 {
+		return g.generateVolumeOutline(ctx, spec, n)
 	}
-	user := fmt.Sprintf("基于主题生成小说大纲，章节数%d，返回JSON：{title, chapters:[{index,title,summary}]}; 仅输出JSON，不要任何额外说明或标注；每项仅单章，禁止范围表达（如1-30章）。主题：%s", chCount, spec.Topic)
-	out, err := g.Client.Chat(ctx, spec.Model, sys, user)
+	sys, user, err := g.prompt(spec, "outline", g.seriesData(spec))
+	if err != nil {
+		return Outline{}, err
+	}
+	out, err := g.chat(ctx, spec.Model, sys, user)
 	if err != nil {
 		return Outline{}, err
 	}
@@ -410,8 +540,11 @@ func (g *Generator) generateOutline(ctx context.Context, spec Spec) (Outline, er
 }
 
 func (g *Generator) generateSettings(ctx context.Context, spec Spec) (Settings, error) {
-	sys, user := BuildSettingPromptWithCategories(spec.Preset, spec.Topic, spec.Categories, spec.Tags)
-	out, err := g.Client.Chat(ctx, spec.Model, sys, user)
+	sys, user, err := g.prompt(spec, "settings", PromptData{Canon: Canon{Schema: SettingSchemaFor(spec.Preset)}, System: presetFor(spec.Preset).System})
+	if err != nil {
+		return Settings{}, err
+	}
+	out, err := g.chat(ctx, spec.Model, sys, user)
 	if err != nil {
 		return Settings{}, err
 	}
@@ -423,15 +556,11 @@ func (g *Generator) generateSettings(ctx context.Context, spec Spec) (Settings,
 }
 
 func (g *Generator) generateCharacters(ctx context.Context, spec Spec, outline Outline) ([]Character, error) {
-	sys := "你是资深中文小说人物设定专家，擅长写西游爽文，深谙‘低调装逼、反差碾压、爽点密集’的核心逻辑，输出结构化结果；仅输出JSON数组，无额外文本"
-	buf := strings.Builder{}
-	buf.WriteString("根据主题与大纲生成主要人物，返回JSON数组[{name,role,traits,background}]，仅输出JSON数组，不要任何其他文字。\n")
-	buf.WriteString("主题：")
-	buf.WriteString(spec.Topic)
-	buf.WriteString("\n大纲标题：")
-	buf.WriteString(outline.Title)
-	user := buf.String()
-	out, err := g.Client.Chat(ctx, spec.Model, sys, user)
+	sys, user, err := g.prompt(spec, "characters", PromptData{Series: g.Series, Outline: outline})
+	if err != nil {
+		return nil, err
+	}
+	out, err := g.chat(ctx, spec.Model, sys, user)
 	if err != nil {
 		return nil, err
 	}
@@ -439,25 +568,23 @@ func (g *Generator) generateCharacters(ctx context.Context, spec Spec, outline O
 	if err := json.Unmarshal([]byte(extractJSON(out)), &characters); err != nil {
 		return nil, err
 	}
+	if g.Series != nil {
+		characters = MergeSeriesCharacters(g.Series.Characters, characters)
+	}
 	return characters, nil
 }
 
 func (g *Generator) generateChapterPlans(ctx context.Context, spec Spec, outline Outline) ([]Chapter, error) {
-	sys := "你是资深中文小说剧情设计师，输出结构化结果"
-	b := strings.Builder{}
-	b.WriteString("根据给定大纲的每一章，扩充为更详细的章节梗概，加入3-5个关键事件。返回JSON数组：[{index,title,summary}]；仅输出JSON数组，无额外文本")
-	b.WriteString("\n大纲标题：")
-	b.WriteString(outline.Title)
-	for _, ch := range outline.Chapters {
-		b.WriteString("\n章节：")
-		b.WriteString(fmt.Sprintf("%d. %s - %s", ch.Index, ch.Title, ch.Summary))
+	sys, user, err := g.prompt(spec, "plans", PromptData{Outline: outline})
+	if err != nil {
+		return nil, err
 	}
 	reqCtx := ctx
 	var cancel func()
 	if g.RequestTimeoutSec > 0 {
 		reqCtx, cancel = context.WithTimeout(ctx, time.Duration(g.RequestTimeoutSec)*time.Second)
 	}
-	out, err := g.Client.ChatWithRetry(reqCtx, spec.Model, sys, b.String(), g.RetryCount, time.Duration(g.RetryBackoffMs)*time.Millisecond)
+	out, err := g.chatWithRetry(reqCtx, spec.Model, sys, user)
 	if cancel != nil {
 		cancel()
 	}
@@ -468,9 +595,7 @@ func (g *Generator) generateChapterPlans(ctx context.Context, spec Spec, outline
 	if err := json.Unmarshal([]byte(extractJSON(out)), &plans); err != nil {
 		return nil, err
 	}
-	for i := range plans {
-		plans[i].Index = i + 1
-	}
+	alignPlans(plans, outline)
 	return plans, nil
 }
 
@@ -489,22 +614,16 @@ func (g *Generator) generateChapterContentsParallelWithCallback(ctx context.Cont
 			}
 			g.Log(fmt.Sprintf("[章节参与] 第%d章 %s | 人物：%s", plans[i].Index, plans[i].Title, strings.Join(names, ", ")))
 		}
-		sys, user := BuildChapterPrompt(canon, plans[i], relevant, spec.Words, spec.Instruction, spec.System)
-		reqCtx := ctx
-		var cancel func()
-		if g.RequestTimeoutSec > 0 {
-			reqCtx, cancel = context.WithTimeout(ctx, time.Duration(g.RequestTimeoutSec)*time.Second)
-		}
-		out, err := g.Client.ChatWithRetry(reqCtx, spec.Model, sys, user, g.RetryCount, time.Duration(g.RetryBackoffMs)*time.Millisecond)
-		if cancel != nil {
-			cancel()
+		if err := g.ensureScenes(ctx, spec, canon, &plans[i]); err != nil {
+			return nil, err
 		}
+		out, err := g.draftChapter(ctx, spec, g.chapterCanon(canon, plans[i]), plans[i], relevant, nil)
 		if err != nil {
 			return nil, err
 		}
-		contents[i] = ChapterContent{Index: plans[i].Index, Title: plans[i].Title, Content: out}
+		contents[i] = ChapterContent{Index: plans[i].Index, Title: plans[i].Title, Content: g.correctTerms(canon, plans[i].Index, out)}
 		if g.PersistDir != "" {
-			_ = persistChapter(g.PersistDir, canon.Title, contents[i])
+			_ = persistChapter(g.PersistDir, canon.Title, contents[i], VersionDraft, g.chapterProvenance(spec))
 		}
 		if g.FinalBaseDir != "" {
 			finalDir := filepath.Join(g.FinalBaseDir, safeDirName(canon.Title))
@@ -514,44 +633,80 @@ func (g *Generator) generateChapterContentsParallelWithCallback(ctx context.Cont
 		if onChapter != nil {
 			onChapter(contents[i])
 		}
+		g.trackGlossary(ctx, spec, canon, contents[i])
+		g.trackStates(ctx, spec, canon, contents[i])
+		g.trackThreads(ctx, spec, contents[i])
+		g.trackTimeline(ctx, spec, canon, contents[i])
 	}
 	return contents, nil
 }
 
 func (g *Generator) GenerateChapterWithHistory(ctx context.Context, spec Spec, canon Canon, plan Chapter, prior []ChapterContent) (ChapterContent, error) {
 	relevant := SelectRelevantCharacters(plan, canon.Characters, 3)
-	sys, user := BuildChapterPromptWithHistory(canon, plan, relevant, spec.Words, spec.Instruction, spec.System, prior)
-	reqCtx := ctx
-	var cancel func()
-	if g.RequestTimeoutSec > 0 {
-		reqCtx, cancel = context.WithTimeout(ctx, time.Duration(g.RequestTimeoutSec)*time.Second)
-	}
-	out, err := g.Client.ChatWithRetry(reqCtx, spec.Model, sys, user, g.RetryCount, time.Duration(g.RetryBackoffMs)*time.Millisecond)
-	if cancel != nil {
-		cancel()
+	if err := g.ensureScenes(ctx, spec, canon, &plan); err != nil {
+		return ChapterContent{}, err
 	}
+	out, err := g.draftChapter(ctx, spec, g.chapterCanon(canon, plan), plan, relevant, prior)
 	if err != nil {
 		return ChapterContent{}, err
 	}
-	c := ChapterContent{Index: plan.Index, Title: plan.Title, Content: out}
+	c := ChapterContent{Index: plan.Index, Title: plan.Title, Content: g.correctTerms(canon, plan.Index, out)}
 	if g.PersistDir != "" {
-		_ = persistChapter(g.PersistDir, canon.Title, c)
+		_ = persistChapter(g.PersistDir, canon.Title, c, VersionDraft, g.chapterProvenance(spec))
 	}
 	if g.FinalBaseDir != "" {
 		finalDir := filepath.Join(g.FinalBaseDir, safeDirName(canon.Title))
 		_ = os.MkdirAll(finalDir, 0o755)
 		_ = writeChapterToDir(finalDir, c)
 	}
+	g.trackGlossary(ctx, spec, canon, c)
+	g.trackStates(ctx, spec, canon, c)
+	g.trackThreads(ctx, spec, c)
+	g.trackTimeline(ctx, spec, canon, c)
 	return c, nil
 }
 
+// draftChapter writes a chapter scene by scene when its plan has scenes and
+// in one call otherwise. A text in another language than the book's is
+// written again once and then rejected with a *LanguageError
+func (g *Generator) draftChapter(ctx context.Context, spec Spec, canon Canon, plan Chapter, relevant []Character, history []ChapterContent) (string, error) {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var out string
+		if len(plan.Scenes) > 0 {
+			out, err = g.writeScenes(ctx, spec, canon, plan, relevant, history)
+		} else {
+			out, err = g.writeChapter(ctx, spec, canon, plan, relevant, history)
+		}
+		if err != nil {
+			return "", err
+		}
+		if err = CheckLanguage(spec.language(), out); err == nil {
+			return out, nil
+		}
+		if g.Log != nil {
+			g.Log(fmt.Sprintf("[语言检查] 第%d章 %s", plan.Index, err.Error()))
+		}
+	}
+	return "", fmt.Errorf("chapter %d: %w", plan.Index, err)
+}
+
+// writeChapter writes the text of a chapter in one call
+func (g *Generator) writeChapter(ctx context.Context, spec Spec, canon Canon, plan Chapter, relevant []Character, history []ChapterContent) (string, error) {
+	sys, user, err := g.prompt(spec, "chapter", PromptData{Canon: canon, Plan: plan, Characters: relevant, History: history, Words: spec.Words, Instruction: spec.instruction(), System: spec.system()})
+	if err != nil {
+		return "", err
+	}
+	return g.chatTimed(ctx, spec.Model, sys, user)
+}
+
 func WriteToFiles(baseDir string, outline Outline, contents []ChapterContent) (string, error) {
 	dir := filepath.Join(baseDir, safeDirName(outline.Title))
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return "", err
 	}
 	for _, c := range contents {
-		fname := fmt.Sprintf("%02d_%s.md", c.Index, safeFileName(c.Title))
+		fname := ChapterFileName(c.Index, c.Title)
 		path := filepath.Join(dir, fname)
 		body := strings.Builder{}
 		body.WriteString("# ")
@@ -573,29 +728,11 @@ type CoherenceIssue struct {
 }
 
 func (g *Generator) coherenceAudit(ctx context.Context, spec Spec, canon Canon, contents []ChapterContent) ([]CoherenceIssue, error) {
-	b := strings.Builder{}
-	b.WriteString("检查以下章节是否与风格、人物与世界观一致，返回JSON问题列表[{chapter,type,detail,fix_hint}]。\n")
-	b.WriteString("风格：")
-	b.WriteString(canon.Style)
-	b.WriteString("\n人物：\n")
-	for _, c := range canon.Characters {
-		b.WriteString(c.Name)
-		b.WriteString("|")
-		b.WriteString(c.Role)
-		b.WriteString("|")
-		b.WriteString(strings.Join([]string(c.Traits), "、"))
-		b.WriteString("|")
-		b.WriteString(c.Background)
-		b.WriteString("\n")
-	}
-	for _, ch := range contents {
-		b.WriteString("\n章节\n")
-		b.WriteString(fmt.Sprintf("%d %s\n", ch.Index, ch.Title))
-		b.WriteString(ch.Content)
-		b.WriteString("\n")
-	}
-	sys := "你是严苛的AI文审查员，负责检查内容是否属于AI生成的"
-	out, err := g.Client.Chat(ctx, spec.Model, sys, b.String())
+	sys, user, err := g.prompt(spec, "audit", PromptData{Canon: canon, Chapters: contents})
+	if err != nil {
+		return nil, err
+	}
+	out, err := g.chat(ctx, spec.Model, sys, user)
 	if err != nil {
 		return nil, err
 	}
@@ -612,34 +749,32 @@ func (g *Generator) applyCoherenceFixes(ctx context.Context, spec Spec, canon Ca
 		byChapter[is.Chapter] = append(byChapter[is.Chapter], is)
 	}
 	revised := make([]ChapterContent, len(contents))
+	g.resetTrace()
 	for i := range contents {
-		sys := "你是资深中文小说修订助手，负责根据问题将AI生成的内容优化，转成口语化的中文"
-		b := strings.Builder{}
-		b.WriteString("根据问题修订章节内容，保持风格一致并避免新增冲突，只返回修订后的完整正文。\n")
-		b.WriteString("风格：")
-		b.WriteString(canon.Style)
-		b.WriteString("\n章节：")
-		b.WriteString(contents[i].Title)
-		b.WriteString("\n原文：\n")
-		b.WriteString(contents[i].Content)
-		if list, ok := byChapter[contents[i].Index]; ok && len(list) > 0 {
-			b.WriteString("\n问题：\n")
-			for _, is := range list {
-				b.WriteString(is.Type)
-				b.WriteString(":")
-				b.WriteString(is.Detail)
-				if is.FixHint != "" {
-					b.WriteString("|")
-					b.WriteString(is.FixHint)
-				}
-				b.WriteString("\n")
-			}
+		sys, user, err := g.prompt(spec, "revise", PromptData{Canon: canon, Chapter: contents[i], Issues: byChapter[contents[i].Index]})
+		if err != nil {
+			return nil, err
 		}
-		out, err := g.Client.Chat(ctx, spec.Model, sys, b.String())
+		out, err := g.chat(ctx, spec.Model, sys, user)
 		if err != nil {
 			return nil, err
 		}
+		if err := CheckLanguage(spec.language(), out); err != nil {
+			// a revision in another language is worse than none
+			if g.Log != nil {
+				g.Log(fmt.Sprintf("[修订] 第%d章 %s，保留原文", contents[i].Index, err.Error()))
+			}
+			out = contents[i].Content
+		}
 		revised[i] = ChapterContent{Index: contents[i].Index, Title: contents[i].Title, Content: out}
+		if g.PersistDir != "" {
+			_ = persistChapter(g.PersistDir, canon.Title, revised[i], VersionRevise, g.provenance(StageChapter))
+		}
+		if g.FinalBaseDir != "" {
+			finalDir := filepath.Join(g.FinalBaseDir, safeDirName(canon.Title))
+			_ = os.MkdirAll(finalDir, 0o755)
+			_ = writeChapterToDir(finalDir, revised[i])
+		}
 	}
 	return revised, nil
 }
@@ -724,14 +859,16 @@ func (g *Generator) extractOutlineChunked(ctx context.Context, spec Spec, source
 	var all []frag
 	title := spec.Topic
 	for i, c := range chunks {
-		sys := "你是资深小说大纲拆解专家，仅输出JSON数组"
-		user := "将以下文本片段拆解为逐章列表，返回JSON数组：[{title,summary}]；仅输出JSON数组。要求：每项仅代表单独一章，不得卷级汇总或范围表达（如1-30章）。\n片段：\n" + c
+		sys, user, err := g.prompt(spec, "outline_chunk", PromptData{Source: c})
+		if err != nil {
+			return Outline{}, err
+		}
 		reqCtx := ctx
 		var cancel func()
 		if g.RequestTimeoutSec > 0 {
 			reqCtx, cancel = context.WithTimeout(ctx, time.Duration(g.RequestTimeoutSec)*time.Second)
 		}
-		out, err := g.Client.ChatWithRetry(reqCtx, spec.Model, sys, user, g.RetryCount, time.Duration(g.RetryBackoffMs)*time.Millisecond)
+		out, err := g.chatWithRetry(reqCtx, spec.Model, sys, user)
 		if cancel != nil {
 			cancel()
 		}
@@ -770,18 +907,11 @@ func (g *Generator) extractOutlineChunked(ctx context.Context, spec Spec, source
 }
 
 func (g *Generator) normalizeOutline(ctx context.Context, spec Spec, current Outline, source string) (Outline, error) {
-	sys := "你是资深大纲拆解与扩展专家，仅输出JSON"
-	b := strings.Builder{}
-	b.WriteString("将以下材料与现有大纲统一，扩展为精确 ")
-	b.WriteString(fmt.Sprintf("%d", spec.Chapters))
-	b.WriteString(" 章，严格输出：{title, chapters:[{index,title,summary}]}。要求：index 从1到")
-	b.WriteString(fmt.Sprintf("%d", spec.Chapters))
-	b.WriteString("，每项仅单章；禁止范围表达如‘1-30章’；不得合并多章至一项；仅输出JSON。\n材料：\n")
-	b.WriteString(source)
-	b.WriteString("\n现有大纲JSON：\n")
-	curJSON, _ := json.Marshal(current)
-	b.Write(curJSON)
-	out, err := g.Client.Chat(ctx, spec.Model, sys, b.String())
+	sys, user, err := g.prompt(spec, "normalize_outline", PromptData{Outline: current, Source: source})
+	if err != nil {
+		return Outline{}, err
+	}
+	out, err := g.chat(ctx, spec.Model, sys, user)
 	if err != nil {
 		return Outline{}, err
 	}
@@ -799,18 +929,16 @@ func (g *Generator) extractCharactersChunked(ctx context.Context, spec Spec, sou
 	chunks := chunkTextByParagraph(source, 8000)
 	dedup := map[string]Character{}
 	for i, c := range chunks {
-		sys := "你是资深人物设定抽取专家，仅输出JSON数组"
-		b := strings.Builder{}
-		b.WriteString("从以下文本片段抽取主要人物，返回JSON数组[{name,role,traits,background}]；仅输出JSON数组。\n标题：")
-		b.WriteString(outline.Title)
-		b.WriteString("\n片段：\n")
-		b.WriteString(c)
+		sys, user, err := g.prompt(spec, "characters_chunk", PromptData{Outline: outline, Source: c})
+		if err != nil {
+			return nil, err
+		}
 		reqCtx := ctx
 		var cancel func()
 		if g.RequestTimeoutSec > 0 {
 			reqCtx, cancel = context.WithTimeout(ctx, time.Duration(g.RequestTimeoutSec)*time.Second)
 		}
-		out, err := g.Client.ChatWithRetry(reqCtx, spec.Model, sys, b.String(), g.RetryCount, time.Duration(g.RetryBackoffMs)*time.Millisecond)
+		out, err := g.chatWithRetry(reqCtx, spec.Model, sys, user)
 		if cancel != nil {
 			cancel()
 		}
@@ -909,7 +1037,7 @@ func chunkTextByParagraph(s string, max int) []string {
 	return chunks
 }
 
-func persistOutline(dir string, outline Outline) error {
+func persistOutline(dir string, outline Outline, p Provenance) error {
 	if dir == "" {
 		return nil
 	}
@@ -917,10 +1045,13 @@ func persistOutline(dir string, outline Outline) error {
 		return err
 	}
 	b, _ := json.MarshalIndent(outline, "", "  ")
-	return os.WriteFile(filepath.Join(dir, "outline.json"), b, 0o644)
+	if err := os.WriteFile(filepath.Join(dir, "outline.json"), b, 0o644); err != nil {
+		return err
+	}
+	return RecordArtifact(dir, "outline.json", p)
 }
 
-func persistCharacters(dir string, characters []Character) error {
+func persistCharacters(dir string, characters []Character, p Provenance) error {
 	if dir == "" {
 		return nil
 	}
@@ -928,10 +1059,13 @@ func persistCharacters(dir string, characters []Character) error {
 		return err
 	}
 	b, _ := json.MarshalIndent(characters, "", "  ")
-	return os.WriteFile(filepath.Join(dir, "characters.json"), b, 0o644)
+	if err := os.WriteFile(filepath.Join(dir, "characters.json"), b, 0o644); err != nil {
+		return err
+	}
+	return RecordArtifact(dir, "characters.json", p)
 }
 
-func persistPlans(dir string, plans []Chapter) error {
+func persistPlans(dir string, plans []Chapter, p Provenance) error {
 	if dir == "" {
 		return nil
 	}
@@ -939,36 +1073,37 @@ func persistPlans(dir string, plans []Chapter) error {
 		return err
 	}
 	b, _ := json.MarshalIndent(plans, "", "  ")
-	return os.WriteFile(filepath.Join(dir, "plans.json"), b, 0o644)
+	if err := os.WriteFile(filepath.Join(dir, "plans.json"), b, 0o644); err != nil {
+		return err
+	}
+	return RecordArtifact(dir, "plans.json", p)
 }
 
-func persistChapter(dir string, title string, c ChapterContent) error {
+func persistSettings(dir string, settings Settings, p Provenance) error {
 	if dir == "" {
 		return nil
 	}
-	chapDir := filepath.Join(dir, "chapters")
-	if err := os.MkdirAll(chapDir, 0o755); err != nil {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	b, _ := json.MarshalIndent(settings, "", "  ")
+	if err := os.WriteFile(filepath.Join(dir, "settings.json"), b, 0o644); err != nil {
 		return err
 	}
-	fname := fmt.Sprintf("%02d_%s.md", c.Index, safeFileName(c.Title))
-	path := filepath.Join(chapDir, fname)
-	body := strings.Builder{}
-	body.WriteString("# ")
-	body.WriteString(c.Title)
-	body.WriteString("\n\n")
-	body.WriteString(c.Content)
-	return os.WriteFile(path, []byte(body.String()), 0o644)
+	return RecordArtifact(dir, "settings.json", p)
+}
+
+func persistChapter(dir string, title string, c ChapterContent, stage string, p Provenance) error {
+	if dir == "" {
+		return nil
+	}
+	_, err := WriteChapter(dir, c, stage, p)
+	return err
 }
 
 func writeChapterToDir(finalDir string, c ChapterContent) error {
-	fname := fmt.Sprintf("%02d_%s.md", c.Index, safeFileName(c.Title))
-	path := filepath.Join(finalDir, fname)
-	body := strings.Builder{}
-	body.WriteString("# ")
-	body.WriteString(c.Title)
-	body.WriteString("\n\n")
-	body.WriteString(c.Content)
-	return os.WriteFile(path, []byte(body.String()), 0o644)
+	_, _, err := ReplaceChapterFile(finalDir, c)
+	return err
 }
 
 func safeFileName(s string) string {
@@ -0,0 +1,475 @@
+package novel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// GlossaryFile is the per-job list of proper nouns and how to spell them
+const GlossaryFile = "glossary.json"
+
+const StageGlossary = "glossary"
+
+// IssueTerminology is the CoherenceIssue type of misspelt proper nouns
+const IssueTerminology = "terminology"
+
+// glossary categories
+const (
+	TermCharacter = "character"
+	TermSect      = "sect"
+	TermArtifact  = "artifact"
+	TermTechnique = "technique"
+	TermPlace     = "place"
+	TermRealm     = "realm"
+	TermOther     = "other"
+)
+
+// GlossaryEntry is one proper noun of the book. Aliases are other correct
+// names of the same thing; Variants are misspellings confirmed by the user,
+// replaced by Term whenever a chapter is written
+type GlossaryEntry struct {
+	Term       string   `json:"term"`
+	Category   string   `json:"category"`
+	Definition string   `json:"definition,omitempty"`
+	Aliases    []string `json:"aliases,omitempty"`
+	Variants   []string `json:"variants,omitempty"`
+	// Chapter is where the term first appeared, 0 for seeded terms
+	Chapter int `json:"chapter,omitempty"`
+}
+
+// Glossary is the content of glossary.json
+type Glossary struct {
+	Entries []GlossaryEntry `json:"entries"`
+}
+
+// glossaryPromptEntries caps the entries quoted in one chapter prompt
+const glossaryPromptEntries = 12
+
+var glossaryMu sync.Mutex
+
+// SeedGlossary starts a glossary from the characters and the setting
+// fields the schema marks as holding names, for a book in language lang
+func SeedGlossary(schema SettingSchema, settings Settings, characters []Character, lang string) Glossary {
+	gl := Glossary{Entries: []GlossaryEntry{}}
+	for _, c := range characters {
+		gl.add(GlossaryEntry{Term: c.Name, Category: TermCharacter, Definition: c.Role})
+	}
+	for _, sec := range schema.Sections {
+		for _, f := range sec.Fields {
+			if f.Term == "" {
+				continue
+			}
+			def := ""
+			if f.Definition != "" {
+				def = settings.Text(sec.Key, f.Definition, lang)
+			}
+			for _, term := range settings.Values(sec.Key, f.Key) {
+				gl.add(GlossaryEntry{Term: term, Category: f.Term, Definition: def})
+			}
+		}
+	}
+	return gl
+}
+
+// LoadGlossary reads the glossary of a job, seeding it from canon when the
+// job has none yet
+func LoadGlossary(dir string, canon Canon) (Glossary, error) {
+	b, err := os.ReadFile(filepath.Join(dir, GlossaryFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return SeedGlossary(canon.Schema, canon.Settings, canon.Characters, canon.Language), nil
+		}
+		return Glossary{}, err
+	}
+	var gl Glossary
+	if err := json.Unmarshal(b, &gl); err != nil {
+		return Glossary{}, err
+	}
+	if gl.Entries == nil {
+		gl.Entries = []GlossaryEntry{}
+	}
+	return gl, nil
+}
+
+func persistGlossary(dir string, gl Glossary, p Provenance) error {
+	b, _ := json.MarshalIndent(gl, "", "  ")
+	if err := os.WriteFile(filepath.Join(dir, GlossaryFile), b, 0o644); err != nil {
+		return err
+	}
+	return RecordArtifact(dir, GlossaryFile, p)
+}
+
+// add appends e unless its term is known already
+func (gl *Glossary) add(e GlossaryEntry) bool {
+	e.Term = strings.TrimSpace(e.Term)
+	if e.Term == "" || gl.lookup(e.Term) >= 0 {
+		return false
+	}
+	if e.Category == "" {
+		e.Category = TermOther
+	}
+	gl.Entries = append(gl.Entries, e)
+	return true
+}
+
+// lookup finds the entry a term, alias or known variant belongs to
+func (gl Glossary) lookup(s string) int {
+	for i, e := range gl.Entries {
+		if e.Term == s || containsString(e.Aliases, s) || containsString(e.Variants, s) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Until drops the terms first seen in chapter or later, for forks
+func (gl Glossary) Until(chapter int) Glossary {
+	out := Glossary{Entries: []GlossaryEntry{}}
+	for _, e := range gl.Entries {
+		if e.Chapter < chapter {
+			out.Entries = append(out.Entries, e)
+		}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// nearMissLimit is how far a spelling may be from a term to count as a
+// misspelling of it; short terms only tolerate homophones
+func nearMissLimit(n int) float64 {
+	if n >= 4 {
+		return 1
+	}
+	return 0.6
+}
+
+// nearMiss finds the entry s may be a misspelling of: same length as the
+// term or one of its aliases, within the distance limit and sharing at
+// least half of the characters in place
+func (gl Glossary) nearMiss(s string) int {
+	rs := []rune(s)
+	for i, e := range gl.Entries {
+		for _, name := range append([]string{e.Term}, e.Aliases...) {
+			if name != s && closeSpelling(rs, []rune(name)) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func closeSpelling(a, b []rune) bool {
+	if len(a) != len(b) || len(a) < 2 {
+		return false
+	}
+	same := 0
+	for i := range a {
+		if a[i] == b[i] {
+			same++
+		}
+	}
+	if same == len(a) || same*2 < len(a) {
+		return false
+	}
+	return termDistance(a, b) <= nearMissLimit(len(b))
+}
+
+// checkedTerm reports whether near misses of a term are looked for; two
+// character words collide with ordinary words too often except for names
+func checkedTerm(e GlossaryEntry, name string) bool {
+	n := len([]rune(name))
+	return n >= 3 || (n == 2 && e.Category == TermCharacter)
+}
+
+// NearMiss is a spelling in a text that is close to, but not, a glossary term
+type NearMiss struct {
+	Found string `json:"found"`
+	Term  string `json:"term"`
+	Known bool   `json:"known"`
+}
+
+// FindNearMisses scans text for spellings of glossary terms that differ
+// by a homophone or a single character. Known variants are reported with
+// Known set; other terms of the glossary are never reported
+func (gl Glossary) FindNearMisses(text string) []NearMiss {
+	rs := []rune(text)
+	seen := map[string]bool{}
+	var out []NearMiss
+	for _, e := range gl.Entries {
+		for _, v := range e.Variants {
+			if !seen[v] && strings.Contains(text, v) {
+				seen[v] = true
+				out = append(out, NearMiss{Found: v, Term: e.Term, Known: true})
+			}
+		}
+		for _, name := range append([]string{e.Term}, e.Aliases...) {
+			if !checkedTerm(e, name) {
+				continue
+			}
+			nr := []rune(name)
+			for i := 0; i+len(nr) <= len(rs); i++ {
+				w := rs[i : i+len(nr)]
+				if !allHan(w) || !closeSpelling(w, nr) {
+					continue
+				}
+				s := string(w)
+				if seen[s] || gl.lookup(s) >= 0 {
+					continue
+				}
+				seen[s] = true
+				out = append(out, NearMiss{Found: s, Term: e.Term})
+			}
+		}
+	}
+	return out
+}
+
+func allHan(rs []rune) bool {
+	for _, r := range rs {
+		if !unicode.Is(unicode.Han, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Correct replaces the confirmed variants in text by their terms, longest
+// first so that a variant inside a longer one is not split. In languages
+// that separate words with spaces only whole words are replaced, so a
+// variant "Jon" leaves "Jonathan" alone. Near misses that are not confirmed
+// are left to Check
+func (gl Glossary) Correct(text, lang string) (string, int) {
+	words := languageFor(lang).spaced
+	type pair struct{ from, to string }
+	var pairs []pair
+	for _, e := range gl.Entries {
+		for _, v := range e.Variants {
+			if v != "" && v != e.Term {
+				pairs = append(pairs, pair{v, e.Term})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(a, b int) bool { return len(pairs[a].from) > len(pairs[b].from) })
+	n := 0
+	for _, p := range pairs {
+		var c int
+		if words {
+			text, c = replaceWords(text, p.from, p.to)
+		} else if c = strings.Count(text, p.from); c > 0 {
+			text = strings.ReplaceAll(text, p.from, p.to)
+		}
+		n += c
+	}
+	return text, n
+}
+
+// replaceWords replaces the occurrences of from in text that are not part
+// of a longer word
+func replaceWords(text, from, to string) (string, int) {
+	var b strings.Builder
+	n, last := 0, 0
+	for i := 0; ; {
+		j := strings.Index(text[i:], from)
+		if j < 0 {
+			break
+		}
+		start, end := i+j, i+j+len(from)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if !wordRune(before) && !wordRune(after) {
+			b.WriteString(text[last:start])
+			b.WriteString(to)
+			last = end
+			n++
+		}
+		i = end
+	}
+	if n == 0 {
+		return text, 0
+	}
+	b.WriteString(text[last:])
+	return b.String(), n
+}
+
+func wordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// Check reports the near misses in one chapter as coherence issues worded
+// in language lang
+func (gl Glossary) Check(chapter int, text, lang string) []CoherenceIssue {
+	txt := languageFor(lang).issues
+	var issues []CoherenceIssue
+	for _, m := range gl.FindNearMisses(text) {
+		detail, hint := txt.nearTerm, txt.nearTermHint
+		if m.Known {
+			detail, hint = txt.knownTerm, txt.knownTermHint
+		}
+		issues = append(issues, CoherenceIssue{Chapter: chapter, Type: IssueTerminology, Detail: fmt.Sprintf(detail, m.Found, m.Term), FixHint: fmt.Sprintf(hint, m.Term)})
+	}
+	return issues
+}
+
+// Relevant picks the entries a chapter plan mentions, characters aside as
+// the prompt lists them already
+func (gl Glossary) Relevant(plan Chapter) []GlossaryEntry {
+	var text strings.Builder
+	text.WriteString(plan.Title + plan.Summary + plan.Goal + plan.Climax)
+	for _, s := range plan.Scenes {
+		text.WriteString(s.Location + s.Goal + s.Conflict + s.Outcome + s.Summary)
+	}
+	t := text.String()
+	var out []GlossaryEntry
+	for _, e := range gl.Entries {
+		if e.Category == TermCharacter {
+			continue
+		}
+		for _, name := range append([]string{e.Term}, e.Aliases...) {
+			if strings.Contains(t, name) {
+				out = append(out, e)
+				break
+			}
+		}
+		if len(out) == glossaryPromptEntries {
+			break
+		}
+	}
+	return out
+}
+
+// withGlossary gives the canon the glossary entries the chapter plan mentions
+func (g *Generator) withGlossary(c Canon, plan Chapter) Canon {
+	if g.PersistDir == "" {
+		return c
+	}
+	if gl, err := LoadGlossary(g.PersistDir, c); err == nil {
+		c.Glossary = gl.Relevant(plan)
+	}
+	return c
+}
+
+// correctTerms replaces known misspellings before a chapter is stored
+func (g *Generator) correctTerms(canon Canon, index int, text string) string {
+	if g.PersistDir == "" {
+		return text
+	}
+	gl, err := LoadGlossary(g.PersistDir, canon)
+	if err != nil {
+		return text
+	}
+	out, n := gl.Correct(text, canon.Language)
+	if n > 0 && g.Log != nil {
+		g.Log(fmt.Sprintf("[术语] 第%d章 自动更正%d处", index, n))
+	}
+	return out
+}
+
+// TrackGlossary asks the model for the proper nouns of a written chapter
+// and adds the new ones to the glossary. A term that is a near miss of a
+// known one is not added nor recorded as its variant: Check reports it
+// until the user confirms it either way
+func (g *Generator) TrackGlossary(ctx context.Context, spec Spec, canon Canon, c ChapterContent) error {
+	if g.PersistDir == "" {
+		return nil
+	}
+	glossaryMu.Lock()
+	gl, err := LoadGlossary(g.PersistDir, canon)
+	glossaryMu.Unlock()
+	if err != nil {
+		return err
+	}
+	terms := make([]string, 0, len(gl.Entries))
+	for _, e := range gl.Entries {
+		terms = append(terms, e.Term)
+	}
+	sys, user, err := g.prompt(spec, "track_glossary", PromptData{Terms: terms, Chapter: c})
+	if err != nil {
+		return err
+	}
+	out, err := g.chatTimed(ctx, spec.Model, sys, user)
+	if err != nil {
+		return err
+	}
+	var found []GlossaryEntry
+	if err := json.Unmarshal([]byte(extractJSON(out)), &found); err != nil {
+		return err
+	}
+
+	glossaryMu.Lock()
+	defer glossaryMu.Unlock()
+	// re-read: another chapter may have been tracked while the model was busy
+	if gl, err = LoadGlossary(g.PersistDir, canon); err != nil {
+		return err
+	}
+	added, near := 0, 0
+	for _, f := range found {
+		f.Term = strings.TrimSpace(f.Term)
+		if f.Term == "" || f.Category == TermCharacter || gl.lookup(f.Term) >= 0 {
+			continue
+		}
+		if gl.nearMiss(f.Term) >= 0 {
+			near++
+			continue
+		}
+		f.Aliases, f.Variants, f.Chapter = nil, nil, c.Index
+		if gl.add(f) {
+			added++
+		}
+	}
+	if err := persistGlossary(g.PersistDir, gl, g.provenance(StageGlossary)); err != nil {
+		return err
+	}
+	if g.Log != nil {
+		g.Log(fmt.Sprintf("[术语] 第%d章 新增=%d 待确认=%d", c.Index, added, near))
+	}
+	return nil
+}
+
+// trackGlossary runs the glossary tracker after a chapter; a failure is
+// logged and does not stop the run
+func (g *Generator) trackGlossary(ctx context.Context, spec Spec, canon Canon, c ChapterContent) {
+	if err := g.TrackGlossary(ctx, spec, canon, c); err != nil && g.Log != nil {
+		g.Log(fmt.Sprintf("[术语] 第%d章 提取失败：%s", c.Index, err.Error()))
+	}
+}
+
+// glossaryIssues checks written chapters against the persisted glossary
+func (g *Generator) glossaryIssues(canon Canon, contents []ChapterContent) []CoherenceIssue {
+	if g.PersistDir == "" {
+		return nil
+	}
+	gl, err := LoadGlossary(g.PersistDir, canon)
+	if err != nil {
+		return nil
+	}
+	var issues []CoherenceIssue
+	for _, c := range contents {
+		issues = append(issues, gl.Check(c.Index, c.Content, canon.Language)...)
+	}
+	return issues
+}
+
+// chapterCanon is the canon a chapter is written from: the tracked
+// character states and open threads before it and the glossary entries
+// its plan mentions
+func (g *Generator) chapterCanon(c Canon, plan Chapter) Canon {
+	c = g.withStates(c, plan.Index)
+	c = g.withThreads(c, plan.Index)
+	return g.withGlossary(c, plan)
+}
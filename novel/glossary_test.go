@@ -0,0 +1,180 @@
+package novel
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTermDistance(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want float64
+	}{
+		{"青云门", "青云门", 0},
+		{"林风", "林峰", 0.3},
+		{"紫霄宫", "紫宵宫", 0.3},
+		{"玄天宗", "玄田宗", 0.6},
+		{"太虚剑诀", "太玄剑诀", 1},
+		{"青云门", "青云", 1},
+		{"张三", "李四", 2},
+	}
+	for _, c := range cases {
+		if got := termDistance([]rune(c.a), []rune(c.b)); got != c.want {
+			t.Errorf("termDistance(%s, %s) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestCloseSpelling(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{"林峰", "林风", true},
+		{"清云门", "青云门", true},
+		{"天箭宗", "天剑宗", true},
+		{"玄田宗", "玄天宗", true},
+		// one character apart in a long term: close, but only ever reported
+		{"太玄剑诀", "太虚剑诀", true},
+		{"青云门", "青云门", false},
+		{"白云门", "青云门", false},
+		{"万剑门", "万剑宗", false},
+		{"林凤", "林风", false},
+		{"青云", "青云门", false},
+		{"李四", "张三", false},
+		{"风", "峰", false},
+	}
+	for _, c := range cases {
+		if got := closeSpelling([]rune(c.a), []rune(c.b)); got != c.want {
+			t.Errorf("closeSpelling(%s, %s) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestGlossaryCorrect(t *testing.T) {
+	gl := Glossary{Entries: []GlossaryEntry{
+		{Term: "太虚剑诀", Category: TermTechnique, Variants: []string{"太墟剑诀"}},
+		{Term: "太玄剑诀", Category: TermTechnique},
+		{Term: "青云门", Category: TermSect, Aliases: []string{"青云"}, Variants: []string{"清云门", "清云"}},
+	}}
+	cases := []struct {
+		in, want string
+		n        int
+	}{
+		{"他练成了太墟剑诀。", "他练成了太虚剑诀。", 1},
+		{"太玄剑诀与太虚剑诀并非一物。", "太玄剑诀与太虚剑诀并非一物。", 0},
+		{"清云门弟子自称清云。", "青云门弟子自称青云门。", 2},
+		{"青云门又称青云。", "青云门又称青云。", 0},
+		{"林峰来了。", "林峰来了。", 0},
+	}
+	for _, c := range cases {
+		got, n := gl.Correct(c.in, LangChinese)
+		if got != c.want || n != c.n {
+			t.Errorf("Correct(%s) = %s, %d; want %s, %d", c.in, got, n, c.want, c.n)
+		}
+	}
+}
+
+func TestGlossaryCorrectWords(t *testing.T) {
+	gl := Glossary{Entries: []GlossaryEntry{
+		{Term: "John", Category: TermCharacter, Variants: []string{"Jon"}},
+	}}
+	cases := []struct {
+		in, want string
+		n        int
+	}{
+		{"Jon went north.", "John went north.", 1},
+		{"Jon's sword. Ask Jon!", "John's sword. Ask John!", 2},
+		{"Jonathan met Jon.", "Jonathan met John.", 1},
+		{"Jonathan stayed.", "Jonathan stayed.", 0},
+		{"DeJon and Jon2 stayed.", "DeJon and Jon2 stayed.", 0},
+	}
+	for _, c := range cases {
+		got, n := gl.Correct(c.in, LangEnglish)
+		if got != c.want || n != c.n {
+			t.Errorf("Correct(%s) = %s, %d; want %s, %d", c.in, got, n, c.want, c.n)
+		}
+	}
+}
+
+func TestGlossaryCheck(t *testing.T) {
+	cases := []struct {
+		name    string
+		entries []GlossaryEntry
+		text    string
+		found   []string
+		known   []bool
+	}{
+		{"unconfirmed near miss", []GlossaryEntry{{Term: "太虚剑诀"}}, "他使出太玄剑诀。", []string{"太玄剑诀"}, []bool{false}},
+		{"distinct entity", []GlossaryEntry{{Term: "太虚剑诀"}, {Term: "太玄剑诀"}}, "他使出太玄剑诀。", nil, nil},
+		{"confirmed variant", []GlossaryEntry{{Term: "太虚剑诀", Variants: []string{"太玄剑诀"}}}, "他使出太玄剑诀。", []string{"太玄剑诀"}, []bool{true}},
+		{"two character words", []GlossaryEntry{{Term: "天剑", Category: TermArtifact}}, "天箭", nil, nil},
+		{"two character names", []GlossaryEntry{{Term: "林风", Category: TermCharacter}}, "林峰笑了", []string{"林峰"}, []bool{false}},
+	}
+	for _, c := range cases {
+		gl := Glossary{Entries: c.entries}
+		var found []string
+		var known []bool
+		for _, m := range gl.FindNearMisses(c.text) {
+			found = append(found, m.Found)
+			known = append(known, m.Known)
+		}
+		if !reflect.DeepEqual(found, c.found) || !reflect.DeepEqual(known, c.known) {
+			t.Errorf("%s: near misses = %v %v, want %v %v", c.name, found, known, c.found, c.known)
+		}
+		issues := gl.Check(3, c.text, LangChinese)
+		if len(issues) != len(c.found) {
+			t.Errorf("%s: %d issues, want %d", c.name, len(issues), len(c.found))
+		}
+		for _, is := range issues {
+			if is.Chapter != 3 || is.Type != IssueTerminology || is.Detail == "" || is.FixHint == "" {
+				t.Errorf("%s: incomplete issue %+v", c.name, is)
+			}
+		}
+	}
+}
+
+type glossaryClient struct{ reply string }
+
+func (c glossaryClient) Chat(ctx context.Context, model, system, user string) (string, error) {
+	return c.reply, nil
+}
+
+func TestTrackGlossaryKeepsNearMissesUnconfirmed(t *testing.T) {
+	dir := t.TempDir()
+	seed := Glossary{Entries: []GlossaryEntry{{Term: "太虚剑诀", Category: TermTechnique}}}
+	b, _ := json.Marshal(seed)
+	if err := os.WriteFile(filepath.Join(dir, GlossaryFile), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reply := `[{"term":"太玄剑诀","category":"technique"},{"term":"天机阁","category":"sect"},{"term":"太虚剑诀","category":"technique"}]`
+	g := NewGenerator(glossaryClient{reply}).WithPersistDir(dir)
+	c := ChapterContent{Index: 2, Title: "第二章", Content: "他以太玄剑诀破了天机阁的阵。"}
+	if err := g.TrackGlossary(context.Background(), Spec{}, Canon{}, c); err != nil {
+		t.Fatal(err)
+	}
+	gl, err := LoadGlossary(dir, Canon{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var terms []string
+	for _, e := range gl.Entries {
+		terms = append(terms, e.Term)
+		if len(e.Variants) > 0 {
+			t.Errorf("%s learned variants %v", e.Term, e.Variants)
+		}
+	}
+	if want := []string{"太虚剑诀", "天机阁"}; !reflect.DeepEqual(terms, want) {
+		t.Fatalf("terms = %v, want %v", terms, want)
+	}
+	if issues := gl.Check(c.Index, c.Content, ""); len(issues) != 1 {
+		t.Fatalf("near miss not reported: %+v", issues)
+	}
+	if fixed, n := gl.Correct(c.Content, LangChinese); n != 0 || fixed != c.Content {
+		t.Fatalf("unconfirmed near miss corrected: %s", fixed)
+	}
+}
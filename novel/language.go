@@ -0,0 +1,267 @@
+package novel
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// languages a book can be written in; Chinese is the default
+const (
+	LangChinese  = "zh"
+	LangEnglish  = "en"
+	LangJapanese = "ja"
+)
+
+// Language is one language books are generated in. Its prompt templates
+// live in prompts/<code>/, Chinese ones at the top of prompts/
+type Language struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	// Unit is what chapter lengths are counted and asked for in
+	Unit string `json:"unit"`
+	// list and colon separate list items and a label from its value in
+	// prompt text built outside the templates
+	list  string
+	colon string
+	// parens enclose an aside such as a character's role
+	parens [2]string
+	// contents and cast head the table of contents and the character
+	// appendix of exported books
+	contents string
+	cast     string
+	// branch titles a fork from the book title and the chapter it branches
+	// at; branchN adds the number of a later fork at the same chapter
+	branch, branchN string
+	// spaced languages separate words with spaces, so glossary corrections
+	// replace whole words only
+	spaced   bool
+	artifact ArtifactText
+	issues   issueText
+}
+
+// ArtifactText words the markdown rendering of a job's artifacts
+type ArtifactText struct {
+	// headings of the plans, characters, character state, timeline and
+	// glossary documents
+	Plans, Characters, States, Timeline, Glossary string
+	// Chapter and Day take a chapter number and a story day
+	Chapter, Day string
+	// labels of a character's traits, a term's aliases and misspellings and
+	// a flashback event
+	Traits, Aliases, Variants, Flashback string
+	// State labels the fields of a character state: location, status,
+	// realm, possessions, relationships and knowledge
+	State [6]string
+	// List, Colon and Parens are the language's punctuation and Space the
+	// gap between words, empty where words are not spaced; Sep separates
+	// the notes of a timeline event and Semi what a character knows
+	List, Colon, Space, Sep, Semi string
+	Parens                        [2]string
+}
+
+// issueText words the coherence issues that are found without a model
+// call; the arguments of each detail are listed next to it
+type issueText struct {
+	// event, days
+	backwards, backwardsHint string
+	// name, earlier chapter, earlier age, days since, age
+	age, ageHint string
+	// name, chapter since, place, place set out from
+	departs, departsHint string
+	// name, chapter since, place, destination
+	revisits, revisitsHint string
+	// spelling, term; the hints take the term
+	knownTerm, knownTermHint string
+	nearTerm, nearTermHint   string
+}
+
+var languages = []Language{
+	{Code: LangChinese, Name: "中文", Unit: "字", list: "、", colon: "：", parens: [2]string{"（", "）"}, contents: "目录", cast: "人物表", branch: "%s（第%d章分支）", branchN: "%s（第%d章分支%d）", artifact: ArtifactText{
+		Plans: "章节规划", Characters: "人物", States: "人物状态", Timeline: "时间线", Glossary: "名词表",
+		Chapter: "第%d章", Day: "第%d天", Traits: "性格", Aliases: "又称", Variants: "误写", Flashback: "回忆",
+		State: [6]string{"位置", "状态", "境界", "持有", "关系", "已知"}, Sep: "，", Semi: "；",
+	}, issues: issueText{
+		backwards:     "“%s”发生在前一事件之前%d天，但并非回忆或插叙",
+		backwardsHint: "改为顺叙的时间表述，或明确写成回忆",
+		age:           "%s第%d章时%d岁，本章（约%d天后）为%d岁，年龄与经过的时间不符",
+		ageHint:       "核对人物年龄或时间跨度",
+		departs:       "%s第%d章起在%s，本章却从%s出发",
+		departsHint:   "补写前往出发地的经过，或修正出发地",
+		revisits:      "%s第%d章起已在%s，本章又前往%s",
+		revisitsHint:  "删去重复的行程，或补写此前离开的经过",
+		knownTerm:     "“%s”是“%s”的已知误写",
+		knownTermHint: "统一写作“%s”",
+		nearTerm:      "“%s”与术语“%s”写法相近，尚未确认是否为同一名称",
+		nearTermHint:  "若为误写，将其加入“%s”的 variants；若是另一名称，将其加入术语表",
+	}},
+	{Code: LangEnglish, Name: "English", Unit: "words", list: ", ", colon: ": ", parens: [2]string{" (", ")"}, contents: "Contents", cast: "Characters", branch: "%s (branch at chapter %d)", branchN: "%s (branch %[3]d at chapter %[2]d)", spaced: true, artifact: ArtifactText{
+		Plans: "Chapter plans", Characters: "Characters", States: "Character states", Timeline: "Timeline", Glossary: "Glossary",
+		Chapter: "chapter %d", Day: "day %d", Traits: "Personality", Aliases: "also called", Variants: "misspelled as", Flashback: "flashback",
+		State: [6]string{"Location", "Condition", "Rank", "Carries", "Relationships", "Knows"}, Sep: ", ", Semi: "; ",
+	}, issues: issueText{
+		backwards:     "\"%s\" happens %d days before the previous event but is not a flashback",
+		backwardsHint: "Use a forward time reference, or make it an explicit flashback",
+		age:           "%[1]s is %[3]d in chapter %[2]d but %[5]d here, about %[4]d days later, which does not match the time passed",
+		ageHint:       "Check the character's age or the time span",
+		departs:       "%[1]s has been in %[3]s since chapter %[2]d but sets out from %[4]s here",
+		departsHint:   "Write the journey to the starting point, or correct it",
+		revisits:      "%[1]s has been in %[3]s since chapter %[2]d and travels to %[4]s again",
+		revisitsHint:  "Drop the repeated journey, or write how the character left before",
+		knownTerm:     "\"%s\" is a known misspelling of \"%s\"",
+		knownTermHint: "Write \"%s\" throughout",
+		nearTerm:      "\"%s\" is spelled close to the term \"%s\" and is not confirmed as the same name",
+		nearTermHint:  "If it is a misspelling, add it to the variants of \"%s\"; if it is another name, add it to the glossary",
+	}},
+	{Code: LangJapanese, Name: "日本語", Unit: "文字", list: "、", colon: "：", parens: [2]string{"（", "）"}, contents: "目次", cast: "登場人物", branch: "%s（第%d章からの分岐）", branchN: "%s（第%d章からの分岐%d）", artifact: ArtifactText{
+		Plans: "章立て", Characters: "登場人物", States: "人物の状態", Timeline: "年表", Glossary: "用語集",
+		Chapter: "第%d章", Day: "%d日目", Traits: "性格", Aliases: "別名", Variants: "誤記", Flashback: "回想",
+		State: [6]string{"居場所", "状態", "境地", "所持品", "関係", "知っていること"}, Sep: "、", Semi: "；",
+	}, issues: issueText{
+		backwards:     "「%s」は前の出来事の%d日前に起きているが、回想や挿話ではない",
+		backwardsHint: "順行の時間表現に改めるか、回想であることを明示する",
+		age:           "%sは第%d章で%d歳だが、本章（約%d日後）では%d歳で、経過した時間と合わない",
+		ageHint:       "人物の年齢か時間の経過を確認する",
+		departs:       "%sは第%d章から%sにいるが、本章では%sから出発している",
+		departsHint:   "出発地へ移動した経緯を書き足すか、出発地を修正する",
+		revisits:      "%sは第%d章から%sにいるが、本章で再び%sへ向かっている",
+		revisitsHint:  "重複した移動を削るか、その前に離れた経緯を書き足す",
+		knownTerm:     "「%s」は「%s」の既知の誤記",
+		knownTermHint: "「%s」に統一する",
+		nearTerm:      "「%s」は用語「%s」と表記が近く、同じ名称かどうか未確認",
+		nearTermHint:  "誤記であれば「%s」の variants に加え、別の名称であれば用語集に加える",
+	}},
+}
+
+// Languages lists the languages books can be generated in
+func Languages() []Language {
+	return append([]Language(nil), languages...)
+}
+
+// NormalizeLanguage maps a language tag such as "en-US", "ja_JP" or "ZH" to
+// the code of a supported language; empty is Chinese
+func NormalizeLanguage(tag string) (string, error) {
+	code := strings.ToLower(strings.TrimSpace(tag))
+	if code == "" {
+		return LangChinese, nil
+	}
+	if i := strings.IndexAny(code, "-_"); i != -1 {
+		code = code[:i]
+	}
+	for _, l := range languages {
+		if l.Code == code {
+			return code, nil
+		}
+	}
+	codes := make([]string, 0, len(languages))
+	for _, l := range languages {
+		codes = append(codes, l.Code)
+	}
+	return "", fmt.Errorf("unsupported language %q, want one of %s", tag, strings.Join(codes, ", "))
+}
+
+// languageFor is the language of a code or tag, Chinese for an unknown one
+func languageFor(code string) Language {
+	code, _ = NormalizeLanguage(code)
+	for _, l := range languages {
+		if l.Code == code {
+			return l
+		}
+	}
+	return languages[0]
+}
+
+// ArtifactLabels is the wording of rendered artifacts in language lang
+func ArtifactLabels(lang string) ArtifactText {
+	l := languageFor(lang)
+	t := l.artifact
+	t.List, t.Colon, t.Parens = l.list, l.colon, l.parens
+	if l.spaced {
+		t.Space = " "
+	}
+	return t
+}
+
+// BranchTitle is the title of the n-th fork of a book at chapter at, in
+// the book's language
+func BranchTitle(lang, title string, at, n int) string {
+	l := languageFor(lang)
+	if n > 1 {
+		return fmt.Sprintf(l.branchN, title, at, n)
+	}
+	return fmt.Sprintf(l.branch, title, at)
+}
+
+// language is the code of the language the book is written in
+func (s Spec) language() string {
+	code, err := NormalizeLanguage(s.Language)
+	if err != nil {
+		return LangChinese
+	}
+	return code
+}
+
+// minDetectUnits is how many characters and words a text needs before its
+// language is told; shorter texts are not judged
+const minDetectUnits = 80
+
+// DetectLanguage tells the language of prose from its scripts: kana mark
+// Japanese, Han without kana Chinese and Latin words English, counted as
+// CountWords counts them. One English word is weighed as two characters.
+// It returns "" for text that is too short or in none of these scripts
+func DetectLanguage(s string) string {
+	var han, kana, latin int
+	inWord := false
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || r == 'ー':
+			kana++
+			inWord = false
+		case unicode.Is(unicode.Han, r):
+			han++
+			inWord = false
+		case unicode.Is(unicode.Latin, r):
+			if !inWord {
+				latin++
+				inWord = true
+			}
+		case inWord && (r == '\'' || r == '’' || r == '-'):
+		default:
+			inWord = false
+		}
+	}
+	cjk := han + kana
+	switch {
+	case cjk+latin < minDetectUnits:
+		return ""
+	case latin*2 > cjk:
+		return LangEnglish
+	case kana*5 > cjk:
+		// Japanese prose is mostly kana; Chinese has none
+		return LangJapanese
+	}
+	return LangChinese
+}
+
+// LanguageError rejects a text written in another language than the book's
+type LanguageError struct {
+	Want string
+	Got  string
+}
+
+func (e *LanguageError) Error() string {
+	return fmt.Sprintf("text is written in %s instead of %s", e.Got, e.Want)
+}
+
+// CheckLanguage reports text that is written in another language than
+// lang; text too short to tell passes
+func CheckLanguage(lang, text string) error {
+	want, err := NormalizeLanguage(lang)
+	if err != nil {
+		return err
+	}
+	if got := DetectLanguage(text); got != "" && got != want {
+		return &LanguageError{Want: want, Got: got}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package novel
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeLanguage(t *testing.T) {
+	cases := []struct {
+		tag  string
+		want string
+		ok   bool
+	}{
+		{"", LangChinese, true},
+		{"  ", LangChinese, true},
+		{"zh", LangChinese, true},
+		{"ZH", LangChinese, true},
+		{"zh-CN", LangChinese, true},
+		{"en-US", LangEnglish, true},
+		{"en_GB", LangEnglish, true},
+		{"ja_JP", LangJapanese, true},
+		{" ja ", LangJapanese, true},
+		{"fr", "", false},
+		{"-en", "", false},
+		{"english", "", false},
+	}
+	for _, c := range cases {
+		got, err := NormalizeLanguage(c.tag)
+		if (err == nil) != c.ok || got != c.want {
+			t.Errorf("NormalizeLanguage(%q) = %q, %v; want %q ok=%v", c.tag, got, err, c.want, c.ok)
+		}
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	zh := strings.Repeat("他推开门，看见院子里站着一个陌生人。", 5)
+	ja := strings.Repeat("彼は扉を開けて、庭に立っている見知らぬ人を見た。", 5)
+	en := strings.Repeat("He opened the door and saw a stranger standing in the yard. ", 8)
+	cases := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"chinese", zh, LangChinese},
+		{"japanese", ja, LangJapanese},
+		{"english", en, LangEnglish},
+		{"too short", "他推开门。", ""},
+		{"digits and punctuation", strings.Repeat("123, 456! ", 50), ""},
+		{"chinese with english names", zh + "Tom and Jerry", LangChinese},
+		{"english quoting chinese", en + "他推开门", LangEnglish},
+		{"contractions are one word", strings.Repeat("don't ", 79) + "x", LangEnglish},
+	}
+	for _, c := range cases {
+		if got := DetectLanguage(c.text); got != c.want {
+			t.Errorf("%s: DetectLanguage = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCheckLanguage(t *testing.T) {
+	en := strings.Repeat("He opened the door and saw a stranger standing in the yard. ", 8)
+	var le *LanguageError
+	if err := CheckLanguage("zh", en); !errors.As(err, &le) || le.Got != LangEnglish || le.Want != LangChinese {
+		t.Fatalf("english text in a chinese book: err = %v", err)
+	}
+	if err := CheckLanguage("en-US", en); err != nil {
+		t.Fatalf("english text in an english book: %v", err)
+	}
+	if err := CheckLanguage("ja", "短い"); err != nil {
+		t.Fatalf("short text: %v", err)
+	}
+	if err := CheckLanguage("fr", en); err == nil || errors.As(err, &le) {
+		t.Fatalf("unsupported language: err = %v", err)
+	}
+}
+
+func TestLanguagePackText(t *testing.T) {
+	cases := []struct {
+		lang          string
+		contents, cas string
+	}{
+		{"", "目录", "人物表"},
+		{LangChinese, "目录", "人物表"},
+		{LangEnglish, "Contents", "Characters"},
+		{"en-US", "Contents", "Characters"},
+		{LangJapanese, "目次", "登場人物"},
+		{"xx", "目录", "人物表"},
+	}
+	for _, c := range cases {
+		if got := tocTitle(c.lang); got != c.contents {
+			t.Errorf("tocTitle(%q) = %s, want %s", c.lang, got, c.contents)
+		}
+		if got := appendixTitle(c.lang); got != c.cas {
+			t.Errorf("appendixTitle(%q) = %s, want %s", c.lang, got, c.cas)
+		}
+	}
+	gl := Glossary{Entries: []GlossaryEntry{{Term: "太虚剑诀", Variants: []string{"太墟剑诀"}}}}
+	text := "太玄剑诀与太墟剑诀"
+	for _, lang := range []string{LangChinese, LangEnglish, LangJapanese} {
+		issues := gl.Check(1, text, lang)
+		if len(issues) != 2 {
+			t.Fatalf("%s: %d issues, want 2", lang, len(issues))
+		}
+		for _, is := range issues {
+			if !strings.Contains(is.Detail, "太虚剑诀") || !strings.Contains(is.FixHint, "太虚剑诀") {
+				t.Errorf("%s: issue does not name the term: %+v", lang, is)
+			}
+			if lang != LangChinese && strings.ContainsAny(is.Detail+is.FixHint, "误写确认统") {
+				t.Errorf("%s: issue worded in chinese: %+v", lang, is)
+			}
+		}
+	}
+}
+
+func TestBranchTitle(t *testing.T) {
+	cases := []struct {
+		lang  string
+		at, n int
+		want  string
+	}{
+		{"", 3, 1, "书（第3章分支）"},
+		{LangChinese, 3, 2, "书（第3章分支2）"},
+		{LangEnglish, 12, 1, "书 (branch at chapter 12)"},
+		{"en-GB", 12, 3, "书 (branch 3 at chapter 12)"},
+		{LangJapanese, 5, 1, "书（第5章からの分岐）"},
+		{LangJapanese, 5, 2, "书（第5章からの分岐2）"},
+	}
+	for _, c := range cases {
+		if got := BranchTitle(c.lang, "书", c.at, c.n); got != c.want {
+			t.Errorf("BranchTitle(%q, %d, %d) = %s, want %s", c.lang, c.at, c.n, got, c.want)
+		}
+	}
+}
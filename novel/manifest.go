@@ -0,0 +1,277 @@
+package novel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ManifestFile is the per-job provenance index kept next to the artifacts
+const ManifestFile = "manifest.json"
+
+const (
+	StageSettings = "settings"
+	StageChapter  = "chapter"
+	StageSpec     = "spec"
+	StageSource   = "source"
+)
+
+// artifact origins recorded in the manifest
+const (
+	OriginGenerated = "generated"
+	OriginEdited    = "edited"
+	OriginInput     = "input"
+)
+
+// Provenance describes how an artifact came to be written
+type Provenance struct {
+	Stage      string
+	Origin     string
+	Model      string
+	PromptHash string
+	// Templates are the prompt templates used, as name@version
+	Templates []string
+	// Calls are the prompts sent, Attempts the requests including retries
+	Calls       int
+	Attempts    int
+	Temperature *float64
+	// Instruction is the extra guidance a chapter was generated with
+	Instruction string
+}
+
+// ManifestEntry is the provenance record of one artifact; Path is relative to the job dir
+type ManifestEntry struct {
+	Path       string   `json:"path"`
+	SHA256     string   `json:"sha256"`
+	Size       int64    `json:"size"`
+	Words      int      `json:"words"`
+	Stage      string   `json:"stage"`
+	Origin     string   `json:"origin"`
+	Model      string   `json:"model,omitempty"`
+	PromptHash string   `json:"prompt_hash,omitempty"`
+	Templates  []string `json:"templates,omitempty"`
+	Calls      int      `json:"calls,omitempty"`
+	Attempts   int      `json:"attempts,omitempty"`
+	// Temperature is the sampling temperature, when the client reports it
+	Temperature *float64  `json:"temperature,omitempty"`
+	Revision    int       `json:"revision"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Provenance returns what RecordArtifact needs to carry the entry over to a copy
+func (e ManifestEntry) Provenance() Provenance {
+	return Provenance{Stage: e.Stage, Origin: e.Origin, Model: e.Model, PromptHash: e.PromptHash, Templates: e.Templates, Calls: e.Calls, Attempts: e.Attempts, Temperature: e.Temperature}
+}
+
+// ManifestParent records the job and chapter a forked job branched from
+type ManifestParent struct {
+	Job      string    `json:"job"`
+	At       int       `json:"at"`
+	ForkedAt time.Time `json:"forked_at"`
+}
+
+type Manifest struct {
+	Version   int             `json:"version"`
+	Generator string          `json:"generator"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Parent    *ManifestParent `json:"parent,omitempty"`
+	Artifacts []ManifestEntry `json:"artifacts"`
+}
+
+// manifestLocks serialises read-modify-write of each job's manifest
+var manifestLocks sync.Map
+
+func manifestLock(dir string) *sync.Mutex {
+	mu, _ := manifestLocks.LoadOrStore(filepath.Clean(dir), &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+// ReadManifest loads dir/manifest.json; a missing file yields an empty manifest
+func ReadManifest(dir string) (Manifest, error) {
+	m := Manifest{Version: 1, Generator: GeneratorName}
+	b, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// RecordArtifact hashes dir/rel as it is on disk and upserts its manifest entry;
+// the revision only moves when the content changed
+func RecordArtifact(dir, rel string, p Provenance) error {
+	if dir == "" {
+		return nil
+	}
+	rel = filepath.ToSlash(rel)
+	b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+	return updateManifest(dir, func(m *Manifest, now time.Time) {
+		e := ManifestEntry{Path: rel, CreatedAt: now}
+		at := -1
+		for i := range m.Artifacts {
+			if m.Artifacts[i].Path == rel {
+				e, at = m.Artifacts[i], i
+				break
+			}
+		}
+		if e.SHA256 == hash && at >= 0 {
+			return
+		}
+		e.SHA256 = hash
+		e.Size = int64(len(b))
+		e.Words = artifactWords(rel, b)
+		e.Stage = p.Stage
+		e.Origin = p.Origin
+		e.Model = p.Model
+		e.PromptHash = p.PromptHash
+		e.Templates = p.Templates
+		e.Calls = p.Calls
+		e.Attempts = p.Attempts
+		e.Temperature = p.Temperature
+		e.Revision++
+		e.UpdatedAt = now
+		if at < 0 {
+			m.Artifacts = append(m.Artifacts, e)
+		} else {
+			m.Artifacts[at] = e
+		}
+	})
+}
+
+// SetManifestParent marks dir as a fork of another job
+func SetManifestParent(dir string, p ManifestParent) error {
+	return updateManifest(dir, func(m *Manifest, now time.Time) {
+		m.Parent = &p
+	})
+}
+
+// ForgetArtifact drops the entry of a deleted artifact
+func ForgetArtifact(dir, rel string) error {
+	rel = filepath.ToSlash(rel)
+	return updateManifest(dir, func(m *Manifest, now time.Time) {
+		kept := m.Artifacts[:0]
+		for _, e := range m.Artifacts {
+			if e.Path != rel {
+				kept = append(kept, e)
+			}
+		}
+		m.Artifacts = kept
+	})
+}
+
+// MoveArtifacts re-keys entries of renamed artifacts by old->new relative path
+func MoveArtifacts(dir string, moves map[string]string) error {
+	if len(moves) == 0 {
+		return nil
+	}
+	return updateManifest(dir, func(m *Manifest, now time.Time) {
+		for i := range m.Artifacts {
+			if to, ok := moves[m.Artifacts[i].Path]; ok {
+				m.Artifacts[i].Path = filepath.ToSlash(to)
+				m.Artifacts[i].UpdatedAt = now
+			}
+		}
+	})
+}
+
+func updateManifest(dir string, edit func(m *Manifest, now time.Time)) error {
+	mu := manifestLock(dir)
+	mu.Lock()
+	defer mu.Unlock()
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	edit(&m, now)
+	sort.Slice(m.Artifacts, func(i, j int) bool { return m.Artifacts[i].Path < m.Artifacts[j].Path })
+	m.Version = 1
+	m.Generator = GeneratorName
+	m.UpdatedAt = now
+	b, _ := json.MarshalIndent(m, "", "  ")
+	return writeAtomic(filepath.Join(dir, ManifestFile), b)
+}
+
+func writeAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// artifactWords counts prose in chapters and string values in JSON artifacts
+func artifactWords(rel string, b []byte) int {
+	switch {
+	case strings.HasSuffix(rel, ".md"):
+		_, body := ParseChapterFile(b)
+		return CountWords(PlainText(body))
+	case strings.HasSuffix(rel, ".json"):
+		var v interface{}
+		if json.Unmarshal(b, &v) != nil {
+			return 0
+		}
+		return jsonWords(v)
+	default:
+		return CountWords(string(b))
+	}
+}
+
+func jsonWords(v interface{}) int {
+	switch t := v.(type) {
+	case string:
+		return CountWords(t)
+	case []interface{}:
+		n := 0
+		for _, x := range t {
+			n += jsonWords(x)
+		}
+		return n
+	case map[string]interface{}:
+		n := 0
+		for _, x := range t {
+			n += jsonWords(x)
+		}
+		return n
+	}
+	return 0
+}
+
+// promptHash identifies the exact system and user prompt sent to the model
+func promptHash(sys, user string) string {
+	h := sha256.New()
+	h.Write([]byte(sys))
+	h.Write([]byte{0})
+	h.Write([]byte(user))
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,145 @@
+package novel
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRecordArtifact(t *testing.T) {
+	dir := t.TempDir()
+	write := func(rel, body string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, rel)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, rel), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry := func(rel string) (ManifestEntry, bool) {
+		t.Helper()
+		m, err := ReadManifest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range m.Artifacts {
+			if e.Path == rel {
+				return e, true
+			}
+		}
+		return ManifestEntry{}, false
+	}
+	temp := 0.7
+	p := Provenance{Stage: StageChapter, Origin: OriginGenerated, Model: "m", Calls: 1, Attempts: 2, Temperature: &temp}
+	write("chapters/0001_a.md", "# a\n\n一二三")
+	if err := RecordArtifact(dir, "chapters/0001_a.md", p); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := entry("chapters/0001_a.md")
+	if first.Revision != 1 || first.Size != int64(len("# a\n\n一二三")) || first.Words != 3 || len(first.SHA256) != 64 {
+		t.Fatalf("first entry = %+v", first)
+	}
+	if first.Model != "m" || first.Attempts != 2 || first.Temperature == nil || *first.Temperature != 0.7 {
+		t.Fatalf("provenance not recorded: %+v", first)
+	}
+	// the same bytes again leave the entry alone, whatever the provenance
+	if err := RecordArtifact(dir, "chapters/0001_a.md", Provenance{Stage: StageChapter, Origin: OriginEdited}); err != nil {
+		t.Fatal(err)
+	}
+	if same, _ := entry("chapters/0001_a.md"); !reflect.DeepEqual(same, first) {
+		t.Fatalf("unchanged artifact rewritten: %+v", same)
+	}
+	write("chapters/0001_a.md", "# a\n\n一二三四")
+	if err := RecordArtifact(dir, "chapters/0001_a.md", Provenance{Stage: StageChapter, Origin: OriginEdited}); err != nil {
+		t.Fatal(err)
+	}
+	next, _ := entry("chapters/0001_a.md")
+	if next.Revision != 2 || next.Origin != OriginEdited || next.Temperature != nil || next.Words != 4 || !next.CreatedAt.Equal(first.CreatedAt) || next.SHA256 == first.SHA256 {
+		t.Fatalf("changed entry = %+v", next)
+	}
+	write("outline.json", `{"title":"书名","chapters":[{"title":"开端","summary":"他来了"}]}`)
+	if err := RecordArtifact(dir, "outline.json", Provenance{Stage: "outline"}); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := entry("outline.json"); e.Words != 7 {
+		t.Fatalf("outline words = %d, want 7", e.Words)
+	}
+	if err := RecordArtifact(dir, "missing.json", Provenance{}); err == nil {
+		t.Fatal("missing artifact recorded")
+	}
+
+	if err := MoveArtifacts(dir, map[string]string{"chapters/0001_a.md": "chapters/0002_a.md"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := entry("chapters/0001_a.md"); ok {
+		t.Fatal("moved entry kept its old path")
+	}
+	if moved, ok := entry("chapters/0002_a.md"); !ok || moved.SHA256 != next.SHA256 || moved.Revision != 2 {
+		t.Fatalf("moved entry = %+v", moved)
+	}
+	if err := ForgetArtifact(dir, "chapters/0002_a.md"); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := ReadManifest(dir)
+	if len(m.Artifacts) != 1 || m.Artifacts[0].Path != "outline.json" || m.Generator != GeneratorName {
+		t.Fatalf("manifest = %+v", m)
+	}
+}
+
+// flakyClient fails its first calls and reports a sampling temperature
+type flakyClient struct {
+	fail  int
+	calls int
+	temp  float64
+}
+
+func (c *flakyClient) Chat(ctx context.Context, model, system, user string) (string, error) {
+	c.calls++
+	if c.calls <= c.fail {
+		return "", errors.New("unavailable")
+	}
+	return "ok", nil
+}
+
+func (c *flakyClient) Temperature() float64 { return c.temp }
+
+func TestProvenanceTracesCalls(t *testing.T) {
+	cases := []struct {
+		name     string
+		client   ChatClient
+		retries  int
+		calls    int
+		attempts int
+		temp     *float64
+		ok       bool
+	}{
+		{"first try", &flakyClient{temp: 0.3}, 3, 1, 1, floatPtr(0.3), true},
+		{"retried", &flakyClient{fail: 2, temp: 1.2}, 3, 1, 3, floatPtr(1.2), true},
+		{"out of retries", &flakyClient{fail: 5}, 2, 1, 2, floatPtr(0), false},
+		{"client without a temperature", glossaryClient{"ok"}, 1, 1, 1, nil, true},
+	}
+	for _, c := range cases {
+		g := NewGenerator(c.client).WithRequestPolicy(0, c.retries, 1)
+		g.traceTemplate("outline@abc")
+		_, err := g.chatWithRetry(context.Background(), "model-x", "sys", "user")
+		if (err == nil) != c.ok {
+			t.Fatalf("%s: err = %v", c.name, err)
+		}
+		p := g.provenance("outline")
+		if p.Calls != c.calls || p.Attempts != c.attempts || p.Model != "model-x" || p.PromptHash == "" || !reflect.DeepEqual(p.Templates, []string{"outline@abc"}) {
+			t.Errorf("%s: provenance = %+v", c.name, p)
+		}
+		if !reflect.DeepEqual(p.Temperature, c.temp) {
+			t.Errorf("%s: temperature = %v, want %v", c.name, p.Temperature, c.temp)
+		}
+		if next := g.provenance("characters"); next.Calls != 0 || next.Attempts != 0 || next.Templates != nil {
+			t.Errorf("%s: trace not reset: %+v", c.name, next)
+		}
+	}
+}
+
+func floatPtr(f float64) *float64 { return &f }
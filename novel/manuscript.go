@@ -0,0 +1,119 @@
+package novel
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PageSize is a paper size in PostScript points
+type PageSize struct {
+	Name          string
+	Width, Height float64
+}
+
+var pageSizes = map[string]PageSize{
+	"a4":     {"A4", 595.28, 841.89},
+	"a5":     {"A5", 419.53, 595.28},
+	"b5":     {"B5", 498.9, 708.66},
+	"letter": {"Letter", 612, 792},
+	"16k":    {"16K", 524.41, 737.01},
+}
+
+const mmToPt = 72 / 25.4
+
+// ParsePageSize accepts a named size (A4, A5, B5, 16K, Letter) or
+// WIDTHxHEIGHTmm; empty means A4
+func ParsePageSize(s string) (PageSize, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return pageSizes["a4"], nil
+	}
+	if p, ok := pageSizes[s]; ok {
+		return p, nil
+	}
+	if dims, ok := strings.CutSuffix(s, "mm"); ok {
+		if w, h, ok := strings.Cut(dims, "x"); ok {
+			wv, err1 := strconv.ParseFloat(strings.TrimSpace(w), 64)
+			hv, err2 := strconv.ParseFloat(strings.TrimSpace(h), 64)
+			if err1 == nil && err2 == nil && wv >= 50 && hv >= 50 && wv <= 1000 && hv <= 1000 {
+				return PageSize{Name: s, Width: wv * mmToPt, Height: hv * mmToPt}, nil
+			}
+		}
+	}
+	return PageSize{}, fmt.Errorf("invalid page size %q, want A4, A5, B5, 16K, Letter or WxHmm", s)
+}
+
+// ManuscriptOptions configures the DOCX and PDF exporters
+type ManuscriptOptions struct {
+	PageSize PageSize
+	Font     *Font   // embedded font; required for PDF, optional for DOCX
+	FontSize float64 // body size in points, default 12
+}
+
+func (o ManuscriptOptions) withDefaults() ManuscriptOptions {
+	if o.PageSize.Width == 0 {
+		o.PageSize = pageSizes["a4"]
+	}
+	if o.FontSize <= 0 {
+		o.FontSize = 12
+	}
+	return o
+}
+
+// Characters that may not start (closing) or end (opening) a line in CJK typesetting
+const (
+	noLineStart = "，。、；：？！）》」』】〕〉”’…—·,.;:?!)]}%"
+	noLineEnd   = "（《「『【〔〈“‘([{"
+)
+
+// wrapLine breaks one paragraph into lines no wider than width; CJK text
+// may break between any two characters except around the punctuation
+// above, other scripts break at spaces
+func wrapLine(f *Font, s string, size, width, firstIndent float64) []string {
+	rs := []rune(s)
+	var lines []string
+	start := 0
+	lastBreak := -1
+	w := firstIndent
+	avail := width
+	scale := size / float64(f.UnitsPerEm)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		if i > start && canBreakBefore(rs, i) {
+			lastBreak = i
+		}
+		w += float64(f.Advance(r)) * scale
+		if w <= avail || i == start {
+			continue
+		}
+		cut := lastBreak
+		if cut <= start {
+			cut = i
+		}
+		lines = append(lines, strings.TrimRight(string(rs[start:cut]), " "))
+		for cut < len(rs) && rs[cut] == ' ' {
+			cut++
+		}
+		start = cut
+		lastBreak = -1
+		w = 0
+		i = cut - 1
+	}
+	if start < len(rs) {
+		lines = append(lines, string(rs[start:]))
+	}
+	return lines
+}
+
+func canBreakBefore(rs []rune, i int) bool {
+	prev, cur := rs[i-1], rs[i]
+	if strings.ContainsRune(noLineStart, cur) || strings.ContainsRune(noLineEnd, prev) {
+		return false
+	}
+	if prev == ' ' {
+		return true
+	}
+	// full-width punctuation and CJK text both allow a break
+	return isCJK(prev) || isCJK(cur) || prev >= 0x3000 || cur >= 0x3000
+}
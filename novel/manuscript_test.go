@@ -0,0 +1,179 @@
+package novel
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParsePageSize(t *testing.T) {
+	cases := []struct {
+		in   string
+		name string
+		w, h float64
+		ok   bool
+	}{
+		{"", "A4", 595.28, 841.89, true},
+		{" a5 ", "A5", 419.53, 595.28, true},
+		{"Letter", "Letter", 612, 792, true},
+		{"148x210mm", "148x210mm", 419.53, 595.28, true},
+		{"148 x 210mm", "148 x 210mm", 419.53, 595.28, true},
+		{"10x210mm", "", 0, 0, false},
+		{"148x210", "", 0, 0, false},
+		{"b6", "", 0, 0, false},
+	}
+	for _, c := range cases {
+		p, err := ParsePageSize(c.in)
+		if (err == nil) != c.ok {
+			t.Errorf("ParsePageSize(%q): err = %v, want ok=%v", c.in, err, c.ok)
+			continue
+		}
+		if c.ok && (p.Name != c.name || math.Abs(p.Width-c.w) > 0.01 || math.Abs(p.Height-c.h) > 0.01) {
+			t.Errorf("ParsePageSize(%q) = %+v", c.in, p)
+		}
+	}
+}
+
+func TestWrapLine(t *testing.T) {
+	// every glyph is one em wide, so at size 10 a 35pt line holds 3 runes
+	f := &Font{UnitsPerEm: 1000}
+	cases := []struct {
+		in     string
+		indent float64
+		want   []string
+	}{
+		{"一二三四五六七", 0, []string{"一二三", "四五六", "七"}},
+		{"一二三，四", 0, []string{"一二", "三，四"}},
+		{"一二《三四", 0, []string{"一二", "《三四"}},
+		{"一二三四", 20, []string{"一", "二三四"}},
+		{"ab cd ef", 0, []string{"ab", "cd", "ef"}},
+		{"abcdef", 0, []string{"abc", "def"}},
+		{"", 0, nil},
+	}
+	for _, c := range cases {
+		if got := wrapLine(f, c.in, 10, 35, c.indent); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("wrapLine(%q, indent %v) = %q, want %q", c.in, c.indent, got, c.want)
+		}
+	}
+}
+
+func TestWriteDOCX(t *testing.T) {
+	b := testBook()
+	font := &Font{Name: "Test Serif", Data: bytes.Repeat([]byte{0xab}, 64), UnitsPerEm: 1000}
+	cases := []struct {
+		name string
+		opts ManuscriptOptions
+		font string
+	}{
+		{"fallback font", ManuscriptOptions{}, docxFallbackFont},
+		{"embedded font", ManuscriptOptions{Font: font, PageSize: pageSizes["a5"]}, "Test Serif"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := WriteDOCX(&buf, b, c.opts); err != nil {
+			t.Fatal(err)
+		}
+		_, files := readZip(t, buf.Bytes())
+		for name, body := range files {
+			if !strings.HasSuffix(name, ".xml") && !strings.HasSuffix(name, ".rels") {
+				continue
+			}
+			dec := xml.NewDecoder(strings.NewReader(body))
+			for {
+				if _, err := dec.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("%s: %s is not well-formed: %v", c.name, name, err)
+				}
+			}
+		}
+		doc := files["word/document.xml"]
+		if n := strings.Count(doc, `<w:pStyle w:val="Heading1"/>`); n != len(b.Chapters) {
+			t.Errorf("%s: %d chapter headings, want %d", c.name, n, len(b.Chapters))
+		}
+		for _, ch := range b.Chapters {
+			anchor := docxBookmark(ch.Index)
+			if !strings.Contains(doc, `w:anchor="`+anchor+`"`) || !strings.Contains(doc, `w:name="`+anchor+`"`) {
+				t.Errorf("%s: chapter %d is not linked from the contents", c.name, ch.Index)
+			}
+		}
+		if !strings.Contains(doc, "She &lt;laughed&gt;.") || !strings.Contains(doc, ">Contents<") {
+			t.Errorf("%s: document text not escaped or contents not localized", c.name)
+		}
+		size := c.opts.withDefaults().PageSize
+		if want := fmt.Sprintf(`<w:pgSz w:w="%d" w:h="%d"/>`, int(size.Width*20+0.5), int(size.Height*20+0.5)); !strings.Contains(doc, want) {
+			t.Errorf("%s: page size %s missing", c.name, want)
+		}
+		if !strings.Contains(files["word/fontTable.xml"], c.font) {
+			t.Errorf("%s: font table lacks %s", c.name, c.font)
+		}
+		odttf, embedded := files["word/fonts/font1.odttf"]
+		if embedded != (c.opts.Font != nil) {
+			t.Fatalf("%s: font embedded = %v", c.name, embedded)
+		}
+		if embedded {
+			key := docxFontKey(b.ID + "\x00" + font.Name)
+			if odttf[:32] == string(font.Data[:32]) || odttf[32:] != string(font.Data[32:]) {
+				t.Errorf("%s: only the first 32 bytes are obfuscated", c.name)
+			}
+			if string(obfuscateFont([]byte(odttf), key)) != string(font.Data) {
+				t.Errorf("%s: obfuscation does not round trip", c.name)
+			}
+		}
+	}
+}
+
+func TestWritePDF(t *testing.T) {
+	b := testBook()
+	if err := WritePDF(io.Discard, b, ManuscriptOptions{}); err == nil {
+		t.Fatal("PDF written without a font")
+	}
+	font := &Font{Name: "Test", PSName: "Test-Regular", Data: []byte("font data"), UnitsPerEm: 1000, Ascent: 800, Descent: -200}
+	var buf bytes.Buffer
+	if err := WritePDF(&buf, b, ManuscriptOptions{Font: font, PageSize: pageSizes["a5"]}); err != nil {
+		t.Fatal(err)
+	}
+	pdf := buf.String()
+	if !strings.HasPrefix(pdf, "%PDF-1.7\n") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Fatal("not a PDF file")
+	}
+	// every xref offset points at its object
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindStringSubmatch(pdf)
+	if m == nil {
+		t.Fatal("no startxref")
+	}
+	at, _ := strconv.Atoi(m[1])
+	lines := strings.Split(pdf[at:], "\n")
+	if lines[0] != "xref" {
+		t.Fatalf("startxref points at %q", lines[0])
+	}
+	var count int
+	fmt.Sscanf(lines[1], "0 %d", &count)
+	for id := 1; id < count; id++ {
+		entry := lines[2+id]
+		if strings.HasSuffix(entry, "f ") {
+			continue
+		}
+		off, _ := strconv.Atoi(entry[:10])
+		if !strings.HasPrefix(pdf[off:], fmt.Sprintf("%d 0 obj\n", id)) {
+			t.Fatalf("xref entry %d points at %q", id, pdf[off:off+10])
+		}
+	}
+	// title page, contents and one page per chapter
+	if n := strings.Count(pdf, "/Type /Page "); n != 2+len(b.Chapters) {
+		t.Errorf("%d pages, want %d", n, 2+len(b.Chapters))
+	}
+	if !strings.Contains(pdf, "/MediaBox [0 0 419.53 595.28]") || !strings.Contains(pdf, "/Lang "+pdfString("en")) || !strings.Contains(pdf, "/BaseFont /Test-Regular") {
+		t.Error("page size, language or font missing")
+	}
+	if n := strings.Count(pdf, "/Subtype /Link"); n != len(b.Chapters) {
+		t.Errorf("%d contents links, want %d", n, len(b.Chapters))
+	}
+}
@@ -0,0 +1,421 @@
+package novel
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+type pdfLink struct {
+	rect [4]float64
+	page int
+}
+
+type pdfPage struct {
+	ops   strings.Builder
+	links []pdfLink
+}
+
+// pdfDoc lays text out on pages with one embedded Identity-H font; glyphs
+// are addressed by glyph ID and recorded for the ToUnicode map
+type pdfDoc struct {
+	opts   ManuscriptOptions
+	font   *Font
+	pages  []*pdfPage
+	used   map[uint16]rune
+	margin float64
+}
+
+func (d *pdfDoc) newPage() *pdfPage {
+	p := &pdfPage{}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+func (d *pdfDoc) top() float64 {
+	return d.opts.PageSize.Height - d.margin
+}
+
+func (d *pdfDoc) bottom() float64 {
+	return d.margin
+}
+
+func (d *pdfDoc) width() float64 {
+	return d.opts.PageSize.Width - 2*d.margin
+}
+
+// text draws s with its baseline at y; spacing is extra space added after
+// every glyph, used to justify CJK lines
+func (d *pdfDoc) text(p *pdfPage, x, y, size, spacing float64, s string) {
+	var hex strings.Builder
+	for _, r := range s {
+		g := d.font.GlyphID(r)
+		if _, ok := d.used[g]; !ok {
+			d.used[g] = r
+		}
+		fmt.Fprintf(&hex, "%04X", g)
+	}
+	fmt.Fprintf(&p.ops, "BT /F1 %.2f Tf %.3f Tc 1 0 0 1 %.2f %.2f Tm <%s> Tj ET\n", size, spacing, x, y, hex.String())
+}
+
+func (d *pdfDoc) centered(p *pdfPage, y, size float64, s string) {
+	d.text(p, d.margin+(d.width()-d.font.Width(s, size))/2, y, size, 0, s)
+}
+
+// ascent returns the distance from the top of a line to its baseline
+func (d *pdfDoc) ascent(size float64) float64 {
+	return float64(d.font.Ascent) * size / float64(d.font.UnitsPerEm)
+}
+
+func (d *pdfDoc) titlePage(b Book) {
+	p := d.newPage()
+	size := d.opts.FontSize
+	y := d.opts.PageSize.Height * 0.62
+	for _, l := range wrapLine(d.font, b.Outline.Title, size*2.2, d.width(), 0) {
+		d.centered(p, y, size*2.2, l)
+		y -= size * 2.2 * 1.4
+	}
+	y -= size * 2
+	if b.Summary != "" {
+		for _, l := range wrapLine(d.font, b.Summary, size, d.width()*0.8, 0) {
+			d.centered(p, y, size, l)
+			y -= size * 1.6
+		}
+	}
+	d.centered(p, d.bottom()+size*2, size*0.8, GeneratorName)
+}
+
+// chapter starts a new page and returns its index
+func (d *pdfDoc) chapter(c ChapterContent) int {
+	start := len(d.pages)
+	p := d.newPage()
+	size := d.opts.FontSize
+	leading := size * 1.8
+	hs := size * 1.5
+	y := d.top() - d.ascent(hs) - size
+	for _, l := range wrapLine(d.font, c.Title, hs, d.width(), 0) {
+		d.centered(p, y, hs, l)
+		y -= hs * 1.5
+	}
+	y -= leading
+	indent := 2 * size
+	for _, para := range Paragraphs(c.Content) {
+		lines := wrapLine(d.font, para, size, d.width(), indent)
+		for i, l := range lines {
+			if y < d.bottom() {
+				p = d.newPage()
+				y = d.top() - d.ascent(size)
+			}
+			x := d.margin
+			avail := d.width()
+			if i == 0 {
+				x += indent
+				avail -= indent
+			}
+			spacing := 0.0
+			if n := len([]rune(l)); i < len(lines)-1 && n > 1 {
+				spacing = (avail - d.font.Width(l, size)) / float64(n-1)
+				if spacing < 0 || spacing > size/2 {
+					spacing = 0
+				}
+			}
+			d.text(p, x, y, size, spacing, l)
+			y -= leading
+		}
+	}
+	return start
+}
+
+// tocPages returns how many pages the contents list needs
+func (d *pdfDoc) tocPages(n int) int {
+	per := d.tocPerPage()
+	return (n + per - 1) / per
+}
+
+func (d *pdfDoc) tocPerPage() int {
+	size := d.opts.FontSize
+	avail := d.top() - d.bottom() - size*4
+	per := int(avail / (size * 1.8))
+	if per < 1 {
+		per = 1
+	}
+	return per
+}
+
+// toc fills the reserved contents pages starting at first with one linked
+// line per chapter and its page number
+func (d *pdfDoc) toc(first int, lang string, chapters []ChapterContent, starts []int) {
+	size := d.opts.FontSize
+	per := d.tocPerPage()
+	var p *pdfPage
+	var y float64
+	for i, c := range chapters {
+		if i%per == 0 {
+			p = d.pages[first+i/per]
+			y = d.top() - d.ascent(size*1.5) - size
+			if i == 0 {
+				d.centered(p, y, size*1.5, tocTitle(lang))
+			}
+			y -= size * 4
+		}
+		num := fmt.Sprint(starts[i] + 1)
+		nw := d.font.Width(num, size)
+		title := c.Title
+		maxW := d.width() - nw - size*2
+		if d.font.Width(title, size) > maxW {
+			rs := []rune(title)
+			for len(rs) > 0 && d.font.Width(string(rs)+"…", size) > maxW {
+				rs = rs[:len(rs)-1]
+			}
+			title = string(rs) + "…"
+		}
+		d.text(p, d.margin, y, size, 0, title)
+		d.text(p, d.margin+d.width()-nw, y, size, 0, num)
+		p.links = append(p.links, pdfLink{rect: [4]float64{d.margin, y - size*0.4, d.margin + d.width(), y + size}, page: starts[i]})
+		y -= size * 1.8
+	}
+}
+
+// WritePDF lays the book out as a manuscript: a title page, a linked table
+// of contents with page numbers and every chapter on a new page, with the
+// configured font embedded so CJK text renders anywhere
+func WritePDF(w io.Writer, b Book, opts ManuscriptOptions) error {
+	opts = opts.withDefaults()
+	if opts.Font == nil {
+		return errors.New("PDF export needs a TrueType font with CJK glyphs")
+	}
+	lang := b.Language
+	if lang == "" {
+		lang = "zh"
+	}
+	margin := 72.0
+	if opts.PageSize.Width < 500 {
+		margin = 54
+	}
+	d := &pdfDoc{opts: opts, font: opts.Font, used: map[uint16]rune{0: 0}, margin: margin}
+	d.titlePage(b)
+	tocFirst := len(d.pages)
+	for i := 0; i < d.tocPages(len(b.Chapters)); i++ {
+		d.newPage()
+	}
+	starts := make([]int, len(b.Chapters))
+	for i, c := range b.Chapters {
+		starts[i] = d.chapter(c)
+	}
+	d.toc(tocFirst, lang, b.Chapters, starts)
+	for i := 1; i < len(d.pages); i++ {
+		d.centered(d.pages[i], d.bottom()/2, opts.FontSize*0.8, fmt.Sprint(i+1))
+	}
+	return d.write(w, b, starts)
+}
+
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+func (pw *pdfWriter) obj(id int, body string) {
+	for len(pw.offsets) <= id {
+		pw.offsets = append(pw.offsets, 0)
+	}
+	pw.offsets[id] = pw.buf.Len()
+	fmt.Fprintf(&pw.buf, "%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+func (pw *pdfWriter) stream(id int, dict string, data []byte) error {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	for len(pw.offsets) <= id {
+		pw.offsets = append(pw.offsets, 0)
+	}
+	pw.offsets[id] = pw.buf.Len()
+	fmt.Fprintf(&pw.buf, "%d 0 obj\n<< /Length %d /Filter /FlateDecode%s >>\nstream\n", id, z.Len(), dict)
+	pw.buf.Write(z.Bytes())
+	pw.buf.WriteString("\nendstream\nendobj\n")
+	return nil
+}
+
+const (
+	pdfCatalog = iota + 1
+	pdfPages
+	pdfInfo
+	pdfFont
+	pdfCIDFont
+	pdfDescriptor
+	pdfFontFile
+	pdfToUnicode
+	pdfOutlines
+	pdfFirstPage
+)
+
+func (d *pdfDoc) write(w io.Writer, b Book, starts []int) error {
+	pw := &pdfWriter{}
+	pw.buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	pageID := func(i int) int { return pdfFirstPage + 2*i }
+	outlineFirst := pdfFirstPage + 2*len(d.pages)
+
+	catalog := fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R", pdfPages)
+	if len(starts) > 0 {
+		catalog += fmt.Sprintf(" /Outlines %d 0 R /PageMode /UseOutlines", pdfOutlines)
+	}
+	lang := b.Language
+	if lang == "" {
+		lang = "zh"
+	}
+	pw.obj(pdfCatalog, catalog+" /Lang "+pdfString(lang)+" >>")
+
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pageID(i))
+	}
+	pw.obj(pdfPages, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+
+	created := b.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+	pw.obj(pdfInfo, fmt.Sprintf("<< /Title %s /Creator %s /Producer %s /CreationDate (D:%s) >>",
+		pdfString(b.Outline.Title), pdfString(GeneratorName), pdfString(GeneratorName), created.UTC().Format("20060102150405Z")))
+
+	f := d.font
+	name := pdfName(f.PSName)
+	scale := 1000 / float64(f.UnitsPerEm)
+	pw.obj(pdfFont, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>", name, pdfCIDFont, pdfToUnicode))
+
+	gids := make([]int, 0, len(d.used))
+	for g := range d.used {
+		gids = append(gids, int(g))
+	}
+	sort.Ints(gids)
+	var widths strings.Builder
+	for _, g := range gids {
+		fmt.Fprintf(&widths, "%d [%d] ", g, int(float64(f.glyphAdvance(uint16(g)))*scale+0.5))
+	}
+	pw.obj(pdfCIDFont, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /DW 1000 /W [%s] /CIDToGIDMap /Identity >>", name, pdfDescriptor, widths.String()))
+
+	flags := 4
+	if f.Fixed {
+		flags |= 1
+	}
+	pw.obj(pdfDescriptor, fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags %d /FontBBox [%d %d %d %d] /ItalicAngle %.2f /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		name, flags, int(float64(f.BBox[0])*scale), int(float64(f.BBox[1])*scale), int(float64(f.BBox[2])*scale), int(float64(f.BBox[3])*scale),
+		f.ItalicAngle, int(float64(f.Ascent)*scale), int(float64(f.Descent)*scale), int(float64(f.CapHeight)*scale), pdfFontFile))
+	if err := pw.stream(pdfFontFile, fmt.Sprintf(" /Length1 %d", len(f.Data)), f.Data); err != nil {
+		return err
+	}
+	if err := pw.stream(pdfToUnicode, "", toUnicodeCMap(d.used)); err != nil {
+		return err
+	}
+
+	if len(starts) > 0 {
+		pw.obj(pdfOutlines, fmt.Sprintf("<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>", outlineFirst, outlineFirst+len(starts)-1, len(starts)))
+	}
+
+	for i, p := range d.pages {
+		annots := ""
+		if len(p.links) > 0 {
+			var a strings.Builder
+			a.WriteString(" /Annots [")
+			for _, l := range p.links {
+				fmt.Fprintf(&a, "<< /Type /Annot /Subtype /Link /Rect [%.2f %.2f %.2f %.2f] /Border [0 0 0] /Dest [%d 0 R /XYZ null null null] >> ",
+					l.rect[0], l.rect[1], l.rect[2], l.rect[3], pageID(l.page))
+			}
+			a.WriteString("]")
+			annots = a.String()
+		}
+		pw.obj(pageID(i), fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 %d 0 R >> >> /Contents %d 0 R%s >>",
+			pdfPages, d.opts.PageSize.Width, d.opts.PageSize.Height, pdfFont, pageID(i)+1, annots))
+		if err := pw.stream(pageID(i)+1, "", []byte(p.ops.String())); err != nil {
+			return err
+		}
+	}
+
+	for i, c := range b.Chapters {
+		item := fmt.Sprintf("<< /Title %s /Parent %d 0 R /Dest [%d 0 R /XYZ null null null]", pdfString(c.Title), pdfOutlines, pageID(starts[i]))
+		if i > 0 {
+			item += fmt.Sprintf(" /Prev %d 0 R", outlineFirst+i-1)
+		}
+		if i < len(starts)-1 {
+			item += fmt.Sprintf(" /Next %d 0 R", outlineFirst+i+1)
+		}
+		pw.obj(outlineFirst+i, item+" >>")
+	}
+
+	xref := pw.buf.Len()
+	fmt.Fprintf(&pw.buf, "xref\n0 %d\n0000000000 65535 f \n", len(pw.offsets))
+	for id := 1; id < len(pw.offsets); id++ {
+		if pw.offsets[id] == 0 {
+			// unused id (no outlines), keep the table contiguous
+			pw.buf.WriteString("0000000000 65535 f \n")
+			continue
+		}
+		fmt.Fprintf(&pw.buf, "%010d 00000 n \n", pw.offsets[id])
+	}
+	fmt.Fprintf(&pw.buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(pw.offsets), pdfCatalog, pdfInfo, xref)
+	_, err := w.Write(pw.buf.Bytes())
+	return err
+}
+
+func toUnicodeCMap(used map[uint16]rune) []byte {
+	gids := make([]int, 0, len(used))
+	for g, r := range used {
+		if r != 0 {
+			gids = append(gids, int(g))
+		}
+	}
+	sort.Ints(gids)
+	var s strings.Builder
+	s.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for len(gids) > 0 {
+		n := len(gids)
+		if n > 100 {
+			n = 100
+		}
+		fmt.Fprintf(&s, "%d beginbfchar\n", n)
+		for _, g := range gids[:n] {
+			fmt.Fprintf(&s, "<%04X> <%s>\n", g, utf16Hex(string(used[uint16(g)])))
+		}
+		s.WriteString("endbfchar\n")
+		gids = gids[n:]
+	}
+	s.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return []byte(s.String())
+}
+
+func utf16Hex(s string) string {
+	var b strings.Builder
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	return b.String()
+}
+
+// pdfString encodes s as a UTF-16BE text string
+func pdfString(s string) string {
+	return "<FEFF" + utf16Hex(s) + ">"
+}
+
+// pdfName escapes characters that are not allowed in a PDF name
+func pdfName(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("()<>[]{}/%#", c) >= 0 {
+			fmt.Fprintf(&b, "#%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,83 @@
+package novel
+
+import (
+	_ "embed"
+	"strings"
+	"sync"
+)
+
+// pinyin.txt holds the CJK unified ideographs grouped by reading in pinyin
+// collation order, one reading per line; it is derived from the pinyin
+// collation table of Unicode::Collate. Readings are not named, so two
+// characters are compared by the distance between their lines: the same
+// line is a homophone and neighbouring lines are mostly the same syllable
+// in another tone
+//
+//go:embed pinyin.txt
+var pinyinData string
+
+var (
+	pinyinOnce  sync.Once
+	pinyinClass map[rune]int
+)
+
+func readingClass(r rune) (int, bool) {
+	pinyinOnce.Do(func() {
+		pinyinClass = make(map[rune]int, 21000)
+		for i, line := range strings.Split(pinyinData, "\n") {
+			for _, c := range line {
+				// the first reading of a polyphone wins
+				if _, ok := pinyinClass[c]; !ok {
+					pinyinClass[c] = i
+				}
+			}
+		}
+	})
+	c, ok := pinyinClass[r]
+	return c, ok
+}
+
+// substitutionCost weighs replacing a with b: homophones are cheap, other
+// tones of a syllable cost more and unrelated characters cost a full edit
+func substitutionCost(a, b rune) float64 {
+	if a == b {
+		return 0
+	}
+	ca, ok1 := readingClass(a)
+	cb, ok2 := readingClass(b)
+	if !ok1 || !ok2 {
+		return 1
+	}
+	switch d := ca - cb; {
+	case d == 0:
+		return 0.3
+	case d == 1 || d == -1:
+		return 0.6
+	}
+	return 1
+}
+
+// termDistance is the edit distance between two terms with substitutions
+// weighted by pinyin similarity
+func termDistance(a, b []rune) float64 {
+	prev := make([]float64, len(b)+1)
+	cur := make([]float64, len(b)+1)
+	for j := range prev {
+		prev[j] = float64(j)
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = float64(i)
+		for j := 1; j <= len(b); j++ {
+			best := prev[j-1] + substitutionCost(a[i-1], b[j-1])
+			if v := prev[j] + 1; v < best {
+				best = v
+			}
+			if v := cur[j-1] + 1; v < best {
+				best = v
+			}
+			cur[j] = best
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -1,78 +1,222 @@
 package novel
 
-import "strings"
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
-const PresetXiyouBase = "你是专业的玄幻爽文+西游衍生小说创作者，精通以下规则:\n1. 世界观：以西游三界（人/神/妖/魔/佛）为基础，可新增原创势力，但需符合玄幻逻辑；\n2. 境界体系：凡仙→地仙→天仙→金仙→太乙金仙→大罗金仙→准圣→圣人→天道→鸿蒙，每个境界有明确能力标签；\n3. 爽点要求：每3-5章一个小爽点，10-15章一个中爽点，30章一个大爽点，打脸情节要直接，升级节奏要快；\n4. 金手指要求：必须与西游绑定，有成长性和限制，不能过于无敌（前期需有挑战）；\n5. 语言风格：简洁有力，动作描写生动，对话符合角色性格（主角桀骜，反派嚣张，配角烘托），避免冗余描写；\n6. 原创要求：主角为原创，原著角色仅作为辅助，剧情不能复刻西游，需有新冲突；\n7. 合规要求：不涉及敏感内容，不写血腥暴力、色情低俗情节，不违背公序良俗。"
+	"github.com/goccy/go-yaml"
+)
 
-const GenericSettingBase = "你是资深小说设定与世界观构建专家。基于提供的主题或文本材料，生成结构化设定，要求逻辑自洽、风格统一、避免模板化措辞，且仅输出JSON结果。"
+// DefaultPreset is the preset of jobs that name none; it is also the
+// fallback settings schema
+const DefaultPreset = "generic"
 
-const PresetXiyouSetting = "请按以下结构仅输出JSON（无任何额外文本或注释）：{\"protagonist\":{\"personality\":...,\"background\":...,\"goal\":...},\"golden_finger\":{\"name\":...,\"activation\":...,\"initial\":...,\"upgrade\":...,\"limit\":...},\"world_fusion\":{\"relations\":...,\"start_location\":...,\"initial_crisis\":...},\"realms\":{\"current\":...,\"next\":[...],\"breakthrough\":{境界:条件}}}。要求：符合爽文逻辑，金手指与西游强绑定，初始危机能快速引出第一个爽点。"
+// Preset is what one genre changes about generation. Presets are YAML or
+// JSON files; the built-in ones are embedded and a preset dir may add more
+// or replace them by name
+type Preset struct {
+	Name        string `json:"name"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	// System carries the genre rules into settings generation and chapter
+	// writing; a system prompt given with the request takes precedence
+	System   string        `json:"system,omitempty"`
+	Settings SettingSchema `json:"settings"`
+	// ChapterInstruction comes before the request's instruction in chapter
+	// prompts
+	ChapterInstruction string `json:"chapter_instruction,omitempty"`
+	CharacterPersona   string `json:"character_persona,omitempty"`
+	// AuditRubric adds the genre's criteria to the coherence audit
+	AuditRubric string `json:"audit_rubric,omitempty"`
+	// Categories are used when a request names none
+	Categories []string `json:"categories,omitempty"`
+	// Prompts replace built-in prompt templates by name for the preset's
+	// jobs, or by <lang>/<name> for its jobs in one language; a job's own
+	// templates take precedence
+	Prompts map[string]string `json:"prompts,omitempty"`
+	// Source is the file the preset was loaded from
+	Source string `json:"source,omitempty"`
+}
+
+//go:embed presets/*.yaml
+var builtinPresetFiles embed.FS
 
-const GenericSettingSchema = "请按以下结构仅输出JSON（无任何额外文本或注释）：{\"protagonist\":{\"personality\":...,\"background\":...,\"goal\":...},\"signature_elements\":{\"devices\":...,\"constraints\":...,\"progression\":...},\"world\":{\"relations\":...,\"start_location\":...,\"initial_crisis\":...}}"
+var (
+	presetMu sync.RWMutex
+	presets  = builtinPresets()
+)
+
+func builtinPresets() map[string]Preset {
+	out := map[string]Preset{}
+	entries, _ := builtinPresetFiles.ReadDir("presets")
+	for _, e := range entries {
+		b, _ := builtinPresetFiles.ReadFile("presets/" + e.Name())
+		p, err := ParsePreset(e.Name(), b)
+		if err != nil {
+			panic(fmt.Sprintf("built-in preset %s: %s", e.Name(), err.Error()))
+		}
+		p.Source = "builtin"
+		out[p.Name] = p
+	}
+	return out
+}
 
-func BuildSettingPrompt(preset string, topic string) (string, string) {
-	sys := GenericSettingBase
-	if preset == "xiyou_shuangwen" {
-		sys = PresetXiyouBase
-	} else if preset != "" {
-		sys = preset
+// ParsePreset reads a preset file; YAML and JSON are told apart by the
+// file name and unknown keys are rejected so typos do not go unnoticed. The
+// name defaults to the file name without extension
+func ParsePreset(file string, b []byte) (Preset, error) {
+	ext := strings.ToLower(filepath.Ext(file))
+	if ext == ".yaml" || ext == ".yml" {
+		j, err := yaml.YAMLToJSON(b)
+		if err != nil {
+			return Preset{}, err
+		}
+		b = j
 	}
-	b := strings.Builder{}
-	if topic != "" {
-		b.WriteString("主题：")
-		b.WriteString(topic)
-		b.WriteString("\n")
+	var p Preset
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return Preset{}, err
 	}
-	if preset == "xiyou_shuangwen" {
-		b.WriteString(PresetXiyouSetting)
-	} else {
-		b.WriteString(GenericSettingSchema)
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
 	}
-	return sys, b.String()
+	p.Source = ""
+	return p, p.validate()
 }
 
-func BuildChapterInstructionPreset() string {
-	return "基于设定与大纲生成章节。开头快速切入关键场景；中段推进冲突与反差爽点；结尾引出下一关键地点。语言风格动作生动、对话简洁有力，逻辑自洽。"
+func (p Preset) validate() error {
+	if strings.TrimSpace(p.Name) == "" || strings.ContainsAny(p.Name, `/\`) {
+		return fmt.Errorf("invalid preset name %q", p.Name)
+	}
+	for name, body := range p.Prompts {
+		// unprefixed prompts apply to books in every language
+		if err := CheckPrompt("", name, body); err != nil {
+			return err
+		}
+	}
+	if len(p.Settings.Sections) == 0 && p.Settings.Render == "" && p.Settings.Brief == "" {
+		// settings follow the default preset
+		return nil
+	}
+	return p.Settings.check()
 }
 
-func BuildSystemFromCategories(gender string, categories, tags []string) string {
-	var b strings.Builder
-	base := "你是资深小说写作助手，保持自然口语化与细节真实，避免模板化措辞与机械排序。"
-	b.WriteString(base)
-	if gender != "" {
-		if strings.ToLower(gender) == "male" {
-			b.WriteString(" 男频取向，视角以男性为主，节奏爽点更直接。")
-		} else if strings.ToLower(gender) == "female" {
-			b.WriteString(" 女频取向，情感与关系戏份更足，细节更柔和。")
+// LoadPresets loads the preset files in dir on top of the built-in presets
+// and puts them in use. A missing dir leaves the built-in presets; when any
+// file is invalid nothing changes and the error names every bad file
+func LoadPresets(dir string) error {
+	next := builtinPresets()
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		var errs []error
+		fromFile := map[string]string{}
+		for _, e := range entries {
+			if e.IsDir() || !isPresetFile(e.Name()) {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			b, err := os.ReadFile(path)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			p, err := ParsePreset(e.Name(), b)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", path, err))
+				continue
+			}
+			if other, ok := fromFile[p.Name]; ok {
+				errs = append(errs, fmt.Errorf("%s: preset %s is already defined in %s", path, p.Name, other))
+				continue
+			}
+			fromFile[p.Name] = path
+			p.Source = path
+			next[p.Name] = p
 		}
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+	}
+	presetMu.Lock()
+	presets = next
+	presetMu.Unlock()
+	return nil
+}
+
+func isPresetFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml", ".json":
+		return true
 	}
-	if len(categories) > 0 {
-		b.WriteString(" 分类要求：")
-		b.WriteString(strings.Join(categories, ", "))
-		b.WriteString("。")
+	return false
+}
+
+// Presets lists the presets in use by name
+func Presets() []Preset {
+	presetMu.RLock()
+	out := make([]Preset, 0, len(presets))
+	for _, p := range presets {
+		out = append(out, p)
 	}
-	if len(tags) > 0 {
-		b.WriteString(" 标签：")
-		b.WriteString(strings.Join(tags, ", "))
-		b.WriteString("。")
+	presetMu.RUnlock()
+	sort.Slice(out, func(a, b int) bool { return out[a].Name < out[b].Name })
+	return out
+}
+
+func LookupPreset(name string) (Preset, bool) {
+	presetMu.RLock()
+	defer presetMu.RUnlock()
+	p, ok := presets[name]
+	return p, ok
+}
+
+// presetFor is the preset a spec names, or the default preset
+func presetFor(name string) Preset {
+	if p, ok := LookupPreset(name); ok {
+		return p
 	}
-	return b.String()
+	p, _ := LookupPreset(DefaultPreset)
+	return p
 }
 
-func BuildSettingPromptWithCategories(preset, topic string, categories, tags []string) (string, string) {
-	sys, user := BuildSettingPrompt(preset, topic)
-	if len(categories) > 0 || len(tags) > 0 {
-		u := strings.Builder{}
-		u.WriteString(user)
-		if len(categories) > 0 {
-			u.WriteString("\n分类偏好：")
-			u.WriteString(strings.Join(categories, ", "))
-		}
-		if len(tags) > 0 {
-			u.WriteString("\n标签：")
-			u.WriteString(strings.Join(tags, ", "))
-		}
-		user = u.String()
+// SettingSchemaFor is the settings schema of a preset; presets without
+// one use the schema of the default preset
+func SettingSchemaFor(preset string) SettingSchema {
+	if sc := presetFor(preset).Settings; len(sc.Sections) > 0 {
+		return sc
+	}
+	return presetFor(DefaultPreset).Settings
+}
+
+// system is the system prompt chapters are written with
+func (s Spec) system() string {
+	if s.System != "" {
+		return s.System
+	}
+	return presetFor(s.Preset).System
+}
+
+// instruction is the preset's chapter instruction followed by the spec's
+func (s Spec) instruction() string {
+	pi := presetFor(s.Preset).ChapterInstruction
+	switch {
+	case pi == "":
+		return s.Instruction
+	case s.Instruction == "":
+		return pi
 	}
-	return sys, user
+	return pi + "\n" + s.Instruction
 }
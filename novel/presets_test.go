@@ -0,0 +1,114 @@
+package novel
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePreset(t *testing.T) {
+	cases := []struct {
+		name string
+		file string
+		body string
+		want string
+		err  string
+	}{
+		{"yaml", "wuxia.yaml", "title: 武侠\nsystem: 江湖\n", "wuxia", ""},
+		{"json", "scifi.json", `{"name":"space","title":"科幻"}`, "space", ""},
+		{"yml with prompts", "a.yml", "prompts:\n  audit: '{{define \"system\"}}审{{end}}'\n  en/audit: '{{define \"user\"}}audit{{end}}'\n", "a", ""},
+		{"with settings", "b.yaml", "settings:\n  sections:\n    - {key: world, label: 世界, fields: [{key: name, label: 名称, type: text, term: place}]}\n  render: '{{.world.name}}'\n", "b", ""},
+		{"unknown key", "c.yaml", "titel: 错字\n", "", "unknown field"},
+		{"bad name", "d.yaml", "name: a/b\n", "", "invalid preset name"},
+		{"unknown prompt", "e.yaml", "prompts:\n  nope: '{{define \"system\"}}x{{end}}'\n", "", "unknown prompt template"},
+		{"unknown prompt language", "f.yaml", "prompts:\n  xx/audit: '{{define \"system\"}}x{{end}}'\n", "", "unknown prompt template"},
+		{"prompt without system or user", "g.yaml", "prompts:\n  audit: 'plain'\n", "", "neither system nor user"},
+		{"broken prompt", "h.yaml", "prompts:\n  audit: '{{define \"system\"}}x'\n", "", "audit"},
+		{"field type", "i.yaml", "settings:\n  sections:\n    - {key: world, fields: [{key: name, type: number}]}\n", "", "unknown type"},
+		{"duplicate section", "j.yaml", "settings:\n  sections:\n    - {key: world, fields: []}\n    - {key: world, fields: []}\n", "", "duplicate key"},
+		{"broken render", "k.yaml", "settings:\n  sections:\n    - {key: world, fields: []}\n  render: '{{.world'\n", "", "settings"},
+		{"broken yaml", "l.yaml", "title: [\n", "", ""},
+	}
+	for _, c := range cases {
+		p, err := ParsePreset(c.file, []byte(c.body))
+		if c.want != "" {
+			if err != nil {
+				t.Errorf("%s: %v", c.name, err)
+			} else if p.Name != c.want || p.Source != "" {
+				t.Errorf("%s: name = %s source = %q, want %s", c.name, p.Name, p.Source, c.want)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.err)
+		}
+	}
+}
+
+func TestLoadPresets(t *testing.T) {
+	t.Cleanup(func() { _ = LoadPresets("") })
+	write := func(dir, name, body string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := LoadPresets(filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Fatalf("missing dir: %v", err)
+	}
+	if _, ok := LookupPreset(DefaultPreset); !ok {
+		t.Fatal("built-in presets not loaded")
+	}
+
+	good := t.TempDir()
+	write(good, "wuxia.yaml", "title: 武侠\n")
+	write(good, "generic.json", `{"title":"替换"}`)
+	write(good, "notes.txt", "ignored")
+	if err := LoadPresets(good); err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := LookupPreset("wuxia"); !ok || p.Source != filepath.Join(good, "wuxia.yaml") {
+		t.Fatalf("wuxia = %+v, %v", p, ok)
+	}
+	if p, _ := LookupPreset(DefaultPreset); p.Title != "替换" {
+		t.Fatalf("built-in preset not replaced: %+v", p)
+	}
+	if len(SettingSchemaFor(DefaultPreset).Sections) != 0 {
+		t.Fatal("replaced default preset kept its built-in schema")
+	}
+	if p, ok := LookupPreset("xiyou_shuangwen"); !ok || p.Source != "builtin" {
+		t.Fatalf("other built-in preset lost: %+v", p)
+	}
+
+	bad := t.TempDir()
+	write(bad, "a.yaml", "name: same\n")
+	write(bad, "b.json", `{"name":"same"}`)
+	write(bad, "c.yaml", "titel: x\n")
+	write(bad, "ok.yaml", "title: ok\n")
+	err := LoadPresets(bad)
+	if err == nil {
+		t.Fatal("invalid preset dir accepted")
+	}
+	for _, want := range []string{"already defined", "c.yaml"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+	if _, ok := LookupPreset("ok"); ok {
+		t.Fatal("presets changed although a file was invalid")
+	}
+	if _, ok := LookupPreset("wuxia"); !ok {
+		t.Fatal("presets in use were dropped by a failed load")
+	}
+}
+
+func TestPresetForUnknown(t *testing.T) {
+	p := presetFor("no such preset")
+	if p.Name != DefaultPreset {
+		t.Fatalf("presetFor(unknown) = %s, want %s", p.Name, DefaultPreset)
+	}
+	if s := (Spec{Preset: "写一部悬疑小说"}).system(); s != p.System {
+		t.Fatalf("unknown preset used as system prompt: %q", s)
+	}
+}
@@ -0,0 +1,356 @@
+package novel
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// PromptDir is the dir of a job holding its prompt overrides, one
+// <name>.tmpl per template. Overrides are written in the job's language
+const PromptDir = "prompts"
+
+// PromptCommon is the template of the partials all prompts share
+const PromptCommon = "common"
+
+// prompt template origins, from the lowest precedence to the highest
+const (
+	PromptBuiltin = "builtin"
+	PromptPreset  = "preset"
+	PromptJob     = "job"
+)
+
+//go:embed prompts/*.tmpl prompts/en/*.tmpl prompts/ja/*.tmpl
+var builtinPromptFiles embed.FS
+
+// builtinPrompts holds the Chinese templates by name and the language
+// packs by <lang>/<name>
+var builtinPrompts = func() map[string]string {
+	out := map[string]string{}
+	for _, lang := range []string{LangChinese, LangEnglish, LangJapanese} {
+		dir := "prompts"
+		if lang != LangChinese {
+			dir += "/" + lang
+		}
+		entries, _ := builtinPromptFiles.ReadDir(dir)
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			b, _ := builtinPromptFiles.ReadFile(dir + "/" + e.Name())
+			name := strings.TrimSuffix(e.Name(), ".tmpl")
+			if lang != LangChinese {
+				name = lang + "/" + name
+			}
+			out[name] = string(b)
+		}
+	}
+	return out
+}()
+
+// builtinPrompt is the built-in template name of the pack of lang, or the
+// Chinese one when the pack lacks it
+func builtinPrompt(lang, name string) string {
+	if lang != "" && lang != LangChinese {
+		if body, ok := builtinPrompts[lang+"/"+name]; ok {
+			return body
+		}
+	}
+	return builtinPrompts[name]
+}
+
+// PromptData is what every prompt template is executed with; each prompt
+// uses the parts it needs, as its template says at the top
+type PromptData struct {
+	Spec   Spec
+	Preset Preset
+	Canon  Canon
+	// Series is the project a sequel continues, nil for a standalone book
+	Series  *Series
+	Outline Outline
+	// Plan is the chapter being planned or written
+	Plan Chapter
+	// Characters are the characters the prompt lists
+	Characters []Character
+	// History holds earlier chapters quoted as context
+	History []ChapterContent
+	// Chapter is the written chapter a tracker reads or a revision edits,
+	// Chapters the ones an audit or a book conclusion reads
+	Chapter     ChapterContent
+	Chapters    []ChapterContent
+	Words       int
+	Instruction string
+	System      string
+	// Source is the text artifacts are extracted from, or one chunk of it;
+	// Retry is set when the first answer was not valid JSON
+	Source string
+	Retry  bool
+	// Scene is the index of the scene being written and Tail the end of the
+	// one before; Parts are the written scenes of a chapter
+	Scene int
+	Tail  string
+	Parts []string
+	// Volume is the volume being planned, Recent the latest chapters the
+	// plan continues from
+	Volume Volume
+	Recent []Chapter
+	// Count and Next are how many volumes or chapters are planned and the
+	// index of the first new chapter
+	Count     int
+	Next      int
+	Direction string
+	// Threads, States, Event and Terms are what the trackers know before
+	// the chapter they read
+	Threads []PlotThread
+	States  []CharacterState
+	Event   *StoryEvent
+	Terms   []string
+	Issues  []CoherenceIssue
+}
+
+// PromptTemplate is one prompt template as a job sees it. Version changes
+// whenever the text a prompt is rendered from changes, its partials included
+type PromptTemplate struct {
+	Name    string `json:"name"`
+	Origin  string `json:"origin"`
+	Version string `json:"version"`
+	Body    string `json:"body"`
+}
+
+var promptFuncs = template.FuncMap{
+	"join": func(v any, sep string) string {
+		if l, ok := v.(StringList); ok {
+			v = []string(l)
+		}
+		return joinSetting(v, sep)
+	},
+	"names": func(cs []Character) []string {
+		out := make([]string, 0, len(cs))
+		for _, c := range cs {
+			out = append(out, c.Name)
+		}
+		return out
+	},
+	"words": fmtInt,
+	"inc":   func(i int) int { return i + 1 },
+	"add": func(n ...int) int {
+		sum := 0
+		for _, x := range n {
+			sum += x
+		}
+		return sum
+	},
+	"lower": strings.ToLower,
+	// tail is the last n runes of s without surrounding space
+	"tail": func(s string, n int) string {
+		rs := []rune(strings.TrimSpace(s))
+		if len(rs) > n {
+			rs = rs[len(rs)-n:]
+		}
+		return string(rs)
+	},
+	"json": func(v any) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	},
+	"settings": renderedSettings,
+	"states":   chapterStates,
+	"payoffs":  chapterPayoffs,
+}
+
+func fmtInt(i int) string {
+	if i <= 0 {
+		return "1200"
+	}
+	return fmt.Sprint(i)
+}
+
+// PromptNames lists the built-in prompt templates, partials included;
+// every language pack has the same ones
+func PromptNames() []string {
+	out := make([]string, 0, len(builtinPrompts))
+	for name := range builtinPrompts {
+		if !strings.Contains(name, "/") {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// CheckPrompt reports an override that cannot be used: an unknown name, a
+// template that does not parse with the common partials of lang or a prompt
+// that defines neither "system" nor "user". The name may be limited to one
+// language, as in en/chapter; an empty lang checks a template that applies
+// to books in every language against each language pack
+func CheckPrompt(lang, name, body string) error {
+	var langs []string
+	if i := strings.Index(name, "/"); i != -1 {
+		code, err := NormalizeLanguage(name[:i])
+		if err != nil || name[:i] == "" {
+			return fmt.Errorf("unknown prompt template %q", name)
+		}
+		langs, name = []string{code}, name[i+1:]
+	} else if lang != "" {
+		code, err := NormalizeLanguage(lang)
+		if err != nil {
+			return err
+		}
+		langs = []string{code}
+	} else {
+		for _, l := range languages {
+			langs = append(langs, l.Code)
+		}
+	}
+	if _, ok := builtinPrompts[name]; !ok {
+		return fmt.Errorf("unknown prompt template %q", name)
+	}
+	for _, l := range langs {
+		common, tmpl := builtinPrompt(l, PromptCommon), body
+		if name == PromptCommon {
+			common, tmpl = body, ""
+		}
+		t, err := parsePrompt(name, common, tmpl)
+		if err != nil {
+			if len(langs) > 1 {
+				return fmt.Errorf("%s: %w", l, err)
+			}
+			return err
+		}
+		if name != PromptCommon && t.Lookup("system") == nil && t.Lookup("user") == nil {
+			return fmt.Errorf("prompt template %s defines neither system nor user", name)
+		}
+	}
+	return nil
+}
+
+// promptSet finds the templates of one job: the job's prompt dir comes
+// first, then the preset and then the built-in templates. Preset templates
+// named <lang>/<name> apply to books in that language only and come before
+// those that apply to all; the built-in ones are taken from the book's
+// language pack
+type promptSet struct {
+	preset string
+	dir    string
+	lang   string
+}
+
+func (s promptSet) lookup(name string) PromptTemplate {
+	if s.dir != "" {
+		if b, err := os.ReadFile(filepath.Join(s.dir, PromptDir, name+".tmpl")); err == nil {
+			return PromptTemplate{Name: name, Origin: PromptJob, Body: string(b)}
+		}
+	}
+	lang := s.lang
+	if lang == "" {
+		lang = LangChinese
+	}
+	p := presetFor(s.preset)
+	for _, key := range []string{lang + "/" + name, name} {
+		if body, ok := p.Prompts[key]; ok {
+			return PromptTemplate{Name: name, Origin: PromptPreset, Body: body}
+		}
+	}
+	return PromptTemplate{Name: name, Origin: PromptBuiltin, Body: builtinPrompt(lang, name)}
+}
+
+// resolve finds template name and the partials it is parsed with; its
+// version covers both
+func (s promptSet) resolve(name string) (PromptTemplate, string) {
+	common := s.lookup(PromptCommon).Body
+	t := s.lookup(name)
+	if name == PromptCommon {
+		t.Version = promptVersion(t.Body)
+		return t, ""
+	}
+	t.Version = promptVersion(common, t.Body)
+	return t, common
+}
+
+// render executes the system and user prompt of template name
+func (s promptSet) render(name string, d PromptData) (sys, user, version string, err error) {
+	t, common := s.resolve(name)
+	tpl, err := parsePrompt(name, common, t.Body)
+	if err != nil {
+		return "", "", "", fmt.Errorf("prompt template %s (%s): %w", name, t.Origin, err)
+	}
+	out := [2]strings.Builder{}
+	for i, part := range []string{"system", "user"} {
+		if tpl.Lookup(part) == nil {
+			continue
+		}
+		if err := tpl.ExecuteTemplate(&out[i], part, d); err != nil {
+			return "", "", "", fmt.Errorf("prompt template %s (%s): %w", name, t.Origin, err)
+		}
+	}
+	return out[0].String(), out[1].String(), t.Version, nil
+}
+
+// JobPrompts lists every template as a job with preset, dir and language
+// lang sees it
+func JobPrompts(dir, preset, lang string) []PromptTemplate {
+	s := promptSet{preset: preset, dir: dir, lang: lang}
+	out := make([]PromptTemplate, 0, len(builtinPrompts))
+	for _, name := range PromptNames() {
+		t, _ := s.resolve(name)
+		out = append(out, t)
+	}
+	return out
+}
+
+func promptVersion(bodies ...string) string {
+	h := sha256.New()
+	for _, b := range bodies {
+		h.Write([]byte(b))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// parsed templates by version; the bodies behind a version never change
+var promptCache sync.Map
+
+func parsePrompt(name, common, body string) (*template.Template, error) {
+	key := promptVersion(name, common, body)
+	if t, ok := promptCache.Load(key); ok {
+		return t.(*template.Template), nil
+	}
+	t, err := template.New(name).Funcs(promptFuncs).Parse(common)
+	if err != nil {
+		return nil, err
+	}
+	if t, err = t.Parse(body); err != nil {
+		return nil, err
+	}
+	promptCache.Store(key, t)
+	return t, nil
+}
+
+// prompt renders template name for spec in the book's language with the
+// job's and the preset's overrides and traces its version for the next
+// persisted artifact
+func (g *Generator) prompt(spec Spec, name string, d PromptData) (string, string, error) {
+	d.Spec = spec
+	d.Preset = presetFor(spec.Preset)
+	sys, user, version, err := promptSet{preset: spec.Preset, dir: g.PersistDir, lang: spec.language()}.render(name, d)
+	if err != nil {
+		return "", "", err
+	}
+	g.traceTemplate(name + "@" + version)
+	return sys, user, nil
+}
+
+// BuildSystemFromCategories is the system prompt of a request that names a
+// gender, categories or tags instead of a system prompt, in language lang
+func BuildSystemFromCategories(lang, gender string, categories, tags []string) string {
+	sys, _, _, _ := promptSet{lang: lang}.render("category_system", PromptData{Spec: Spec{Language: lang, Gender: gender, Categories: categories, Tags: tags}})
+	return sys
+}
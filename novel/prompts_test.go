@@ -0,0 +1,145 @@
+package novel
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckPrompt(t *testing.T) {
+	cases := []struct {
+		name string
+		lang string
+		tmpl string
+		body string
+		err  string
+	}{
+		{"override", LangChinese, "audit", `{{define "system"}}审{{end}}{{define "user"}}{{.Chapters}}{{end}}`, ""},
+		{"user only", LangChinese, "chapter", `{{define "user"}}写{{end}}`, ""},
+		{"uses a partial", LangChinese, "chapter", `{{define "user"}}{{template "glossary" .}}{{end}}`, ""},
+		{"english job", LangEnglish, "chapter", `{{define "user"}}{{template "states" .}}{{end}}`, ""},
+		{"job language tag", "ja-JP", "chapter", `{{define "user"}}書く{{end}}`, ""},
+		{"every language", "", "chapter", `{{define "user"}}{{template "glossary" .}}{{end}}`, ""},
+		{"language override", "", "en/chapter", `{{define "user"}}write{{end}}`, ""},
+		{"language alias", "", "EN/chapter", `{{define "user"}}write{{end}}`, ""},
+		{"prefix wins over the job language", LangChinese, "en/chapter", `{{define "user"}}write{{end}}`, ""},
+		{"common", LangChinese, PromptCommon, `{{define "glossary"}}{{end}}`, ""},
+		{"unknown template", LangChinese, "nope", `{{define "user"}}x{{end}}`, "unknown prompt template"},
+		{"unknown language", "", "xx/chapter", `{{define "user"}}x{{end}}`, "unknown prompt template"},
+		{"unsupported job language", "fr", "chapter", `{{define "user"}}x{{end}}`, "unsupported language"},
+		{"empty language", "", "/chapter", `{{define "user"}}x{{end}}`, "unknown prompt template"},
+		{"language pack dir", "", "en/", `{{define "user"}}x{{end}}`, "unknown prompt template"},
+		{"does not parse", LangEnglish, "chapter", `{{define "user"}}{{.Plan.Title}{{end}}`, "chapter"},
+		{"does not parse in any language", "", "chapter", `{{define "user"}}{{.Plan.Title}{{end}}`, "zh: template: chapter"},
+		{"unknown partial function", LangChinese, "chapter", `{{define "user"}}{{shout .Words}}{{end}}`, "shout"},
+		{"no system or user", LangJapanese, "chapter", `{{define "other"}}x{{end}}`, "neither system nor user"},
+		{"common does not parse", LangChinese, PromptCommon, `{{define "glossary"}}`, "common"},
+	}
+	for _, c := range cases {
+		err := CheckPrompt(c.lang, c.tmpl, c.body)
+		if c.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", c.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.err)
+		}
+	}
+}
+
+func TestPromptResolution(t *testing.T) {
+	t.Cleanup(func() { _ = LoadPresets("") })
+	presetDir := t.TempDir()
+	preset := "prompts:\n  audit: 'preset all'\n  en/audit: 'preset en'\n"
+	if err := os.WriteFile(filepath.Join(presetDir, "ptest.yaml"), []byte(preset), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// prompts defining neither system nor user are rejected when loaded
+	if err := LoadPresets(presetDir); err == nil {
+		t.Fatal("preset with invalid prompts accepted")
+	}
+	preset = "prompts:\n  audit: '{{define \"user\"}}preset all{{end}}'\n  en/audit: '{{define \"user\"}}preset en{{end}}'\n"
+	if err := os.WriteFile(filepath.Join(presetDir, "ptest.yaml"), []byte(preset), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadPresets(presetDir); err != nil {
+		t.Fatal(err)
+	}
+	jobDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(jobDir, PromptDir), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(jobDir, PromptDir, "audit.tmpl"), []byte(`{{define "user"}}job{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		set    promptSet
+		origin string
+		user   string
+	}{
+		{"job dir first", promptSet{preset: "ptest", dir: jobDir, lang: LangEnglish}, PromptJob, "job"},
+		{"preset in the book's language", promptSet{preset: "ptest", lang: LangEnglish}, PromptPreset, "preset en"},
+		{"preset for all languages", promptSet{preset: "ptest", lang: LangJapanese}, PromptPreset, "preset all"},
+		{"preset for chinese", promptSet{preset: "ptest"}, PromptPreset, "preset all"},
+		{"job dir without the template", promptSet{preset: "ptest", dir: t.TempDir(), lang: LangEnglish}, PromptPreset, "preset en"},
+		{"built-in language pack", promptSet{preset: DefaultPreset, lang: LangEnglish}, PromptBuiltin, ""},
+		{"built-in chinese", promptSet{preset: DefaultPreset}, PromptBuiltin, ""},
+	}
+	for _, c := range cases {
+		got := c.set.lookup("audit")
+		if got.Origin != c.origin {
+			t.Errorf("%s: origin = %s, want %s", c.name, got.Origin, c.origin)
+			continue
+		}
+		if c.user != "" {
+			_, user, _, err := c.set.render("audit", PromptData{})
+			if err != nil || user != c.user {
+				t.Errorf("%s: user = %q, %v; want %q", c.name, user, err, c.user)
+			}
+			continue
+		}
+		if want := builtinPrompt(c.set.lang, "audit"); got.Body != want {
+			t.Errorf("%s: body is not the built-in one", c.name)
+		}
+	}
+	if builtinPrompt(LangEnglish, "audit") == builtinPrompt("", "audit") {
+		t.Fatal("english pack falls back to chinese")
+	}
+	if builtinPrompt(LangEnglish, "no such template") != "" {
+		t.Fatal("unknown template resolved")
+	}
+}
+
+func TestPromptVersionCoversPartials(t *testing.T) {
+	plain, _ := promptSet{}.resolve("chapter")
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, PromptDir), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	common := builtinPrompt(LangChinese, PromptCommon) + "\n"
+	if err := os.WriteFile(filepath.Join(dir, PromptDir, PromptCommon+".tmpl"), []byte(common), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	edited, _ := promptSet{dir: dir}.resolve("chapter")
+	if edited.Origin != PromptBuiltin || edited.Body != plain.Body {
+		t.Fatalf("chapter resolved to %s", edited.Origin)
+	}
+	if edited.Version == plain.Version {
+		t.Fatal("editing the partials kept the chapter version")
+	}
+	names := map[string]bool{}
+	for _, p := range JobPrompts(dir, DefaultPreset, LangChinese) {
+		names[p.Name] = true
+		if p.Name == PromptCommon && p.Origin != PromptJob {
+			t.Errorf("common origin = %s, want %s", p.Origin, PromptJob)
+		}
+	}
+	if len(names) != len(PromptNames()) {
+		t.Fatalf("JobPrompts lists %d templates, want %d", len(names), len(PromptNames()))
+	}
+}
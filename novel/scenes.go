@@ -0,0 +1,174 @@
+package novel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Scene is one beat of a chapter; chapters whose plan has scenes are
+// written scene by scene and then smoothed into one text
+type Scene struct {
+	POV      string `json:"pov"`
+	Location string `json:"location"`
+	Goal     string `json:"goal"`
+	Conflict string `json:"conflict"`
+	Outcome  string `json:"outcome"`
+	Summary  string `json:"summary,omitempty"`
+}
+
+// minScenes and maxScenes bound the scenes of a chapter; the scene plan
+// prompt asks for 3-6
+const (
+	minScenes = 3
+	maxScenes = 6
+)
+
+// sceneTailRunes is how much of the previous scene a scene prompt quotes
+const sceneTailRunes = 600
+
+// minSceneWords keeps scenes of short chapters from collapsing into a paragraph
+const minSceneWords = 300
+
+// PlanScenes expands a chapter plan into 3-6 scenes and stores them on the
+// plan in plans.json. A plan with fewer scenes is asked for once more and
+// then rejected
+func (g *Generator) PlanScenes(ctx context.Context, spec Spec, canon Canon, plan Chapter) ([]Scene, error) {
+	sys, user, err := g.prompt(spec, "scene_plan", PromptData{Canon: canon, Plan: plan, Characters: SelectRelevantCharacters(plan, canon.Characters, 3)})
+	if err != nil {
+		return nil, err
+	}
+	var scenes []Scene
+	for attempt := 0; attempt < 2; attempt++ {
+		out, err := g.chatTimed(ctx, spec.Model, sys, user)
+		if err != nil {
+			return nil, err
+		}
+		scenes = nil
+		if err := json.Unmarshal([]byte(extractJSON(out)), &scenes); err != nil {
+			return nil, err
+		}
+		if len(scenes) >= minScenes {
+			break
+		}
+		if attempt == 0 && g.Log != nil {
+			g.Log(fmt.Sprintf("[场景规划] 第%d章 仅%d个场景，重新规划", plan.Index, len(scenes)))
+		}
+	}
+	if len(scenes) < minScenes {
+		return nil, fmt.Errorf("chapter %d has %d scenes, want at least %d", plan.Index, len(scenes), minScenes)
+	}
+	if len(scenes) > maxScenes {
+		scenes = scenes[:maxScenes]
+	}
+	if g.PersistDir != "" {
+		if err := saveScenes(g.PersistDir, plan.Index, scenes, g.provenance(StagePlans)); err != nil {
+			return nil, err
+		}
+	}
+	if g.Log != nil {
+		g.Log(fmt.Sprintf("[场景规划] 第%d章 %s 场景数=%d", plan.Index, plan.Title, len(scenes)))
+	}
+	return scenes, nil
+}
+
+// saveScenes stores the scenes of chapter index in plans.json
+func saveScenes(dir string, index int, scenes []Scene, p Provenance) error {
+	b, err := os.ReadFile(filepath.Join(dir, "plans.json"))
+	if err != nil {
+		return err
+	}
+	var plans []Chapter
+	if err := json.Unmarshal(b, &plans); err != nil {
+		return err
+	}
+	for i := range plans {
+		if plans[i].Index == index {
+			plans[i].Scenes = scenes
+			return persistPlans(dir, plans, p)
+		}
+	}
+	return fmt.Errorf("chapter %d has no plan", index)
+}
+
+// ensureScenes plans the scenes of plan when the spec asks for scenes and
+// the plan has none yet
+func (g *Generator) ensureScenes(ctx context.Context, spec Spec, canon Canon, plan *Chapter) error {
+	if !spec.Scenes || len(plan.Scenes) > 0 {
+		return nil
+	}
+	scenes, err := g.PlanScenes(ctx, spec, canon, *plan)
+	if err != nil {
+		return err
+	}
+	plan.Scenes = scenes
+	return nil
+}
+
+// writeScenes writes every scene of plan with the tail of the previous one
+// as context and smooths the stitched scenes into one chapter text
+func (g *Generator) writeScenes(ctx context.Context, spec Spec, canon Canon, plan Chapter, relevant []Character, history []ChapterContent) (string, error) {
+	words := spec.Words / len(plan.Scenes)
+	if words < minSceneWords {
+		words = minSceneWords
+	}
+	parts := make([]string, 0, len(plan.Scenes))
+	tail := ""
+	for i := range plan.Scenes {
+		h := history
+		if i > 0 {
+			h = nil
+		}
+		sys, user, err := g.prompt(spec, "scene", PromptData{Canon: canon, Plan: plan, Scene: i, Characters: relevant, History: h, Tail: tail, Words: words, Instruction: spec.instruction(), System: spec.system()})
+		if err != nil {
+			return "", err
+		}
+		out, err := g.chatTimed(ctx, spec.Model, sys, user)
+		if err != nil {
+			return "", err
+		}
+		out = strings.TrimSpace(out)
+		parts = append(parts, out)
+		rs := []rune(out)
+		if len(rs) > sceneTailRunes {
+			rs = rs[len(rs)-sceneTailRunes:]
+		}
+		tail = string(rs)
+	}
+	stitched := strings.Join(parts, "\n\n")
+	smoothed, err := g.smoothScenes(ctx, spec, plan, parts)
+	// a much shorter result means the model summarised instead of editing
+	if err != nil || len([]rune(smoothed)) < len([]rune(stitched))*3/5 {
+		if g.Log != nil {
+			g.Log(fmt.Sprintf("[场景衔接] 第%d章 润色结果不可用，使用拼接正文", plan.Index))
+		}
+		return stitched, nil
+	}
+	return smoothed, nil
+}
+
+func (g *Generator) smoothScenes(ctx context.Context, spec Spec, plan Chapter, parts []string) (string, error) {
+	sys, user, err := g.prompt(spec, "scene_smooth", PromptData{Plan: plan, Parts: parts})
+	if err != nil {
+		return "", err
+	}
+	out, err := g.chatTimed(ctx, spec.Model, sys, user)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// chatTimed is chatWithRetry bounded by the per-request timeout
+func (g *Generator) chatTimed(ctx context.Context, model, sys, user string) (string, error) {
+	if g.RequestTimeoutSec > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(g.RequestTimeoutSec)*time.Second)
+		defer cancel()
+	}
+	return g.chatWithRetry(ctx, model, sys, user)
+}
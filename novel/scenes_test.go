@@ -0,0 +1,80 @@
+package novel
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func scenesReply(n int) string {
+	scenes := make([]Scene, n)
+	for i := range scenes {
+		scenes[i] = Scene{POV: "陈巽", Goal: "g"}
+	}
+	b, _ := json.Marshal(scenes)
+	return string(b)
+}
+
+func TestPlanScenes(t *testing.T) {
+	cases := []struct {
+		name    string
+		replies []string
+		want    int
+		calls   int
+		err     string
+	}{
+		{"within bounds", []string{scenesReply(4)}, 4, 1, ""},
+		{"capped", []string{scenesReply(8)}, maxScenes, 1, ""},
+		{"planned again", []string{scenesReply(2), scenesReply(3)}, 3, 2, ""},
+		{"too few twice", []string{scenesReply(1), scenesReply(2)}, 0, 2, "2 scenes"},
+		{"none", []string{"[]", "[]"}, 0, 2, "0 scenes"},
+		{"not json", []string{"场景一"}, 0, 1, "invalid"},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		plans := []Chapter{{Index: 1, Title: "一"}, {Index: 2, Title: "二"}}
+		if err := persistPlans(dir, plans, Provenance{}); err != nil {
+			t.Fatal(err)
+		}
+		client := &scriptClient{replies: c.replies}
+		g := NewGenerator(client).WithPersistDir(dir)
+		scenes, err := g.PlanScenes(context.Background(), Spec{}, Canon{}, plans[1])
+		if len(client.users) != c.calls {
+			t.Errorf("%s: %d calls, want %d", c.name, len(client.users), c.calls)
+		}
+		saved := readChapters(t, dir, "plans.json")[1].Scenes
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: err = %v, want %q", c.name, err, c.err)
+			}
+			if len(saved) != 0 {
+				t.Errorf("%s: rejected scenes were saved", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if len(scenes) != c.want || len(saved) != c.want {
+			t.Errorf("%s: %d scenes, %d saved, want %d", c.name, len(scenes), len(saved), c.want)
+		}
+	}
+}
+
+func TestPlanScenesTimeout(t *testing.T) {
+	g := NewGenerator(blockingClient{}).WithRequestPolicy(1, 1, 0)
+	_, err := g.PlanScenes(context.Background(), Spec{}, Canon{}, Chapter{Index: 1})
+	if err == nil || !strings.Contains(err.Error(), "deadline") {
+		t.Fatalf("err = %v, want the request timeout", err)
+	}
+}
+
+// blockingClient answers only when the request is cancelled
+type blockingClient struct{}
+
+func (blockingClient) Chat(ctx context.Context, model, system, user string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
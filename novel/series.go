@@ -0,0 +1,182 @@
+package novel
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// SeriesFile is the snapshot of the project canon a book was started from
+const SeriesFile = "series.json"
+
+const StageSeries = "series"
+
+// TimelineEvent is one dated fact of a shared universe
+type TimelineEvent struct {
+	Book    string `json:"book,omitempty"`
+	When    string `json:"when"`
+	Event   string `json:"event"`
+	Chapter int    `json:"chapter,omitempty"`
+}
+
+// Series is the canon shared by the books of a project: a world bible,
+// recurring characters with their latest state, a timeline and settings
+type Series struct {
+	Name       string          `json:"name"`
+	World      string          `json:"world"`
+	Facts      []string        `json:"facts"`
+	Characters []Character     `json:"characters"`
+	Timeline   []TimelineEvent `json:"timeline"`
+	Settings   Settings        `json:"settings"`
+	// States is the tracked state recurring characters ended the last
+	// concluded book in; the next book's character_state.json starts from it
+	States []CharacterState `json:"states,omitempty"`
+}
+
+func (s *Series) empty() bool {
+	return s == nil || (s.World == "" && len(s.Facts) == 0 && len(s.Characters) == 0 && len(s.Timeline) == 0 && len(s.States) == 0)
+}
+
+// WithSeries applies the shared canon of a project to the canon of one book;
+// recurring characters the book did not redefine are appended and keep the
+// state they ended the previous book in
+func (c Canon) WithSeries(s *Series) Canon {
+	if s == nil {
+		return c
+	}
+	c.Series = s.Name
+	c.World = s.World
+	c.Facts = append([]string(nil), s.Facts...)
+	for _, e := range s.Timeline {
+		c.Facts = append(c.Facts, e.String())
+	}
+	c.Characters = MergeSeriesCharacters(s.Characters, c.Characters)
+	if c.Settings.Empty() {
+		c.Settings = s.Settings
+	}
+	return c
+}
+
+func (e TimelineEvent) String() string {
+	var b strings.Builder
+	if e.Book != "" {
+		b.WriteString("《" + e.Book + "》")
+	}
+	if e.When != "" {
+		b.WriteString(e.When)
+		b.WriteString("：")
+	}
+	b.WriteString(e.Event)
+	return b.String()
+}
+
+// MergeSeriesCharacters keeps the book's own characters first; a book
+// character that is also recurring inherits missing fields and the state
+// from the series
+func MergeSeriesCharacters(recurring, book []Character) []Character {
+	byName := map[string]Character{}
+	for _, r := range recurring {
+		byName[r.Name] = r
+	}
+	seen := map[string]bool{}
+	out := make([]Character, 0, len(book)+len(recurring))
+	for _, c := range book {
+		if r, ok := byName[c.Name]; ok {
+			c = mergeCharacters(r, c)
+			if c.State == "" {
+				c.State = r.State
+			}
+		}
+		seen[c.Name] = true
+		out = append(out, c)
+	}
+	for _, r := range recurring {
+		if !seen[r.Name] {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func (g *Generator) WithSeries(s *Series) *Generator {
+	if !s.empty() {
+		g.Series = s
+	}
+	return g
+}
+
+// seriesData is the prompt data of outline prompts: the series of a sequel
+// and a canon carrying its world and facts
+func (g *Generator) seriesData(spec Spec) PromptData {
+	return PromptData{Series: g.Series, Canon: BuildCanon(spec, Outline{}, nil, Settings{}).WithSeries(g.Series)}
+}
+
+// canon builds the canon of the current book on top of the series, if any
+func (g *Generator) canon(spec Spec, outline Outline, characters []Character, settings Settings) Canon {
+	return BuildCanon(spec, outline, characters, settings).WithSeries(g.Series)
+}
+
+// BookConclusion is what a finished book hands on to the next one
+type BookConclusion struct {
+	Characters []Character     `json:"characters"`
+	Events     []TimelineEvent `json:"events"`
+	Facts      []string        `json:"facts"`
+}
+
+// ConcludeBook asks the model for the state every character ends the book
+// in, the key timeline events and the facts later books must respect; states
+// are the latest entries of the book's character state ledger
+func (g *Generator) ConcludeBook(ctx context.Context, spec Spec, outline Outline, characters []Character, states []CharacterState, last []ChapterContent) (BookConclusion, error) {
+	sys, user, err := g.prompt(spec, "conclude", PromptData{Outline: outline, Characters: characters, States: states, Chapters: last})
+	if err != nil {
+		return BookConclusion{}, err
+	}
+	out, err := g.chatWithRetry(ctx, spec.Model, sys, user)
+	if err != nil {
+		return BookConclusion{}, err
+	}
+	var res BookConclusion
+	if err := json.Unmarshal([]byte(extractJSON(out)), &res); err != nil {
+		return BookConclusion{}, err
+	}
+	for i := range res.Events {
+		res.Events[i].Book = outline.Title
+	}
+	return res, nil
+}
+
+// Absorb folds a finished book into the series: its characters become
+// recurring with their end state, the tracked states replace those of the
+// same characters, and its events and facts are appended
+func (s *Series) Absorb(characters []Character, states []CharacterState, c BookConclusion) {
+	byName := map[string]CharacterState{}
+	for _, st := range s.States {
+		byName[st.Name] = st
+	}
+	for _, st := range states {
+		st.Chapter = 0
+		byName[st.Name] = st
+	}
+	s.States = make([]CharacterState, 0, len(byName))
+	for _, name := range sortedStateNames(byName) {
+		s.States = append(s.States, byName[name])
+	}
+
+	ends := map[string]string{}
+	for _, ch := range c.Characters {
+		ends[ch.Name] = ch.State
+	}
+	merged := MergeSeriesCharacters(s.Characters, characters)
+	for i := range merged {
+		if st, ok := ends[merged[i].Name]; ok && st != "" {
+			merged[i].State = st
+		}
+	}
+	s.Characters = merged
+	s.Timeline = append(s.Timeline, c.Events...)
+	for _, f := range c.Facts {
+		if f = strings.TrimSpace(f); f != "" {
+			s.Facts = append(s.Facts, f)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package novel
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSeriesAbsorb(t *testing.T) {
+	s := Series{
+		Characters: []Character{{Name: "陈巽", Role: "主角", State: "初入江湖"}},
+		States:     []CharacterState{{Name: "陈巽", Location: "城南"}, {Name: "老周", Status: "重伤"}},
+	}
+	characters := []Character{{Name: "陈巽", Role: "主角"}, {Name: "苏晚晴", Role: "女主"}}
+	states := []CharacterState{
+		{Name: "陈巽", Location: "省城", Realm: "筑基", Chapter: 30},
+		{Name: "苏晚晴", Possessions: []string{"罗盘"}, Chapter: 28},
+	}
+	res := BookConclusion{
+		Characters: []Character{{Name: "陈巽", State: "名动省城"}, {Name: "苏晚晴"}},
+		Events:     []TimelineEvent{{Book: "一", Event: "旧宅案破"}},
+		Facts:      []string{" 罗盘已毁 ", ""},
+	}
+	s.Absorb(characters, states, res)
+
+	wantStates := []CharacterState{
+		{Name: "老周", Status: "重伤"},
+		{Name: "苏晚晴", Possessions: []string{"罗盘"}},
+		{Name: "陈巽", Location: "省城", Realm: "筑基"},
+	}
+	if !reflect.DeepEqual(s.States, wantStates) {
+		t.Errorf("states = %+v, want %+v", s.States, wantStates)
+	}
+	ends := map[string]string{}
+	for _, c := range s.Characters {
+		ends[c.Name] = c.State
+	}
+	if want := map[string]string{"陈巽": "名动省城", "苏晚晴": ""}; !reflect.DeepEqual(ends, want) {
+		t.Errorf("end states = %v, want %v", ends, want)
+	}
+	if !reflect.DeepEqual(s.Facts, []string{"罗盘已毁"}) || len(s.Timeline) != 1 {
+		t.Errorf("facts %q and timeline %+v", s.Facts, s.Timeline)
+	}
+}
+
+func TestCarriedStates(t *testing.T) {
+	cases := []struct {
+		name   string
+		states []CharacterState
+		want   []string
+	}{
+		{"nothing carried", nil, []string{}},
+		{"carried into chapter 1", []CharacterState{{Name: "陈巽", Location: "省城"}, {Name: "老周"}}, []string{"老周", "陈巽"}},
+	}
+	for _, c := range cases {
+		ledger := CarriedStates(c.states)
+		ledger.Set(StateUpdate{Chapter: 2, Characters: []CharacterState{{Name: "陈巽", Location: "京城"}}})
+		if got := sortedStateNames(ledger.Before(1)); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: states before chapter 1 = %v, want %v", c.name, got, c.want)
+		}
+		if st := ledger.Before(3)["陈巽"]; st.Location != "京城" {
+			t.Errorf("%s: chapter 2 did not update the carried state: %+v", c.name, st)
+		}
+	}
+}
+
+func TestConcludeBookPassesStates(t *testing.T) {
+	for _, lang := range []string{LangChinese, LangEnglish, LangJapanese} {
+		client := &scriptClient{replies: []string{`{"characters":[{"name":"陈巽","state":"s"}],"events":[{"event":"e"}],"facts":[]}`}}
+		g := NewGenerator(client)
+		states := []CharacterState{{Name: "陈巽", Location: "省城"}}
+		res, err := g.ConcludeBook(context.Background(), Spec{Language: lang}, Outline{Title: "一"}, nil, states, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", lang, err)
+		}
+		if len(res.Events) != 1 || res.Events[0].Book != "一" {
+			t.Errorf("%s: events = %+v", lang, res.Events)
+		}
+		if p := client.users[0]; !strings.Contains(p, "陈巽") || !strings.Contains(p, "省城") {
+			t.Errorf("%s: prompt misses the tracked state:\n%s", lang, p)
+		}
+	}
+}
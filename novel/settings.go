@@ -0,0 +1,407 @@
+package novel
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// setting field types
+const (
+	FieldText = "text"
+	FieldList = "list"
+	FieldMap  = "map"
+)
+
+// SettingField is one typed field of a settings section
+type SettingField struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	// Hint stands in for the value in the settings prompt
+	Hint string `json:"hint,omitempty"`
+	// Term is the glossary category of the names the field holds, empty for
+	// fields that hold prose
+	Term string `json:"term,omitempty"`
+	// Definition is the key of the field in the same section that defines
+	// the term
+	Definition string `json:"definition,omitempty"`
+}
+
+// SettingSection is one object of the settings, such as the protagonist
+type SettingSection struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+	// Labels name the section in books of other languages by language code
+	Labels map[string]string `json:"labels,omitempty"`
+	Fields []SettingField    `json:"fields"`
+}
+
+// label is the name of the section in books in language lang
+func (sec SettingSection) label(lang string) string {
+	if l, ok := sec.Labels[lang]; ok {
+		return l
+	}
+	return sec.Label
+}
+
+// SettingSchema is the shape of a preset's world settings and how they are
+// written into chapter prompts. Render and Brief are text/template bodies
+// executed with the settings, so {{.protagonist.goal}} is the protagonist's
+// goal and join concatenates lists; Brief is used in scene prompts, and
+// without Render every section is written as one line of its values
+type SettingSchema struct {
+	Sections []SettingSection `json:"sections"`
+	// Requirements are appended to the settings prompt
+	Requirements string `json:"requirements,omitempty"`
+	Render       string `json:"render,omitempty"`
+	Brief        string `json:"brief,omitempty"`
+}
+
+// Settings are the world settings of a book by section and field. Values
+// are strings, string lists or string maps as the schema declares; the JSON
+// form is the object the settings prompt asks for
+type Settings map[string]map[string]any
+
+func (s *Settings) UnmarshalJSON(b []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	out := Settings{}
+	for key, r := range raw {
+		if string(r) == "null" {
+			continue
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(r, &fields); err != nil {
+			return fmt.Errorf("settings section %s must be an object", key)
+		}
+		sec := map[string]any{}
+		for k, v := range fields {
+			if v != nil {
+				sec[k] = settingValue(v)
+			}
+		}
+		out[key] = sec
+	}
+	*s = out
+	return nil
+}
+
+// settingValue turns a decoded JSON value into a string, a string list or a
+// string map
+func settingValue(v any) any {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []any:
+		out := make([]string, 0, len(t))
+		for _, x := range t {
+			out = append(out, settingText(x))
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]string, len(t))
+		for k, x := range t {
+			out[k] = settingText(x)
+		}
+		return out
+	}
+	return settingText(v)
+}
+
+func settingText(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64, bool:
+		return fmt.Sprint(t)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// Text is a field as one string; lists and maps are joined as language
+// lang writes lists
+func (s Settings) Text(section, field, lang string) string {
+	return joinSetting(s[section][field], languageFor(lang).list)
+}
+
+// Values lists the strings of a field: the text, the list items or the
+// keys of a map
+func (s Settings) Values(section, field string) []string {
+	switch t := s[section][field].(type) {
+	case string:
+		if t == "" {
+			return nil
+		}
+		return []string{t}
+	case []string:
+		return t
+	case map[string]string:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	return nil
+}
+
+// Empty reports whether no field holds a value
+func (s Settings) Empty() bool {
+	for key := range s {
+		for field := range s[key] {
+			if joinSetting(s[key][field], "") != "" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func joinSetting(v any, sep string) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []string:
+		return strings.Join(t, sep)
+	case map[string]string:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, 0, len(keys))
+		for _, k := range keys {
+			parts = append(parts, k+"："+t[k])
+		}
+		return strings.Join(parts, "；")
+	}
+	return ""
+}
+
+var settingFuncs = template.FuncMap{
+	"join": func(v any, sep string) string { return joinSetting(v, sep) },
+}
+
+// Shape is the JSON object the settings prompt asks for, with the field
+// hints as values; mapHint stands in for map fields without a hint
+func (sc SettingSchema) Shape(mapHint string) string {
+	b := strings.Builder{}
+	b.WriteString("{")
+	for i, sec := range sc.Sections {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(fmt.Sprintf("%q:{", sec.Key))
+		for k, f := range sec.Fields {
+			if k > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(fmt.Sprintf("%q:", f.Key))
+			switch {
+			case f.Type == FieldList:
+				b.WriteString("[" + orDefault(f.Hint, "...") + "]")
+			case f.Type == FieldMap:
+				b.WriteString("{" + orDefault(f.Hint, mapHint) + "}")
+			default:
+				b.WriteString(orDefault(f.Hint, "..."))
+			}
+		}
+		b.WriteString("}")
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// Validate checks the fields the schema declares hold values of their type;
+// sections and fields the schema does not know are kept as they are
+func (sc SettingSchema) Validate(s Settings) error {
+	for _, sec := range sc.Sections {
+		for _, f := range sec.Fields {
+			v, ok := s[sec.Key][f.Key]
+			if !ok {
+				continue
+			}
+			var valid bool
+			switch f.Type {
+			case FieldList:
+				_, valid = v.([]string)
+			case FieldMap:
+				_, valid = v.(map[string]string)
+			default:
+				_, valid = v.(string)
+			}
+			if !valid {
+				return fmt.Errorf("%s.%s must be %s", sec.Key, f.Key, fieldTypeName(f.Type))
+			}
+		}
+	}
+	return nil
+}
+
+func fieldTypeName(t string) string {
+	switch t {
+	case FieldList:
+		return "a list of strings"
+	case FieldMap:
+		return "an object of strings"
+	}
+	return "a string"
+}
+
+// RenderSettings writes the settings as prompt text for a book in language
+// lang with the schema's template, or with the brief template for scene
+// prompts
+func (sc SettingSchema) RenderSettings(s Settings, brief bool, lang string) (string, error) {
+	body := sc.Render
+	if brief && sc.Brief != "" {
+		body = sc.Brief
+	}
+	if body == "" {
+		return sc.renderLines(s, lang), nil
+	}
+	t, err := template.New("settings").Funcs(settingFuncs).Parse(body)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, sc.fill(s)); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// fill copies the settings with every declared field present, so templates
+// print empty values instead of "<no value>"
+func (sc SettingSchema) fill(s Settings) Settings {
+	out := Settings{}
+	for key, sec := range s {
+		out[key] = map[string]any{}
+		for k, v := range sec {
+			out[key][k] = v
+		}
+	}
+	for _, sec := range sc.Sections {
+		if out[sec.Key] == nil {
+			out[sec.Key] = map[string]any{}
+		}
+		for _, f := range sec.Fields {
+			if _, ok := out[sec.Key][f.Key]; ok {
+				continue
+			}
+			switch f.Type {
+			case FieldList:
+				out[sec.Key][f.Key] = []string{}
+			case FieldMap:
+				out[sec.Key][f.Key] = map[string]string{}
+			default:
+				out[sec.Key][f.Key] = ""
+			}
+		}
+	}
+	return out
+}
+
+// renderLines writes one line per section with its values separated by |;
+// sections the schema does not declare follow by key
+func (sc SettingSchema) renderLines(s Settings, lang string) string {
+	l := languageFor(lang)
+	var lines []string
+	known := map[string]bool{}
+	for _, sec := range sc.Sections {
+		known[sec.Key] = true
+		var vals []string
+		for _, f := range sec.Fields {
+			if v := joinSetting(s[sec.Key][f.Key], l.list); v != "" {
+				vals = append(vals, v)
+			}
+		}
+		if len(vals) > 0 {
+			lines = append(lines, sec.label(l.Code)+l.colon+strings.Join(vals, "|"))
+		}
+	}
+	keys := make([]string, 0, len(s))
+	for key := range s {
+		if !known[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fields := make([]string, 0, len(s[key]))
+		for f := range s[key] {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		var vals []string
+		for _, f := range fields {
+			if v := joinSetting(s[key][f], l.list); v != "" {
+				vals = append(vals, v)
+			}
+		}
+		if len(vals) > 0 {
+			lines = append(lines, key+l.colon+strings.Join(vals, "|"))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderedSettings is the settings as chapter prompts show them, in the
+// brief form for scenes; empty when there are none
+func renderedSettings(c Canon, brief bool) string {
+	if c.Settings.Empty() {
+		return ""
+	}
+	out, err := c.Schema.RenderSettings(c.Settings, brief, c.Language)
+	if err != nil {
+		// a broken template must not drop the settings from the prompt
+		out = c.Schema.renderLines(c.Settings, c.Language)
+	}
+	return out
+}
+
+// check reports a schema that cannot be used: sections and fields without
+// keys, unknown field types or templates that do not parse
+func (sc SettingSchema) check() error {
+	if len(sc.Sections) == 0 {
+		return fmt.Errorf("settings schema has no sections")
+	}
+	seen := map[string]bool{}
+	for _, sec := range sc.Sections {
+		if sec.Key == "" || seen[sec.Key] {
+			return fmt.Errorf("settings section %q has no key or a duplicate key", sec.Label)
+		}
+		seen[sec.Key] = true
+		for _, f := range sec.Fields {
+			if f.Key == "" {
+				return fmt.Errorf("settings section %s has a field without key", sec.Key)
+			}
+			switch f.Type {
+			case FieldText, FieldList, FieldMap:
+			default:
+				return fmt.Errorf("field %s.%s has unknown type %q", sec.Key, f.Key, f.Type)
+			}
+		}
+	}
+	for _, body := range []string{sc.Render, sc.Brief} {
+		if _, err := template.New("settings").Funcs(settingFuncs).Parse(body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
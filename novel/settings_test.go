@@ -0,0 +1,107 @@
+package novel
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSettingsUnmarshalJSON(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want Settings
+		ok   bool
+	}{
+		{"empty", `{}`, Settings{}, true},
+		{"text list and map", `{"world":{"name":"九州","sects":["青云门","天机阁"],"realms":{"筑基":"初入仙途","金丹":"结丹"}}}`, Settings{"world": {
+			"name":   "九州",
+			"sects":  []string{"青云门", "天机阁"},
+			"realms": map[string]string{"筑基": "初入仙途", "金丹": "结丹"},
+		}}, true},
+		{"scalars become text", `{"hero":{"age":16,"alive":true,"height":1.75}}`, Settings{"hero": {"age": "16", "alive": "true", "height": "1.75"}}, true},
+		{"nulls are dropped", `{"hero":{"name":"林风","goal":null},"villain":null}`, Settings{"hero": {"name": "林风"}}, true},
+		{"nested values are kept as JSON", `{"hero":{"items":[1,{"a":"b"},null],"stats":{"str":9,"tags":["x"]}}}`, Settings{"hero": {
+			"items": []string{"1", `{"a":"b"}`, ""},
+			"stats": map[string]string{"str": "9", "tags": `["x"]`},
+		}}, true},
+		{"section is not an object", `{"hero":"林风"}`, nil, false},
+		{"section is a list", `{"hero":["林风"]}`, nil, false},
+		{"not an object", `["hero"]`, nil, false},
+	}
+	for _, c := range cases {
+		var s Settings
+		err := json.Unmarshal([]byte(c.in), &s)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: err = %v, want ok=%v", c.name, err, c.ok)
+			continue
+		}
+		if c.ok && !reflect.DeepEqual(s, c.want) {
+			t.Errorf("%s: settings = %#v, want %#v", c.name, s, c.want)
+		}
+	}
+}
+
+func TestSettingsRoundTrip(t *testing.T) {
+	in := Settings{"world": {"name": "九州", "sects": []string{"青云门"}, "realms": map[string]string{"筑基": "初入仙途"}}}
+	b, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Settings
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("round trip = %#v, want %#v", out, in)
+	}
+	if got := out.Text("world", "sects", LangChinese); got != "青云门" {
+		t.Errorf("Text = %s", got)
+	}
+	if got := out.Values("world", "realms"); !reflect.DeepEqual(got, []string{"筑基"}) {
+		t.Errorf("Values = %v", got)
+	}
+}
+
+func TestSettingSchemaValidate(t *testing.T) {
+	schema := SettingSchema{Sections: []SettingSection{{Key: "world", Fields: []SettingField{
+		{Key: "name", Type: FieldText},
+		{Key: "sects", Type: FieldList},
+		{Key: "realms", Type: FieldMap},
+	}}}}
+	cases := []struct {
+		name string
+		s    Settings
+		ok   bool
+	}{
+		{"matching types", Settings{"world": {"name": "九州", "sects": []string{"a"}, "realms": map[string]string{}}}, true},
+		{"unknown fields are kept", Settings{"world": {"extra": []string{"a"}}, "other": {"x": "y"}}, true},
+		{"text for a list", Settings{"world": {"sects": "青云门"}}, false},
+		{"list for text", Settings{"world": {"name": []string{"九州"}}}, false},
+		{"list for a map", Settings{"world": {"realms": []string{"筑基"}}}, false},
+	}
+	for _, c := range cases {
+		if err := schema.Validate(c.s); (err == nil) != c.ok {
+			t.Errorf("%s: err = %v, want ok=%v", c.name, err, c.ok)
+		}
+	}
+}
+
+func TestSettingsText(t *testing.T) {
+	s := Settings{"world": {"name": "Avalon", "sects": []string{"Order", "Guild"}}}
+	cases := []struct {
+		lang, field, want string
+	}{
+		{LangChinese, "sects", "Order、Guild"},
+		{LangEnglish, "sects", "Order, Guild"},
+		{"en-US", "sects", "Order, Guild"},
+		{LangJapanese, "sects", "Order、Guild"},
+		{LangEnglish, "name", "Avalon"},
+		{LangEnglish, "missing", ""},
+	}
+	for _, c := range cases {
+		if got := s.Text("world", c.field, c.lang); got != c.want {
+			t.Errorf("Text(%s, %s) = %q, want %q", c.field, c.lang, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package novel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	StageOutline    = "outline"
+	StageCharacters = "characters"
+	StagePlans      = "plans"
+)
+
+// Stages lists the artifact stages in pipeline order; each may be used as an approval gate
+var Stages = []string{StageOutline, StageCharacters, StagePlans}
+
+func ValidStage(stage string) bool {
+	for _, s := range Stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// GateError stops the artifact pipeline after Stage has been persisted so
+// that a human can review or edit it before ResumeArtifacts continues
+type GateError struct {
+	Stage string
+}
+
+func (e *GateError) Error() string {
+	return fmt.Sprintf("awaiting approval after %s", e.Stage)
+}
+
+// PauseError stops the artifact pipeline after Stage has been persisted
+// because a pause was requested; ResumeArtifacts continues it like a gate
+type PauseError struct {
+	Stage string
+}
+
+func (e *PauseError) Error() string {
+	return fmt.Sprintf("paused after %s", e.Stage)
+}
+
+func (s Spec) gated(stage string) bool {
+	for _, g := range s.Gates {
+		if g == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// ResumeArtifacts continues the artifact pipeline after an approved stage,
+// reading earlier artifacts back from PersistDir so manual edits are kept
+func (g *Generator) ResumeArtifacts(ctx context.Context, spec Spec, source string, after string) (Outline, []Character, []Chapter, error) {
+	if !ValidStage(after) {
+		return Outline{}, nil, nil, fmt.Errorf("unknown stage %s", after)
+	}
+	if g.PersistDir == "" {
+		return Outline{}, nil, nil, fmt.Errorf("resume needs a persist dir")
+	}
+	return g.runArtifactStages(ctx, spec, source, after)
+}
+
+func (g *Generator) runArtifactStages(ctx context.Context, spec Spec, source string, after string) (Outline, []Character, []Chapter, error) {
+	var outline Outline
+	var characters []Character
+	var plans []Chapter
+	var err error
+	done := after == ""
+	for _, stage := range Stages {
+		if !done {
+			if err := g.loadStage(stage, &outline, &characters, &plans); err != nil {
+				return Outline{}, nil, nil, err
+			}
+			done = stage == after
+			continue
+		}
+		switch stage {
+		case StageOutline:
+			if source != "" {
+				outline, err = g.parseOutlineFromText(ctx, spec, source)
+			} else {
+				outline, err = g.generateOutline(ctx, spec)
+			}
+			if err == nil && g.PersistDir != "" {
+				_ = persistOutline(g.PersistDir, outline, g.provenance(StageOutline))
+			}
+		case StageCharacters:
+			if source != "" {
+				characters, err = g.parseCharactersFromText(ctx, spec, source, outline)
+			} else {
+				characters, err = g.generateCharacters(ctx, spec, outline)
+			}
+			if err == nil && g.PersistDir != "" {
+				_ = persistCharacters(g.PersistDir, characters, g.provenance(StageCharacters))
+			}
+		case StagePlans:
+			plans, err = g.generateChapterPlans(ctx, spec, outline)
+			if err == nil && g.PersistDir != "" {
+				_ = persistPlans(g.PersistDir, plans, g.provenance(StagePlans))
+			}
+		}
+		if err != nil {
+			return Outline{}, nil, nil, err
+		}
+		if spec.gated(stage) && g.PersistDir != "" {
+			if g.Log != nil {
+				g.Log(fmt.Sprintf("[等待审批] %s 已生成，等待确认后继续", stage))
+			}
+			return outline, characters, plans, &GateError{Stage: stage}
+		}
+		if g.Paused != nil && g.Paused() && g.PersistDir != "" {
+			if g.Log != nil {
+				g.Log(fmt.Sprintf("[任务暂停] %s 已生成，继续后从下一阶段开始", stage))
+			}
+			return outline, characters, plans, &PauseError{Stage: stage}
+		}
+	}
+	if g.PersistDir == "" || !fileExists(filepath.Join(g.PersistDir, "settings.json")) {
+		if settings, err := g.generateSettings(ctx, spec); err == nil && g.PersistDir != "" {
+			_ = persistSettings(g.PersistDir, settings, g.provenance(StageSettings))
+		}
+	}
+	return outline, characters, plans, nil
+}
+
+func (g *Generator) loadStage(stage string, outline *Outline, characters *[]Character, plans *[]Chapter) error {
+	var file string
+	var v interface{}
+	switch stage {
+	case StageOutline:
+		file, v = "outline.json", outline
+	case StageCharacters:
+		file, v = "characters.json", characters
+	case StagePlans:
+		file, v = "plans.json", plans
+	}
+	b, err := os.ReadFile(filepath.Join(g.PersistDir, file))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
@@ -0,0 +1,38 @@
+package novel
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPauseStopsAfterStage(t *testing.T) {
+	dir := t.TempDir()
+	polls := 0
+	g := NewGenerator(glossaryClient{`{"title":"t","chapters":[{"title":"一"}]}`}).WithPersistDir(dir).WithPause(func() bool {
+		polls++
+		return true
+	})
+	outline, _, _, err := g.GenerateArtifacts(context.Background(), Spec{Topic: "t", Chapters: 1})
+	var pe *PauseError
+	if !errors.As(err, &pe) || pe.Stage != StageOutline {
+		t.Fatalf("err = %v, want a pause after %s", err, StageOutline)
+	}
+	if polls != 1 || outline.Title != "t" {
+		t.Fatalf("polls = %d, outline = %+v", polls, outline)
+	}
+	if !fileExists(filepath.Join(dir, "outline.json")) {
+		t.Fatal("paused stage not persisted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "characters.json")); !os.IsNotExist(err) {
+		t.Fatalf("stage after the pause ran: %v", err)
+	}
+	// gates win over a pause requested during the same stage
+	_, _, _, err = g.GenerateArtifacts(context.Background(), Spec{Topic: "t", Chapters: 1, Gates: []string{StageOutline}})
+	var ge *GateError
+	if !errors.As(err, &ge) || ge.Stage != StageOutline {
+		t.Fatalf("err = %v, want the outline gate", err)
+	}
+}
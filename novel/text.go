@@ -0,0 +1,34 @@
+package novel
+
+import "unicode"
+
+// CountWords counts CJK characters one by one and other scripts by
+// whitespace/punctuation separated words, matching how web-novel
+// platforms report chapter length: characters for Chinese and Japanese,
+// words for English. Apostrophes and hyphens inside a word keep it whole
+func CountWords(s string) int {
+	n := 0
+	inWord := false
+	for _, r := range s {
+		switch {
+		case isCJK(r):
+			n++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if !inWord {
+				n++
+				inWord = true
+			}
+		case inWord && (r == '\'' || r == '’' || r == '-'):
+		default:
+			inWord = false
+		}
+	}
+	return n
+}
+
+// isCJK reports the characters counted one by one; the prolonged sound
+// mark ー belongs to no script but is a character of Japanese words
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) || r == 'ー'
+}
@@ -0,0 +1,248 @@
+package novel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ThreadsFile is the per-job ledger of foreshadowing, mysteries and promises
+const ThreadsFile = "threads.json"
+
+const StageThreads = "threads"
+
+// thread kinds
+const (
+	ThreadForeshadow = "foreshadow"
+	ThreadMystery    = "mystery"
+	ThreadPromise    = "promise"
+)
+
+// PlotThread is one setup the story owes the reader a payoff for
+type PlotThread struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Summary string `json:"summary"`
+	// Opened is the chapter the thread was set up in
+	Opened int `json:"opened"`
+	// Resolved is the chapter that paid it off, 0 while the thread is open
+	Resolved   int    `json:"resolved,omitempty"`
+	Resolution string `json:"resolution,omitempty"`
+}
+
+// ThreadLedger is the content of threads.json
+type ThreadLedger struct {
+	Threads []PlotThread `json:"threads"`
+	// Issued is the number of the last thread id handed out; ids of
+	// forgotten threads are not reused
+	Issued int `json:"issued,omitempty"`
+}
+
+var threadsMu sync.Mutex
+
+func LoadThreads(dir string) (ThreadLedger, error) {
+	var l ThreadLedger
+	b, err := os.ReadFile(filepath.Join(dir, ThreadsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ThreadLedger{Threads: []PlotThread{}}, nil
+		}
+		return l, err
+	}
+	if err := json.Unmarshal(b, &l); err != nil {
+		return l, err
+	}
+	if l.Threads == nil {
+		l.Threads = []PlotThread{}
+	}
+	return l, nil
+}
+
+// OpenAt lists the threads open when chapter starts
+func (l ThreadLedger) OpenAt(chapter int) []PlotThread {
+	var out []PlotThread
+	for _, t := range l.Threads {
+		if t.Opened < chapter && (t.Resolved == 0 || t.Resolved >= chapter) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// Until keeps what the chapters before chapter established, for forks and
+// for re-tracking a regenerated chapter
+func (l ThreadLedger) Until(chapter int) ThreadLedger {
+	out := ThreadLedger{Threads: []PlotThread{}, Issued: l.Issued}
+	for _, t := range l.Threads {
+		if t.Opened >= chapter {
+			continue
+		}
+		if t.Resolved >= chapter {
+			t.Resolved, t.Resolution = 0, ""
+		}
+		out.Threads = append(out.Threads, t)
+	}
+	return out
+}
+
+// forget drops what one chapter opened and resolved so that chapter can be
+// tracked again; later chapters keep their threads
+func (l *ThreadLedger) forget(chapter int) {
+	kept := l.Threads[:0]
+	for _, t := range l.Threads {
+		if t.Opened == chapter {
+			continue
+		}
+		if t.Resolved == chapter {
+			t.Resolved, t.Resolution = 0, ""
+		}
+		kept = append(kept, t)
+	}
+	l.Threads = kept
+}
+
+// nextID issues a new thread id; ledgers written before Issued was kept
+// continue after their highest id
+func (l *ThreadLedger) nextID() string {
+	for _, t := range l.Threads {
+		if n, err := strconv.Atoi(strings.TrimPrefix(t.ID, "T")); err == nil && n > l.Issued {
+			l.Issued = n
+		}
+	}
+	l.Issued++
+	return fmt.Sprintf("T%d", l.Issued)
+}
+
+func (l ThreadLedger) find(id string) int {
+	for i, t := range l.Threads {
+		if t.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func persistThreads(dir string, l ThreadLedger, p Provenance) error {
+	sort.SliceStable(l.Threads, func(a, b int) bool { return l.Threads[a].Opened < l.Threads[b].Opened })
+	b, _ := json.MarshalIndent(l, "", "  ")
+	if err := os.WriteFile(filepath.Join(dir, ThreadsFile), b, 0o644); err != nil {
+		return err
+	}
+	return RecordArtifact(dir, ThreadsFile, p)
+}
+
+func threadKind(k string) string {
+	switch k {
+	case ThreadMystery, ThreadPromise:
+		return k
+	}
+	return ThreadForeshadow
+}
+
+// chapterPayoffs is the open threads a chapter plan is meant to pay off
+func chapterPayoffs(c Canon, plan Chapter) []PlotThread {
+	byID := map[string]PlotThread{}
+	for _, t := range c.Threads {
+		byID[t.ID] = t
+	}
+	var out []PlotThread
+	for _, id := range plan.Payoffs {
+		if t, ok := byID[id]; ok {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// TrackThreads reads a written chapter against the threads open before it
+// and records in threads.json which threads it opened and which it paid off
+func (g *Generator) TrackThreads(ctx context.Context, spec Spec, c ChapterContent) error {
+	if g.PersistDir == "" {
+		return nil
+	}
+	threadsMu.Lock()
+	l, err := LoadThreads(g.PersistDir)
+	threadsMu.Unlock()
+	if err != nil {
+		return err
+	}
+	sys, user, err := g.prompt(spec, "track_threads", PromptData{Threads: l.OpenAt(c.Index), Chapter: c})
+	if err != nil {
+		return err
+	}
+	out, err := g.chatTimed(ctx, spec.Model, sys, user)
+	if err != nil {
+		return err
+	}
+	var res struct {
+		Opened []struct {
+			Kind    string `json:"kind"`
+			Summary string `json:"summary"`
+		} `json:"opened"`
+		Resolved []struct {
+			ID         string `json:"id"`
+			Resolution string `json:"resolution"`
+		} `json:"resolved"`
+	}
+	if err := json.Unmarshal([]byte(extractJSON(out)), &res); err != nil {
+		return err
+	}
+
+	threadsMu.Lock()
+	defer threadsMu.Unlock()
+	// re-read: another chapter may have been tracked while the model was busy
+	if l, err = LoadThreads(g.PersistDir); err != nil {
+		return err
+	}
+	l.forget(c.Index)
+	opened, resolved := 0, 0
+	for _, r := range res.Resolved {
+		i := l.find(strings.TrimSpace(r.ID))
+		if i < 0 || l.Threads[i].Opened >= c.Index || (l.Threads[i].Resolved != 0 && l.Threads[i].Resolved < c.Index) {
+			continue
+		}
+		l.Threads[i].Resolved, l.Threads[i].Resolution = c.Index, r.Resolution
+		resolved++
+	}
+	for _, o := range res.Opened {
+		if strings.TrimSpace(o.Summary) == "" {
+			continue
+		}
+		l.Threads = append(l.Threads, PlotThread{ID: l.nextID(), Kind: threadKind(o.Kind), Summary: strings.TrimSpace(o.Summary), Opened: c.Index})
+		opened++
+	}
+	if err := persistThreads(g.PersistDir, l, g.provenance(StageThreads)); err != nil {
+		return err
+	}
+	if g.Log != nil {
+		g.Log(fmt.Sprintf("[伏笔] 第%d章 新增=%d 回收=%d", c.Index, opened, resolved))
+	}
+	return nil
+}
+
+// withThreads gives the canon the threads open when chapter starts
+func (g *Generator) withThreads(c Canon, chapter int) Canon {
+	if g.PersistDir == "" {
+		return c
+	}
+	l, err := LoadThreads(g.PersistDir)
+	if err != nil {
+		return c
+	}
+	c.Threads = l.OpenAt(chapter)
+	return c
+}
+
+// trackThreads runs the thread tracker after a chapter; like the state
+// tracker a failure is logged and does not fail the chapter
+func (g *Generator) trackThreads(ctx context.Context, spec Spec, c ChapterContent) {
+	if err := g.TrackThreads(ctx, spec, c); err != nil && g.Log != nil {
+		g.Log(fmt.Sprintf("[伏笔] 第%d章 提取失败：%s", c.Index, err.Error()))
+	}
+}
@@ -0,0 +1,102 @@
+package novel
+
+import (
+	"reflect"
+	"testing"
+)
+
+func threadIDs(ts []PlotThread) []string {
+	var ids []string
+	for _, t := range ts {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
+
+var testLedger = ThreadLedger{Threads: []PlotThread{
+	{ID: "T1", Opened: 1, Resolved: 4, Resolution: "揭晓"},
+	{ID: "T2", Opened: 2},
+	{ID: "T3", Opened: 3, Resolved: 3},
+	{ID: "T4", Opened: 5},
+}, Issued: 4}
+
+func TestThreadLedgerOpenAt(t *testing.T) {
+	cases := []struct {
+		chapter int
+		want    []string
+	}{
+		{1, nil},
+		{2, []string{"T1"}},
+		{4, []string{"T1", "T2"}},
+		{5, []string{"T2"}},
+		{6, []string{"T2", "T4"}},
+	}
+	for _, c := range cases {
+		if got := threadIDs(testLedger.OpenAt(c.chapter)); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("OpenAt(%d) = %v, want %v", c.chapter, got, c.want)
+		}
+	}
+}
+
+func TestThreadLedgerUntil(t *testing.T) {
+	cases := []struct {
+		chapter  int
+		want     []string
+		resolved []int
+	}{
+		{1, nil, nil},
+		{3, []string{"T1", "T2"}, []int{0, 0}},
+		{4, []string{"T1", "T2", "T3"}, []int{0, 0, 3}},
+		{5, []string{"T1", "T2", "T3"}, []int{4, 0, 3}},
+	}
+	for _, c := range cases {
+		got := testLedger.Until(c.chapter)
+		if ids := threadIDs(got.Threads); !reflect.DeepEqual(ids, c.want) {
+			t.Errorf("Until(%d) = %v, want %v", c.chapter, ids, c.want)
+			continue
+		}
+		var resolved []int
+		for _, th := range got.Threads {
+			resolved = append(resolved, th.Resolved)
+			if th.Resolved == 0 && th.Resolution != "" {
+				t.Errorf("Until(%d): %s keeps the resolution of a later chapter", c.chapter, th.ID)
+			}
+		}
+		if !reflect.DeepEqual(resolved, c.resolved) {
+			t.Errorf("Until(%d) resolved = %v, want %v", c.chapter, resolved, c.resolved)
+		}
+		if got.Issued != testLedger.Issued {
+			t.Errorf("Until(%d) issued = %d, want %d", c.chapter, got.Issued, testLedger.Issued)
+		}
+	}
+	if testLedger.Threads[0].Resolved != 4 {
+		t.Fatal("Until modified the ledger")
+	}
+}
+
+func TestThreadLedgerNextID(t *testing.T) {
+	cases := []struct {
+		name   string
+		ledger ThreadLedger
+		want   []string
+	}{
+		{"empty", ThreadLedger{}, []string{"T1", "T2"}},
+		{"legacy ledger continues after its highest id", ThreadLedger{Threads: []PlotThread{{ID: "T2"}, {ID: "T7"}, {ID: "x"}}}, []string{"T8"}},
+		{"forgotten ids are not reused", ThreadLedger{Threads: []PlotThread{{ID: "T1"}}, Issued: 5}, []string{"T6", "T7"}},
+	}
+	for _, c := range cases {
+		l := c.ledger
+		var got []string
+		for range c.want {
+			got = append(got, l.nextID())
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: ids = %v, want %v", c.name, got, c.want)
+		}
+	}
+	l := ThreadLedger{Threads: []PlotThread{{ID: "T1", Opened: 1}, {ID: "T2", Opened: 2}}, Issued: 2}
+	l.forget(2)
+	if id := l.nextID(); id != "T3" {
+		t.Fatalf("after forgetting T2 nextID = %s, want T3", id)
+	}
+}
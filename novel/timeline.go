@@ -0,0 +1,268 @@
+package novel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TimelineFile is the per-job log of dated story events
+const TimelineFile = "timeline.json"
+
+const StageTimeline = "timeline"
+
+// IssueTimeline is the CoherenceIssue type of chronology problems
+const IssueTimeline = "timeline"
+
+// Travel moves the characters of an event from one place to another
+type Travel struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to"`
+}
+
+// StoryEvent is one event of the in-story chronology. Delta is the time
+// since the previous event in days as stated by the prose ("三天后" is 3);
+// Day is resolved from the deltas and counts days since the first event
+type StoryEvent struct {
+	Chapter    int            `json:"chapter"`
+	When       string         `json:"when,omitempty"`
+	Delta      int            `json:"delta"`
+	Flashback  bool           `json:"flashback,omitempty"`
+	Day        int            `json:"day"`
+	Event      string         `json:"event"`
+	Location   string         `json:"location,omitempty"`
+	Characters []string       `json:"characters,omitempty"`
+	Travel     *Travel        `json:"travel,omitempty"`
+	Ages       map[string]int `json:"ages,omitempty"`
+}
+
+// Timeline is the content of timeline.json; events are kept in chapter
+// order and each chapter's events in the order they happen in the text
+type Timeline struct {
+	Events []StoryEvent `json:"events"`
+}
+
+var timelineMu sync.Mutex
+
+func LoadTimeline(dir string) (Timeline, error) {
+	var t Timeline
+	b, err := os.ReadFile(filepath.Join(dir, TimelineFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Timeline{Events: []StoryEvent{}}, nil
+		}
+		return t, err
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, err
+	}
+	if t.Events == nil {
+		t.Events = []StoryEvent{}
+	}
+	return t, nil
+}
+
+// SetChapter replaces the events of one chapter and resolves the days of
+// the whole timeline again
+func (t *Timeline) SetChapter(chapter int, events []StoryEvent) {
+	kept := make([]StoryEvent, 0, len(t.Events)+len(events))
+	for _, e := range t.Events {
+		if e.Chapter != chapter {
+			kept = append(kept, e)
+		}
+	}
+	for _, e := range events {
+		e.Chapter = chapter
+		kept = append(kept, e)
+	}
+	t.Events = kept
+	t.Resolve()
+}
+
+// Resolve orders the events by chapter and turns the relative deltas into
+// days. Flashbacks are placed Delta days from the current day without
+// moving the story clock
+func (t *Timeline) Resolve() {
+	sort.SliceStable(t.Events, func(a, b int) bool { return t.Events[a].Chapter < t.Events[b].Chapter })
+	clock := 0
+	for i := range t.Events {
+		e := &t.Events[i]
+		e.Day = clock + e.Delta
+		if !e.Flashback && e.Delta > 0 {
+			clock = e.Day
+		}
+	}
+}
+
+// Until keeps the events of the chapters before chapter, for forks
+func (t Timeline) Until(chapter int) Timeline {
+	out := Timeline{Events: []StoryEvent{}}
+	for _, e := range t.Events {
+		if e.Chapter < chapter {
+			out.Events = append(out.Events, e)
+		}
+	}
+	return out
+}
+
+// Last is the latest non-flashback event before chapter, if any
+func (t Timeline) Last(chapter int) (StoryEvent, bool) {
+	for i := len(t.Events) - 1; i >= 0; i-- {
+		if e := t.Events[i]; e.Chapter < chapter && !e.Flashback {
+			return e, true
+		}
+	}
+	return StoryEvent{}, false
+}
+
+func persistTimeline(dir string, t Timeline, p Provenance) error {
+	b, _ := json.MarshalIndent(t, "", "  ")
+	if err := os.WriteFile(filepath.Join(dir, TimelineFile), b, 0o644); err != nil {
+		return err
+	}
+	return RecordArtifact(dir, TimelineFile, p)
+}
+
+// daysPerYear is close enough for checking stated ages
+const daysPerYear = 365
+
+type ageClaim struct {
+	age, day, chapter int
+}
+
+// CheckTimeline flags chronology that cannot be right: time running
+// backwards outside a flashback, characters departing from a place they are
+// not at or travelling to where they already are, and ages that do not add
+// up with the days in between. Issues are worded in language lang
+func CheckTimeline(t Timeline, lang string) []CoherenceIssue {
+	txt := languageFor(lang).issues
+	var issues []CoherenceIssue
+	flag := func(chapter int, detail, hint string) {
+		issues = append(issues, CoherenceIssue{Chapter: chapter, Type: IssueTimeline, Detail: detail, FixHint: hint})
+	}
+	where := map[string]string{}
+	seenAt := map[string]int{}
+	ages := map[string]ageClaim{}
+	for _, e := range t.Events {
+		if e.Delta < 0 && !e.Flashback {
+			flag(e.Chapter, fmt.Sprintf(txt.backwards, e.Event, -e.Delta), txt.backwardsHint)
+		}
+		names := make([]string, 0, len(e.Ages))
+		for name := range e.Ages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			age := e.Ages[name]
+			prev, ok := ages[name]
+			if ok {
+				years := float64(e.Day-prev.day) / daysPerYear
+				diff := float64(age - prev.age)
+				if diff < years-1 || diff > years+1 {
+					flag(e.Chapter, fmt.Sprintf(txt.age, name, prev.chapter, prev.age, e.Day-prev.day, age), txt.ageHint)
+				}
+			}
+			ages[name] = ageClaim{age: age, day: e.Day, chapter: e.Chapter}
+		}
+		if e.Flashback {
+			continue
+		}
+		for _, name := range e.Characters {
+			cur, known := where[name]
+			if e.Travel != nil {
+				if known && e.Travel.From != "" && e.Travel.From != cur {
+					flag(e.Chapter, fmt.Sprintf(txt.departs, name, seenAt[name], cur, e.Travel.From), txt.departsHint)
+				}
+				if known && e.Travel.To != "" && e.Travel.To == cur {
+					flag(e.Chapter, fmt.Sprintf(txt.revisits, name, seenAt[name], cur, e.Travel.To), txt.revisitsHint)
+				}
+				if e.Travel.To != "" {
+					where[name], seenAt[name] = e.Travel.To, e.Chapter
+				}
+				continue
+			}
+			if e.Location != "" && cur != e.Location {
+				where[name], seenAt[name] = e.Location, e.Chapter
+			}
+		}
+	}
+	return issues
+}
+
+// TrackTimeline reads a written chapter and records its events in
+// timeline.json with the time that passes between them
+func (g *Generator) TrackTimeline(ctx context.Context, spec Spec, canon Canon, c ChapterContent) error {
+	if g.PersistDir == "" {
+		return nil
+	}
+	timelineMu.Lock()
+	t, err := LoadTimeline(g.PersistDir)
+	timelineMu.Unlock()
+	if err != nil {
+		return err
+	}
+	d := PromptData{Canon: canon, Chapter: c}
+	if last, ok := t.Last(c.Index); ok {
+		d.Event = &last
+	}
+	sys, user, err := g.prompt(spec, "track_timeline", d)
+	if err != nil {
+		return err
+	}
+	out, err := g.chatTimed(ctx, spec.Model, sys, user)
+	if err != nil {
+		return err
+	}
+	var events []StoryEvent
+	if err := json.Unmarshal([]byte(extractJSON(out)), &events); err != nil {
+		return err
+	}
+	kept := make([]StoryEvent, 0, len(events))
+	for _, e := range events {
+		if e.Event = strings.TrimSpace(e.Event); e.Event == "" {
+			continue
+		}
+		if e.Travel != nil && e.Travel.To == "" {
+			e.Travel = nil
+		}
+		kept = append(kept, e)
+	}
+
+	timelineMu.Lock()
+	defer timelineMu.Unlock()
+	// re-read: another chapter may have been tracked while the model was busy
+	if t, err = LoadTimeline(g.PersistDir); err != nil {
+		return err
+	}
+	t.SetChapter(c.Index, kept)
+	if err := persistTimeline(g.PersistDir, t, g.provenance(StageTimeline)); err != nil {
+		return err
+	}
+	if g.Log != nil {
+		g.Log(fmt.Sprintf("[时间线] 第%d章 事件=%d", c.Index, len(kept)))
+	}
+	return nil
+}
+
+func (g *Generator) trackTimeline(ctx context.Context, spec Spec, canon Canon, c ChapterContent) {
+	if err := g.TrackTimeline(ctx, spec, canon, c); err != nil && g.Log != nil {
+		g.Log(fmt.Sprintf("[时间线] 第%d章 提取失败：%s", c.Index, err.Error()))
+	}
+}
+
+// timelineIssues checks the persisted timeline of the job
+func (g *Generator) timelineIssues(lang string) []CoherenceIssue {
+	if g.PersistDir == "" {
+		return nil
+	}
+	t, err := LoadTimeline(g.PersistDir)
+	if err != nil {
+		return nil
+	}
+	return CheckTimeline(t, lang)
+}
@@ -0,0 +1,127 @@
+package novel
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTimelineResolve(t *testing.T) {
+	cases := []struct {
+		name   string
+		events []StoryEvent
+		days   []int
+	}{
+		{"deltas add up", []StoryEvent{{Chapter: 1}, {Chapter: 1, Delta: 3}, {Chapter: 2, Delta: 2}}, []int{0, 3, 5}},
+		{"ordered by chapter", []StoryEvent{{Chapter: 2, Delta: 2, Event: "b"}, {Chapter: 1, Delta: 1, Event: "a"}}, []int{1, 3}},
+		{"flashback keeps the clock", []StoryEvent{{Chapter: 1, Delta: 10}, {Chapter: 2, Delta: -5, Flashback: true}, {Chapter: 2, Delta: 1}}, []int{10, 5, 11}},
+		{"backwards step does not move the clock", []StoryEvent{{Chapter: 1, Delta: 10}, {Chapter: 2, Delta: -2}, {Chapter: 3, Delta: 1}}, []int{10, 8, 11}},
+	}
+	for _, c := range cases {
+		tl := Timeline{Events: c.events}
+		tl.Resolve()
+		var days []int
+		for _, e := range tl.Events {
+			days = append(days, e.Day)
+		}
+		if !reflect.DeepEqual(days, c.days) {
+			t.Errorf("%s: days = %v, want %v", c.name, days, c.days)
+		}
+	}
+}
+
+func TestTimelineSetChapter(t *testing.T) {
+	tl := Timeline{}
+	tl.SetChapter(1, []StoryEvent{{Delta: 0}, {Delta: 2}})
+	tl.SetChapter(2, []StoryEvent{{Delta: 1}})
+	tl.SetChapter(1, []StoryEvent{{Delta: 5}})
+	var got [][2]int
+	for _, e := range tl.Events {
+		got = append(got, [2]int{e.Chapter, e.Day})
+	}
+	if want := [][2]int{{1, 5}, {2, 6}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	if e, ok := tl.Last(2); !ok || e.Chapter != 1 {
+		t.Fatalf("Last(2) = %+v, %v", e, ok)
+	}
+}
+
+func TestCheckTimeline(t *testing.T) {
+	cases := []struct {
+		name     string
+		events   []StoryEvent
+		chapters []int
+	}{
+		{"consistent", []StoryEvent{
+			{Chapter: 1, Location: "村口", Characters: []string{"林"}, Ages: map[string]int{"林": 16}},
+			{Chapter: 2, Delta: 400, Travel: &Travel{From: "村口", To: "京城"}, Characters: []string{"林"}, Ages: map[string]int{"林": 17}},
+			{Chapter: 3, Delta: -30, Flashback: true, Location: "村口", Characters: []string{"林"}},
+			{Chapter: 3, Delta: 1, Travel: &Travel{To: "村口"}, Characters: []string{"林"}},
+		}, nil},
+		{"time runs backwards", []StoryEvent{
+			{Chapter: 1, Delta: 5},
+			{Chapter: 2, Delta: -3},
+		}, []int{2}},
+		{"departs from elsewhere", []StoryEvent{
+			{Chapter: 1, Location: "村口", Characters: []string{"林"}},
+			{Chapter: 4, Travel: &Travel{From: "京城", To: "江南"}, Characters: []string{"林"}},
+		}, []int{4}},
+		{"travels to where they are", []StoryEvent{
+			{Chapter: 1, Travel: &Travel{To: "京城"}, Characters: []string{"林", "苏"}},
+			{Chapter: 2, Travel: &Travel{To: "京城"}, Characters: []string{"林"}},
+		}, []int{2}},
+		{"ages do not add up", []StoryEvent{
+			{Chapter: 1, Ages: map[string]int{"林": 16, "苏": 20}},
+			{Chapter: 5, Delta: 30, Ages: map[string]int{"林": 19, "苏": 20}},
+		}, []int{5}},
+	}
+	for _, c := range cases {
+		tl := Timeline{Events: c.events}
+		tl.Resolve()
+		issues := CheckTimeline(tl, LangChinese)
+		var chapters []int
+		for _, is := range issues {
+			chapters = append(chapters, is.Chapter)
+			if is.Type != IssueTimeline || is.Detail == "" || is.FixHint == "" {
+				t.Errorf("%s: incomplete issue %+v", c.name, is)
+			}
+		}
+		if !reflect.DeepEqual(chapters, c.chapters) {
+			t.Errorf("%s: issues in chapters %v, want %v: %+v", c.name, chapters, c.chapters, issues)
+		}
+	}
+}
+
+func TestCheckTimelineLanguage(t *testing.T) {
+	tl := Timeline{Events: []StoryEvent{
+		{Chapter: 1, Location: "Rome", Characters: []string{"Ann"}, Ages: map[string]int{"Ann": 20}},
+		{Chapter: 2, Delta: 10, Travel: &Travel{From: "Paris", To: "Rome"}, Characters: []string{"Ann"}, Ages: map[string]int{"Ann": 25}},
+		{Chapter: 3, Delta: -2, Event: "the duel"},
+	}}
+	tl.Resolve()
+	cases := []struct {
+		lang string
+		want []string
+	}{
+		{LangEnglish, []string{"Ann is 20 in chapter 1 but 25 here, about 10 days later", "Ann has been in Rome since chapter 1 but sets out from Paris here", "Ann has been in Rome since chapter 1 and travels to Rome again", `"the duel" happens 2 days before`}},
+		{LangJapanese, []string{"Annは第1章で20歳だが", "Annは第1章からRomeにいるが、本章ではParisから出発している", "本章で再びRomeへ向かっている", "「the duel」は前の出来事の2日前"}},
+		{"en-GB", []string{"Ann is 20 in chapter 1"}},
+		{"", []string{"Ann第1章时20岁"}},
+	}
+	for _, c := range cases {
+		issues := CheckTimeline(tl, c.lang)
+		var all string
+		for _, is := range issues {
+			all += is.Detail + "\n" + is.FixHint + "\n"
+		}
+		for _, w := range c.want {
+			if !strings.Contains(all, w) {
+				t.Errorf("%q: issues do not contain %q:\n%s", c.lang, w, all)
+			}
+		}
+		if c.lang == LangEnglish && strings.ContainsAny(all, "章，天") {
+			t.Errorf("english issues contain chinese text:\n%s", all)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package novel
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/transform"
+)
+
+// TXTOptions controls the plain-text export expected by web-novel platforms;
+// the zero value gives 第一章 headings, full-width indents and punctuation in
+// UTF-8, or the conventions of the book's language (see ForLanguage)
+type TXTOptions struct {
+	From      int    // first chapter to include, 0 for the first one
+	To        int    // last chapter to include, 0 for the last one
+	Numbering string // "chinese" (default) or "arabic"
+	Indent    string // "fullwidth" (default, two U+3000, one for Japanese) or "none"
+	Punct     string // "fullwidth" (default) or "keep"
+	Encoding  string // "utf-8" (default) or "gb18030"
+}
+
+var txtNumbering = []string{"chinese", "arabic"}
+var txtIndents = []string{"fullwidth", "none"}
+var txtPuncts = []string{"fullwidth", "keep"}
+var txtEncodings = []string{"utf-8", "gb18030"}
+
+// txtDefaults are the conventions of books in each language: 第一章 and
+// two full-width spaces for Chinese, 第1章 and one for Japanese, and
+// "Chapter 1" without indent or full-width punctuation for English
+var txtDefaults = map[string]TXTOptions{
+	LangChinese:  {Numbering: "chinese", Indent: "fullwidth", Punct: "fullwidth"},
+	LangJapanese: {Numbering: "arabic", Indent: "fullwidth", Punct: "fullwidth"},
+	LangEnglish:  {Numbering: "arabic", Indent: "none", Punct: "keep"},
+}
+
+// ForLanguage fills the fields left empty with the conventions of books in
+// lang; Validate fills the rest
+func (o *TXTOptions) ForLanguage(lang string) {
+	code, err := NormalizeLanguage(lang)
+	if err != nil {
+		return
+	}
+	def := txtDefaults[code]
+	if strings.TrimSpace(o.Numbering) == "" {
+		o.Numbering = def.Numbering
+	}
+	if strings.TrimSpace(o.Indent) == "" {
+		o.Indent = def.Indent
+	}
+	if strings.TrimSpace(o.Punct) == "" {
+		o.Punct = def.Punct
+	}
+}
+
+// Validate normalises empty fields to their defaults and rejects unknown values
+func (o *TXTOptions) Validate() error {
+	pick := func(field *string, name string, allowed []string) error {
+		v := strings.ToLower(strings.TrimSpace(*field))
+		if v == "" {
+			v = allowed[0]
+		}
+		if v == "utf8" {
+			v = "utf-8"
+		}
+		for _, a := range allowed {
+			if v == a {
+				*field = v
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid %s %q, want one of %s", name, *field, strings.Join(allowed, ", "))
+	}
+	if err := pick(&o.Numbering, "numbering", txtNumbering); err != nil {
+		return err
+	}
+	if err := pick(&o.Indent, "indent", txtIndents); err != nil {
+		return err
+	}
+	if err := pick(&o.Punct, "punct", txtPuncts); err != nil {
+		return err
+	}
+	if err := pick(&o.Encoding, "encoding", txtEncodings); err != nil {
+		return err
+	}
+	if o.From < 0 || o.To < 0 || (o.To > 0 && o.From > o.To) {
+		return fmt.Errorf("invalid chapter range %d-%d", o.From, o.To)
+	}
+	return nil
+}
+
+// WriteTXT writes the book as a single text file: the title, then each
+// selected chapter as a 第N章 heading, or "Chapter N" for English books,
+// followed by indented paragraphs
+func WriteTXT(w io.Writer, b Book, opts TXTOptions) error {
+	opts.ForLanguage(b.Language)
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if opts.Encoding == "gb18030" {
+		tw := transform.NewWriter(w, simplifiedchinese.GB18030.NewEncoder())
+		if err := writeTXT(tw, b, opts); err != nil {
+			return err
+		}
+		return tw.Close()
+	}
+	return writeTXT(w, b, opts)
+}
+
+func writeTXT(w io.Writer, b Book, opts TXTOptions) error {
+	lang, _ := NormalizeLanguage(b.Language)
+	indent := ""
+	if opts.Indent == "fullwidth" {
+		indent = "　　"
+		if lang == LangJapanese {
+			indent = "　"
+		}
+	}
+	punct := fullWidth
+	if lang == LangJapanese {
+		punct = fullWidthJapanese
+	}
+	var s strings.Builder
+	s.WriteString(b.Outline.Title + "\n\n")
+	for _, c := range b.Range(opts.From, opts.To).Chapters {
+		title := stripChapterNumber(c.Title)
+		if title == "" && c.Index >= 1 && c.Index <= len(b.Outline.Chapters) {
+			title = stripChapterNumber(b.Outline.Chapters[c.Index-1].Title)
+		}
+		num := fmt.Sprint(c.Index)
+		if opts.Numbering == "chinese" {
+			num = ChineseNumeral(c.Index)
+		}
+		if lang == LangEnglish {
+			s.WriteString("Chapter " + num)
+		} else {
+			s.WriteString("第" + num + "章")
+		}
+		if title != "" {
+			s.WriteString(" " + title)
+		}
+		s.WriteString("\n\n")
+		for _, p := range Paragraphs(c.Content) {
+			if opts.Punct == "fullwidth" {
+				p = fullWidthPunct(p, punct)
+			}
+			s.WriteString(indent + p + "\n")
+		}
+		s.WriteString("\n")
+	}
+	_, err := io.WriteString(w, s.String())
+	return err
+}
+
+var chapterNumberRe = regexp.MustCompile(`^(第\s*[0-9零〇一二两三四五六七八九十百千万]+\s*章|(?i:chapter)\s+[0-9]+\b)[\s:：、.．-]*`)
+
+// stripChapterNumber drops a leading 第N章 or "Chapter N" the model may
+// have put in the title so the exporter can number chapters itself
+func stripChapterNumber(title string) string {
+	return strings.TrimSpace(chapterNumberRe.ReplaceAllString(strings.TrimSpace(title), ""))
+}
+
+var cnDigits = []string{"零", "一", "二", "三", "四", "五", "六", "七", "八", "九"}
+var cnUnits = []string{"", "十", "百", "千"}
+
+// ChineseNumeral spells n in Chinese numerals as used in chapter headings:
+// 10 is 十, 11 is 十一, 101 is 一百零一, 10000 is 一万
+func ChineseNumeral(n int) string {
+	if n <= 0 {
+		return cnDigits[0]
+	}
+	if n >= 100000000 {
+		return fmt.Sprint(n)
+	}
+	var s string
+	if n >= 10000 {
+		s = chineseBelow10000(n/10000, false) + "万"
+		n %= 10000
+		if n == 0 {
+			return s
+		}
+		if n < 1000 {
+			s += "零"
+		}
+	}
+	return s + chineseBelow10000(n, s != "")
+}
+
+// chineseBelow10000 spells 0 < n < 10000; a leading 一十 is shortened to 十
+// unless the number continues a larger one (一万零一十)
+func chineseBelow10000(n int, inner bool) string {
+	var s strings.Builder
+	zero := false
+	for i := 3; i >= 0; i-- {
+		p := 1
+		for k := 0; k < i; k++ {
+			p *= 10
+		}
+		d := n / p % 10
+		if d == 0 {
+			if s.Len() > 0 {
+				zero = true
+			}
+			continue
+		}
+		if zero {
+			s.WriteString("零")
+			zero = false
+		}
+		if !(d == 1 && i == 1 && s.Len() == 0 && !inner) {
+			s.WriteString(cnDigits[d])
+		}
+		s.WriteString(cnUnits[i])
+	}
+	return s.String()
+}
+
+var fullWidth = map[rune]rune{
+	',': '，', '.': '。', '?': '？', '!': '！', ':': '：', ';': '；', '(': '（', ')': '）',
+}
+
+// fullWidthJapanese differs from the Chinese forms in the comma
+var fullWidthJapanese = map[rune]rune{
+	',': '、', '.': '。', '?': '？', '!': '！', ':': '：', ';': '；', '(': '（', ')': '）',
+}
+
+// FullWidthPunct replaces ASCII punctuation next to CJK text with its
+// full-width form and leaves punctuation inside Latin text or numbers alone
+func FullWidthPunct(s string) string {
+	return fullWidthPunct(s, fullWidth)
+}
+
+func fullWidthPunct(s string, table map[rune]rune) string {
+	rs := []rune(s)
+	out := make([]rune, 0, len(rs))
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		fw, ok := table[r]
+		if !ok {
+			out = append(out, r)
+			continue
+		}
+		if r == '.' && i+2 < len(rs) && rs[i+1] == '.' && rs[i+2] == '.' {
+			if cjkNeighbour(rs, i, i+2) {
+				out = append(out, '…', '…')
+				i += 2
+				continue
+			}
+			out = append(out, rs[i:i+3]...)
+			i += 2
+			continue
+		}
+		if cjkNeighbour(rs, i, i) {
+			out = append(out, fw)
+			// full-width punctuation carries its own spacing
+			for i+1 < len(rs) && rs[i+1] == ' ' {
+				i++
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+// cjkNeighbour reports whether the nearest non-space rune before start or
+// after end is CJK text or already full-width punctuation
+func cjkNeighbour(rs []rune, start, end int) bool {
+	near := func(r rune) bool {
+		return isCJK(r) || (r >= 0x3000 && r <= 0x303f) || (r >= 0xff00 && r <= 0xffef)
+	}
+	for j := start - 1; j >= 0; j-- {
+		if rs[j] != ' ' {
+			if near(rs[j]) {
+				return true
+			}
+			break
+		}
+	}
+	for j := end + 1; j < len(rs); j++ {
+		if rs[j] != ' ' {
+			return near(rs[j])
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package novel
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func TestChineseNumeral(t *testing.T) {
+	cases := []struct {
+		n    int
+		want string
+	}{
+		{0, "零"},
+		{1, "一"},
+		{10, "十"},
+		{11, "十一"},
+		{20, "二十"},
+		{99, "九十九"},
+		{100, "一百"},
+		{101, "一百零一"},
+		{110, "一百一十"},
+		{1001, "一千零一"},
+		{1010, "一千零一十"},
+		{10000, "一万"},
+		{10010, "一万零一十"},
+		{10100, "一万零一百"},
+		{12345, "一万二千三百四十五"},
+		{100000000, "100000000"},
+	}
+	for _, c := range cases {
+		if got := ChineseNumeral(c.n); got != c.want {
+			t.Errorf("ChineseNumeral(%d) = %s, want %s", c.n, got, c.want)
+		}
+	}
+}
+
+func TestFullWidthPunct(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"他说,走吧.", "他说，走吧。"},
+		{"真的? 好!", "真的？好！"},
+		{"等等...", "等等……"},
+		{"version 1.2, ok", "version 1.2, ok"},
+		{"共3.5公里", "共3.5公里"},
+		{"Hello, 世界", "Hello，世界"},
+	}
+	for _, c := range cases {
+		if got := FullWidthPunct(c.in); got != c.want {
+			t.Errorf("FullWidthPunct(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestWriteTXT(t *testing.T) {
+	b := Book{
+		Outline: Outline{Title: "书", Chapters: []Chapter{{Index: 1, Title: "开端"}, {Index: 2, Title: "第二章 转折"}, {Index: 3, Title: "尾声"}}},
+		Chapters: []ChapterContent{
+			{Index: 1, Title: "开端", Content: "他来了,天黑了.\n\n她走了."},
+			{Index: 2, Title: "第二章 转折", Content: "风起."},
+			{Index: 3, Title: "尾声", Content: "完."},
+		},
+	}
+	cases := []struct {
+		name string
+		lang string
+		opts TXTOptions
+		want string
+	}{
+		{"defaults", "", TXTOptions{}, "书\n\n第一章 开端\n\n　　他来了，天黑了。\n　　她走了。\n\n第二章 转折\n\n　　风起。\n\n第三章 尾声\n\n　　完。\n\n"},
+		{"range arabic plain", "zh", TXTOptions{From: 2, To: 2, Numbering: "arabic", Indent: "none", Punct: "keep"}, "书\n\n第2章 转折\n\n风起.\n\n"},
+		{"japanese", "ja", TXTOptions{To: 1}, "書\n\n第1章 開端\n\n　彼は来た、夜だ。\n\n"},
+		{"english", "en", TXTOptions{From: 3}, "Book\n\nChapter 3 End\n\nDone.\n\n"},
+	}
+	for _, c := range cases {
+		book := b
+		book.Language = c.lang
+		switch c.lang {
+		case "ja":
+			book.Outline.Title = "書"
+			book.Chapters = []ChapterContent{{Index: 1, Title: "開端", Content: "彼は来た,夜だ."}}
+		case "en":
+			book.Outline.Title = "Book"
+			book.Chapters = []ChapterContent{{Index: 3, Title: "Chapter 3: End", Content: "Done."}}
+		}
+		var out bytes.Buffer
+		if err := WriteTXT(&out, book, c.opts); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if out.String() != c.want {
+			t.Errorf("%s:\n got %q\nwant %q", c.name, out.String(), c.want)
+		}
+	}
+}
+
+func TestWriteTXTGB18030(t *testing.T) {
+	b := Book{Outline: Outline{Title: "书名"}, Chapters: []ChapterContent{{Index: 1, Title: "开端", Content: "正文𠀀。"}}}
+	var utf8, gb bytes.Buffer
+	if err := WriteTXT(&utf8, b, TXTOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteTXT(&gb, b, TXTOptions{Encoding: "GB18030"}); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(gb.Bytes(), utf8.Bytes()) {
+		t.Fatal("gb18030 output is not re-encoded")
+	}
+	decoded, err := simplifiedchinese.GB18030.NewDecoder().Bytes(gb.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != utf8.String() {
+		t.Fatalf("gb18030 round trip = %q, want %q", decoded, utf8.String())
+	}
+}
+
+func TestTXTOptionsValidate(t *testing.T) {
+	cases := []struct {
+		opts TXTOptions
+		ok   bool
+	}{
+		{TXTOptions{}, true},
+		{TXTOptions{Encoding: "UTF8"}, true},
+		{TXTOptions{Numbering: "roman"}, false},
+		{TXTOptions{Indent: "tab"}, false},
+		{TXTOptions{Encoding: "big5"}, false},
+		{TXTOptions{From: 3, To: 2}, false},
+		{TXTOptions{From: -1}, false},
+	}
+	for _, c := range cases {
+		o := c.opts
+		err := o.Validate()
+		if (err == nil) != c.ok {
+			t.Errorf("Validate(%+v) = %v, want ok=%v", c.opts, err, c.ok)
+		}
+		if err == nil && (o.Numbering == "" || o.Indent == "" || o.Punct == "" || !strings.Contains("utf-8 gb18030", o.Encoding)) {
+			t.Errorf("Validate(%+v) left defaults unset: %+v", c.opts, o)
+		}
+	}
+}
@@ -6,28 +6,57 @@ import (
 )
 
 type Spec struct {
-    Topic       string
-    Language    string
-    Model       string
-    Chapters    int
-    Words       int
-    Preset      string
-    Instruction string
-    System      string
-    Gender      string
-    Categories  []string
-    Tags        []string
+    Topic       string   `json:"topic"`
+    Language    string   `json:"language"`
+    Model       string   `json:"model"`
+    Chapters    int      `json:"chapters"`
+    Words       int      `json:"words"`
+    Preset      string   `json:"preset"`
+    Instruction string   `json:"instruction"`
+    System      string   `json:"system"`
+    Gender      string   `json:"gender"`
+    Categories  []string `json:"categories"`
+    Tags        []string `json:"tags"`
+    Gates       []string `json:"gates,omitempty"`
+    Project     string   `json:"project,omitempty"`
+    Volumes     int      `json:"volumes,omitempty"`
+    Scenes      bool     `json:"scenes,omitempty"`
+    // Temperature is the sampling temperature; nil is the server default
+    Temperature *float64 `json:"temperature,omitempty"`
 }
 
 type Outline struct {
 	Title    string    `json:"title"`
+	Goal     string    `json:"goal,omitempty"`
+	Climax   string    `json:"climax,omitempty"`
+	Volumes  []Volume  `json:"volumes,omitempty"`
 	Chapters []Chapter `json:"chapters"`
 }
 
+// Volume is one arc (卷) of a long serial; its chapters are planned on
+// demand and occupy indexes Start..End
+type Volume struct {
+	Index    int    `json:"index"`
+	Title    string `json:"title"`
+	Summary  string `json:"summary"`
+	Goal     string `json:"goal"`
+	Climax   string `json:"climax"`
+	Chapters int    `json:"chapters"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+	Planned  bool   `json:"planned"`
+}
+
 type Chapter struct {
-	Index   int    `json:"index"`
-	Title   string `json:"title"`
-	Summary string `json:"summary"`
+	Index   int     `json:"index"`
+	Title   string  `json:"title"`
+	Summary string  `json:"summary"`
+	Volume  int     `json:"volume,omitempty"`
+	Goal    string  `json:"goal,omitempty"`
+	Climax  string  `json:"climax,omitempty"`
+	Scenes  []Scene `json:"scenes,omitempty"`
+	// Payoffs are the ids of the plot threads the chapter pays off
+	Payoffs []string `json:"payoffs,omitempty"`
 }
 
 type Character struct {
@@ -35,6 +64,7 @@ type Character struct {
 	Role       string     `json:"role"`
 	Traits     StringList `json:"traits"`
 	Background string     `json:"background"`
+	State      string     `json:"state,omitempty"`
 }
 
 type ChapterContent struct {
@@ -43,31 +73,6 @@ type ChapterContent struct {
 	Content string
 }
 
-type Settings struct {
-	Protagonist struct {
-		Personality string `json:"personality"`
-		Background  string `json:"background"`
-		Goal        string `json:"goal"`
-	} `json:"protagonist"`
-	GoldenFinger struct {
-		Name       string `json:"name"`
-		Activation string `json:"activation"`
-		Initial    string `json:"initial"`
-		Upgrade    string `json:"upgrade"`
-		Limit      string `json:"limit"`
-	} `json:"golden_finger"`
-	WorldFusion struct {
-		Relations     string `json:"relations"`
-		StartLocation string `json:"start_location"`
-		InitialCrisis string `json:"initial_crisis"`
-	} `json:"world_fusion"`
-	Realms struct {
-		Current      string            `json:"current"`
-		Next         []string          `json:"next"`
-		Breakthrough map[string]string `json:"breakthrough"`
-	} `json:"realms"`
-}
-
 type StringList []string
 
 func (s *StringList) UnmarshalJSON(b []byte) error {
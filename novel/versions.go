@@ -0,0 +1,454 @@
+package novel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// chapter version stages
+const (
+	VersionDraft  = "draft"
+	VersionRevise = "revise"
+	VersionManual = "manual"
+)
+
+// VersionsDir holds the history of every chapter, one sub directory per index
+const VersionsDir = "versions"
+
+// DeletedDir, under VersionsDir, keeps the file and history of chapters
+// deleted from the plan, one sub directory per deletion
+const DeletedDir = "deleted"
+
+const historyFile = "history.json"
+
+// ChapterVersion describes one stored write of a chapter
+type ChapterVersion struct {
+	Version     int       `json:"version"`
+	Stage       string    `json:"stage"`
+	Title       string    `json:"title"`
+	Instruction string    `json:"instruction,omitempty"`
+	Model       string    `json:"model,omitempty"`
+	SHA256      string    `json:"sha256"`
+	Words       int       `json:"words"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ChapterHistory lists the versions of a chapter and which one is current
+type ChapterHistory struct {
+	Chapter  int              `json:"chapter"`
+	Current  int              `json:"current"`
+	Versions []ChapterVersion `json:"versions"`
+}
+
+var historyLocks sync.Map
+
+func historyLock(dir string) *sync.Mutex {
+	mu, _ := historyLocks.LoadOrStore(filepath.Clean(dir), &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+// chapterHistoryDir is the history dir of chapter index; a dir left under
+// the legacy two-digit name is moved to the current one
+func chapterHistoryDir(dir string, index int) string {
+	p := filepath.Join(dir, VersionsDir, chapterPrefix(index))
+	if legacy := filepath.Join(dir, VersionsDir, legacyChapterPrefix(index)); legacy != p && !fileExists(p) && fileExists(legacy) {
+		_ = os.Rename(legacy, p)
+	}
+	return p
+}
+
+func chapterMarkdown(title, content string) []byte {
+	return []byte("# " + title + "\n\n" + content)
+}
+
+// ReadChapterHistory loads the version list of chapter index; chapters
+// written before versioning have an empty history
+func ReadChapterHistory(dir string, index int) (ChapterHistory, error) {
+	h := ChapterHistory{Chapter: index}
+	b, err := os.ReadFile(filepath.Join(chapterHistoryDir(dir, index), historyFile))
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return h, err
+	}
+	if err := json.Unmarshal(b, &h); err != nil {
+		return h, err
+	}
+	h.Chapter = index
+	return h, nil
+}
+
+// ReadChapterVersion returns the stored text of one version
+func ReadChapterVersion(dir string, index, version int) (ChapterContent, ChapterVersion, error) {
+	h, err := ReadChapterHistory(dir, index)
+	if err != nil {
+		return ChapterContent{}, ChapterVersion{}, err
+	}
+	for _, v := range h.Versions {
+		if v.Version != version {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(chapterHistoryDir(dir, index), versionFile(version)))
+		if err != nil {
+			return ChapterContent{}, ChapterVersion{}, err
+		}
+		title, body := ParseChapterFile(b)
+		return ChapterContent{Index: index, Title: title, Content: body}, v, nil
+	}
+	return ChapterContent{}, ChapterVersion{}, os.ErrNotExist
+}
+
+func versionFile(version int) string {
+	return "v" + strconv.Itoa(version) + ".md"
+}
+
+// SaveChapterVersion stores c as the newest version of its chapter and makes
+// it current; writing the same text as the current version is a no-op
+func SaveChapterVersion(dir string, c ChapterContent, stage string, p Provenance) (ChapterVersion, error) {
+	mu := historyLock(dir)
+	mu.Lock()
+	defer mu.Unlock()
+	h, err := ReadChapterHistory(dir, c.Index)
+	if err != nil {
+		return ChapterVersion{}, err
+	}
+	body := chapterMarkdown(c.Title, c.Content)
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	for _, v := range h.Versions {
+		if v.Version == h.Current && v.SHA256 == hash {
+			return v, nil
+		}
+	}
+	next := 1
+	for _, v := range h.Versions {
+		if v.Version >= next {
+			next = v.Version + 1
+		}
+	}
+	v := ChapterVersion{
+		Version:     next,
+		Stage:       stage,
+		Title:       c.Title,
+		Instruction: p.Instruction,
+		Model:       p.Model,
+		SHA256:      hash,
+		Words:       CountWords(PlainText(c.Content)),
+		CreatedAt:   time.Now().UTC(),
+	}
+	hdir := chapterHistoryDir(dir, c.Index)
+	if err := os.MkdirAll(hdir, 0o755); err != nil {
+		return ChapterVersion{}, err
+	}
+	if err := writeAtomic(filepath.Join(hdir, versionFile(next)), body); err != nil {
+		return ChapterVersion{}, err
+	}
+	h.Versions = append(h.Versions, v)
+	h.Current = next
+	return v, writeHistory(dir, h)
+}
+
+func writeHistory(dir string, h ChapterHistory) error {
+	sort.Slice(h.Versions, func(i, j int) bool { return h.Versions[i].Version < h.Versions[j].Version })
+	b, _ := json.MarshalIndent(h, "", "  ")
+	return writeAtomic(filepath.Join(chapterHistoryDir(dir, h.Chapter), historyFile), b)
+}
+
+// RollbackChapter makes an earlier version current again and rewrites the
+// chapter file from it
+func RollbackChapter(dir string, index, version int) (ChapterVersion, error) {
+	c, v, err := ReadChapterVersion(dir, index, version)
+	if err != nil {
+		return ChapterVersion{}, err
+	}
+	mu := historyLock(dir)
+	mu.Lock()
+	defer mu.Unlock()
+	h, err := ReadChapterHistory(dir, index)
+	if err != nil {
+		return ChapterVersion{}, err
+	}
+	if err := writeChapterFile(dir, c, Provenance{Stage: StageChapter, Origin: OriginEdited, Model: v.Model}); err != nil {
+		return ChapterVersion{}, err
+	}
+	h.Current = version
+	return v, writeHistory(dir, h)
+}
+
+// WriteChapter replaces the current text of a chapter in the job dir,
+// records it in the manifest and stores it as a new version
+func WriteChapter(dir string, c ChapterContent, stage string, p Provenance) (ChapterVersion, error) {
+	if err := seedChapterHistory(dir, c.Index); err != nil {
+		return ChapterVersion{}, err
+	}
+	if err := writeChapterFile(dir, c, p); err != nil {
+		return ChapterVersion{}, err
+	}
+	return SaveChapterVersion(dir, c, stage, p)
+}
+
+// seedChapterHistory keeps a chapter written before versioning existed as
+// its first draft so the first tracked write does not lose it
+func seedChapterHistory(dir string, index int) error {
+	h, err := ReadChapterHistory(dir, index)
+	if err != nil || len(h.Versions) > 0 {
+		return err
+	}
+	path, ok := FindChapterFile(filepath.Join(dir, "chapters"), index)
+	if !ok {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	title, body := ParseChapterFile(b)
+	_, err = SaveChapterVersion(dir, ChapterContent{Index: index, Title: title, Content: body}, VersionDraft, Provenance{})
+	return err
+}
+
+func writeChapterFile(dir string, c ChapterContent, p Provenance) error {
+	chapDir := filepath.Join(dir, "chapters")
+	if err := os.MkdirAll(chapDir, 0o755); err != nil {
+		return err
+	}
+	fname, stale, err := ReplaceChapterFile(chapDir, c)
+	if err != nil {
+		return err
+	}
+	if stale != "" {
+		_ = ForgetArtifact(dir, "chapters/"+stale)
+	}
+	return RecordArtifact(dir, "chapters/"+fname, p)
+}
+
+// ReplaceChapterFile writes c into dir and removes a file left for the same
+// index under an older title; it returns the new and the removed file name
+func ReplaceChapterFile(dir string, c ChapterContent) (string, string, error) {
+	fname := ChapterFileName(c.Index, c.Title)
+	stale := ""
+	if old, ok := ChapterFiles(dir)[c.Index]; ok && old != fname {
+		if err := os.Remove(filepath.Join(dir, old)); err != nil && !os.IsNotExist(err) {
+			return "", "", err
+		}
+		stale = old
+	}
+	if err := os.WriteFile(filepath.Join(dir, fname), chapterMarkdown(c.Title, c.Content), 0o644); err != nil {
+		return "", "", err
+	}
+	return fname, stale, nil
+}
+
+// RenumberChapterHistory moves version histories by old->new chapter index
+// and retires those of chapters 1..count missing from moves; every move is
+// staged in r
+func RenumberChapterHistory(dir string, moves map[int]int, count int, r *Renames) error {
+	root := filepath.Join(dir, VersionsDir)
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	type pending struct {
+		tmp string
+		to  int
+	}
+	var staged []pending
+	for _, e := range entries {
+		idx, err := strconv.Atoi(e.Name())
+		if !e.IsDir() || err != nil || idx <= 0 || idx > count {
+			continue
+		}
+		to, ok := moves[idx]
+		if !ok {
+			if err := r.Retire(dir, idx, filepath.Join(root, e.Name())); err != nil {
+				return err
+			}
+			continue
+		}
+		if to == idx {
+			continue
+		}
+		tmp := filepath.Join(root, fmt.Sprintf(".renumber-%d", idx))
+		if err := r.Rename(filepath.Join(root, e.Name()), tmp); err != nil {
+			return err
+		}
+		staged = append(staged, pending{tmp: tmp, to: to})
+	}
+	for _, p := range staged {
+		final := chapterHistoryDir(dir, p.to)
+		if err := r.Rename(p.tmp, final); err != nil {
+			return err
+		}
+		h, err := ReadChapterHistory(dir, p.to)
+		if err != nil {
+			return err
+		}
+		if err := writeHistory(dir, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeletedChapterDir is where chapter index of the job dir is kept when it
+// is deleted at the given time
+func DeletedChapterDir(dir string, index int, at time.Time) string {
+	return filepath.Join(dir, VersionsDir, DeletedDir, at.Format("20060102-150405.000000")+"-"+chapterPrefix(index))
+}
+
+// Renames stages the file moves of one edit so that a failed step can put
+// every file back. Files replaced along the way are set aside until Commit
+type Renames struct {
+	at    time.Time
+	steps []renameStep
+}
+
+// renameStep moved from to to; from is empty when to was created
+type renameStep struct {
+	from, to string
+	discard  bool
+}
+
+func NewRenames() *Renames {
+	return &Renames{at: time.Now()}
+}
+
+func (r *Renames) Rename(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	r.steps = append(r.steps, renameStep{from: from, to: to})
+	return nil
+}
+
+// Write creates path, which must not exist; Undo removes it again
+func (r *Renames) Write(path string, b []byte) error {
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	r.steps = append(r.steps, renameStep{to: path})
+	return nil
+}
+
+// Discard sets path aside; Commit removes it and Undo puts it back
+func (r *Renames) Discard(path string) error {
+	tmp := filepath.Join(filepath.Dir(path), ".discard-"+filepath.Base(path))
+	if err := os.Rename(path, tmp); err != nil {
+		return err
+	}
+	r.steps = append(r.steps, renameStep{from: path, to: tmp, discard: true})
+	return nil
+}
+
+// Retire keeps path, the file or history dir of deleted chapter index of
+// the job dir, under DeletedChapterDir
+func (r *Renames) Retire(dir string, index int, path string) error {
+	dst := DeletedChapterDir(dir, index, r.at)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() && !fileExists(dst) {
+		return r.Rename(path, dst)
+	}
+	if !fileExists(dst) {
+		if err := os.Mkdir(dst, 0o755); err != nil {
+			return err
+		}
+		r.steps = append(r.steps, renameStep{to: dst})
+	}
+	return r.Rename(path, filepath.Join(dst, filepath.Base(path)))
+}
+
+// ReplaceChapterFile is ReplaceChapterFile with the old file set aside
+func (r *Renames) ReplaceChapterFile(dir string, c ChapterContent) (string, string, error) {
+	fname := ChapterFileName(c.Index, c.Title)
+	stale := ""
+	if old, ok := ChapterFiles(dir)[c.Index]; ok {
+		if err := r.Discard(filepath.Join(dir, old)); err != nil {
+			return "", "", err
+		}
+		if old != fname {
+			stale = old
+		}
+	}
+	if err := r.Write(filepath.Join(dir, fname), chapterMarkdown(c.Title, c.Content)); err != nil {
+		return "", "", err
+	}
+	return fname, stale, nil
+}
+
+// Undo reverts the staged steps, newest first
+func (r *Renames) Undo() error {
+	var first error
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		st := r.steps[i]
+		var err error
+		if st.from == "" {
+			err = os.Remove(st.to)
+		} else {
+			err = os.Rename(st.to, st.from)
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	r.steps = nil
+	return first
+}
+
+// Commit keeps the staged steps and removes the discarded files
+func (r *Renames) Commit() {
+	for _, st := range r.steps {
+		if st.discard {
+			_ = os.RemoveAll(st.to)
+		}
+	}
+	r.steps = nil
+}
+
+// CopyChapterHistory copies the version history of chapter index from the
+// job dir src to dst
+func CopyChapterHistory(src, dst string, index int) error {
+	from := chapterHistoryDir(src, index)
+	entries, err := os.ReadDir(from)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	to := chapterHistoryDir(dst, index)
+	if err := os.MkdirAll(to, 0o755); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(from, e.Name()))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(to, e.Name()), b, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package novel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// MaxFlatChapters is the longest book whose outline is planned in a single
+// call; longer books are split into volumes planned one at a time
+const MaxFlatChapters = 60
+
+// volumeChapters is the default volume length when only a chapter count is given
+const volumeChapters = 40
+
+// volumeCount is the number of volumes spec asks for, 0 for a flat outline
+func (s Spec) volumeCount() int {
+	n := s.Volumes
+	if n <= 0 && s.Chapters > MaxFlatChapters {
+		n = (s.Chapters + volumeChapters - 1) / volumeChapters
+	}
+	if s.Chapters > 0 && n > s.Chapters {
+		n = s.Chapters
+	}
+	return n
+}
+
+// SyncVolumes recomputes the chapter ranges of o's volumes: a planned volume
+// is as long as its chapters in o.Chapters, the others keep their planned
+// length. Chapters without a volume join the one before them
+func SyncVolumes(o *Outline) {
+	if len(o.Volumes) == 0 {
+		return
+	}
+	counts := map[int]int{}
+	vol := 1
+	for i := range o.Chapters {
+		if o.Chapters[i].Volume > 0 {
+			vol = o.Chapters[i].Volume
+		}
+		o.Chapters[i].Volume = vol
+		counts[vol]++
+	}
+	next := 1
+	for i := range o.Volumes {
+		v := &o.Volumes[i]
+		v.Index = i + 1
+		n, planned := counts[v.Index]
+		v.Planned = planned
+		if planned {
+			v.Chapters = n
+		}
+		if v.Chapters < 1 {
+			v.Chapters = 1
+		}
+		v.Start, v.End = next, next+v.Chapters-1
+		next = v.End + 1
+	}
+}
+
+// TotalChapters is the planned length of the book, including volumes whose
+// chapters are not planned yet
+func (o Outline) TotalChapters() int {
+	if n := len(o.Volumes); n > 0 {
+		return o.Volumes[n-1].End
+	}
+	return len(o.Chapters)
+}
+
+// VolumeOf returns the volume chapter index belongs to
+func (o Outline) VolumeOf(index int) (Volume, bool) {
+	for _, v := range o.Volumes {
+		if index >= v.Start && index <= v.End {
+			return v, true
+		}
+	}
+	return Volume{}, false
+}
+
+// generateVolumeOutline plans a long book level by level: the volume
+// skeleton with goals and climaxes first, then the chapters of volume 1
+func (g *Generator) generateVolumeOutline(ctx context.Context, spec Spec, volumes int) (Outline, error) {
+	d := g.seriesData(spec)
+	d.Count = volumes
+	sys, user, err := g.prompt(spec, "volume_outline", d)
+	if err != nil {
+		return Outline{}, err
+	}
+	out, err := g.chatTimed(ctx, spec.Model, sys, user)
+	if err != nil {
+		return Outline{}, err
+	}
+	var outline Outline
+	if err := json.Unmarshal([]byte(extractJSON(out)), &outline); err != nil {
+		return Outline{}, err
+	}
+	if len(outline.Volumes) == 0 {
+		return Outline{}, fmt.Errorf("volume outline has no volumes")
+	}
+	fitVolumes(outline.Volumes, spec.Chapters)
+	outline.Chapters = nil
+	SyncVolumes(&outline)
+	if g.Log != nil {
+		for _, v := range outline.Volumes {
+			g.Log(fmt.Sprintf("[分卷] 第%d卷 %s 第%d-%d章 | 目标：%s | 高潮：%s", v.Index, v.Title, v.Start, v.End, v.Goal, v.Climax))
+		}
+	}
+	chapters, err := g.generateVolumeChapters(ctx, spec, outline, 1)
+	if err != nil {
+		return Outline{}, err
+	}
+	outline.Chapters = chapters
+	SyncVolumes(&outline)
+	return outline, nil
+}
+
+// fitVolumes spreads total chapters evenly when the model's volume lengths
+// do not add up
+func fitVolumes(vols []Volume, total int) {
+	sum := 0
+	for _, v := range vols {
+		if v.Chapters <= 0 {
+			sum = -1
+			break
+		}
+		sum += v.Chapters
+	}
+	if total <= 0 || sum == total {
+		return
+	}
+	for i := range vols {
+		vols[i].Chapters = total / len(vols)
+		if i < total%len(vols) {
+			vols[i].Chapters++
+		}
+	}
+}
+
+// generateVolumeChapters plans the chapters of one volume; the chapter
+// summaries closing the previous volume keep the plot continuous
+func (g *Generator) generateVolumeChapters(ctx context.Context, spec Spec, outline Outline, volume int) ([]Chapter, error) {
+	vol := outline.Volumes[volume-1]
+	var prev []Chapter
+	for _, ch := range outline.Chapters {
+		if ch.Volume == volume-1 {
+			prev = append(prev, ch)
+		}
+	}
+	if len(prev) > 5 {
+		prev = prev[len(prev)-5:]
+	}
+	d := g.seriesData(spec)
+	d.Outline, d.Volume, d.Recent = outline, vol, prev
+	sys, user, err := g.prompt(spec, "volume_chapters", d)
+	if err != nil {
+		return nil, err
+	}
+	out, err := g.chatTimed(ctx, spec.Model, sys, user)
+	if err != nil {
+		return nil, err
+	}
+	var chapters []Chapter
+	if err := json.Unmarshal([]byte(extractJSON(out)), &chapters); err != nil {
+		return nil, err
+	}
+	if len(chapters) == 0 {
+		return nil, fmt.Errorf("volume %d has no chapters", volume)
+	}
+	if len(chapters) > vol.Chapters {
+		chapters = chapters[:vol.Chapters]
+	}
+	for i := range chapters {
+		chapters[i].Index = vol.Start + i
+		chapters[i].Volume = volume
+	}
+	return chapters, nil
+}
+
+// ExpandVolume plans the chapters and chapter plans of one volume and
+// replaces whatever was planned for it before. Volumes are planned in
+// order, so volume-1 must be planned and no later volume may be
+func (g *Generator) ExpandVolume(ctx context.Context, spec Spec, outline Outline, plans []Chapter, volume int) (Outline, []Chapter, error) {
+	SyncVolumes(&outline)
+	if volume < 1 || volume > len(outline.Volumes) {
+		return Outline{}, nil, fmt.Errorf("volume %d out of range", volume)
+	}
+	if volume > 1 && !outline.Volumes[volume-2].Planned {
+		return Outline{}, nil, fmt.Errorf("volume %d is not planned yet", volume-1)
+	}
+	if volume < len(outline.Volumes) && outline.Volumes[volume].Planned {
+		return Outline{}, nil, fmt.Errorf("volume %d is already planned", volume+1)
+	}
+	start := outline.Volumes[volume-1].Start
+	chapters, err := g.generateVolumeChapters(ctx, spec, outline, volume)
+	if err != nil {
+		return Outline{}, nil, err
+	}
+	kept := make([]Chapter, 0, len(outline.Chapters)+len(chapters))
+	for _, ch := range outline.Chapters {
+		if ch.Index < start {
+			kept = append(kept, ch)
+		}
+	}
+	outline.Chapters = append(kept, chapters...)
+	SyncVolumes(&outline)
+	op := g.provenance(StageOutline)
+
+	volPlans, err := g.generateChapterPlans(ctx, spec, Outline{Title: outline.Title, Volumes: outline.Volumes, Chapters: chapters})
+	if err != nil {
+		return Outline{}, nil, err
+	}
+	next := make([]Chapter, 0, len(plans)+len(volPlans))
+	for _, p := range plans {
+		if p.Index < start {
+			next = append(next, p)
+		}
+	}
+	next = append(next, volPlans...)
+	if g.PersistDir != "" {
+		if err := persistOutline(g.PersistDir, outline, op); err != nil {
+			return Outline{}, nil, err
+		}
+		if err := persistPlans(g.PersistDir, next, g.provenance(StagePlans)); err != nil {
+			return Outline{}, nil, err
+		}
+	}
+	if g.Log != nil {
+		v := outline.Volumes[volume-1]
+		g.Log(fmt.Sprintf("[分卷规划] 第%d卷 %s 第%d-%d章", v.Index, v.Title, v.Start, v.End))
+	}
+	return outline, next, nil
+}
+
+// alignPlans gives plans the index, volume, goal and climax of the outline
+// chapters they expand. Whole-book outlines are numbered from 1; a slice of
+// the book (a volume, an extension) keeps its chapter indexes
+func alignPlans(plans []Chapter, outline Outline) {
+	slice := len(outline.Volumes) > 0 || (len(outline.Chapters) > 0 && outline.Chapters[0].Index > 1)
+	for i := range plans {
+		plans[i].Index = i + 1
+		if !slice || i >= len(outline.Chapters) {
+			continue
+		}
+		ch := outline.Chapters[i]
+		plans[i].Index, plans[i].Volume = ch.Index, ch.Volume
+		if plans[i].Goal == "" {
+			plans[i].Goal = ch.Goal
+		}
+		if plans[i].Climax == "" {
+			plans[i].Climax = ch.Climax
+		}
+		if len(plans[i].Payoffs) == 0 {
+			plans[i].Payoffs = ch.Payoffs
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package novel
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSyncVolumes(t *testing.T) {
+	type span struct{ start, end int }
+	cases := []struct {
+		name    string
+		vols    []int
+		chaps   []int
+		spans   []span
+		planned []bool
+		volOf   []int
+	}{
+		{"unplanned", []int{10, 20}, nil, []span{{1, 10}, {11, 30}}, []bool{false, false}, nil},
+		{"first volume planned shorter", []int{10, 20}, []int{1, 1, 1}, []span{{1, 3}, {4, 23}}, []bool{true, false}, []int{1, 1, 1}},
+		{"chapters join the volume before", []int{2, 2}, []int{1, 0, 2, 0}, []span{{1, 2}, {3, 4}}, []bool{true, true}, []int{1, 1, 2, 2}},
+		{"empty volume keeps one chapter", []int{0, 3}, nil, []span{{1, 1}, {2, 4}}, []bool{false, false}, nil},
+	}
+	for _, c := range cases {
+		var o Outline
+		for _, n := range c.vols {
+			o.Volumes = append(o.Volumes, Volume{Index: 9, Chapters: n})
+		}
+		for i, v := range c.chaps {
+			o.Chapters = append(o.Chapters, Chapter{Index: i + 1, Volume: v})
+		}
+		SyncVolumes(&o)
+		for i, v := range o.Volumes {
+			if v.Index != i+1 || (span{v.Start, v.End}) != c.spans[i] || v.Planned != c.planned[i] {
+				t.Errorf("%s: volume %d = %+v, want %v planned=%v", c.name, i+1, v, c.spans[i], c.planned[i])
+			}
+		}
+		var volOf []int
+		for _, ch := range o.Chapters {
+			volOf = append(volOf, ch.Volume)
+		}
+		if !reflect.DeepEqual(volOf, c.volOf) {
+			t.Errorf("%s: chapter volumes = %v, want %v", c.name, volOf, c.volOf)
+		}
+		if want := c.spans[len(c.spans)-1].end; o.TotalChapters() != want {
+			t.Errorf("%s: TotalChapters = %d, want %d", c.name, o.TotalChapters(), want)
+		}
+	}
+}
+
+func TestFitVolumes(t *testing.T) {
+	cases := []struct {
+		in    []int
+		total int
+		want  []int
+	}{
+		{[]int{40, 60}, 100, []int{40, 60}},
+		{[]int{40, 40}, 100, []int{50, 50}},
+		{[]int{10, 10, 10}, 100, []int{34, 33, 33}},
+		{[]int{0, 50}, 50, []int{25, 25}},
+		{[]int{30, 10}, 0, []int{30, 10}},
+	}
+	for _, c := range cases {
+		vols := make([]Volume, len(c.in))
+		for i, n := range c.in {
+			vols[i].Chapters = n
+		}
+		fitVolumes(vols, c.total)
+		var got []int
+		for _, v := range vols {
+			got = append(got, v.Chapters)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("fitVolumes(%v, %d) = %v, want %v", c.in, c.total, got, c.want)
+		}
+	}
+}
+
+func TestAlignPlans(t *testing.T) {
+	cases := []struct {
+		name    string
+		outline Outline
+		plans   []Chapter
+		want    []Chapter
+	}{
+		{
+			"flat outline numbers plans",
+			Outline{Chapters: []Chapter{{Index: 1, Goal: "g1"}, {Index: 2}}},
+			[]Chapter{{Index: 7}, {Index: 7}},
+			[]Chapter{{Index: 1}, {Index: 2}},
+		},
+		{
+			"volume slice takes indexes and goals",
+			Outline{Volumes: []Volume{{Index: 1}, {Index: 2}}, Chapters: []Chapter{{Index: 41, Volume: 2, Goal: "g", Climax: "c", Payoffs: []string{"t1"}}, {Index: 42, Volume: 2}}},
+			[]Chapter{{Index: 1}, {Index: 2, Goal: "own"}},
+			[]Chapter{{Index: 41, Volume: 2, Goal: "g", Climax: "c", Payoffs: []string{"t1"}}, {Index: 42, Volume: 2, Goal: "own"}},
+		},
+		{
+			"extension slice without volumes",
+			Outline{Chapters: []Chapter{{Index: 11}}},
+			[]Chapter{{Index: 1}, {Index: 2}},
+			[]Chapter{{Index: 11}, {Index: 2}},
+		},
+	}
+	for _, c := range cases {
+		alignPlans(c.plans, c.outline)
+		if !reflect.DeepEqual(c.plans, c.want) {
+			t.Errorf("%s: plans = %+v, want %+v", c.name, c.plans, c.want)
+		}
+	}
+}
+
+func TestExpandVolume(t *testing.T) {
+	// volume n holds chapters[n-1] planned chapters, 0 while unplanned
+	book := func(planned ...int) (Outline, []Chapter) {
+		o := Outline{Title: "t"}
+		var plans []Chapter
+		for v, n := range planned {
+			o.Volumes = append(o.Volumes, Volume{Title: "卷", Goal: "卷目标", Chapters: 3})
+			for i := 0; i < n; i++ {
+				idx := len(o.Chapters) + 1
+				o.Chapters = append(o.Chapters, Chapter{Index: idx, Title: "旧", Volume: v + 1})
+				plans = append(plans, Chapter{Index: idx, Title: "旧", Volume: v + 1})
+			}
+		}
+		return o, plans
+	}
+	chapters := `[{"title":"新","goal":"g"},{"title":"新"},{"title":"新"},{"title":"多余"}]`
+	plans := `[{"title":"新"},{"title":"新"},{"title":"新"}]`
+	cases := []struct {
+		name    string
+		planned []int
+		volume  int
+		idx     []int
+		vol     []int
+		err     string
+	}{
+		{"first volume", []int{0, 0}, 1, []int{1, 2, 3}, []int{1, 1, 1}, ""},
+		{"next volume", []int{3, 0}, 2, []int{1, 2, 3, 4, 5, 6}, []int{1, 1, 1, 2, 2, 2}, ""},
+		{"replans the last volume", []int{3, 3}, 2, []int{1, 2, 3, 4, 5, 6}, []int{1, 1, 1, 2, 2, 2}, ""},
+		{"previous volume unplanned", []int{0, 0}, 2, nil, nil, "volume 1 is not planned"},
+		{"later volume planned", []int{3, 3}, 1, nil, nil, "volume 2 is already planned"},
+		{"out of range", []int{0, 0}, 3, nil, nil, "out of range"},
+	}
+	for _, c := range cases {
+		outline, old := book(c.planned...)
+		client := &scriptClient{replies: []string{chapters, plans}}
+		g := NewGenerator(client).WithPersistDir(t.TempDir())
+		got, next, err := g.ExpandVolume(context.Background(), Spec{}, outline, old, c.volume)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: err = %v, want %q", c.name, err, c.err)
+			}
+			if len(client.users) > 0 {
+				t.Errorf("%s: rejected expansion called the model", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		idx, vol := chapterIndexes(got.Chapters)
+		if !reflect.DeepEqual(idx, c.idx) || !reflect.DeepEqual(vol, c.vol) {
+			t.Errorf("%s: chapters %v in volumes %v, want %v in %v", c.name, idx, vol, c.idx, c.vol)
+		}
+		if pidx, pvol := chapterIndexes(next); !reflect.DeepEqual(pidx, c.idx) || !reflect.DeepEqual(pvol, c.vol) {
+			t.Errorf("%s: plans %v in volumes %v, want %v in %v", c.name, pidx, pvol, c.idx, c.vol)
+		}
+		for _, ch := range got.Chapters {
+			if want := map[bool]string{true: "新", false: "旧"}[ch.Volume == c.volume]; ch.Title != want {
+				t.Errorf("%s: chapter %d is %q, want %q", c.name, ch.Index, ch.Title, want)
+			}
+		}
+		if v := got.Volumes[c.volume-1]; !v.Planned {
+			t.Errorf("%s: volume %d still unplanned: %+v", c.name, c.volume, v)
+		}
+		if p := next[len(next)-3]; p.Goal != "g" {
+			t.Errorf("%s: plan %d lost the goal of its chapter", c.name, p.Index)
+		}
+		if saved, _ := chapterIndexes(readChapters(t, g.PersistDir, "plans.json")); !reflect.DeepEqual(saved, c.idx) {
+			t.Errorf("%s: plans.json = %v, want %v", c.name, saved, c.idx)
+		}
+	}
+}
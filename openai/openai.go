@@ -2,14 +2,17 @@ package openai
 
 import (
 	"context"
-	"time"
 
 	openai "github.com/openai/openai-go/v3" // imported as openai
 	"github.com/openai/openai-go/v3/option"
 )
 
+// DefaultTemperature is the sampling temperature of a new client
+const DefaultTemperature = 0.9
+
 type Client struct {
-	cli openai.Client
+	cli         openai.Client
+	temperature float64
 }
 
 func NewClient(apiKey string, baseURL string) *Client {
@@ -18,14 +21,26 @@ func NewClient(apiKey string, baseURL string) *Client {
 		option.WithBaseURL(baseURL),
 	)
 	return &Client{
-		cli: openAICli,
+		cli:         openAICli,
+		temperature: DefaultTemperature,
 	}
 }
 
+// WithTemperature sets the sampling temperature of every request
+func (c *Client) WithTemperature(t float64) *Client {
+	c.temperature = t
+	return c
+}
+
+// Temperature is the sampling temperature requests are sent with
+func (c *Client) Temperature() float64 {
+	return c.temperature
+}
+
 func (c *Client) Chat(ctx context.Context, model string, system string, user string) (string, error) {
 	res, err := c.cli.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
 		Model:       model,
-		Temperature: openai.Opt(0.9),
+		Temperature: openai.Opt(c.temperature),
 		TopP:        openai.Opt(0.95),
 		Messages: []openai.ChatCompletionMessageParamUnion{
 			openai.SystemMessage(system),
@@ -37,28 +52,3 @@ func (c *Client) Chat(ctx context.Context, model string, system string, user str
 	}
 	return res.Choices[0].Message.Content, nil
 }
-
-func (c *Client) ChatWithRetry(ctx context.Context, model string, system string, user string, retries int, backoff time.Duration) (string, error) {
-	var lastErr error
-	attempts := retries
-	if attempts <= 0 {
-		attempts = 1
-	}
-	for i := 0; i < attempts; i++ {
-		res, err := c.Chat(ctx, model, system, user)
-		if err == nil {
-			return res, nil
-		}
-		lastErr = err
-		if i < attempts-1 {
-			if backoff > 0 {
-				select {
-				case <-time.After(backoff):
-				case <-ctx.Done():
-					return "", ctx.Err()
-				}
-			}
-		}
-	}
-	return "", lastErr
-}
@@ -0,0 +1,859 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+var (
+	ErrUnknownArtifact      = errors.New("unknown artifact")
+	ErrPreconditionFailed   = errors.New("etag does not match current artifact")
+	ErrPreconditionRequired = errors.New("missing If-Match header")
+	ErrJobBusy              = errors.New("job is generating, try again when it finishes")
+	ErrAwaitingApproval     = errors.New("job is awaiting approval")
+)
+
+// ValidationError reports an artifact body that cannot be stored
+type ValidationError struct {
+	Msg string
+}
+
+func (e *ValidationError) Error() string { return e.Msg }
+
+func invalidf(format string, args ...interface{}) error {
+	return &ValidationError{Msg: fmt.Sprintf(format, args...)}
+}
+
+var artifactFiles = map[string]string{
+	"outline":    "outline.json",
+	"characters": "characters.json",
+	"plans":      "plans.json",
+	"settings":   "settings.json",
+	// character_state is written by the tracker after every chapter
+	novel.StageCharacterState: novel.CharacterStateFile,
+	novel.StageTimeline:       novel.TimelineFile,
+	novel.StageGlossary:       novel.GlossaryFile,
+}
+
+func ArtifactNames() []string {
+	return []string{"outline", "characters", "plans", "settings", novel.StageCharacterState, novel.StageTimeline, novel.StageGlossary}
+}
+
+// ETag is a strong validator derived from the stored bytes
+func ETag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+type ChapterOp struct {
+	Op      string         `json:"op"`
+	At      int            `json:"at,omitempty"`
+	Index   int            `json:"index,omitempty"`
+	From    int            `json:"from,omitempty"`
+	To      int            `json:"to,omitempty"`
+	Chapter *novel.Chapter `json:"chapter,omitempty"`
+}
+
+type CharacterOp struct {
+	Op        string           `json:"op"`
+	Name      string           `json:"name,omitempty"`
+	Character *novel.Character `json:"character,omitempty"`
+}
+
+func (m *Manager) jobDir(cfg config.Config, id string) (string, error) {
+	if !validJobID(id) {
+		return "", ErrInvalidJobID
+	}
+	base := filepath.Join(cfg.Output.Dir, "jobs", id)
+	if j := m.Get(id); j != nil {
+		base = jobWorkDir(cfg, j)
+	}
+	if !fileExists(base) {
+		return "", os.ErrNotExist
+	}
+	return base, nil
+}
+
+// Manifest returns the provenance of every artifact recorded for a job
+func (m *Manager) Manifest(cfg config.Config, id string) (novel.Manifest, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return novel.Manifest{}, err
+	}
+	return novel.ReadManifest(base)
+}
+
+// busy reports whether a job, one of its chapter tasks or a volume being
+// planned may still write artifacts
+func (m *Manager) busy(id string) bool {
+	if j := m.Get(id); j != nil {
+		switch j.Status() {
+		case JobQueued, JobRunning:
+			return true
+		}
+	}
+	if m.isPlanning(id) {
+		return true
+	}
+	m.chMu.Lock()
+	defer m.chMu.Unlock()
+	for _, t := range m.chapters {
+		if t.JobID != id {
+			continue
+		}
+		switch t.Snapshot().Status {
+		case ChapterQueued, ChapterRunning:
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) ReadArtifact(cfg config.Config, id, name string) ([]byte, string, error) {
+	file, ok := artifactFiles[name]
+	if !ok {
+		return nil, "", ErrUnknownArtifact
+	}
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return nil, "", err
+	}
+	m.artMu.Lock()
+	defer m.artMu.Unlock()
+	b, err := os.ReadFile(filepath.Join(base, file))
+	if err != nil {
+		return nil, "", err
+	}
+	return b, ETag(b), nil
+}
+
+// JobLanguage returns the language a job writes in, as saved in its spec
+func (m *Manager) JobLanguage(cfg config.Config, id string) (string, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return "", err
+	}
+	return jobSpec(base).Language, nil
+}
+
+// PutArtifact replaces an artifact after validating it; chapter lists are
+// renumbered from 1 and their sibling list and chapter files follow the
+// chapters by the index they are sent with
+func (m *Manager) PutArtifact(cfg config.Config, id, name string, body []byte, ifMatch string) ([]byte, string, error) {
+	return m.editArtifact(cfg, id, name, ifMatch, func(base string, cur []byte) ([]byte, error) {
+		if name == "outline" || name == "plans" {
+			return putChapters(cfg, base, name, cur, body)
+		}
+		return normalizeArtifact(base, name, body)
+	})
+}
+
+// PatchArtifact applies chapter ops to outline/plans, character ops to
+// characters and a JSON merge patch to settings and character state
+func (m *Manager) PatchArtifact(cfg config.Config, id, name string, body []byte, ifMatch string) ([]byte, string, error) {
+	return m.editArtifact(cfg, id, name, ifMatch, func(base string, cur []byte) ([]byte, error) {
+		switch name {
+		case "outline", "plans":
+			var req struct {
+				Ops []ChapterOp `json:"ops"`
+			}
+			if err := json.Unmarshal(body, &req); err != nil {
+				return nil, invalidf("invalid patch: %s", err.Error())
+			}
+			return patchChapters(cfg, base, name, cur, req.Ops)
+		case "characters":
+			var req struct {
+				Ops []CharacterOp `json:"ops"`
+			}
+			if err := json.Unmarshal(body, &req); err != nil {
+				return nil, invalidf("invalid patch: %s", err.Error())
+			}
+			return patchCharacters(cur, req.Ops)
+		default:
+			var doc, patch interface{}
+			if len(cur) > 0 {
+				if err := json.Unmarshal(cur, &doc); err != nil {
+					return nil, err
+				}
+			}
+			if err := json.Unmarshal(body, &patch); err != nil {
+				return nil, invalidf("invalid patch: %s", err.Error())
+			}
+			merged, _ := json.Marshal(mergePatch(doc, patch))
+			return normalizeArtifact(base, name, merged)
+		}
+	})
+}
+
+func (m *Manager) editArtifact(cfg config.Config, id, name, ifMatch string, edit func(base string, cur []byte) ([]byte, error)) ([]byte, string, error) {
+	file, ok := artifactFiles[name]
+	if !ok {
+		return nil, "", ErrUnknownArtifact
+	}
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if ifMatch == "" {
+		return nil, "", ErrPreconditionRequired
+	}
+	m.artMu.Lock()
+	defer m.artMu.Unlock()
+	if m.busy(id) {
+		return nil, "", ErrJobBusy
+	}
+	path := filepath.Join(base, file)
+	cur, err := os.ReadFile(path)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, "", err
+	}
+	// settings may not exist yet for older jobs; "*" creates it
+	if ifMatch == "*" && exists || ifMatch != "*" && ifMatch != ETag(cur) {
+		return nil, "", ErrPreconditionFailed
+	}
+	next, err := edit(base, cur)
+	if err != nil {
+		return nil, "", err
+	}
+	// chapter lists are written by commitChapters along with their files
+	if name != "outline" && name != "plans" {
+		if err := writeFileAtomic(path, next); err != nil {
+			return nil, "", err
+		}
+	}
+	_ = novel.RecordArtifact(base, file, novel.Provenance{Stage: name, Origin: novel.OriginEdited})
+	if name == "outline" || name == "plans" {
+		_ = refreshProgress(base)
+	}
+	return next, ETag(next), nil
+}
+
+// normalizeArtifact validates an artifact of the job in base other than the
+// chapter lists; settings are checked against the schema of the job's preset
+func normalizeArtifact(base, name string, body []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	switch name {
+	case "characters":
+		var chars []novel.Character
+		if err := dec.Decode(&chars); err != nil {
+			return nil, invalidf("invalid characters: %s", err.Error())
+		}
+		seen := map[string]bool{}
+		for i, c := range chars {
+			if c.Name == "" {
+				return nil, invalidf("character %d has no name", i+1)
+			}
+			if seen[c.Name] {
+				return nil, invalidf("duplicate character %s", c.Name)
+			}
+			seen[c.Name] = true
+		}
+		return json.MarshalIndent(chars, "", "  ")
+	case "settings":
+		if t := bytes.TrimSpace(body); len(t) == 0 || t[0] != '{' {
+			return nil, invalidf("settings must be a JSON object")
+		}
+		var s novel.Settings
+		if err := dec.Decode(&s); err != nil {
+			return nil, invalidf("invalid settings: %s", err.Error())
+		}
+		if err := loadSettingSchema(base).Validate(s); err != nil {
+			return nil, invalidf("invalid settings: %s", err.Error())
+		}
+		return json.MarshalIndent(s, "", "  ")
+	case novel.StageCharacterState:
+		var s novel.CharacterStates
+		if err := dec.Decode(&s); err != nil {
+			return nil, invalidf("invalid character state: %s", err.Error())
+		}
+		if s.Updates == nil {
+			s.Updates = []novel.StateUpdate{}
+		}
+		seen := map[int]bool{}
+		for _, u := range s.Updates {
+			// chapter 0 holds the states carried over from the previous book
+			if u.Chapter < 0 {
+				return nil, invalidf("state update has no chapter")
+			}
+			if seen[u.Chapter] {
+				return nil, invalidf("duplicate state update for chapter %d", u.Chapter)
+			}
+			seen[u.Chapter] = true
+			for _, c := range u.Characters {
+				if c.Name == "" {
+					return nil, invalidf("chapter %d has a character state without name", u.Chapter)
+				}
+			}
+		}
+		return json.MarshalIndent(s, "", "  ")
+	case novel.StageTimeline:
+		var t novel.Timeline
+		if err := dec.Decode(&t); err != nil {
+			return nil, invalidf("invalid timeline: %s", err.Error())
+		}
+		if t.Events == nil {
+			t.Events = []novel.StoryEvent{}
+		}
+		for i, e := range t.Events {
+			if e.Chapter < 1 {
+				return nil, invalidf("event %d has no chapter", i+1)
+			}
+			if e.Event == "" {
+				return nil, invalidf("event %d has no description", i+1)
+			}
+		}
+		// days follow from the deltas, so edits only need to touch those
+		t.Resolve()
+		return json.MarshalIndent(t, "", "  ")
+	case novel.StageGlossary:
+		var gl novel.Glossary
+		if err := dec.Decode(&gl); err != nil {
+			return nil, invalidf("invalid glossary: %s", err.Error())
+		}
+		if gl.Entries == nil {
+			gl.Entries = []novel.GlossaryEntry{}
+		}
+		// a spelling may belong to one entry only, or corrections would fight
+		owner := map[string]string{}
+		for i := range gl.Entries {
+			e := &gl.Entries[i]
+			if e.Term = strings.TrimSpace(e.Term); e.Term == "" {
+				return nil, invalidf("glossary entry %d has no term", i+1)
+			}
+			if e.Category == "" {
+				e.Category = novel.TermOther
+			}
+			for _, s := range append(append([]string{e.Term}, e.Aliases...), e.Variants...) {
+				if o, ok := owner[s]; ok && o != e.Term {
+					return nil, invalidf("%s is listed under both %s and %s", s, o, e.Term)
+				}
+				owner[s] = e.Term
+			}
+			for _, v := range e.Variants {
+				if v == e.Term {
+					return nil, invalidf("term %s is listed as its own variant", v)
+				}
+			}
+		}
+		return json.MarshalIndent(gl, "", "  ")
+	}
+	return nil, ErrUnknownArtifact
+}
+
+func validateChapters(list []novel.Chapter) error {
+	for i, c := range list {
+		if c.Title == "" {
+			return invalidf("chapter %d has no title", i+1)
+		}
+	}
+	return nil
+}
+
+// indexedChapter remembers the index a chapter had before the patch so
+// files can follow it; orig is 0 for inserted chapters
+type indexedChapter struct {
+	orig int
+	ch   novel.Chapter
+}
+
+func applyChapterOp(list []indexedChapter, op ChapterOp) ([]indexedChapter, error) {
+	n := len(list)
+	switch op.Op {
+	case "insert":
+		if op.Chapter == nil || op.Chapter.Title == "" {
+			return nil, invalidf("insert needs a chapter with a title")
+		}
+		at := op.At
+		if at <= 0 || at > n {
+			at = n + 1
+		}
+		out := make([]indexedChapter, 0, n+1)
+		out = append(out, list[:at-1]...)
+		out = append(out, indexedChapter{ch: *op.Chapter})
+		return append(out, list[at-1:]...), nil
+	case "delete":
+		if op.Index < 1 || op.Index > n {
+			return nil, invalidf("delete index %d out of range", op.Index)
+		}
+		out := make([]indexedChapter, 0, n-1)
+		out = append(out, list[:op.Index-1]...)
+		return append(out, list[op.Index:]...), nil
+	case "move":
+		if op.From < 1 || op.From > n || op.To < 1 || op.To > n {
+			return nil, invalidf("move %d -> %d out of range", op.From, op.To)
+		}
+		item := list[op.From-1]
+		rest := make([]indexedChapter, 0, n)
+		rest = append(rest, list[:op.From-1]...)
+		rest = append(rest, list[op.From:]...)
+		out := make([]indexedChapter, 0, n)
+		out = append(out, rest[:op.To-1]...)
+		out = append(out, item)
+		return append(out, rest[op.To-1:]...), nil
+	case "update":
+		if op.Index < 1 || op.Index > n {
+			return nil, invalidf("update index %d out of range", op.Index)
+		}
+		if op.Chapter == nil {
+			return nil, invalidf("update needs a chapter")
+		}
+		out := append([]indexedChapter(nil), list...)
+		if op.Chapter.Title != "" {
+			out[op.Index-1].ch.Title = op.Chapter.Title
+		}
+		if op.Chapter.Summary != "" {
+			out[op.Index-1].ch.Summary = op.Chapter.Summary
+		}
+		return out, nil
+	}
+	return nil, invalidf("unknown op %q", op.Op)
+}
+
+func toIndexed(list []novel.Chapter) []indexedChapter {
+	out := make([]indexedChapter, len(list))
+	for i, c := range list {
+		out[i] = indexedChapter{orig: i + 1, ch: c}
+	}
+	return out
+}
+
+func fromIndexed(list []indexedChapter) []novel.Chapter {
+	out := make([]novel.Chapter, len(list))
+	for i, c := range list {
+		out[i] = c.ch
+		out[i].Index = i + 1
+	}
+	return out
+}
+
+// chapterLists are the outline and plans of a job while one of them is
+// edited; name is the edited one
+type chapterLists struct {
+	name    string
+	outline novel.Outline
+	plans   []novel.Chapter
+	// sibling is false while the other list has not been generated yet
+	sibling bool
+}
+
+func loadChapterLists(base, name string, cur []byte) (chapterLists, error) {
+	l := chapterLists{name: name}
+	if name == "plans" {
+		if err := json.Unmarshal(cur, &l.plans); err != nil {
+			return l, err
+		}
+		if b, err := os.ReadFile(filepath.Join(base, "outline.json")); err == nil {
+			l.sibling = json.Unmarshal(b, &l.outline) == nil
+		}
+		return l, nil
+	}
+	if err := json.Unmarshal(cur, &l.outline); err != nil {
+		return l, err
+	}
+	if b, err := os.ReadFile(filepath.Join(base, "plans.json")); err == nil {
+		l.sibling = json.Unmarshal(b, &l.plans) == nil
+	}
+	return l, nil
+}
+
+func (l chapterLists) siblingName() string {
+	if l.name == "plans" {
+		return "outline"
+	}
+	return "plans"
+}
+
+// indexed returns the edited and the sibling list
+func (l chapterLists) indexed() ([]indexedChapter, []indexedChapter) {
+	if l.name == "plans" {
+		return toIndexed(l.plans), toIndexed(l.outline.Chapters)
+	}
+	return toIndexed(l.outline.Chapters), toIndexed(l.plans)
+}
+
+// patchChapters applies ops to the outline or plans; insert, delete and
+// move are mirrored onto the sibling list and the chapter files so that
+// outline, plans and chapters/ keep sharing the same numbering. An op the
+// sibling list cannot take fails the whole patch
+func patchChapters(cfg config.Config, base, name string, cur []byte, ops []ChapterOp) ([]byte, error) {
+	if len(ops) == 0 {
+		return nil, invalidf("no ops")
+	}
+	l, err := loadChapterLists(base, name, cur)
+	if err != nil {
+		return nil, err
+	}
+	target, other := l.indexed()
+	for _, op := range ops {
+		next, err := applyChapterOp(target, op)
+		if err != nil {
+			return nil, err
+		}
+		target = next
+		if op.Op == "update" || !l.sibling {
+			continue
+		}
+		if other, err = applyChapterOp(other, op); err != nil {
+			return nil, invalidf("%s cannot follow the %s: %s", l.siblingName(), op.Op, err.Error())
+		}
+	}
+	return commitChapters(cfg, base, l, target, other)
+}
+
+// putChapters replaces the outline or plans. Chapters sent with the index
+// they had keep their file and their sibling entry; chapters without one
+// are new and are copied into the sibling list
+func putChapters(cfg config.Config, base, name string, cur, body []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	var list []novel.Chapter
+	var outline novel.Outline
+	if name == "plans" {
+		if err := dec.Decode(&list); err != nil {
+			return nil, invalidf("invalid plans: %s", err.Error())
+		}
+	} else {
+		if err := dec.Decode(&outline); err != nil {
+			return nil, invalidf("invalid outline: %s", err.Error())
+		}
+		if outline.Title == "" {
+			return nil, invalidf("outline title is required")
+		}
+		list = outline.Chapters
+	}
+	if err := validateChapters(list); err != nil {
+		return nil, err
+	}
+	l := chapterLists{name: name}
+	if len(cur) > 0 {
+		var err error
+		if l, err = loadChapterLists(base, name, cur); err != nil {
+			return nil, err
+		}
+	}
+	old, other := l.indexed()
+	target := make([]indexedChapter, len(list))
+	seen := map[int]bool{}
+	for i, c := range list {
+		target[i].ch = c
+		if c.Index >= 1 && c.Index <= len(old) && !seen[c.Index] {
+			target[i].orig = c.Index
+			seen[c.Index] = true
+		}
+	}
+	if l.sibling {
+		next := make([]indexedChapter, len(target))
+		for i, c := range target {
+			switch {
+			case c.orig == 0:
+				next[i] = indexedChapter{ch: c.ch}
+			case c.orig > len(other):
+				return nil, invalidf("%s has no chapter %d", l.siblingName(), c.orig)
+			default:
+				next[i] = other[c.orig-1]
+			}
+		}
+		other = next
+	}
+	if name == "outline" {
+		// title and volumes come from the body, chapters from target
+		outline.Chapters = l.outline.Chapters
+		l.outline = outline
+	}
+	return commitChapters(cfg, base, l, target, other)
+}
+
+// commitChapters stores an edited chapter list and its sibling. Chapters
+// that changed place take their sibling entry, file, version history and
+// final dir copy along; deleted chapters are kept under versions/deleted and
+// files of chapters whose plan was retitled are renamed. The moves are
+// staged and undone when a later step or an artifact write fails
+func commitChapters(cfg config.Config, base string, l chapterLists, target, other []indexedChapter) ([]byte, error) {
+	origCount := len(l.outline.Chapters)
+	if l.name == "plans" {
+		origCount = len(l.plans)
+	}
+	moves := map[int]int{}
+	structural := len(target) != origCount
+	for i, c := range target {
+		if c.orig > 0 {
+			moves[c.orig] = i + 1
+		}
+		if c.orig != i+1 {
+			structural = true
+		}
+	}
+	retitled := map[int]string{}
+	if l.name == "plans" {
+		for i, c := range target {
+			if c.orig > 0 && l.plans[c.orig-1].Title != c.ch.Title {
+				retitled[i+1] = c.ch.Title
+			}
+		}
+		l.plans, l.outline.Chapters = fromIndexed(target), fromIndexed(other)
+	} else {
+		l.outline.Chapters, l.plans = fromIndexed(target), fromIndexed(other)
+	}
+	novel.SyncVolumes(&l.outline)
+	// the final dir is named after the title the book had before the edit
+	fd := finalDir(cfg, base)
+	r := novel.NewRenames()
+	renamed := map[string]string{}
+	var err error
+	if structural {
+		if err = novel.RenumberChapterHistory(base, moves, origCount, r); err == nil {
+			renamed, err = renumberChapterFiles(r, base, filepath.Join(base, "chapters"), moves, origCount)
+		}
+	}
+	if err == nil {
+		err = retitleChapterFiles(r, filepath.Join(base, "chapters"), retitled, renamed)
+	}
+	if err == nil {
+		err = writeChapterLists(base, l, structural && l.sibling)
+	}
+	if err != nil {
+		_ = r.Undo()
+		return nil, err
+	}
+	r.Commit()
+	recordChapterRenames(base, renamed)
+	if fd != "" && fileExists(fd) {
+		// the final dir is an export; it follows on a best effort basis
+		fr := novel.NewRenames()
+		if structural {
+			_, _ = renumberChapterFiles(fr, "", fd, moves, origCount)
+		}
+		_ = retitleChapterFiles(fr, fd, retitled, map[string]string{})
+		fr.Commit()
+	}
+	return chapterListJSON(l, l.name), nil
+}
+
+func chapterListJSON(l chapterLists, name string) []byte {
+	var b []byte
+	if name == "plans" {
+		b, _ = json.MarshalIndent(l.plans, "", "  ")
+	} else {
+		b, _ = json.MarshalIndent(l.outline, "", "  ")
+	}
+	return b
+}
+
+// writeChapterLists writes the edited list and, with sibling, the other
+// one; the sibling is restored when the edited list cannot be written
+func writeChapterLists(base string, l chapterLists, sibling bool) error {
+	path := filepath.Join(base, artifactFiles[l.name])
+	if !sibling {
+		return writeFileAtomic(path, chapterListJSON(l, l.name))
+	}
+	name := l.siblingName()
+	spath := filepath.Join(base, artifactFiles[name])
+	prev, err := os.ReadFile(spath)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(spath, chapterListJSON(l, name)); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, chapterListJSON(l, l.name)); err != nil {
+		_ = writeFileAtomic(spath, prev)
+		return err
+	}
+	_ = novel.RecordArtifact(base, artifactFiles[name], novel.Provenance{Stage: name, Origin: novel.OriginEdited})
+	return nil
+}
+
+// retitleChapterFiles renames the files of chapters whose plan got a new
+// title, by index, and rewrites their heading; renamed collects the old->new
+// file names
+func retitleChapterFiles(r *novel.Renames, dir string, titles map[int]string, renamed map[string]string) error {
+	files := novel.ChapterFiles(dir)
+	for idx, title := range titles {
+		name, ok := files[idx]
+		if !ok {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		_, body := novel.ParseChapterFile(b)
+		next, stale, err := r.ReplaceChapterFile(dir, novel.ChapterContent{Index: idx, Title: title, Content: body})
+		if err != nil {
+			return err
+		}
+		if stale == "" {
+			continue
+		}
+		// a file renumbered by the same edit keeps its original name as key
+		from := stale
+		for orig, to := range renamed {
+			if to == stale {
+				from = orig
+			}
+		}
+		renamed[from] = next
+	}
+	return nil
+}
+
+// renumberChapterFiles renames chapter files by old->new index and retires
+// files of chapters 1..count missing from moves into the deleted chapters
+// of the job dir base, or removes them when base is empty; renames go
+// through temporary names so swapped indexes do not clobber each other. It
+// returns old->new file names, with "" for deleted files
+func renumberChapterFiles(r *novel.Renames, base, dir string, moves map[int]int, count int) (map[string]string, error) {
+	files := novel.ChapterFiles(dir)
+	renamed := map[string]string{}
+	type pending struct{ tmp, final string }
+	var staged []pending
+	for idx, name := range files {
+		if idx > count {
+			continue
+		}
+		to, ok := moves[idx]
+		if !ok {
+			var err error
+			if base != "" {
+				err = r.Retire(base, idx, filepath.Join(dir, name))
+			} else {
+				err = r.Discard(filepath.Join(dir, name))
+			}
+			if err != nil {
+				return renamed, err
+			}
+			renamed[name] = ""
+			continue
+		}
+		if to == idx {
+			continue
+		}
+		tmp := filepath.Join(dir, fmt.Sprintf(".renumber-%d-%s", idx, name))
+		if err := r.Rename(filepath.Join(dir, name), tmp); err != nil {
+			return renamed, err
+		}
+		next := novel.RenumberChapterFile(name, to)
+		staged = append(staged, pending{tmp: tmp, final: filepath.Join(dir, next)})
+		renamed[name] = next
+	}
+	for _, p := range staged {
+		if err := r.Rename(p.tmp, p.final); err != nil {
+			return renamed, err
+		}
+	}
+	return renamed, nil
+}
+
+// recordChapterRenames carries manifest entries along with renumbered chapter files
+func recordChapterRenames(base string, renamed map[string]string) {
+	moves := map[string]string{}
+	for from, to := range renamed {
+		if to == "" {
+			_ = novel.ForgetArtifact(base, "chapters/"+from)
+			continue
+		}
+		moves["chapters/"+from] = "chapters/" + to
+	}
+	_ = novel.MoveArtifacts(base, moves)
+}
+
+func patchCharacters(cur []byte, ops []CharacterOp) ([]byte, error) {
+	var chars []novel.Character
+	if len(cur) > 0 {
+		if err := json.Unmarshal(cur, &chars); err != nil {
+			return nil, err
+		}
+	}
+	for _, op := range ops {
+		switch op.Op {
+		case "upsert":
+			if op.Character == nil || op.Character.Name == "" {
+				return nil, invalidf("upsert needs a character with a name")
+			}
+			name := op.Name
+			if name == "" {
+				name = op.Character.Name
+			}
+			found := false
+			for i := range chars {
+				if chars[i].Name == name {
+					chars[i] = *op.Character
+					found = true
+					break
+				}
+			}
+			if !found {
+				chars = append(chars, *op.Character)
+			}
+		case "delete":
+			out := chars[:0]
+			found := false
+			for _, c := range chars {
+				if c.Name == op.Name {
+					found = true
+					continue
+				}
+				out = append(out, c)
+			}
+			if !found {
+				return nil, invalidf("character %s not found", op.Name)
+			}
+			chars = out
+		default:
+			return nil, invalidf("unknown op %q", op.Op)
+		}
+	}
+	b, _ := json.Marshal(chars)
+	return normalizeArtifact("", "characters", b)
+}
+
+// mergePatch implements RFC 7386 JSON merge patch
+func mergePatch(doc, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	d, ok := doc.(map[string]interface{})
+	if !ok {
+		d = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(d, k)
+			continue
+		}
+		d[k] = mergePatch(d[k], v)
+	}
+	return d
+}
+
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
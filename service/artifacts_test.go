@@ -0,0 +1,236 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+// chapterJob writes a job whose outline and plans hold the given chapter
+// titles, each chapter written with its title as text and copied to the
+// final dir
+func chapterJob(t *testing.T, titles ...string) (config.Config, string) {
+	t.Helper()
+	cfg := config.Config{}
+	cfg.Output.Dir = t.TempDir()
+	id := "job-edit"
+	base := filepath.Join(cfg.Output.Dir, "jobs", id)
+	fd := filepath.Join(cfg.Output.Dir, "书")
+	for _, d := range []string{base, fd} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var plans []novel.Chapter
+	for i, title := range titles {
+		plans = append(plans, novel.Chapter{Index: i + 1, Title: title, Summary: "summary " + title})
+		c := novel.ChapterContent{Index: i + 1, Title: title, Content: "text " + title}
+		if _, err := novel.WriteChapter(base, c, novel.VersionDraft, novel.Provenance{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := novel.ReplaceChapterFile(fd, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outline, _ := json.Marshal(novel.Outline{Title: "书", Goal: "goal", Chapters: plans})
+	pb, _ := json.Marshal(plans)
+	if err := os.WriteFile(filepath.Join(base, "outline.json"), outline, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "plans.json"), pb, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return cfg, id
+}
+
+// chapterState lists, by index, the text of every chapter file, the title
+// of its current version and the plan and outline titles
+func chapterState(t *testing.T, cfg config.Config, id string) map[string][]string {
+	t.Helper()
+	base := filepath.Join(cfg.Output.Dir, "jobs", id)
+	out := map[string][]string{}
+	for _, dir := range []string{filepath.Join(base, "chapters"), filepath.Join(cfg.Output.Dir, "书")} {
+		files := novel.ChapterFiles(dir)
+		for i := 1; i <= 9; i++ {
+			if _, ok := files[i]; !ok {
+				continue
+			}
+			b, err := os.ReadFile(filepath.Join(dir, files[i]))
+			if err != nil {
+				t.Fatalf("chapter %d in %s: %v", i, dir, err)
+			}
+			_, body := novel.ParseChapterFile(b)
+			out[filepath.Base(dir)] = append(out[filepath.Base(dir)], strings.TrimSpace(body))
+		}
+	}
+	written := novel.ChapterFiles(filepath.Join(base, "chapters"))
+	for i := 1; i <= 9; i++ {
+		if _, ok := written[i]; !ok {
+			continue
+		}
+		c, _, err := novel.ReadChapterVersion(base, i, 1)
+		if err != nil {
+			t.Fatalf("history of chapter %d: %v", i, err)
+		}
+		out["versions"] = append(out["versions"], c.Title)
+	}
+	var plans []novel.Chapter
+	var outline novel.Outline
+	b, _ := os.ReadFile(filepath.Join(base, "plans.json"))
+	_ = json.Unmarshal(b, &plans)
+	b, _ = os.ReadFile(filepath.Join(base, "outline.json"))
+	_ = json.Unmarshal(b, &outline)
+	for _, c := range plans {
+		out["plans"] = append(out["plans"], c.Title)
+	}
+	for _, c := range outline.Chapters {
+		out["outline"] = append(out["outline"], c.Title)
+	}
+	return out
+}
+
+func TestChapterEditsRenumberFiles(t *testing.T) {
+	cases := []struct {
+		name   string
+		put    bool
+		list   string
+		body   string
+		want   []string
+		texts  []string
+		delete []string
+	}{
+		{"move", false, "plans", `{"ops":[{"op":"move","from":1,"to":3}]}`, []string{"b", "c", "a"}, []string{"text b", "text c", "text a"}, nil},
+		{"delete", false, "outline", `{"ops":[{"op":"delete","index":2}]}`, []string{"a", "c"}, []string{"text a", "text c"}, []string{"b"}},
+		{"insert", false, "plans", `{"ops":[{"op":"insert","at":2,"chapter":{"title":"new"}}]}`, []string{"a", "new", "b", "c"}, []string{"text a", "text b", "text c"}, nil},
+		{"put reorders by index", true, "plans", `[{"index":3,"title":"c"},{"index":1,"title":"a"},{"title":"new"}]`, []string{"c", "a", "new"}, []string{"text c", "text a"}, []string{"b"}},
+		{"put outline", true, "outline", `{"title":"书","chapters":[{"index":2,"title":"b"},{"index":1,"title":"a"}]}`, []string{"b", "a"}, []string{"text b", "text a"}, []string{"c"}},
+	}
+	for _, c := range cases {
+		cfg, id := chapterJob(t, "a", "b", "c")
+		m := NewManager()
+		_, etag, err := m.ReadArtifact(cfg, id, c.list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.put {
+			_, _, err = m.PutArtifact(cfg, id, c.list, []byte(c.body), etag)
+		} else {
+			_, _, err = m.PatchArtifact(cfg, id, c.list, []byte(c.body), etag)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		got := chapterState(t, cfg, id)
+		if !reflect.DeepEqual(got["plans"], c.want) || !reflect.DeepEqual(got["outline"], c.want) {
+			t.Errorf("%s: plans %v, outline %v, want %v", c.name, got["plans"], got["outline"], c.want)
+		}
+		if !reflect.DeepEqual(got["chapters"], c.texts) || !reflect.DeepEqual(got["书"], c.texts) {
+			t.Errorf("%s: chapters %v, final dir %v, want %v", c.name, got["chapters"], got["书"], c.texts)
+		}
+		var titles []string
+		for _, text := range c.texts {
+			titles = append(titles, strings.TrimPrefix(text, "text "))
+		}
+		if !reflect.DeepEqual(got["versions"], titles) {
+			t.Errorf("%s: histories %v, want %v", c.name, got["versions"], titles)
+		}
+		base := filepath.Join(cfg.Output.Dir, "jobs", id)
+		deleted, _ := filepath.Glob(filepath.Join(base, novel.VersionsDir, novel.DeletedDir, "*", "*_*.md"))
+		if len(deleted) != len(c.delete) {
+			t.Fatalf("%s: kept %v, want %v", c.name, deleted, c.delete)
+		}
+		for i, title := range c.delete {
+			dir := filepath.Dir(deleted[i])
+			if !strings.HasSuffix(deleted[i], "_"+title+".md") || !fileExists(filepath.Join(dir, "history.json")) {
+				t.Errorf("%s: deleted chapter %s kept as %s without its history", c.name, title, deleted[i])
+			}
+		}
+		if leftovers, _ := filepath.Glob(filepath.Join(base, "chapters", ".*")); len(leftovers) > 0 {
+			t.Errorf("%s: staging files left: %v", c.name, leftovers)
+		}
+	}
+}
+
+func TestChapterEditRollsBack(t *testing.T) {
+	cfg, id := chapterJob(t, "a", "b", "c")
+	base := filepath.Join(cfg.Output.Dir, "jobs", id)
+	// a directory in the way of the renamed file fails the edit half way
+	blocker := filepath.Join(base, "chapters", "0002_a.md", "x")
+	if err := os.MkdirAll(blocker, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	before := chapterState(t, cfg, id)
+	plans, _ := os.ReadFile(filepath.Join(base, "plans.json"))
+	m := NewManager()
+	_, etag, _ := m.ReadArtifact(cfg, id, "plans")
+	if _, _, err := m.PatchArtifact(cfg, id, "plans", []byte(`{"ops":[{"op":"move","from":1,"to":3},{"op":"delete","index":1}]}`), etag); err == nil {
+		t.Fatal("edit succeeded")
+	}
+	if err := os.RemoveAll(filepath.Dir(blocker)); err != nil {
+		t.Fatal(err)
+	}
+	if after := chapterState(t, cfg, id); !reflect.DeepEqual(after, before) {
+		t.Fatalf("failed edit changed the job:\n%v\nwant\n%v", after, before)
+	}
+	if now, _ := os.ReadFile(filepath.Join(base, "plans.json")); string(now) != string(plans) {
+		t.Fatal("failed edit rewrote plans.json")
+	}
+	if _, err := os.Stat(filepath.Join(base, novel.VersionsDir, novel.DeletedDir)); err == nil {
+		entries, _ := os.ReadDir(filepath.Join(base, novel.VersionsDir, novel.DeletedDir))
+		if len(entries) > 0 {
+			t.Fatalf("failed edit kept deleted chapters: %v", entries)
+		}
+	}
+	if _, _, err := m.PatchArtifact(cfg, id, "plans", []byte(`{"ops":[{"op":"move","from":1,"to":3}]}`), etag); err != nil {
+		t.Fatalf("edit after a rollback: %v", err)
+	}
+	if got := chapterState(t, cfg, id)["chapters"]; !reflect.DeepEqual(got, []string{"text b", "text c", "text a"}) {
+		t.Fatalf("chapters = %v", got)
+	}
+	var ve *ValidationError
+	if _, _, err := m.PatchArtifact(cfg, id, "plans", []byte(`{"ops":[{"op":"delete","index":9}]}`), `"stale"`); errors.As(err, &ve) || !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("stale etag: err = %v", err)
+	}
+}
+
+func TestRenderArtifact(t *testing.T) {
+	chars := `[{"name":"Lin","role":"hero","traits":["brave","calm"]}]`
+	states := `{"updates":[{"chapter":2,"characters":[{"name":"Lin","location":"Pine Peak"}]}]}`
+	timeline := `{"events":[{"chapter":2,"day":3,"when":"dawn","flashback":true,"event":"Lin leaves"}]}`
+	glossary := `{"entries":[{"term":"Pine Peak","category":"place","aliases":["the Peak"],"variants":["Pine Park"]}]}`
+	cases := []struct {
+		lang, name, body string
+		want             []string
+	}{
+		{"zh", "plans", `[]`, []string{"# 章节规划"}},
+		{"zh", "characters", chars, []string{"# 人物", "## Lin（hero）", "性格：brave、calm"}},
+		{"zh", novel.StageCharacterState, states, []string{"# 人物状态", "## Lin（第2章）", "位置：Pine Peak"}},
+		{"zh", novel.StageTimeline, timeline, []string{"# 时间线", "- 第3天（第2章，dawn，回忆）Lin leaves"}},
+		{"zh", novel.StageGlossary, glossary, []string{"# 名词表", "又称：the Peak", "误写：Pine Park"}},
+		{"en", "plans", `[]`, []string{"# Chapter plans"}},
+		{"en", "characters", chars, []string{"# Characters", "## Lin (hero)", "Personality: brave, calm"}},
+		{"en", novel.StageCharacterState, states, []string{"# Character states", "## Lin (chapter 2)", "Location: Pine Peak"}},
+		{"en", novel.StageTimeline, timeline, []string{"# Timeline", "- day 3 (chapter 2, dawn, flashback) Lin leaves"}},
+		{"en", novel.StageGlossary, glossary, []string{"# Glossary", "- **Pine Peak** (place) also called: the Peak misspelled as: Pine Park"}},
+		{"ja", novel.StageTimeline, timeline, []string{"# 年表", "- 3日目（第2章、dawn、回想）Lin leaves"}},
+		{"ja", novel.StageGlossary, glossary, []string{"# 用語集", "別名：the Peak", "誤記：Pine Park"}},
+	}
+	for _, c := range cases {
+		md, err := RenderArtifact(c.lang, c.name, []byte(c.body))
+		if err != nil {
+			t.Fatalf("%s %s: %v", c.lang, c.name, err)
+		}
+		for _, w := range c.want {
+			if !strings.Contains(md, w) {
+				t.Errorf("%s %s: missing %q in\n%s", c.lang, c.name, w, md)
+			}
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package service
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+type JobSummary struct {
+	ID        string    `json:"id"`
+	Status    JobStatus `json:"status"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Planned   int       `json:"planned_chapters"`
+	Written   int       `json:"written_chapters"`
+	Words     int       `json:"words"`
+	Archive   string    `json:"archive,omitempty"`
+}
+
+type ListFilter struct {
+	Status   JobStatus
+	Title    string
+	Since    time.Time
+	Until    time.Time
+	Page     int
+	PageSize int
+}
+
+type JobPage struct {
+	Total    int          `json:"total"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"page_size"`
+	Items    []JobSummary `json:"items"`
+}
+
+// List merges jobs known to the manager with job directories found on disk
+func (m *Manager) List(cfg config.Config, f ListFilter) JobPage {
+	items := m.summaries(cfg, f)
+	page, size := f.Page, f.PageSize
+	if page <= 0 {
+		page = 1
+	}
+	if size <= 0 {
+		size = 20
+	}
+	if size > 100 {
+		size = 100
+	}
+	res := JobPage{Total: len(items), Page: page, PageSize: size, Items: []JobSummary{}}
+	start := (page - 1) * size
+	if start < len(items) {
+		end := start + size
+		if end > len(items) {
+			end = len(items)
+		}
+		res.Items = items[start:end]
+	}
+	return res
+}
+
+// summaries returns every job matching f (pagination is ignored), newest first
+func (m *Manager) summaries(cfg config.Config, f ListFilter) []JobSummary {
+	byID := map[string]JobSummary{}
+	jobsDir := filepath.Join(cfg.Output.Dir, "jobs")
+	if entries, err := os.ReadDir(jobsDir); err == nil {
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+			byID[e.Name()] = readJobSummary(filepath.Join(jobsDir, e.Name()), e.Name())
+		}
+	}
+	m.mu.Lock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j)
+	}
+	m.mu.Unlock()
+	for _, j := range jobs {
+		snap := j.Snapshot()
+		s, ok := byID[snap.ID]
+		if !ok {
+			s = JobSummary{ID: snap.ID}
+		}
+		s.Status = snap.Status
+		s.CreatedAt = snap.CreatedAt
+		s.UpdatedAt = snap.UpdatedAt
+		if s.Planned == 0 {
+			s.Planned = snap.Total
+		}
+		byID[snap.ID] = s
+	}
+	archiveDir := filepath.Join(cfg.Output.Dir, "archive")
+	items := make([]JobSummary, 0, len(byID))
+	for _, s := range byID {
+		if p := filepath.Join(archiveDir, s.ID+".tar.gz"); fileExists(p) {
+			s.Archive = p
+		}
+		if !f.match(s) {
+			continue
+		}
+		items = append(items, s)
+	}
+	sort.Slice(items, func(a, b int) bool {
+		if items[a].CreatedAt.Equal(items[b].CreatedAt) {
+			return items[a].ID > items[b].ID
+		}
+		return items[a].CreatedAt.After(items[b].CreatedAt)
+	})
+	return items
+}
+
+func (f ListFilter) match(s JobSummary) bool {
+	if f.Status != "" && s.Status != f.Status {
+		return false
+	}
+	if f.Title != "" && !strings.Contains(strings.ToLower(s.Title), strings.ToLower(f.Title)) {
+		return false
+	}
+	if !f.Since.IsZero() && s.CreatedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !s.CreatedAt.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// readJobSummary summarises a job from its outline and progress.json;
+// progress.json is counted once for jobs that predate it
+func readJobSummary(base, id string) JobSummary {
+	s := JobSummary{ID: id}
+	if fi, err := os.Stat(base); err == nil {
+		s.CreatedAt = fi.ModTime()
+		s.UpdatedAt = fi.ModTime()
+	}
+	if ns, err := strconv.ParseInt(strings.TrimPrefix(id, "job-"), 10, 64); err == nil && strings.HasPrefix(id, "job-") {
+		s.CreatedAt = time.Unix(0, ns)
+	}
+	if b, err := os.ReadFile(filepath.Join(base, "outline.json")); err == nil {
+		var outline novel.Outline
+		if json.Unmarshal(b, &outline) == nil {
+			s.Title = outline.Title
+		}
+	}
+	path := filepath.Join(base, "progress.json")
+	if !fileExists(path) {
+		_ = refreshProgress(base)
+	}
+	p, _ := readProgress(base)
+	s.Status = p.restoredStatus()
+	s.Planned = p.Total
+	s.Written = p.Completed
+	s.Words = p.Words
+	if fi, err := os.Stat(path); err == nil && fi.ModTime().After(s.UpdatedAt) {
+		s.UpdatedAt = fi.ModTime()
+	}
+	return s
+}
+
+// finalDir resolves the output/<title> directory of a job, or "" when the
+// outline has no usable title
+func finalDir(cfg config.Config, workDir string) string {
+	b, err := os.ReadFile(filepath.Join(workDir, "outline.json"))
+	if err != nil {
+		return ""
+	}
+	var outline novel.Outline
+	if json.Unmarshal(b, &outline) != nil {
+		return ""
+	}
+	name := sanitizeDirName(outline.Title)
+	if name == "" || name == "." || name == ".." || name == "jobs" || name == "archive" {
+		return ""
+	}
+	return filepath.Join(cfg.Output.Dir, name)
+}
+
+var (
+	ErrInvalidJobID   = errors.New("invalid job id")
+	ErrJobNotFinished = errors.New("job is not completed")
+)
+
+func validJobID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, `/\`) && id != "." && id != ".."
+}
+
+// Delete cancels a running job and its chapter tasks, waits for them to
+// stop and removes the job's work dir, log and archive and its entry in its
+// project's books. The final dir is removed too unless another job writes
+// to it as well
+func (m *Manager) Delete(cfg config.Config, id string) error {
+	if !validJobID(id) {
+		return ErrInvalidJobID
+	}
+	workDir := filepath.Join(cfg.Output.Dir, "jobs", id)
+	j := m.Get(id)
+	if j == nil && !fileExists(workDir) {
+		return os.ErrNotExist
+	}
+	m.planMu.Lock()
+	planning := m.planning[id]
+	m.planMu.Unlock()
+	if planning {
+		return ErrJobBusy
+	}
+	// a book being concluded is busy; others leave their project first
+	project := ""
+	if j != nil {
+		project = j.Spec().Project
+	} else {
+		project = jobSpec(workDir).Project
+	}
+	if project != "" {
+		if err := m.removeProjectBook(cfg, project, id); err != nil {
+			return err
+		}
+	}
+	if j != nil {
+		if !j.Status().Terminal() {
+			_ = m.Cancel(id, "job deleted")
+		}
+		m.cancelChapterTasks(id, "job deleted")
+		j.workers.Wait()
+		if wd := j.Snapshot().WorkDir; wd != "" {
+			workDir = wd
+		}
+	}
+	if fd := finalDir(cfg, workDir); fd != "" && !m.finalDirShared(cfg, id, fd) {
+		if err := os.RemoveAll(fd); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(workDir); err != nil {
+		return err
+	}
+	_ = os.Remove(filepath.Join(cfg.Output.Dir, "jobs", id+".log"))
+	_ = os.Remove(filepath.Join(cfg.Output.Dir, "archive", id+".tar.gz"))
+	m.mu.Lock()
+	delete(m.jobs, id)
+	m.mu.Unlock()
+	m.chMu.Lock()
+	for k, t := range m.chapters {
+		if t.JobID == id {
+			delete(m.chapters, k)
+		}
+	}
+	m.chMu.Unlock()
+	return nil
+}
+
+// finalDirShared reports whether a job other than id, on disk or in
+// memory, resolves to the final dir fd
+func (m *Manager) finalDirShared(cfg config.Config, id, fd string) bool {
+	dirs := map[string]bool{}
+	jobsDir := filepath.Join(cfg.Output.Dir, "jobs")
+	if entries, err := os.ReadDir(jobsDir); err == nil {
+		for _, e := range entries {
+			if e.IsDir() && e.Name() != id {
+				dirs[filepath.Join(jobsDir, e.Name())] = true
+			}
+		}
+	}
+	m.mu.Lock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for other, j := range m.jobs {
+		if other != id {
+			jobs = append(jobs, j)
+		}
+	}
+	m.mu.Unlock()
+	for _, j := range jobs {
+		snap := j.Snapshot()
+		if snap.Dir == fd {
+			return true
+		}
+		if snap.WorkDir != "" {
+			dirs[snap.WorkDir] = true
+		}
+	}
+	for dir := range dirs {
+		if finalDir(cfg, dir) == fd {
+			return true
+		}
+	}
+	return false
+}
+
+// Archive packs the work dir, final dir and log of a finished job into
+// output/archive/<id>.tar.gz and returns the archive path
+func (m *Manager) Archive(cfg config.Config, id string) (string, error) {
+	if !validJobID(id) {
+		return "", ErrInvalidJobID
+	}
+	workDir := filepath.Join(cfg.Output.Dir, "jobs", id)
+	status := JobDone
+	j := m.Get(id)
+	if j != nil {
+		snap := j.Snapshot()
+		status = snap.Status
+		if snap.WorkDir != "" {
+			workDir = snap.WorkDir
+		}
+	}
+	if !fileExists(workDir) {
+		return "", os.ErrNotExist
+	}
+	if j == nil {
+		// a job from an earlier run: its status is only known from disk
+		p, _ := readProgress(workDir)
+		status = p.restoredStatus()
+	}
+	if status != JobDone {
+		return "", fmt.Errorf("%w: %s is %s", ErrJobNotFinished, id, status)
+	}
+	archiveDir := filepath.Join(cfg.Output.Dir, "archive")
+	if err := os.MkdirAll(archiveDir, 0o755); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(archiveDir, id+".tar.gz")
+	tmp := dst + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	err = addToTar(tw, workDir, filepath.Join(id, "work"))
+	if err == nil {
+		if fd := finalDir(cfg, workDir); fd != "" && fileExists(fd) {
+			err = addToTar(tw, fd, filepath.Join(id, "final"))
+		}
+	}
+	if err == nil {
+		if lp := filepath.Join(cfg.Output.Dir, "jobs", id+".log"); fileExists(lp) {
+			err = addToTar(tw, lp, filepath.Join(id, id+".log"))
+		}
+	}
+	if e := tw.Close(); err == nil {
+		err = e
+	}
+	if e := gz.Close(); err == nil {
+		err = e
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+func addToTar(tw *tar.Writer, src, prefix string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(filepath.Join(prefix, rel))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(tw, in)
+		return err
+	})
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ibreez3/ai-reader/config"
+)
+
+func TestArchiveChecksStatusOnDisk(t *testing.T) {
+	cfg := config.Config{}
+	cfg.Output.Dir = t.TempDir()
+	cases := []struct {
+		name     string
+		progress string
+		ok       bool
+	}{
+		{"done", `{"status":"completed"}`, true},
+		{"before statuses were recorded", `{"completed":1,"total":1}`, true},
+		{"awaiting approval", `{"status":"awaiting_approval","awaiting":"outline"}`, false},
+		{"paused", `{"status":"paused","awaiting":"characters"}`, false},
+		{"interrupted", `{"status":"running"}`, false},
+		{"failed", `{"status":"failed"}`, false},
+	}
+	for i, c := range cases {
+		id := "job-" + string(rune('a'+i))
+		dir := filepath.Join(cfg.Output.Dir, "jobs", id)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "progress.json"), []byte(c.progress), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := NewManager().Archive(cfg, id)
+		if c.ok && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if !c.ok && !errors.Is(err, ErrJobNotFinished) {
+			t.Errorf("%s: err = %v, want %v", c.name, err, ErrJobNotFinished)
+		}
+	}
+	if _, err := NewManager().Archive(cfg, "job-missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing job: err = %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+type ChapterInfo struct {
+	Index     int               `json:"index"`
+	Title     string            `json:"title"`
+	Words     int               `json:"words"`
+	Volume    int               `json:"volume,omitempty"`
+	Status    ChapterTaskStatus `json:"status"`
+	UpdatedAt *time.Time        `json:"updated_at,omitempty"`
+	URL       string            `json:"url"`
+}
+
+type ChapterDoc struct {
+	Index     int       `json:"index"`
+	Title     string    `json:"title"`
+	Words     int       `json:"words"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version,omitempty"`
+	Content   string    `json:"content"`
+	ETag      string    `json:"-"`
+}
+
+func ChapterURL(jobID string, index int) string {
+	return "/api/jobs/" + jobID + "/chapters/" + strconv.Itoa(index)
+}
+
+// ListChapters reports every planned chapter, plus chapter files that no
+// longer have a plan, with the state of its latest generation task
+func (m *Manager) ListChapters(cfg config.Config, id string) ([]ChapterInfo, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return nil, err
+	}
+	_, _, plans, _ := loadArtifacts(base)
+	chapDir := filepath.Join(base, "chapters")
+	files := novel.ChapterFiles(chapDir)
+	tasks := m.latestChapterTasks(id)
+	seen := map[int]bool{}
+	out := make([]ChapterInfo, 0, len(plans))
+	add := func(index int, title string) {
+		seen[index] = true
+		info := ChapterInfo{Index: index, Title: title, Status: ChapterPending, URL: ChapterURL(id, index)}
+		if name, ok := files[index]; ok {
+			info.Status = ChapterDone
+			if b, err := os.ReadFile(filepath.Join(chapDir, name)); err == nil {
+				t, body := novel.ParseChapterFile(b)
+				if t != "" {
+					info.Title = t
+				}
+				info.Words = novel.CountWords(body)
+			}
+			if fi, err := os.Stat(filepath.Join(chapDir, name)); err == nil {
+				mt := fi.ModTime()
+				info.UpdatedAt = &mt
+			}
+		}
+		if t, ok := tasks[index]; ok && (t.Status != ChapterDone || info.Status == ChapterPending) {
+			info.Status = t.Status
+			if info.UpdatedAt == nil || t.UpdatedAt.After(*info.UpdatedAt) {
+				ut := t.UpdatedAt
+				info.UpdatedAt = &ut
+			}
+		}
+		out = append(out, info)
+	}
+	for _, p := range plans {
+		add(p.Index, p.Title)
+		out[len(out)-1].Volume = p.Volume
+	}
+	for idx := range files {
+		if !seen[idx] {
+			add(idx, "")
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].Index < out[b].Index })
+	return out, nil
+}
+
+func (m *Manager) ReadChapter(cfg config.Config, id string, index int) (ChapterDoc, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return ChapterDoc{}, err
+	}
+	return readChapterDoc(base, index)
+}
+
+func readChapterDoc(base string, index int) (ChapterDoc, error) {
+	path, ok := novel.FindChapterFile(filepath.Join(base, "chapters"), index)
+	if !ok {
+		return ChapterDoc{}, os.ErrNotExist
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ChapterDoc{}, err
+	}
+	doc := ChapterDoc{Index: index, ETag: ETag(b)}
+	doc.Title, doc.Content = novel.ParseChapterFile(b)
+	doc.Words = novel.CountWords(doc.Content)
+	if fi, err := os.Stat(path); err == nil {
+		doc.UpdatedAt = fi.ModTime()
+	}
+	if h, err := novel.ReadChapterHistory(base, index); err == nil {
+		doc.Version = h.Current
+	}
+	return doc, nil
+}
+
+func (m *Manager) latestChapterTasks(jobID string) map[int]ChapterTaskSnapshot {
+	m.chMu.Lock()
+	defer m.chMu.Unlock()
+	out := map[int]ChapterTaskSnapshot{}
+	for _, t := range m.chapters {
+		if t.JobID != jobID {
+			continue
+		}
+		snap := t.Snapshot()
+		if cur, ok := out[snap.Chapter]; !ok || snap.CreatedAt.After(cur.CreatedAt) {
+			out[snap.Chapter] = snap
+		}
+	}
+	return out
+}
+
+// RenderArtifact turns a JSON artifact into a readable markdown document
+// headed and labelled in lang
+func RenderArtifact(lang, name string, b []byte) (string, error) {
+	t := novel.ArtifactLabels(lang)
+	var sb strings.Builder
+	switch name {
+	case "outline":
+		var o novel.Outline
+		if err := json.Unmarshal(b, &o); err != nil {
+			return "", err
+		}
+		sb.WriteString("# " + o.Title + "\n\n")
+		writeChapterList(&sb, o.Chapters)
+	case "plans":
+		var plans []novel.Chapter
+		if err := json.Unmarshal(b, &plans); err != nil {
+			return "", err
+		}
+		sb.WriteString("# " + t.Plans + "\n\n")
+		writeChapterList(&sb, plans)
+	case "characters":
+		var chars []novel.Character
+		if err := json.Unmarshal(b, &chars); err != nil {
+			return "", err
+		}
+		sb.WriteString("# " + t.Characters + "\n\n")
+		for _, c := range chars {
+			sb.WriteString("## " + c.Name + t.Parens[0] + c.Role + t.Parens[1] + "\n\n")
+			if len(c.Traits) > 0 {
+				sb.WriteString(t.Traits + t.Colon + strings.Join([]string(c.Traits), t.List) + "\n\n")
+			}
+			if c.Background != "" {
+				sb.WriteString(c.Background + "\n\n")
+			}
+		}
+	case novel.StageCharacterState:
+		var st novel.CharacterStates
+		if err := json.Unmarshal(b, &st); err != nil {
+			return "", err
+		}
+		sb.WriteString("# " + t.States + "\n\n")
+		for _, c := range st.Latest() {
+			sb.WriteString("## " + c.Name + t.Parens[0] + fmt.Sprintf(t.Chapter, c.Chapter) + t.Parens[1] + "\n\n")
+			if line := c.Text(lang); line != "" {
+				sb.WriteString(line + "\n\n")
+			}
+		}
+	case novel.StageTimeline:
+		var tl novel.Timeline
+		if err := json.Unmarshal(b, &tl); err != nil {
+			return "", err
+		}
+		sb.WriteString("# " + t.Timeline + "\n\n")
+		for _, e := range tl.Events {
+			sb.WriteString("- " + fmt.Sprintf(t.Day, e.Day) + t.Parens[0] + fmt.Sprintf(t.Chapter, e.Chapter))
+			if e.When != "" {
+				sb.WriteString(t.Sep + e.When)
+			}
+			if e.Flashback {
+				sb.WriteString(t.Sep + t.Flashback)
+			}
+			sb.WriteString(t.Parens[1] + t.Space + e.Event)
+			if e.Location != "" {
+				sb.WriteString(" @" + e.Location)
+			}
+			sb.WriteString("\n")
+		}
+	case novel.StageGlossary:
+		var gl novel.Glossary
+		if err := json.Unmarshal(b, &gl); err != nil {
+			return "", err
+		}
+		sb.WriteString("# " + t.Glossary + "\n\n")
+		for _, e := range gl.Entries {
+			sb.WriteString("- **" + e.Term + "**" + t.Parens[0] + e.Category + t.Parens[1])
+			if e.Definition != "" {
+				sb.WriteString(" " + e.Definition)
+			}
+			if len(e.Aliases) > 0 {
+				sb.WriteString(" " + t.Aliases + t.Colon + strings.Join(e.Aliases, t.List))
+			}
+			if len(e.Variants) > 0 {
+				sb.WriteString(" " + t.Variants + t.Colon + strings.Join(e.Variants, t.List))
+			}
+			sb.WriteString("\n")
+		}
+	default:
+		sb.WriteString("```json\n")
+		sb.Write(b)
+		sb.WriteString("\n```\n")
+	}
+	return sb.String(), nil
+}
+
+func writeChapterList(sb *strings.Builder, list []novel.Chapter) {
+	for _, c := range list {
+		sb.WriteString(fmt.Sprintf("%d. **%s** %s\n", c.Index, c.Title, c.Summary))
+	}
+}
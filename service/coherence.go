@@ -0,0 +1,43 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+// Coherence runs the checks that need no model call over a job's
+// artifacts and reports the problems tied to chapter indexes: the timeline
+// checks and near misses of glossary terms in the written chapters
+func (m *Manager) Coherence(cfg config.Config, id string) ([]novel.CoherenceIssue, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return nil, err
+	}
+	issues := []novel.CoherenceIssue{}
+	tl, err := novel.LoadTimeline(base)
+	if err != nil {
+		return nil, err
+	}
+	lang := jobSpec(base).Language
+	issues = append(issues, novel.CheckTimeline(tl, lang)...)
+	if _, characters, _, err := loadArtifacts(base); err == nil {
+		canon := novel.Canon{Language: lang, Characters: characters, Settings: loadSettings(base), Schema: loadSettingSchema(base)}
+		gl, err := novel.LoadGlossary(base, canon)
+		if err != nil {
+			return nil, err
+		}
+		dir := filepath.Join(base, "chapters")
+		for idx, name := range novel.ChapterFiles(dir) {
+			if b, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+				_, body := novel.ParseChapterFile(b)
+				issues = append(issues, gl.Check(idx, body, lang)...)
+			}
+		}
+	}
+	sort.SliceStable(issues, func(a, b int) bool { return issues[a].Chapter < issues[b].Chapter })
+	return issues, nil
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+// ExportOptions selects the output format and its format-specific settings
+type ExportOptions struct {
+	Format   string
+	TXT      novel.TXTOptions
+	PageSize string // overrides export.page_size for docx/pdf
+}
+
+type ExportFile struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// LoadBook reads the written chapters and artifacts of a job
+func (m *Manager) LoadBook(cfg config.Config, id string) (novel.Book, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return novel.Book{}, err
+	}
+	b, err := novel.LoadBook(base)
+	if err != nil {
+		return b, err
+	}
+	b.ID = id
+	if j := m.Get(id); j != nil {
+		b.Created = j.CreatedAt
+	}
+	return b, nil
+}
+
+func (m *Manager) Export(cfg config.Config, id string, opts ExportOptions) (ExportFile, error) {
+	b, err := m.LoadBook(cfg, id)
+	if err != nil {
+		return ExportFile{}, err
+	}
+	if len(b.Chapters) == 0 {
+		return ExportFile{}, invalidf("no chapters written yet")
+	}
+	var buf bytes.Buffer
+	out := ExportFile{Name: exportFileName(b.Outline.Title, id)}
+	format := strings.ToLower(opts.Format)
+	switch format {
+	case "epub":
+		err = novel.WriteEPUB(&buf, b)
+		out.Name += ".epub"
+		out.ContentType = "application/epub+zip"
+	case "txt":
+		opts.TXT.ForLanguage(b.Language)
+		if err := opts.TXT.Validate(); err != nil {
+			return ExportFile{}, invalidf("%v", err)
+		}
+		if len(b.Range(opts.TXT.From, opts.TXT.To).Chapters) == 0 {
+			return ExportFile{}, invalidf("no chapters in range %d-%d", opts.TXT.From, opts.TXT.To)
+		}
+		err = novel.WriteTXT(&buf, b, opts.TXT)
+		out.Name += ".txt"
+		out.ContentType = "text/plain; charset=" + opts.TXT.Encoding
+	case "docx", "pdf":
+		var mo novel.ManuscriptOptions
+		if mo, err = m.manuscriptOptions(cfg, opts.PageSize, format == "pdf"); err != nil {
+			return ExportFile{}, err
+		}
+		if format == "pdf" {
+			err = novel.WritePDF(&buf, b, mo)
+			out.ContentType = "application/pdf"
+		} else {
+			err = novel.WriteDOCX(&buf, b, mo)
+			out.ContentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+		}
+		out.Name += "." + format
+	default:
+		return ExportFile{}, invalidf("unsupported export format %q", opts.Format)
+	}
+	if err != nil {
+		return ExportFile{}, err
+	}
+	out.Data = buf.Bytes()
+	return out, nil
+}
+
+func exportFileName(title, id string) string {
+	name := sanitizeDirName(title)
+	if name == "" {
+		name = id
+	}
+	return name
+}
+
+// manuscriptOptions resolves page size and font from the config; the font
+// is parsed once per path since CJK fonts run to tens of megabytes
+func (m *Manager) manuscriptOptions(cfg config.Config, pageSize string, needFont bool) (novel.ManuscriptOptions, error) {
+	if pageSize == "" {
+		pageSize = cfg.Export.PageSize
+	}
+	ps, err := novel.ParsePageSize(pageSize)
+	if err != nil {
+		return novel.ManuscriptOptions{}, invalidf("%v", err)
+	}
+	mo := novel.ManuscriptOptions{PageSize: ps, FontSize: cfg.Export.FontSize}
+	if cfg.Export.Font == "" {
+		if needFont {
+			return mo, invalidf("PDF export needs export.font set to a TrueType font with CJK glyphs")
+		}
+		return mo, nil
+	}
+	m.fontMu.Lock()
+	defer m.fontMu.Unlock()
+	f, ok := m.fonts[cfg.Export.Font]
+	if !ok {
+		if f, err = novel.LoadFont(cfg.Export.Font); err != nil {
+			// not wrapped: a missing font file is a server problem, not a missing job
+			return mo, fmt.Errorf("load export font: %v", err)
+		}
+		m.fonts[cfg.Export.Font] = f
+	}
+	mo.Font = f
+	return mo, nil
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+// forkFiles are the job artifacts a fork starts from; plans are copied whole
+// so the chapters from the branch point on can be edited and regenerated
+var forkFiles = []struct{ name, stage string }{
+	{"spec.json", novel.StageSpec},
+	{"settings.json", novel.StageSettings},
+	{"characters.json", novel.StageCharacters},
+	{"plans.json", novel.StagePlans},
+	{"source.txt", novel.StageSource},
+	{novel.SeriesFile, novel.StageSeries},
+}
+
+// Fork creates a new job from the source job's artifacts and the chapters
+// before at. The fork gets its own title so that its final dir does not
+// overwrite the source book
+func (m *Manager) Fork(cfg config.Config, id string, at int) (*Job, error) {
+	src, err := m.jobDir(cfg, id)
+	if err != nil {
+		return nil, err
+	}
+	// busy is checked under artMu so a chapter task or planning cannot
+	// start while the artifacts are copied
+	m.artMu.Lock()
+	defer m.artMu.Unlock()
+	if m.busy(id) {
+		return nil, ErrJobBusy
+	}
+	outline, _, plans, err := loadArtifacts(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, invalidf("job %s has no outline, characters and plans to fork", id)
+		}
+		return nil, err
+	}
+	if at < 1 || at > len(plans) {
+		return nil, invalidf("at must be between 1 and %d", len(plans))
+	}
+	lang := loadJobSpec(cfg, src, outline).Language
+
+	forkID := fmt.Sprintf("job-%d", time.Now().UnixNano())
+	dst := filepath.Join(cfg.Output.Dir, "jobs", forkID)
+	if err := os.MkdirAll(filepath.Join(dst, "chapters"), 0o755); err != nil {
+		return nil, err
+	}
+	final := ""
+	fail := func(err error) (*Job, error) {
+		_ = os.RemoveAll(dst)
+		if final != "" {
+			_ = os.RemoveAll(final)
+		}
+		return nil, err
+	}
+	srcMan, _ := novel.ReadManifest(src)
+	record := func(rel, stage string) error {
+		p := novel.Provenance{Stage: stage, Origin: novel.OriginInput}
+		for _, e := range srcMan.Artifacts {
+			if e.Path == rel {
+				p = e.Provenance()
+			}
+		}
+		return novel.RecordArtifact(dst, rel, p)
+	}
+	for _, f := range forkFiles {
+		name := f.name
+		b, err := os.ReadFile(filepath.Join(src, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if err := os.WriteFile(filepath.Join(dst, name), b, 0o644); err != nil {
+			return fail(err)
+		}
+		if err := record(name, f.stage); err != nil {
+			return fail(err)
+		}
+	}
+	// the fork only keeps what its chapters established
+	if states, err := novel.LoadCharacterStates(src); err == nil && len(states.Updates) > 0 {
+		sb, _ := json.MarshalIndent(states.Until(at), "", "  ")
+		if err := os.WriteFile(filepath.Join(dst, novel.CharacterStateFile), sb, 0o644); err != nil {
+			return fail(err)
+		}
+		if err := novel.RecordArtifact(dst, novel.CharacterStateFile, novel.Provenance{Stage: novel.StageCharacterState, Origin: novel.OriginEdited}); err != nil {
+			return fail(err)
+		}
+	}
+	if ledger, err := novel.LoadThreads(src); err == nil && len(ledger.Threads) > 0 {
+		tb, _ := json.MarshalIndent(ledger.Until(at), "", "  ")
+		if err := os.WriteFile(filepath.Join(dst, novel.ThreadsFile), tb, 0o644); err != nil {
+			return fail(err)
+		}
+		if err := novel.RecordArtifact(dst, novel.ThreadsFile, novel.Provenance{Stage: novel.StageThreads, Origin: novel.OriginEdited}); err != nil {
+			return fail(err)
+		}
+	}
+	if tl, err := novel.LoadTimeline(src); err == nil && len(tl.Events) > 0 {
+		tb, _ := json.MarshalIndent(tl.Until(at), "", "  ")
+		if err := os.WriteFile(filepath.Join(dst, novel.TimelineFile), tb, 0o644); err != nil {
+			return fail(err)
+		}
+		if err := novel.RecordArtifact(dst, novel.TimelineFile, novel.Provenance{Stage: novel.StageTimeline, Origin: novel.OriginEdited}); err != nil {
+			return fail(err)
+		}
+	}
+	if gb, err := os.ReadFile(filepath.Join(src, novel.GlossaryFile)); err == nil {
+		var gl novel.Glossary
+		if err := json.Unmarshal(gb, &gl); err != nil {
+			return fail(err)
+		}
+		gb, _ = json.MarshalIndent(gl.Until(at), "", "  ")
+		if err := os.WriteFile(filepath.Join(dst, novel.GlossaryFile), gb, 0o644); err != nil {
+			return fail(err)
+		}
+		if err := novel.RecordArtifact(dst, novel.GlossaryFile, novel.Provenance{Stage: novel.StageGlossary, Origin: novel.OriginEdited}); err != nil {
+			return fail(err)
+		}
+	}
+	if err := copyPrompts(src, dst); err != nil {
+		return fail(err)
+	}
+	title := outline.Title
+	for n := 1; ; n++ {
+		outline.Title = novel.BranchTitle(lang, title, at, n)
+		if !fileExists(filepath.Join(cfg.Output.Dir, sanitizeDirName(outline.Title))) {
+			break
+		}
+	}
+	ob, _ := json.MarshalIndent(outline, "", "  ")
+	if err := os.WriteFile(filepath.Join(dst, "outline.json"), ob, 0o644); err != nil {
+		return fail(err)
+	}
+	if err := novel.RecordArtifact(dst, "outline.json", novel.Provenance{Stage: novel.StageOutline, Origin: novel.OriginEdited}); err != nil {
+		return fail(err)
+	}
+
+	if fd := finalDir(cfg, dst); fd != "" {
+		if err := os.MkdirAll(fd, 0o755); err != nil {
+			return fail(err)
+		}
+		final = fd
+	}
+	files := novel.ChapterFiles(filepath.Join(src, "chapters"))
+	copied := 0
+	for idx := 1; idx < at; idx++ {
+		name, ok := files[idx]
+		if !ok {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(src, "chapters", name))
+		if err != nil {
+			return fail(err)
+		}
+		if err := os.WriteFile(filepath.Join(dst, "chapters", name), b, 0o644); err != nil {
+			return fail(err)
+		}
+		if err := record("chapters/"+name, novel.StageChapter); err != nil {
+			return fail(err)
+		}
+		if err := novel.CopyChapterHistory(src, dst, idx); err != nil {
+			return fail(err)
+		}
+		if final != "" {
+			_ = os.WriteFile(filepath.Join(final, name), b, 0o644)
+		}
+		copied++
+	}
+	if err := novel.SetManifestParent(dst, novel.ManifestParent{Job: id, At: at, ForkedAt: time.Now().UTC()}); err != nil {
+		return fail(err)
+	}
+	_ = refreshProgress(dst)
+
+	var spec novel.Spec
+	if b, err := os.ReadFile(filepath.Join(dst, "spec.json")); err == nil {
+		_ = json.Unmarshal(b, &spec)
+	} else if j := m.Get(id); j != nil {
+		spec = j.Spec()
+	}
+	j := &Job{ID: forkID, CreatedAt: time.Now(), st: newState(JobDone, fmt.Sprintf("forked from %s at chapter %d", id, at)), completed: copied, total: len(plans), workDir: dst, dir: final, spec: spec}
+	if jl, err := NewJobLogger(cfg.Output.Dir, forkID); err == nil {
+		j.logPath = jl.Path()
+		jl.Log(fmt.Sprintf("[分支] 来源=%s 分支点=第%d章 复制章节=%d", id, at, copied))
+	}
+	j.persistStatus()
+	m.mu.Lock()
+	m.jobs[forkID] = j
+	m.mu.Unlock()
+	return j, nil
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+func TestFork(t *testing.T) {
+	cases := []struct {
+		name    string
+		lang    string
+		at      int
+		forks   int
+		title   string
+		written []string
+	}{
+		{"first branch", "", 3, 1, "书（第3章分支）", []string{"text a", "text b"}},
+		{"second branch at the same chapter", "", 2, 2, "书（第2章分支2）", []string{"text a"}},
+		{"english book", novel.LangEnglish, 2, 1, "书 (branch at chapter 2)", []string{"text a"}},
+		{"branch at the first chapter", "", 1, 1, "书（第1章分支）", nil},
+	}
+	for _, c := range cases {
+		cfg, id := chapterJob(t, "a", "b", "c")
+		base := filepath.Join(cfg.Output.Dir, "jobs", id)
+		if err := os.WriteFile(filepath.Join(base, "characters.json"), []byte("[]"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := persistJobSpec(base, novel.Spec{Topic: "书", Language: c.lang}); err != nil {
+			t.Fatal(err)
+		}
+		series, _ := json.Marshal(novel.Series{Name: "系列", World: "九州"})
+		if err := os.WriteFile(filepath.Join(base, novel.SeriesFile), series, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		m := NewManager()
+		var j *Job
+		for n := 0; n < c.forks; n++ {
+			var err error
+			if j, err = m.Fork(cfg, id, c.at); err != nil {
+				t.Fatalf("%s: fork %d: %v", c.name, n+1, err)
+			}
+		}
+		dst := j.Snapshot().WorkDir
+		outline, _, plans, err := loadArtifacts(dst)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if outline.Title != c.title || len(plans) != 3 {
+			t.Errorf("%s: fork %q with %d plans, want %q with 3", c.name, outline.Title, len(plans), c.title)
+		}
+		if s := loadSeries(dst); s == nil || s.World != "九州" {
+			t.Errorf("%s: fork lost the series canon: %+v", c.name, s)
+		}
+		var written []string
+		files := novel.ChapterFiles(filepath.Join(dst, "chapters"))
+		for i := 1; i <= 3; i++ {
+			if name, ok := files[i]; ok {
+				b, _ := os.ReadFile(filepath.Join(dst, "chapters", name))
+				_, body := novel.ParseChapterFile(b)
+				written = append(written, body)
+			}
+		}
+		if !reflect.DeepEqual(written, c.written) {
+			t.Errorf("%s: fork chapters = %q, want %q", c.name, written, c.written)
+		}
+		if final := novel.ChapterFiles(filepath.Join(cfg.Output.Dir, sanitizeDirName(c.title))); len(final) != len(c.written) {
+			t.Errorf("%s: final dir holds %d chapters, want %d", c.name, len(final), len(c.written))
+		}
+		if got := chapterState(t, cfg, id)["书"]; len(got) != 3 {
+			t.Errorf("%s: source final dir changed: %v", c.name, got)
+		}
+	}
+}
+
+func TestForkRejects(t *testing.T) {
+	cases := []struct {
+		name    string
+		id      string
+		at      int
+		prepare func(m *Manager)
+		err     func(error) bool
+	}{
+		{"branch point past the plans", "job-edit", 3, nil, isValidation},
+		{"branch point before the first chapter", "job-edit", 0, nil, isValidation},
+		{"job planning", "job-edit", 1, func(m *Manager) { m.startPlanning("job-edit") }, func(err error) bool { return errors.Is(err, ErrJobBusy) }},
+		{"unknown job", "job-missing", 1, nil, func(err error) bool { return errors.Is(err, os.ErrNotExist) }},
+	}
+	for _, c := range cases {
+		cfg, _ := chapterJob(t, "a", "b")
+		base := filepath.Join(cfg.Output.Dir, "jobs", "job-edit")
+		if err := os.WriteFile(filepath.Join(base, "characters.json"), []byte("[]"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		m := NewManager()
+		if c.prepare != nil {
+			c.prepare(m)
+		}
+		if _, err := m.Fork(cfg, c.id, c.at); !c.err(err) {
+			t.Errorf("%s: err = %v", c.name, err)
+		}
+		if jobs, _ := os.ReadDir(filepath.Join(cfg.Output.Dir, "jobs")); len(jobs) != 1 {
+			t.Errorf("%s: rejected fork left %d job dirs", c.name, len(jobs))
+		}
+	}
+}
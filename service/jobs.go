@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -15,83 +16,336 @@ import (
 	"github.com/ibreez3/ai-reader/openai"
 )
 
-type JobStatus string
-
-const (
-	JobPending JobStatus = "pending"
-	JobRunning JobStatus = "running"
-	JobDone    JobStatus = "completed"
-	JobFailed  JobStatus = "failed"
-)
-
+// Job is mutated by its worker goroutine and read by the HTTP handlers;
+// readers must go through Snapshot
 type Job struct {
 	ID        string
-	Status    JobStatus
 	CreatedAt time.Time
-	UpdatedAt time.Time
-	Completed int
-	Total     int
-	Dir       string
-	Error     string
-	LogPath   string
-	WorkDir   string
+
+	mu        sync.RWMutex
+	st        state
+	completed int
+	total     int
+	dir       string
+	err       string
+	logPath   string
+	workDir   string
+	awaiting  string
+	pausing   bool
+	spec      novel.Spec
+	cancel    context.CancelFunc
+	// workers counts the goroutines writing to the work dir; Delete waits
+	// for them before removing it
+	workers sync.WaitGroup
+}
+
+type JobSnapshot struct {
+	ID        string       `json:"id"`
+	Status    JobStatus    `json:"status"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	Completed int          `json:"completed"`
+	Total     int          `json:"total"`
+	Dir       string       `json:"dir"`
+	Error     string       `json:"error"`
+	LogPath   string       `json:"log"`
+	WorkDir   string       `json:"work_dir"`
+	Awaiting  string       `json:"awaiting_stage,omitempty"`
+	Pausing   bool         `json:"pausing,omitempty"`
+	Gates     []string     `json:"gates,omitempty"`
+	History   []Transition `json:"history"`
+}
+
+func newJob(id string, total int) *Job {
+	now := time.Now()
+	return &Job{ID: id, CreatedAt: now, st: newState(JobPending, "created"), total: total}
+}
+
+func (j *Job) Snapshot() JobSnapshot {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return JobSnapshot{
+		ID:        j.ID,
+		Status:    j.st.status,
+		CreatedAt: j.CreatedAt,
+		UpdatedAt: j.st.updatedAt,
+		Completed: j.completed,
+		Total:     j.total,
+		Dir:       j.dir,
+		Error:     j.err,
+		LogPath:   j.logPath,
+		WorkDir:   j.workDir,
+		Awaiting:  j.awaiting,
+		Pausing:   j.pausing,
+		Gates:     append([]string(nil), j.spec.Gates...),
+		History:   j.st.historyCopy(),
+	}
+}
+
+func (j *Job) Status() JobStatus {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.st.status
+}
+
+func (j *Job) transition(to JobStatus, reason string) error {
+	j.mu.Lock()
+	if err := j.st.move(to, reason); err != nil {
+		j.mu.Unlock()
+		return err
+	}
+	if to == JobFailed {
+		j.err = reason
+	}
+	j.mu.Unlock()
+	j.persistStatus()
+	return nil
+}
+
+// await parks the job at an approval gate
+func (j *Job) await(stage string) error {
+	j.mu.Lock()
+	if err := j.st.move(JobAwaitingApproval, "awaiting approval after "+stage); err != nil {
+		j.mu.Unlock()
+		return err
+	}
+	j.awaiting = stage
+	j.mu.Unlock()
+	j.persistStatus()
+	return nil
+}
+
+// approve queues a job waiting at stage again
+func (j *Job) approve(stage string) error {
+	j.mu.Lock()
+	if j.st.status != JobAwaitingApproval {
+		j.mu.Unlock()
+		return &TransitionError{From: j.st.status, To: JobQueued}
+	}
+	if j.awaiting != stage {
+		j.mu.Unlock()
+		return invalidf("job is waiting for %s approval, not %s", j.awaiting, stage)
+	}
+	if err := j.st.move(JobQueued, "approved "+stage); err != nil {
+		j.mu.Unlock()
+		return err
+	}
+	j.awaiting = ""
+	j.mu.Unlock()
+	j.persistStatus()
+	return nil
+}
+
+// requestPause asks a running job to stop after the stage it is generating
+func (j *Job) requestPause() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.st.status != JobRunning {
+		return &TransitionError{From: j.st.status, To: JobPaused}
+	}
+	j.pausing = true
+	return nil
+}
+
+func (j *Job) pauseRequested() bool {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.pausing
+}
+
+// pause parks the job after stage; like an approval gate the stage is kept
+// in awaiting so that resume continues after it
+func (j *Job) pause(stage string) error {
+	j.mu.Lock()
+	j.pausing = false
+	if err := j.st.move(JobPaused, "paused after "+stage); err != nil {
+		j.mu.Unlock()
+		return err
+	}
+	j.awaiting = stage
+	j.mu.Unlock()
+	j.persistStatus()
+	return nil
+}
+
+// resume queues a paused job again and returns the stage it stopped after
+func (j *Job) resume() (string, error) {
+	j.mu.Lock()
+	if j.st.status != JobPaused {
+		j.mu.Unlock()
+		return "", &TransitionError{From: j.st.status, To: JobQueued}
+	}
+	stage := j.awaiting
+	if err := j.st.move(JobQueued, "resumed after "+stage); err != nil {
+		j.mu.Unlock()
+		return "", err
+	}
+	j.awaiting = ""
+	j.mu.Unlock()
+	j.persistStatus()
+	return stage, nil
+}
+
+// persistStatus records the job's status and the gate it waits at in
+// progress.json so that they survive a restart; jobs without a work dir on
+// disk are skipped
+func (j *Job) persistStatus() {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	j.mu.RLock()
+	dir, status, awaiting := j.workDir, j.st.status, j.awaiting
+	j.mu.RUnlock()
+	if dir == "" || !fileExists(dir) {
+		return
+	}
+	p, _ := readProgress(dir)
+	p.Status, p.Awaiting = status, awaiting
+	_ = writeProgress(dir, p)
+}
+
+func (j *Job) Spec() novel.Spec {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.spec
+}
+
+func (j *Job) setProgress(completed, total int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.completed = completed
+	j.total = total
+	j.st.touch()
+}
+
+func (j *Job) setWorkDir(dir string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.workDir = dir
+}
+
+func (j *Job) setLogPath(p string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.logPath = p
+}
+
+func (j *Job) setCancel(cancel context.CancelFunc) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.cancel = cancel
 }
 
 type Manager struct {
-    mu   sync.Mutex
-    jobs map[string]*Job
-    chMu sync.Mutex
-    chapters map[string]*ChapterTask
+	mu         sync.Mutex
+	jobs       map[string]*Job
+	chMu       sync.Mutex
+	chapters   map[string]*ChapterTask
+	artMu      sync.Mutex
+	fontMu     sync.Mutex
+	fonts      map[string]*novel.Font
+	projMu     sync.Mutex
+	concluding map[string]bool
+	planMu     sync.Mutex
+	planning   map[string]bool
 }
 
 func NewManager() *Manager {
-	return &Manager{jobs: map[string]*Job{}, chapters: map[string]*ChapterTask{}}
+	return &Manager{jobs: map[string]*Job{}, chapters: map[string]*ChapterTask{}, fonts: map[string]*novel.Font{}, concluding: map[string]bool{}, planning: map[string]bool{}}
 }
 
 func (m *Manager) Get(id string) *Job {
-    m.mu.Lock()
-    defer m.mu.Unlock()
-    return m.jobs[id]
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jobs[id]
+}
+
+// Cancel stops a job that has not reached a terminal state yet
+func (m *Manager) Cancel(id string, reason string) error {
+	j := m.Get(id)
+	if j == nil {
+		return os.ErrNotExist
+	}
+	if reason == "" {
+		reason = "cancelled by user"
+	}
+	if err := j.transition(JobCancelled, reason); err != nil {
+		return err
+	}
+	j.mu.RLock()
+	cancel := j.cancel
+	j.mu.RUnlock()
+	if cancel != nil {
+		cancel()
+	}
+	return nil
 }
 
 func (m *Manager) LoadJobFromDisk(cfg config.Config, id string) (*Job, error) {
-    base := filepath.Join(cfg.Output.Dir, "jobs", id)
-    if _, err := os.Stat(base); err != nil { return nil, err }
-    bOutline, err := os.ReadFile(filepath.Join(base, "outline.json"))
-    if err != nil { return nil, err }
-    var outline novel.Outline
-    if err := json.Unmarshal(bOutline, &outline); err != nil { return nil, err }
-    plansPath := filepath.Join(base, "plans.json")
-    var total int
-    if bPlans, err := os.ReadFile(plansPath); err == nil {
-        var plans []novel.Chapter
-        if e := json.Unmarshal(bPlans, &plans); e == nil { total = len(plans) }
-    }
-    comp := 0
-    chapDir := filepath.Join(base, "chapters")
-    if files, err := os.ReadDir(chapDir); err == nil {
-        for _, f := range files { if !f.IsDir() && strings.HasSuffix(f.Name(), ".md") { comp++ } }
-    }
-    j := &Job{ID: id, Status: JobDone, CreatedAt: time.Now(), UpdatedAt: time.Now(), Completed: comp, Total: total, WorkDir: base}
-    j.Dir = filepath.Join(cfg.Output.Dir, sanitizeDirName(outline.Title))
-    m.mu.Lock(); m.jobs[id] = j; m.mu.Unlock()
-    return j, nil
+	base := filepath.Join(cfg.Output.Dir, "jobs", id)
+	fi, err := os.Stat(base)
+	if err != nil {
+		return nil, err
+	}
+	bOutline, err := os.ReadFile(filepath.Join(base, "outline.json"))
+	if err != nil {
+		return nil, err
+	}
+	var outline novel.Outline
+	if err := json.Unmarshal(bOutline, &outline); err != nil {
+		return nil, err
+	}
+	plansPath := filepath.Join(base, "plans.json")
+	var total int
+	if bPlans, err := os.ReadFile(plansPath); err == nil {
+		var plans []novel.Chapter
+		if e := json.Unmarshal(bPlans, &plans); e == nil {
+			total = len(plans)
+		}
+	}
+	if len(outline.Volumes) > 0 {
+		total = outline.TotalChapters()
+	}
+	comp := 0
+	chapDir := filepath.Join(base, "chapters")
+	if files, err := os.ReadDir(chapDir); err == nil {
+		for _, f := range files {
+			if !f.IsDir() && strings.HasSuffix(f.Name(), ".md") {
+				comp++
+			}
+		}
+	}
+	p, _ := readProgress(base)
+	status := p.restoredStatus()
+	j := &Job{ID: id, CreatedAt: fi.ModTime(), st: newState(status, "restored from disk"), completed: comp, total: total, workDir: base, spec: jobSpec(base)}
+	switch {
+	case status == JobAwaitingApproval, status == JobPaused:
+		j.awaiting = p.Awaiting
+	case status == JobFailed && p.Status != JobFailed:
+		j.err = "interrupted by a restart"
+	}
+	j.dir = filepath.Join(cfg.Output.Dir, sanitizeDirName(outline.Title))
+	m.mu.Lock()
+	m.jobs[id] = j
+	m.mu.Unlock()
+	return j, nil
 }
 
 func sanitizeDirName(s string) string {
-    s = strings.TrimSpace(s)
-    s = strings.ReplaceAll(s, " ", "_")
-    s = strings.ReplaceAll(s, "/", "-")
-    return s
+	s = strings.TrimSpace(s)
+	s = strings.ReplaceAll(s, " ", "_")
+	s = strings.ReplaceAll(s, "/", "-")
+	return s
 }
 
-type ChapterTaskStatus string
+type ChapterTaskStatus = JobStatus
 
 const (
-	ChapterPending ChapterTaskStatus = "pending"
-	ChapterRunning ChapterTaskStatus = "running"
-	ChapterDone    ChapterTaskStatus = "completed"
-	ChapterFailed  ChapterTaskStatus = "failed"
+	ChapterPending   = JobPending
+	ChapterQueued    = JobQueued
+	ChapterRunning   = JobRunning
+	ChapterDone      = JobDone
+	ChapterFailed    = JobFailed
+	ChapterCancelled = JobCancelled
 )
 
 type ChapterTask struct {
@@ -100,23 +354,127 @@ type ChapterTask struct {
 	Chapter     int
 	Words       int
 	Instruction string
-	Status      ChapterTaskStatus
-	Path        string
-	Error       string
 	CreatedAt   time.Time
-	UpdatedAt   time.Time
+
+	mu     sync.RWMutex
+	st     state
+	path   string
+	err    string
+	cancel context.CancelFunc
+}
+
+type ChapterTaskSnapshot struct {
+	ID          string            `json:"id"`
+	JobID       string            `json:"job_id"`
+	Chapter     int               `json:"chapter"`
+	Words       int               `json:"words"`
+	Instruction string            `json:"instruction"`
+	Status      ChapterTaskStatus `json:"status"`
+	Path        string            `json:"path"`
+	Error       string            `json:"error"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	History     []Transition      `json:"history"`
+}
+
+func (t *ChapterTask) Snapshot() ChapterTaskSnapshot {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return ChapterTaskSnapshot{
+		ID:          t.ID,
+		JobID:       t.JobID,
+		Chapter:     t.Chapter,
+		Words:       t.Words,
+		Instruction: t.Instruction,
+		Status:      t.st.status,
+		Path:        t.path,
+		Error:       t.err,
+		CreatedAt:   t.CreatedAt,
+		UpdatedAt:   t.st.updatedAt,
+		History:     t.st.historyCopy(),
+	}
+}
+
+func (t *ChapterTask) transition(to ChapterTaskStatus, reason string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.st.move(to, reason); err != nil {
+		return err
+	}
+	if to == ChapterFailed {
+		t.err = reason
+	}
+	return nil
+}
+
+func (t *ChapterTask) complete(path string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.st.move(ChapterDone, "chapter written"); err != nil {
+		return err
+	}
+	t.path = path
+	return nil
+}
+
+func (t *ChapterTask) setCancel(cancel context.CancelFunc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cancel = cancel
 }
 
 func (m *Manager) StartChapterTask(cfg config.Config, j *Job, chapter int, words int, instruction string) (*ChapterTask, error) {
+	switch snap := j.Snapshot(); snap.Status {
+	case JobAwaitingApproval:
+		return nil, fmt.Errorf("%w: %s", ErrAwaitingApproval, snap.Awaiting)
+	case JobPaused:
+		return nil, fmt.Errorf("%w: paused after %s", ErrJobNotFinished, snap.Awaiting)
+	}
 	id := fmt.Sprintf("chap-%d", time.Now().UnixNano())
-	t := &ChapterTask{ID: id, JobID: j.ID, Chapter: chapter, Words: words, Instruction: instruction, Status: ChapterPending, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	t := &ChapterTask{ID: id, JobID: j.ID, Chapter: chapter, Words: words, Instruction: instruction, CreatedAt: time.Now(), st: newState(ChapterPending, "created")}
+	// artifact edits check for running tasks under artMu
+	m.artMu.Lock()
+	if m.isPlanning(j.ID) {
+		m.artMu.Unlock()
+		return nil, ErrJobBusy
+	}
 	m.chMu.Lock()
 	m.chapters[id] = t
 	m.chMu.Unlock()
+	err := t.transition(ChapterQueued, "dispatched")
+	m.artMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	j.workers.Add(1)
 	go m.runChapterTask(cfg, j, t)
 	return t, nil
 }
 
+// cancelChapterTasks stops the chapter tasks of a job that are still
+// queued or running
+func (m *Manager) cancelChapterTasks(jobID, reason string) {
+	m.chMu.Lock()
+	tasks := []*ChapterTask{}
+	for _, t := range m.chapters {
+		if t.JobID == jobID {
+			tasks = append(tasks, t)
+		}
+	}
+	m.chMu.Unlock()
+	for _, t := range tasks {
+		if t.transition(ChapterCancelled, reason) != nil {
+			continue
+		}
+		t.mu.RLock()
+		cancel := t.cancel
+		t.mu.RUnlock()
+		if cancel != nil {
+			cancel()
+		}
+	}
+}
+
 func (m *Manager) GetChapterTask(id string) *ChapterTask {
 	m.chMu.Lock()
 	defer m.chMu.Unlock()
@@ -124,257 +482,430 @@ func (m *Manager) GetChapterTask(id string) *ChapterTask {
 }
 
 func (m *Manager) runChapterTask(cfg config.Config, j *Job, t *ChapterTask) {
-	t.Status = ChapterRunning
-	t.UpdatedAt = time.Now()
-	base := j.WorkDir
-	if base == "" {
-		base = filepath.Join(cfg.Output.Dir, "jobs", j.ID)
+	defer j.workers.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.JobTimeoutMin)*time.Minute)
+	defer cancel()
+	t.setCancel(cancel)
+	if err := t.transition(ChapterRunning, "generation started"); err != nil {
+		return
 	}
-	bOutline, err := os.ReadFile(filepath.Join(base, "outline.json"))
+	path, err := m.GenerateChapter(ctx, cfg, j, t.Chapter, t.Words, t.Instruction)
 	if err != nil {
-		t.Status = ChapterFailed
-		t.Error = err.Error()
-		t.UpdatedAt = time.Now()
+		_ = t.transition(ChapterFailed, err.Error())
 		return
 	}
-	var outline novel.Outline
-	if err := json.Unmarshal(bOutline, &outline); err != nil {
-		t.Status = ChapterFailed
-		t.Error = err.Error()
-		t.UpdatedAt = time.Now()
-		return
+	_ = t.complete(path)
+}
+
+func (m *Manager) Start(cfg config.Config, spec novel.Spec) (*Job, error) {
+	return m.start(cfg, spec, "")
+}
+
+func (m *Manager) StartFromSource(cfg config.Config, spec novel.Spec, source string) (*Job, error) {
+	return m.start(cfg, spec, source)
+}
+
+func (m *Manager) start(cfg config.Config, spec novel.Spec, source string) (*Job, error) {
+	for _, g := range spec.Gates {
+		if !novel.ValidStage(g) {
+			return nil, invalidf("unknown gate %s", g)
+		}
 	}
-	bChars, err := os.ReadFile(filepath.Join(base, "characters.json"))
+	lang, err := novel.NormalizeLanguage(spec.Language)
 	if err != nil {
-		t.Status = ChapterFailed
-		t.Error = err.Error()
-		t.UpdatedAt = time.Now()
-		return
+		return nil, invalidf("%s", err.Error())
 	}
-	var characters []novel.Character
-	if err := json.Unmarshal(bChars, &characters); err != nil {
-		t.Status = ChapterFailed
-		t.Error = err.Error()
-		t.UpdatedAt = time.Now()
-		return
+	spec.Language = lang
+	if t := spec.Temperature; t != nil && (*t < 0 || *t > 2) {
+		return nil, invalidf("temperature must be between 0 and 2")
 	}
-	bPlans, err := os.ReadFile(filepath.Join(base, "plans.json"))
+	if spec.Preset != "" {
+		if _, ok := novel.LookupPreset(spec.Preset); !ok {
+			return nil, invalidf("unknown preset %s", spec.Preset)
+		}
+	}
+	id := fmt.Sprintf("job-%d", time.Now().UnixNano())
+	workDir := filepath.Join(cfg.Output.Dir, "jobs", id)
+	if err := os.MkdirAll(workDir, 0o755); err != nil {
+		return nil, err
+	}
+	if spec.Project != "" {
+		series, err := m.addProjectBook(cfg, spec.Project, id)
+		if err != nil {
+			_ = os.RemoveAll(workDir)
+			return nil, err
+		}
+		if err := persistSeries(workDir, series); err != nil {
+			_ = os.RemoveAll(workDir)
+			_ = m.removeProjectBook(cfg, spec.Project, id)
+			return nil, err
+		}
+	}
+	j := newJob(id, spec.Chapters)
+	j.spec = mergeSpecDefaults(cfg, spec)
+	j.workDir = workDir
+	m.mu.Lock()
+	m.jobs[id] = j
+	m.mu.Unlock()
+	if err := j.transition(JobQueued, "dispatched"); err != nil {
+		return nil, err
+	}
+	j.workers.Add(1)
+	go m.runJob(cfg, j, source, "")
+	return j, nil
+}
+
+// Approve resumes a job waiting at stage; artifacts are re-read from disk
+// so edits made while waiting are used by the remaining stages. Jobs that
+// waited across a restart are loaded from their work dir
+func (m *Manager) Approve(cfg config.Config, id, stage string) error {
+	j, err := m.stoppedJob(cfg, id)
 	if err != nil {
-		t.Status = ChapterFailed
-		t.Error = err.Error()
-		t.UpdatedAt = time.Now()
-		return
+		return err
 	}
-	var plans []novel.Chapter
-	if err := json.Unmarshal(bPlans, &plans); err != nil {
-		t.Status = ChapterFailed
-		t.Error = err.Error()
-		t.UpdatedAt = time.Now()
-		return
+	// planning checks for a queued job under artMu
+	m.artMu.Lock()
+	if m.isPlanning(id) {
+		m.artMu.Unlock()
+		return ErrJobBusy
 	}
-	var plan novel.Chapter
-	for _, p := range plans {
-		if p.Index == t.Chapter {
-			plan = p
-			break
-		}
+	err = j.approve(stage)
+	m.artMu.Unlock()
+	if err != nil {
+		return err
 	}
-	if plan.Index == 0 {
-		t.Status = ChapterFailed
-		t.Error = "chapter plan not found"
-		t.UpdatedAt = time.Now()
-		return
+	m.continueJob(cfg, j, stage)
+	return nil
+}
+
+// Pause stops a running job after the artifact stage it is generating. The
+// job stays running until that stage is persisted; a job already past its
+// last stage completes instead
+func (m *Manager) Pause(id string) error {
+	j := m.Get(id)
+	if j == nil {
+		return os.ErrNotExist
+	}
+	return j.requestPause()
+}
+
+// Resume continues a paused job from the stage after the one it stopped at,
+// reading the artifacts back from disk like Approve
+func (m *Manager) Resume(cfg config.Config, id string) error {
+	j, err := m.stoppedJob(cfg, id)
+	if err != nil {
+		return err
 	}
-	prior := []novel.ChapterContent{}
-	if t.Chapter > 1 {
-		cd := filepath.Join(base, "chapters")
-		files, _ := os.ReadDir(cd)
-		for i := 1; i < t.Chapter; i++ {
-			for _, f := range files {
-				name := f.Name()
-				if strings.HasPrefix(name, fmt.Sprintf("%02d_", i)) {
-					path := filepath.Join(cd, name)
-					data, _ := os.ReadFile(path)
-					prior = append(prior, novel.ChapterContent{Index: i, Title: name, Content: string(data)})
-					break
-				}
-			}
-		}
+	m.artMu.Lock()
+	if m.isPlanning(id) {
+		m.artMu.Unlock()
+		return ErrJobBusy
 	}
-	cli := openai.NewClient(cfg.OpenAI.APIKey, cfg.OpenAI.BaseURL)
-	gen := novel.NewGenerator(cli).WithPersistDir(base).WithFinalBaseDir(cfg.Output.Dir)
-	spec := novel.Spec{Topic: outline.Title, Language: "zh", Model: cfg.OpenAI.Model, Words: t.Words, Instruction: t.Instruction}
-	canon := novel.BuildCanon(spec, outline, characters, novel.Settings{})
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.JobTimeoutMin)*time.Minute)
-	defer cancel()
-	c, err := gen.GenerateChapterWithHistory(ctx, spec, canon, plan, prior)
+	stage, err := j.resume()
+	m.artMu.Unlock()
 	if err != nil {
-		t.Status = ChapterFailed
-		t.Error = err.Error()
-		t.UpdatedAt = time.Now()
-		return
+		return err
 	}
-	name := fmt.Sprintf("%02d_%s.md", c.Index, sanitizeFileName(c.Title))
-	t.Path = filepath.Join(base, "chapters", name)
-	t.Status = ChapterDone
-	t.UpdatedAt = time.Now()
+	m.continueJob(cfg, j, stage)
+	return nil
 }
 
-func (m *Manager) Start(cfg config.Config, spec novel.Spec) (*Job, error) {
-	id := fmt.Sprintf("job-%d", time.Now().UnixNano())
-	j := &Job{ID: id, Status: JobPending, CreatedAt: time.Now(), UpdatedAt: time.Now(), Completed: 0, Total: spec.Chapters}
-	m.mu.Lock()
-	m.jobs[id] = j
-	m.mu.Unlock()
-	go m.runJob(cfg, spec, j)
+// stoppedJob finds a job that may have stopped before a restart
+func (m *Manager) stoppedJob(cfg config.Config, id string) (*Job, error) {
+	if j := m.Get(id); j != nil {
+		return j, nil
+	}
+	if !validJobID(id) {
+		return nil, ErrInvalidJobID
+	}
+	j, err := m.LoadJobFromDisk(cfg, id)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
 	return j, nil
 }
 
-func (m *Manager) StartFromSource(cfg config.Config, spec novel.Spec, source string) (*Job, error) {
-	id := fmt.Sprintf("job-%d", time.Now().UnixNano())
-	j := &Job{ID: id, Status: JobPending, CreatedAt: time.Now(), UpdatedAt: time.Now(), Completed: 0, Total: spec.Chapters}
-	m.mu.Lock()
-	m.jobs[id] = j
-	m.mu.Unlock()
-	go m.runJobFromSource(cfg, spec, source, j)
-	return j, nil
+func (m *Manager) continueJob(cfg config.Config, j *Job, after string) {
+	source := ""
+	if b, err := os.ReadFile(filepath.Join(jobWorkDir(cfg, j), "source.txt")); err == nil {
+		source = string(b)
+	}
+	j.workers.Add(1)
+	go m.runJob(cfg, j, source, after)
 }
 
-func (m *Manager) runJob(cfg config.Config, spec novel.Spec, j *Job) {
-	j.Status = JobRunning
-	j.UpdatedAt = time.Now()
+// runJob produces the job artifacts, from the beginning when after is
+// empty or from the stage following an approved gate
+func (m *Manager) runJob(cfg config.Config, j *Job, source string, after string) {
+	defer j.workers.Done()
+	reason := "generation started"
+	if after != "" {
+		reason = "resumed after " + after
+	}
+	if err := j.transition(JobRunning, reason); err != nil {
+		return
+	}
 	jl, err := NewJobLogger(cfg.Output.Dir, j.ID)
 	if err == nil {
-		j.LogPath = jl.Path()
-		jl.Log("[任务开始] 生成小说任务启动")
+		j.setLogPath(jl.Path())
+		switch {
+		case after != "":
+			jl.Log(fmt.Sprintf("[任务继续] 从 %s 之后继续生成", after))
+		case source != "":
+			jl.Log("[任务开始] 使用来源文本生成小说")
+		default:
+			jl.Log("[任务开始] 生成小说任务启动")
+		}
 	}
-	j.WorkDir = filepath.Join(cfg.Output.Dir, "jobs", j.ID)
-	_ = os.MkdirAll(j.WorkDir, 0o755)
-	cli := openai.NewClient(cfg.OpenAI.APIKey, cfg.OpenAI.BaseURL)
-	gen := novel.NewGenerator(cli)
+	workDir := filepath.Join(cfg.Output.Dir, "jobs", j.ID)
+	j.setWorkDir(workDir)
+	_ = os.MkdirAll(workDir, 0o755)
+	merged := j.Spec()
+	gen := novel.NewGenerator(newClient(cfg, merged))
 	if err == nil {
 		gen.WithLogger(jl.Log)
 	}
-	gen.WithPersistDir(j.WorkDir)
 	timeoutMin := cfg.Server.JobTimeoutMin
 	if timeoutMin <= 0 {
 		timeoutMin = 60
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutMin)*time.Minute)
 	defer cancel()
-	merged := mergeSpecDefaults(cfg, spec)
-	if jl != nil {
-		jl.Log(fmt.Sprintf("[参数] topic=%s chapters=%d words=%d model=%s preset=%s", merged.Topic, merged.Chapters, merged.Words, merged.Model, merged.Preset))
+	j.setCancel(cancel)
+	if j.Status() == JobCancelled {
+		return
+	}
+	if after == "" {
+		if jl != nil {
+			jl.Log(fmt.Sprintf("[参数] topic=%s chapters=%d words=%d model=%s preset=%s", merged.Topic, merged.Chapters, merged.Words, merged.Model, merged.Preset))
+		}
+		_ = persistJobSpec(workDir, merged)
+		if source != "" {
+			if err := os.WriteFile(filepath.Join(workDir, "source.txt"), []byte(source), 0o644); err == nil {
+				_ = novel.RecordArtifact(workDir, "source.txt", novel.Provenance{Stage: novel.StageSource, Origin: novel.OriginInput})
+			}
+		}
 	}
-	gen.WithPersistDir(j.WorkDir).WithFinalBaseDir(cfg.Output.Dir)
+	gen.WithPersistDir(workDir).WithFinalBaseDir(cfg.Output.Dir).WithSeries(loadSeries(workDir)).WithPause(j.pauseRequested)
 	gen.WithRequestPolicy(cfg.OpenAI.RequestTimeoutSec, cfg.OpenAI.MaxRetries, cfg.OpenAI.RetryBackoffMs)
-	outline, characters, plans, err := gen.GenerateArtifacts(ctx, merged)
+	var outline novel.Outline
+	var plans []novel.Chapter
+	switch {
+	case after != "":
+		outline, _, plans, err = gen.ResumeArtifacts(ctx, merged, source, after)
+	case source != "":
+		outline, _, plans, err = gen.GenerateArtifactsFromSource(ctx, merged, source)
+	default:
+		outline, _, plans, err = gen.GenerateArtifacts(ctx, merged)
+	}
+	m.finishJob(j, jl, workDir, outline, plans, err)
+}
+
+func (m *Manager) finishJob(j *Job, jl *JobLogger, workDir string, outline novel.Outline, plans []novel.Chapter, err error) {
+	var gate *novel.GateError
+	if errors.As(err, &gate) {
+		if e := j.await(gate.Stage); e != nil && jl != nil {
+			jl.Log(fmt.Sprintf("[状态冲突] %s", e.Error()))
+		}
+		return
+	}
+	var paused *novel.PauseError
+	if errors.As(err, &paused) {
+		if e := j.pause(paused.Stage); e != nil && jl != nil {
+			jl.Log(fmt.Sprintf("[状态冲突] %s", e.Error()))
+		}
+		return
+	}
 	if err != nil {
 		if jl != nil {
 			jl.Log(fmt.Sprintf("[任务失败] %s", err.Error()))
 		}
-		j.Status = JobFailed
-		j.Error = err.Error()
-		j.UpdatedAt = time.Now()
+		_ = j.transition(JobFailed, err.Error())
 		return
 	}
-	j.Total = len(plans)
+	total := len(plans)
+	if len(outline.Volumes) > 0 {
+		total = outline.TotalChapters()
+	}
+	j.setProgress(0, total)
 	if jl != nil {
-		jl.Log(fmt.Sprintf("[大纲] 标题=%s 章节数=%d", outline.Title, j.Total))
+		jl.Log(fmt.Sprintf("[大纲] 标题=%s 章节数=%d", outline.Title, total))
 	}
-	_ = writeProgress(j.WorkDir, 0, j.Total)
-	_ = characters // artifacts persisted; avoid unused warning
+	_ = refreshProgress(workDir)
 	if jl != nil {
 		jl.Log("[产物就绪] outline.json / characters.json / plans.json")
 	}
-	j.Status = JobDone
-	j.UpdatedAt = time.Now()
+	if err := j.transition(JobDone, "artifacts ready"); err != nil && jl != nil {
+		jl.Log(fmt.Sprintf("[状态冲突] %s", err.Error()))
+	}
 }
 
-func writeProgress(dir string, completed, total int) error {
-	if dir == "" {
-		return nil
+// jobProgress is the progress.json of a work dir. It holds what the job
+// list shows, so that listing jobs does not read their chapters
+type jobProgress struct {
+	Status    JobStatus `json:"status,omitempty"`
+	Awaiting  string    `json:"awaiting,omitempty"`
+	Completed int       `json:"completed"`
+	Total     int       `json:"total"`
+	Words     int       `json:"words"`
+}
+
+// progressMu serialises the read-modify-write cycles on progress.json
+var progressMu sync.Mutex
+
+func readProgress(dir string) (jobProgress, error) {
+	var p jobProgress
+	b, err := os.ReadFile(filepath.Join(dir, "progress.json"))
+	if err != nil {
+		return p, err
 	}
-	b := []byte(fmt.Sprintf("{\"completed\":%d,\"total\":%d}", completed, total))
+	return p, json.Unmarshal(b, &p)
+}
+
+func writeProgress(dir string, p jobProgress) error {
+	b, _ := json.Marshal(p)
 	return os.WriteFile(filepath.Join(dir, "progress.json"), b, 0o644)
 }
 
-func (m *Manager) runJobFromSource(cfg config.Config, spec novel.Spec, source string, j *Job) {
-	j.Status = JobRunning
-	j.UpdatedAt = time.Now()
-	jl, err := NewJobLogger(cfg.Output.Dir, j.ID)
-	if err == nil {
-		j.LogPath = jl.Path()
-		jl.Log("[任务开始] 使用来源文本生成小说")
-	}
-	cli := openai.NewClient(cfg.OpenAI.APIKey, cfg.OpenAI.BaseURL)
-	gen := novel.NewGenerator(cli)
-	if err == nil {
-		gen.WithLogger(jl.Log)
+// refreshProgress recounts the planned and written chapters of a work dir
+// and the words written; call it whenever chapters or plans change
+func refreshProgress(dir string) error {
+	if dir == "" {
+		return nil
 	}
-	j.WorkDir = filepath.Join(cfg.Output.Dir, "jobs", j.ID)
-	_ = os.MkdirAll(j.WorkDir, 0o755)
-	gen.WithPersistDir(j.WorkDir)
-	timeoutMin := cfg.Server.JobTimeoutMin
-	if timeoutMin <= 0 {
-		timeoutMin = 60
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	p, _ := readProgress(dir)
+	p.Total = plannedChapters(dir)
+	p.Completed, p.Words = 0, 0
+	chapDir := filepath.Join(dir, "chapters")
+	for _, name := range novel.ChapterFiles(chapDir) {
+		p.Completed++
+		if data, err := os.ReadFile(filepath.Join(chapDir, name)); err == nil {
+			p.Words += novel.CountWords(string(data))
+		}
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutMin)*time.Minute)
-	defer cancel()
-	merged := mergeSpecDefaults(cfg, spec)
-	if jl != nil {
-		jl.Log(fmt.Sprintf("[参数] topic=%s chapters=%d words=%d model=%s preset=%s", merged.Topic, merged.Chapters, merged.Words, merged.Model, merged.Preset))
+	return writeProgress(dir, p)
+}
+
+// plannedChapters is the length of a book: its plans, or all volumes of a
+// serial whose later volumes are not planned yet
+func plannedChapters(dir string) int {
+	planned, volumeTotal := 0, 0
+	if b, err := os.ReadFile(filepath.Join(dir, "outline.json")); err == nil {
+		var outline novel.Outline
+		if json.Unmarshal(b, &outline) == nil {
+			planned = len(outline.Chapters)
+			if len(outline.Volumes) > 0 {
+				volumeTotal = outline.TotalChapters()
+			}
+		}
 	}
-	outline, characters, plans, err := gen.GenerateArtifactsFromSource(ctx, merged, source)
-	if err != nil {
-		if jl != nil {
-			jl.Log(fmt.Sprintf("[任务失败] %s", err.Error()))
+	if b, err := os.ReadFile(filepath.Join(dir, "plans.json")); err == nil {
+		var plans []novel.Chapter
+		if json.Unmarshal(b, &plans) == nil && len(plans) > 0 {
+			planned = len(plans)
 		}
-		j.Status = JobFailed
-		j.Error = err.Error()
-		j.UpdatedAt = time.Now()
-		return
 	}
-	j.Total = len(plans)
-	if jl != nil {
-		jl.Log(fmt.Sprintf("[大纲] 标题=%s 章节数=%d", outline.Title, j.Total))
+	if volumeTotal > planned {
+		return volumeTotal
 	}
-	_ = writeProgress(j.WorkDir, 0, j.Total)
-	_ = characters
-	if jl != nil {
-		jl.Log("[产物就绪] outline.json / characters.json / plans.json")
+	return planned
+}
+
+// restoredStatus is the status of a job known from its work dir only. Jobs
+// that were still queued or running when the server stopped never finish;
+// jobs stopped at a gate or paused can still be continued
+func (p jobProgress) restoredStatus() JobStatus {
+	switch {
+	case p.Status == "":
+		// written before statuses were recorded
+		return JobDone
+	case p.Status.Terminal(), p.Status == JobAwaitingApproval, p.Status == JobPaused:
+		return p.Status
+	}
+	return JobFailed
+}
+
+func persistJobSpec(dir string, spec novel.Spec) error {
+	b, _ := json.MarshalIndent(spec, "", "  ")
+	if err := os.WriteFile(filepath.Join(dir, "spec.json"), b, 0o644); err != nil {
+		return err
 	}
-	j.Status = JobDone
-	j.UpdatedAt = time.Now()
+	return novel.RecordArtifact(dir, "spec.json", novel.Provenance{Stage: novel.StageSpec, Origin: novel.OriginInput})
 }
 
-func (m *Manager) GenerateChapter(cfg config.Config, j *Job, chapter int, words int, instruction string) (string, error) {
-	base := j.WorkDir
-	if base == "" {
-		base = filepath.Join(cfg.Output.Dir, "jobs", j.ID)
+func jobWorkDir(cfg config.Config, j *Job) string {
+	if base := j.Snapshot().WorkDir; base != "" {
+		return base
 	}
+	return filepath.Join(cfg.Output.Dir, "jobs", j.ID)
+}
+
+func loadArtifacts(base string) (novel.Outline, []novel.Character, []novel.Chapter, error) {
+	var outline novel.Outline
 	bOutline, err := os.ReadFile(filepath.Join(base, "outline.json"))
 	if err != nil {
-		return "", err
+		return outline, nil, nil, err
 	}
-	var outline novel.Outline
 	if err := json.Unmarshal(bOutline, &outline); err != nil {
-		return "", err
+		return outline, nil, nil, err
 	}
 	bChars, err := os.ReadFile(filepath.Join(base, "characters.json"))
 	if err != nil {
-		return "", err
+		return outline, nil, nil, err
 	}
 	var characters []novel.Character
 	if err := json.Unmarshal(bChars, &characters); err != nil {
-		return "", err
+		return outline, nil, nil, err
 	}
 	bPlans, err := os.ReadFile(filepath.Join(base, "plans.json"))
 	if err != nil {
-		return "", err
+		return outline, nil, nil, err
 	}
 	var plans []novel.Chapter
 	if err := json.Unmarshal(bPlans, &plans); err != nil {
+		return outline, nil, nil, err
+	}
+	return outline, characters, plans, nil
+}
+
+func loadSettings(base string) novel.Settings {
+	var settings novel.Settings
+	if b, err := os.ReadFile(filepath.Join(base, "settings.json")); err == nil {
+		_ = json.Unmarshal(b, &settings)
+	}
+	return settings
+}
+
+// loadSettingSchema is the settings schema of the preset a job was started
+// with
+func loadSettingSchema(base string) novel.SettingSchema {
+	return novel.SettingSchemaFor(jobSpec(base).Preset)
+}
+
+func loadPriorChapters(base string, chapter int) []novel.ChapterContent {
+	prior := []novel.ChapterContent{}
+	if chapter <= 1 {
+		return prior
+	}
+	cd := filepath.Join(base, "chapters")
+	files := novel.ChapterFiles(cd)
+	for i := 1; i < chapter; i++ {
+		name, ok := files[i]
+		if !ok {
+			continue
+		}
+		data, _ := os.ReadFile(filepath.Join(cd, name))
+		prior = append(prior, novel.ChapterContent{Index: i, Title: name, Content: string(data)})
+	}
+	return prior
+}
+
+func (m *Manager) GenerateChapter(ctx context.Context, cfg config.Config, j *Job, chapter int, words int, instruction string) (string, error) {
+	base := jobWorkDir(cfg, j)
+	outline, characters, plans, err := loadArtifacts(base)
+	if err != nil {
 		return "", err
 	}
 	var plan novel.Chapter
@@ -387,41 +918,34 @@ func (m *Manager) GenerateChapter(cfg config.Config, j *Job, chapter int, words
 	if plan.Index == 0 {
 		return "", fmt.Errorf("chapter plan not found")
 	}
-	prior := []novel.ChapterContent{}
-	if chapter > 1 {
-		cd := filepath.Join(base, "chapters")
-		for i := 1; i < chapter; i++ {
-			files, _ := os.ReadDir(cd)
-			for _, f := range files {
-				name := f.Name()
-				if strings.HasPrefix(name, fmt.Sprintf("%02d_", i)) {
-					path := filepath.Join(cd, name)
-					data, _ := os.ReadFile(path)
-					prior = append(prior, novel.ChapterContent{Index: i, Title: name, Content: string(data)})
-					break
-				}
-			}
+	prior := loadPriorChapters(base, chapter)
+	// later volumes reach earlier ones through the volume summaries only
+	if v, ok := outline.VolumeOf(chapter); ok {
+		for len(prior) > 0 && prior[0].Index < v.Start {
+			prior = prior[1:]
 		}
 	}
-	cli := openai.NewClient(cfg.OpenAI.APIKey, cfg.OpenAI.BaseURL)
-	gen := novel.NewGenerator(cli).WithLogger(func(s string) {}).WithPersistDir(base).WithFinalBaseDir(cfg.Output.Dir)
-	spec := novel.Spec{Topic: outline.Title, Language: "zh", Model: cfg.OpenAI.Model, Words: words, Instruction: instruction}
-	canon := novel.BuildCanon(spec, outline, characters, novel.Settings{})
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.JobTimeoutMin)*time.Minute)
-	defer cancel()
+	series := loadSeries(base)
+	js := loadJobSpec(cfg, base, outline)
+	gen := novel.NewGenerator(newClient(cfg, js)).WithLogger(func(s string) {}).WithPersistDir(base).WithFinalBaseDir(cfg.Output.Dir).WithSeries(series)
+	spec := novel.Spec{Topic: outline.Title, Language: js.Language, Model: cfg.OpenAI.Model, Words: words, Instruction: instruction, Preset: js.Preset, System: js.System, Scenes: js.Scenes, Temperature: js.Temperature}
+	canon := novel.BuildCanon(spec, outline, characters, loadSettings(base)).WithSeries(series)
 	c, err := gen.GenerateChapterWithHistory(ctx, spec, canon, plan, prior)
 	if err != nil {
 		return "", err
 	}
-	name := fmt.Sprintf("%02d_%s.md", c.Index, sanitizeFileName(c.Title))
-	return filepath.Join(base, "chapters", name), nil
+	_ = refreshProgress(base)
+	return filepath.Join(base, "chapters", novel.ChapterFileName(c.Index, c.Title)), nil
 }
 
-func sanitizeFileName(s string) string {
-	s = strings.TrimSpace(s)
-	s = strings.ReplaceAll(s, " ", "_")
-	s = strings.ReplaceAll(s, "/", "-")
-	return s
+// newClient is the model client of a job, sampling at the job's temperature
+// or the configured one
+func newClient(cfg config.Config, spec novel.Spec) *openai.Client {
+	cli := openai.NewClient(cfg.OpenAI.APIKey, cfg.OpenAI.BaseURL)
+	if spec.Temperature != nil {
+		return cli.WithTemperature(*spec.Temperature)
+	}
+	return cli.WithTemperature(cfg.OpenAI.Temperature)
 }
 
 func mergeSpecDefaults(cfg config.Config, spec novel.Spec) novel.Spec {
@@ -435,7 +959,14 @@ func mergeSpecDefaults(cfg config.Config, spec novel.Spec) novel.Spec {
 		spec.Chapters = 10
 	}
 	if spec.Preset == "" {
-		spec.Preset = "爽文"
+		spec.Preset = novel.DefaultPreset
+	}
+	if spec.Temperature == nil {
+		t := cfg.OpenAI.Temperature
+		spec.Temperature = &t
+	}
+	if p, ok := novel.LookupPreset(spec.Preset); ok && len(spec.Categories) == 0 {
+		spec.Categories = p.Categories
 	}
 	return spec
 }
@@ -0,0 +1,354 @@
+package service
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+const (
+	OPDSAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	OPDS2Type           = "application/opds+json"
+
+	opdsPageSize = 50
+)
+
+// CatalogBook is a completed job as listed in the OPDS feeds
+type CatalogBook struct {
+	ID       string
+	Title    string
+	Summary  string
+	Language string
+	Created  time.Time
+	Updated  time.Time
+	Words    int
+}
+
+// CatalogPage is one page of completed books with written chapters
+type CatalogPage struct {
+	Books   []CatalogBook
+	Page    int
+	Pages   int
+	Total   int
+	Updated time.Time
+}
+
+// Catalog lists completed jobs that have chapters to download, newest first
+func (m *Manager) Catalog(cfg config.Config, page int) CatalogPage {
+	var books []CatalogBook
+	for _, s := range m.summaries(cfg, ListFilter{Status: JobDone}) {
+		if s.Written == 0 {
+			continue
+		}
+		b := CatalogBook{ID: s.ID, Title: s.Title, Created: s.CreatedAt, Updated: s.UpdatedAt, Words: s.Words, Language: "zh"}
+		if b.Title == "" {
+			b.Title = s.ID
+		}
+		b.Summary, b.Language = readBookMeta(filepath.Join(cfg.Output.Dir, "jobs", s.ID))
+		books = append(books, b)
+	}
+	res := CatalogPage{Total: len(books), Page: page, Pages: (len(books) + opdsPageSize - 1) / opdsPageSize}
+	for _, b := range books {
+		if b.Updated.After(res.Updated) {
+			res.Updated = b.Updated
+		}
+	}
+	if res.Updated.IsZero() {
+		res.Updated = time.Now()
+	}
+	if res.Page < 1 {
+		res.Page = 1
+	}
+	start := (res.Page - 1) * opdsPageSize
+	if start < len(books) {
+		end := start + opdsPageSize
+		if end > len(books) {
+			end = len(books)
+		}
+		res.Books = books[start:end]
+	}
+	return res
+}
+
+// readBookMeta returns a short summary built from the outline's chapter
+// summaries and the spec language
+func readBookMeta(base string) (summary, lang string) {
+	lang = "zh"
+	if b, err := os.ReadFile(filepath.Join(base, "spec.json")); err == nil {
+		var spec novel.Spec
+		if json.Unmarshal(b, &spec) == nil && spec.Language != "" {
+			lang = spec.Language
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(base, "outline.json"))
+	if err != nil {
+		return "", lang
+	}
+	var outline novel.Outline
+	if json.Unmarshal(b, &outline) != nil {
+		return "", lang
+	}
+	var parts []string
+	n := 0
+	for _, c := range outline.Chapters {
+		if c.Summary == "" {
+			continue
+		}
+		parts = append(parts, c.Summary)
+		n += utf8.RuneCountInString(c.Summary)
+		if n >= 200 || len(parts) == 3 {
+			break
+		}
+	}
+	return strings.Join(parts, " "), lang
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Text string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	ID        string     `xml:"id"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published"`
+	Language  string     `xml:"dc:language"`
+	Author    string     `xml:"author>name"`
+	Summary   atomText   `xml:"summary"`
+	Links     []atomLink `xml:"link"`
+}
+
+type atomFeed struct {
+	XMLName     xml.Name    `xml:"feed"`
+	Xmlns       string      `xml:"xmlns,attr"`
+	XmlnsDC     string      `xml:"xmlns:dc,attr"`
+	XmlnsOPDS   string      `xml:"xmlns:opds,attr"`
+	XmlnsSearch string      `xml:"xmlns:opensearch,attr"`
+	ID          string      `xml:"id"`
+	Title       string      `xml:"title"`
+	Updated     string      `xml:"updated"`
+	Author      string      `xml:"author>name"`
+	Total       int         `xml:"opensearch:totalResults"`
+	PerPage     int         `xml:"opensearch:itemsPerPage"`
+	Links       []atomLink  `xml:"link"`
+	Entries     []atomEntry `xml:"entry"`
+}
+
+// CoverURL, ExportURL and OPDSURL are the server paths the feeds link to
+func CoverURL(id string) string {
+	return "/api/jobs/" + id + "/cover.svg"
+}
+
+func ExportURL(id, format string) string {
+	return "/api/jobs/" + id + "/export?format=" + format
+}
+
+func OPDSURL(page int) string {
+	if page <= 1 {
+		return "/opds"
+	}
+	return fmt.Sprintf("/opds?page=%d", page)
+}
+
+func OPDS2URL(page int) string {
+	if page <= 1 {
+		return "/opds/v2"
+	}
+	return fmt.Sprintf("/opds/v2?page=%d", page)
+}
+
+var opdsExports = []struct{ format, mime string }{
+	{"epub", "application/epub+zip"},
+	{"txt", "text/plain"},
+}
+
+// RenderOPDS renders a catalog page as an OPDS 1.2 acquisition feed
+func RenderOPDS(p CatalogPage) ([]byte, error) {
+	feed := atomFeed{
+		Xmlns:       "http://www.w3.org/2005/Atom",
+		XmlnsDC:     "http://purl.org/dc/terms/",
+		XmlnsOPDS:   "http://opds-spec.org/2010/catalog",
+		XmlnsSearch: "http://a9.com/-/spec/opensearch/1.1/",
+		ID:          "urn:ai-reader:catalog",
+		Title:       novel.GeneratorName,
+		Updated:     p.Updated.UTC().Format(time.RFC3339),
+		Author:      novel.GeneratorName,
+		Total:       p.Total,
+		PerPage:     opdsPageSize,
+		Links: []atomLink{
+			{Rel: "self", Href: OPDSURL(p.Page), Type: OPDSAcquisitionType},
+			{Rel: "start", Href: OPDSURL(1), Type: OPDSAcquisitionType},
+		},
+	}
+	if p.Page > 1 {
+		feed.Links = append(feed.Links, atomLink{Rel: "previous", Href: OPDSURL(p.Page - 1), Type: OPDSAcquisitionType})
+	}
+	if p.Page < p.Pages {
+		feed.Links = append(feed.Links, atomLink{Rel: "next", Href: OPDSURL(p.Page + 1), Type: OPDSAcquisitionType})
+	}
+	for _, b := range p.Books {
+		e := atomEntry{
+			Title:     b.Title,
+			ID:        "urn:ai-reader:job:" + b.ID,
+			Updated:   b.Updated.UTC().Format(time.RFC3339),
+			Published: b.Created.UTC().Format(time.RFC3339),
+			Language:  b.Language,
+			Author:    novel.GeneratorName,
+			Summary:   atomText{Type: "text", Text: b.Summary},
+			Links: []atomLink{
+				{Rel: "http://opds-spec.org/image", Href: CoverURL(b.ID), Type: "image/svg+xml"},
+				{Rel: "http://opds-spec.org/image/thumbnail", Href: CoverURL(b.ID), Type: "image/svg+xml"},
+			},
+		}
+		for _, x := range opdsExports {
+			e.Links = append(e.Links, atomLink{Rel: "http://opds-spec.org/acquisition/open-access", Href: ExportURL(b.ID, x.format), Type: x.mime})
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+type opds2Link struct {
+	Rel  string `json:"rel,omitempty"`
+	Href string `json:"href"`
+	Type string `json:"type,omitempty"`
+}
+
+type opds2Publication struct {
+	Metadata struct {
+		Type        string    `json:"@type"`
+		Identifier  string    `json:"identifier"`
+		Title       string    `json:"title"`
+		Author      string    `json:"author"`
+		Language    string    `json:"language"`
+		Description string    `json:"description,omitempty"`
+		Published   time.Time `json:"published"`
+		Modified    time.Time `json:"modified"`
+		Words       int       `json:"numberOfWords,omitempty"`
+	} `json:"metadata"`
+	Links  []opds2Link `json:"links"`
+	Images []opds2Link `json:"images"`
+}
+
+type opds2Feed struct {
+	Metadata struct {
+		Title         string    `json:"title"`
+		Modified      time.Time `json:"modified"`
+		NumberOfItems int       `json:"numberOfItems"`
+		ItemsPerPage  int       `json:"itemsPerPage"`
+		CurrentPage   int       `json:"currentPage"`
+	} `json:"metadata"`
+	Links        []opds2Link        `json:"links"`
+	Publications []opds2Publication `json:"publications"`
+}
+
+// RenderOPDS2 renders a catalog page as an OPDS 2.0 JSON feed
+func RenderOPDS2(p CatalogPage) ([]byte, error) {
+	var feed opds2Feed
+	feed.Metadata.Title = novel.GeneratorName
+	feed.Metadata.Modified = p.Updated.UTC()
+	feed.Metadata.NumberOfItems = p.Total
+	feed.Metadata.ItemsPerPage = opdsPageSize
+	feed.Metadata.CurrentPage = p.Page
+	feed.Links = []opds2Link{
+		{Rel: "self", Href: OPDS2URL(p.Page), Type: OPDS2Type},
+		{Rel: "start", Href: OPDS2URL(1), Type: OPDS2Type},
+	}
+	if p.Page > 1 {
+		feed.Links = append(feed.Links, opds2Link{Rel: "previous", Href: OPDS2URL(p.Page - 1), Type: OPDS2Type})
+	}
+	if p.Page < p.Pages {
+		feed.Links = append(feed.Links, opds2Link{Rel: "next", Href: OPDS2URL(p.Page + 1), Type: OPDS2Type})
+	}
+	feed.Publications = []opds2Publication{}
+	for _, b := range p.Books {
+		var pub opds2Publication
+		pub.Metadata.Type = "http://schema.org/Book"
+		pub.Metadata.Identifier = "urn:ai-reader:job:" + b.ID
+		pub.Metadata.Title = b.Title
+		pub.Metadata.Author = novel.GeneratorName
+		pub.Metadata.Language = b.Language
+		pub.Metadata.Description = b.Summary
+		pub.Metadata.Published = b.Created.UTC()
+		pub.Metadata.Modified = b.Updated.UTC()
+		pub.Metadata.Words = b.Words
+		for _, x := range opdsExports {
+			pub.Links = append(pub.Links, opds2Link{Rel: "http://opds-spec.org/acquisition/open-access", Href: ExportURL(b.ID, x.format), Type: x.mime})
+		}
+		pub.Images = []opds2Link{{Href: CoverURL(b.ID), Type: "image/svg+xml"}}
+		feed.Publications = append(feed.Publications, pub)
+	}
+	return json.MarshalIndent(feed, "", "  ")
+}
+
+// Cover returns the placeholder cover of a job
+func (m *Manager) Cover(cfg config.Config, id string) ([]byte, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(base, "outline.json"))
+	if err != nil {
+		return nil, err
+	}
+	var outline novel.Outline
+	if err := json.Unmarshal(b, &outline); err != nil {
+		return nil, err
+	}
+	return CoverSVG(outline.Title), nil
+}
+
+// CoverSVG draws a placeholder cover with the title, wrapped for CJK and
+// Latin text alike
+func CoverSVG(title string) []byte {
+	var lines []string
+	var cur []rune
+	for _, r := range title {
+		cur = append(cur, r)
+		if len(cur) >= 8 && (r == ' ' || len(cur) >= 10) {
+			lines = append(lines, strings.TrimSpace(string(cur)))
+			cur = nil
+		}
+	}
+	if len(cur) > 0 {
+		lines = append(lines, strings.TrimSpace(string(cur)))
+	}
+	if len(lines) > 5 {
+		lines = append(lines[:4], lines[4]+"…")
+	}
+	var s strings.Builder
+	s.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="600" height="800" viewBox="0 0 600 800">
+<rect width="600" height="800" fill="#2f3e46"/>
+<rect x="30" y="30" width="540" height="740" fill="none" stroke="#cad2c5" stroke-width="3"/>
+`)
+	y := 330 - 35*(len(lines)-1)
+	for _, l := range lines {
+		fmt.Fprintf(&s, `<text x="300" y="%d" text-anchor="middle" font-family="serif" font-size="52" fill="#f1faee">%s</text>`+"\n", y, html.EscapeString(l))
+		y += 70
+	}
+	fmt.Fprintf(&s, `<text x="300" y="720" text-anchor="middle" font-family="sans-serif" font-size="24" fill="#cad2c5">%s</text>
+</svg>
+`, novel.GeneratorName)
+	return []byte(s.String())
+}
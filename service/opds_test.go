@@ -0,0 +1,166 @@
+package service
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ibreez3/ai-reader/config"
+)
+
+func writeCatalogJob(t *testing.T, cfg config.Config, id, outline, spec, progress string) {
+	t.Helper()
+	dir := filepath.Join(cfg.Output.Dir, "jobs", id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{"outline.json": outline, "spec.json": spec, "progress.json": progress}
+	for name, body := range files {
+		if body == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	cfg := config.Config{}
+	cfg.Output.Dir = t.TempDir()
+	writeCatalogJob(t, cfg, "job-100", `{"title":"Tom & <Jerry>","chapters":[{"title":"a","summary":"A chase."},{"title":"b"},{"title":"c","summary":"A truce."}]}`, `{"language":"en"}`, `{"status":"completed","completed":2,"total":3,"words":900}`)
+	writeCatalogJob(t, cfg, "job-200", `{"title":"unwritten"}`, "", `{"status":"completed","completed":0,"total":3}`)
+	writeCatalogJob(t, cfg, "job-300", `{"title":"failed"}`, "", `{"status":"failed","completed":1,"total":3}`)
+	writeCatalogJob(t, cfg, "job-400", `{"chapters":[]}`, "", `{"status":"completed","completed":1,"total":1}`)
+
+	p := NewManager().Catalog(cfg, 0)
+	if p.Page != 1 || p.Pages != 1 || p.Total != 2 {
+		t.Fatalf("page %d of %d, %d books", p.Page, p.Pages, p.Total)
+	}
+	var ids, titles, langs []string
+	for _, b := range p.Books {
+		ids, titles, langs = append(ids, b.ID), append(titles, b.Title), append(langs, b.Language)
+	}
+	if want := []string{"job-400", "job-100"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("books = %v, want %v", ids, want)
+	}
+	if !reflect.DeepEqual(titles, []string{"job-400", "Tom & <Jerry>"}) || !reflect.DeepEqual(langs, []string{"zh", "en"}) {
+		t.Fatalf("titles %v, languages %v", titles, langs)
+	}
+	if b := p.Books[1]; b.Summary != "A chase. A truce." || b.Words != 900 {
+		t.Fatalf("summary %q, words %d", b.Summary, b.Words)
+	}
+	if empty := NewManager().Catalog(cfg, 2); len(empty.Books) != 0 || empty.Page != 2 {
+		t.Fatalf("page past the end: %+v", empty)
+	}
+
+	out, err := RenderOPDS(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var feed struct {
+		Entries []struct {
+			Title string     `xml:"title"`
+			Links []atomLink `xml:"link"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(out, &feed); err != nil {
+		t.Fatalf("OPDS feed is not XML: %v", err)
+	}
+	if len(feed.Entries) != 2 || feed.Entries[1].Title != "Tom & <Jerry>" {
+		t.Fatalf("entries = %+v", feed.Entries)
+	}
+	var acquisitions []string
+	for _, l := range feed.Entries[1].Links {
+		if strings.HasPrefix(l.Rel, "http://opds-spec.org/acquisition") {
+			acquisitions = append(acquisitions, l.Href+" "+l.Type)
+		}
+	}
+	if want := []string{ExportURL("job-100", "epub") + " application/epub+zip", ExportURL("job-100", "txt") + " text/plain"}; !reflect.DeepEqual(acquisitions, want) {
+		t.Fatalf("acquisition links = %v, want %v", acquisitions, want)
+	}
+
+	out, err = RenderOPDS2(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var feed2 opds2Feed
+	if err := json.Unmarshal(out, &feed2); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed2.Publications) != 2 || feed2.Publications[1].Metadata.Language != "en" || len(feed2.Publications[1].Links) != 2 || feed2.Publications[1].Images[0].Href != CoverURL("job-100") {
+		t.Fatalf("publications = %+v", feed2.Publications)
+	}
+}
+
+func TestCatalogNavigation(t *testing.T) {
+	cases := []struct {
+		page, pages int
+		want        []string
+	}{
+		{1, 1, []string{"self /opds", "start /opds"}},
+		{1, 3, []string{"self /opds", "start /opds", "next /opds?page=2"}},
+		{2, 3, []string{"self /opds?page=2", "start /opds", "previous /opds", "next /opds?page=3"}},
+		{3, 3, []string{"self /opds?page=3", "start /opds", "previous /opds?page=2"}},
+	}
+	for _, c := range cases {
+		p := CatalogPage{Page: c.page, Pages: c.pages}
+		out, err := RenderOPDS(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var feed struct {
+			Links []atomLink `xml:"link"`
+		}
+		if err := xml.Unmarshal(out, &feed); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, l := range feed.Links {
+			got = append(got, l.Rel+" "+l.Href)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("page %d of %d: links %v, want %v", c.page, c.pages, got, c.want)
+		}
+		out, _ = RenderOPDS2(p)
+		var feed2 opds2Feed
+		_ = json.Unmarshal(out, &feed2)
+		var got2 []string
+		for _, l := range feed2.Links {
+			got2 = append(got2, l.Rel+" "+strings.Replace(l.Href, "/opds/v2", "/opds", 1))
+		}
+		if !reflect.DeepEqual(got2, c.want) {
+			t.Errorf("page %d of %d: OPDS 2 links %v, want %v", c.page, c.pages, got2, c.want)
+		}
+	}
+}
+
+func TestCoverSVG(t *testing.T) {
+	cases := []struct {
+		title string
+		lines []string
+	}{
+		{"星辰", []string{"星辰"}},
+		{"一二三四五六七八九十十一", []string{"一二三四五六七八九十", "十一"}},
+		{"The Long Way Home", []string{"The Long", "Way Home"}},
+		{"A & B", []string{"A &amp; B"}},
+		{strings.Repeat("长", 70), []string{strings.Repeat("长", 10), strings.Repeat("长", 10), strings.Repeat("长", 10), strings.Repeat("长", 10), strings.Repeat("长", 10) + "…"}},
+	}
+	for _, c := range cases {
+		svg := string(CoverSVG(c.title))
+		if err := xml.Unmarshal([]byte(svg), new(struct{})); err != nil {
+			t.Errorf("%q: cover is not XML: %v", c.title, err)
+		}
+		var lines []string
+		for _, part := range strings.Split(svg, `fill="#f1faee">`)[1:] {
+			lines = append(lines, part[:strings.Index(part, "</text>")])
+		}
+		if !reflect.DeepEqual(lines, c.lines) {
+			t.Errorf("%q: lines %q, want %q", c.title, lines, c.lines)
+		}
+	}
+}
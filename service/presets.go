@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+// ReloadPresets loads the preset dir again; when a file is invalid the
+// presets in use are kept and the error is a ValidationError naming the
+// bad files
+func ReloadPresets(cfg config.Config) ([]novel.Preset, error) {
+	if err := novel.LoadPresets(cfg.Presets.Dir); err != nil {
+		return nil, invalidf("presets not reloaded: %s", err.Error())
+	}
+	return novel.Presets(), nil
+}
+
+// WatchPresets reloads the presets whenever a file in the preset dir is
+// added, changed or removed, until ctx is done
+func WatchPresets(ctx context.Context, cfg config.Config, logf func(string)) {
+	if cfg.Presets.ReloadSec <= 0 {
+		return
+	}
+	t := time.NewTicker(time.Duration(cfg.Presets.ReloadSec) * time.Second)
+	defer t.Stop()
+	last := presetDirState(cfg.Presets.Dir)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		cur := presetDirState(cfg.Presets.Dir)
+		if cur == last {
+			continue
+		}
+		last = cur
+		if _, err := ReloadPresets(cfg); err != nil {
+			logf(err.Error())
+			continue
+		}
+		logf(fmt.Sprintf("presets reloaded from %s", cfg.Presets.Dir))
+	}
+}
+
+// presetDirState sums up the names, sizes and modification times of the
+// files in dir so that a change to any of them shows
+func presetDirState(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	var parts []string
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || e.IsDir() {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d:%d", filepath.Join(dir, e.Name()), info.Size(), info.ModTime().UnixNano()))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,359 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+// Project groups the books of a series around a shared canon; books started
+// in a project snapshot its canon and hand their ending back when concluded
+type Project struct {
+	ID          string `json:"id"`
+	Description string `json:"description,omitempty"`
+	novel.Series
+	Books     []ProjectBook `json:"books"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+type ProjectBook struct {
+	Job       string    `json:"job"`
+	Title     string    `json:"title,omitempty"`
+	Concluded bool      `json:"concluded"`
+	AddedAt   time.Time `json:"added_at"`
+}
+
+// concludeChapters is how many closing chapters are read when a book is concluded
+const concludeChapters = 3
+
+func projectPath(cfg config.Config, id string) string {
+	return filepath.Join(cfg.Output.Dir, "projects", id+".json")
+}
+
+func readProject(cfg config.Config, id string) (Project, []byte, error) {
+	if !validJobID(id) {
+		return Project{}, nil, ErrInvalidJobID
+	}
+	b, err := os.ReadFile(projectPath(cfg, id))
+	if err != nil {
+		return Project{}, nil, err
+	}
+	var p Project
+	if err := json.Unmarshal(b, &p); err != nil {
+		return Project{}, nil, err
+	}
+	return p, b, nil
+}
+
+func writeProject(cfg config.Config, p Project) ([]byte, error) {
+	if err := os.MkdirAll(filepath.Join(cfg.Output.Dir, "projects"), 0o755); err != nil {
+		return nil, err
+	}
+	p.UpdatedAt = time.Now()
+	b, _ := json.MarshalIndent(p, "", "  ")
+	return b, writeFileAtomic(projectPath(cfg, p.ID), b)
+}
+
+func normalizeProject(p *Project) error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return invalidf("project name is required")
+	}
+	for _, c := range p.Characters {
+		if strings.TrimSpace(c.Name) == "" {
+			return invalidf("character name is required")
+		}
+	}
+	for _, c := range p.States {
+		if strings.TrimSpace(c.Name) == "" {
+			return invalidf("character state name is required")
+		}
+	}
+	if p.Facts == nil {
+		p.Facts = []string{}
+	}
+	if p.Characters == nil {
+		p.Characters = []novel.Character{}
+	}
+	if p.Timeline == nil {
+		p.Timeline = []novel.TimelineEvent{}
+	}
+	if p.Books == nil {
+		p.Books = []ProjectBook{}
+	}
+	return nil
+}
+
+func (m *Manager) ListProjects(cfg config.Config) []Project {
+	out := []Project{}
+	files, err := os.ReadDir(filepath.Join(cfg.Output.Dir, "projects"))
+	if err != nil {
+		return out
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		if p, _, err := readProject(cfg, strings.TrimSuffix(f.Name(), ".json")); err == nil {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].CreatedAt.Before(out[b].CreatedAt) })
+	return out
+}
+
+func (m *Manager) CreateProject(cfg config.Config, p Project) (Project, string, error) {
+	if err := normalizeProject(&p); err != nil {
+		return Project{}, "", err
+	}
+	m.projMu.Lock()
+	defer m.projMu.Unlock()
+	p.ID = fmt.Sprintf("proj-%d", time.Now().UnixNano())
+	p.Books = []ProjectBook{}
+	p.CreatedAt = time.Now()
+	b, err := writeProject(cfg, p)
+	if err != nil {
+		return Project{}, "", err
+	}
+	_ = json.Unmarshal(b, &p)
+	return p, ETag(b), nil
+}
+
+func (m *Manager) GetProject(cfg config.Config, id string) (Project, string, error) {
+	m.projMu.Lock()
+	defer m.projMu.Unlock()
+	p, b, err := readProject(cfg, id)
+	if err != nil {
+		return Project{}, "", err
+	}
+	return p, ETag(b), nil
+}
+
+// PutProject replaces the shared canon of a project; its id, books and
+// creation time are kept
+func (m *Manager) PutProject(cfg config.Config, id string, next Project, ifMatch string) (Project, string, error) {
+	if ifMatch == "" {
+		return Project{}, "", ErrPreconditionRequired
+	}
+	if err := normalizeProject(&next); err != nil {
+		return Project{}, "", err
+	}
+	m.projMu.Lock()
+	defer m.projMu.Unlock()
+	cur, b, err := readProject(cfg, id)
+	if err != nil {
+		return Project{}, "", err
+	}
+	if ifMatch != "*" && ifMatch != ETag(b) {
+		return Project{}, "", ErrPreconditionFailed
+	}
+	next.ID, next.Books, next.CreatedAt = cur.ID, cur.Books, cur.CreatedAt
+	if b, err = writeProject(cfg, next); err != nil {
+		return Project{}, "", err
+	}
+	_ = json.Unmarshal(b, &next)
+	return next, ETag(b), nil
+}
+
+// addProjectBook registers a new job with its project and returns the canon
+// the book starts from
+func (m *Manager) addProjectBook(cfg config.Config, id, jobID string) (novel.Series, error) {
+	m.projMu.Lock()
+	defer m.projMu.Unlock()
+	p, _, err := readProject(cfg, id)
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, ErrInvalidJobID) {
+			return novel.Series{}, invalidf("unknown project %s", id)
+		}
+		return novel.Series{}, err
+	}
+	p.Books = append(p.Books, ProjectBook{Job: jobID, AddedAt: time.Now()})
+	if _, err := writeProject(cfg, p); err != nil {
+		return novel.Series{}, err
+	}
+	return p.Series, nil
+}
+
+// removeProjectBook drops a deleted job from the books of its project; a
+// project that no longer exists or does not list the job is left alone
+func (m *Manager) removeProjectBook(cfg config.Config, id, jobID string) error {
+	m.projMu.Lock()
+	defer m.projMu.Unlock()
+	if m.concluding[jobID] {
+		return ErrJobBusy
+	}
+	p, _, err := readProject(cfg, id)
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, ErrInvalidJobID) {
+			return nil
+		}
+		return err
+	}
+	kept := p.Books[:0]
+	for _, b := range p.Books {
+		if b.Job != jobID {
+			kept = append(kept, b)
+		}
+	}
+	if len(kept) == len(p.Books) {
+		return nil
+	}
+	p.Books = kept
+	_, err = writeProject(cfg, p)
+	return err
+}
+
+// ConcludeBook hands a finished book on to its project: the model reads the
+// closing chapters and the tracked character states, and the characters'
+// end states, the latest tracked states, key events and facts are folded
+// into the shared canon the next book starts from
+func (m *Manager) ConcludeBook(cfg config.Config, id, jobID string) (Project, error) {
+	if err := m.startConcluding(cfg, id, jobID); err != nil {
+		return Project{}, err
+	}
+	defer m.endConcluding(jobID)
+	base, err := m.jobDir(cfg, jobID)
+	if err != nil {
+		return Project{}, err
+	}
+	if m.busy(jobID) {
+		return Project{}, ErrJobBusy
+	}
+	outline, characters, _, err := loadArtifacts(base)
+	if err != nil {
+		return Project{}, err
+	}
+	planned := plannedChapters(base)
+	last := loadPriorChapters(base, planned+1)
+	if planned == 0 || len(last) < planned {
+		return Project{}, ErrJobNotFinished
+	}
+	if len(last) > concludeChapters {
+		last = last[len(last)-concludeChapters:]
+	}
+	ledger, err := novel.LoadCharacterStates(base)
+	if err != nil {
+		return Project{}, err
+	}
+	states := ledger.Latest()
+	spec := loadJobSpec(cfg, base, outline)
+	timeout := time.Duration(cfg.OpenAI.RequestTimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	gen := novel.NewGenerator(newClient(cfg, spec))
+	gen.WithRequestPolicy(cfg.OpenAI.RequestTimeoutSec, cfg.OpenAI.MaxRetries, cfg.OpenAI.RetryBackoffMs)
+	res, err := gen.ConcludeBook(ctx, spec, outline, characters, states, last)
+	if err != nil {
+		return Project{}, err
+	}
+
+	m.projMu.Lock()
+	defer m.projMu.Unlock()
+	// re-read: the canon may have been edited while the model was busy
+	p, _, err := readProject(cfg, id)
+	if err != nil {
+		return Project{}, err
+	}
+	p.Series.Absorb(characters, states, res)
+	for i := range p.Books {
+		if p.Books[i].Job == jobID {
+			p.Books[i].Title = outline.Title
+			p.Books[i].Concluded = true
+		}
+	}
+	b, err := writeProject(cfg, p)
+	if err != nil {
+		return Project{}, err
+	}
+	_ = json.Unmarshal(b, &p)
+	return p, nil
+}
+
+// startConcluding claims a book of the project for ConcludeBook so that its
+// ending is absorbed into the canon only once
+func (m *Manager) startConcluding(cfg config.Config, id, jobID string) error {
+	m.projMu.Lock()
+	defer m.projMu.Unlock()
+	p, _, err := readProject(cfg, id)
+	if err != nil {
+		return err
+	}
+	at := -1
+	for i, b := range p.Books {
+		if b.Job == jobID {
+			at = i
+		}
+	}
+	if at < 0 {
+		return invalidf("job %s is not a book of project %s", jobID, id)
+	}
+	if p.Books[at].Concluded {
+		return invalidf("job %s is already concluded in project %s", jobID, id)
+	}
+	if m.concluding[jobID] {
+		return ErrJobBusy
+	}
+	m.concluding[jobID] = true
+	return nil
+}
+
+func (m *Manager) endConcluding(jobID string) {
+	m.projMu.Lock()
+	delete(m.concluding, jobID)
+	m.projMu.Unlock()
+}
+
+// persistSeries snapshots the project canon into a new book's work dir and
+// seeds its settings and character state ledger from the project
+func persistSeries(dir string, s novel.Series) error {
+	b, _ := json.MarshalIndent(s, "", "  ")
+	if err := os.WriteFile(filepath.Join(dir, novel.SeriesFile), b, 0o644); err != nil {
+		return err
+	}
+	if err := novel.RecordArtifact(dir, novel.SeriesFile, novel.Provenance{Stage: novel.StageSeries, Origin: novel.OriginInput}); err != nil {
+		return err
+	}
+	if len(s.States) > 0 && !fileExists(filepath.Join(dir, novel.CharacterStateFile)) {
+		cb, _ := json.MarshalIndent(novel.CarriedStates(s.States), "", "  ")
+		if err := os.WriteFile(filepath.Join(dir, novel.CharacterStateFile), cb, 0o644); err != nil {
+			return err
+		}
+		if err := novel.RecordArtifact(dir, novel.CharacterStateFile, novel.Provenance{Stage: novel.StageCharacterState, Origin: novel.OriginInput}); err != nil {
+			return err
+		}
+	}
+	if s.Settings.Empty() || fileExists(filepath.Join(dir, "settings.json")) {
+		return nil
+	}
+	sb, _ := json.MarshalIndent(s.Settings, "", "  ")
+	if err := os.WriteFile(filepath.Join(dir, "settings.json"), sb, 0o644); err != nil {
+		return err
+	}
+	return novel.RecordArtifact(dir, "settings.json", novel.Provenance{Stage: novel.StageSettings, Origin: novel.OriginInput})
+}
+
+// loadSeries reads the canon snapshot of a book, nil for standalone books
+func loadSeries(base string) *novel.Series {
+	b, err := os.ReadFile(filepath.Join(base, novel.SeriesFile))
+	if err != nil {
+		return nil
+	}
+	var s novel.Series
+	if json.Unmarshal(b, &s) != nil {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+// projectBook writes a finished book and registers it as the first book of
+// a new project
+func projectBook(t *testing.T, m *Manager) (config.Config, string, string) {
+	t.Helper()
+	cfg, id := chapterJob(t, "a", "b")
+	base := filepath.Join(cfg.Output.Dir, "jobs", id)
+	chars, _ := json.Marshal([]novel.Character{{Name: "陈巽", Role: "主角"}})
+	if err := os.WriteFile(filepath.Join(base, "characters.json"), chars, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, _, err := m.CreateProject(cfg, Project{Series: novel.Series{Name: "系列"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.addProjectBook(cfg, p.ID, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := persistJobSpec(base, novel.Spec{Topic: "书", Project: p.ID}); err != nil {
+		t.Fatal(err)
+	}
+	return cfg, p.ID, id
+}
+
+func projectBooks(t *testing.T, cfg config.Config, id string) []string {
+	t.Helper()
+	p, _, err := readProject(cfg, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := []string{}
+	for _, b := range p.Books {
+		out = append(out, b.Job)
+	}
+	return out
+}
+
+func TestConcludeBookCarriesStates(t *testing.T) {
+	m := NewManager()
+	cfg, pid, id := projectBook(t, m)
+	base := filepath.Join(cfg.Output.Dir, "jobs", id)
+	ledger := novel.CharacterStates{Updates: []novel.StateUpdate{
+		{Chapter: 1, Characters: []novel.CharacterState{{Name: "陈巽", Location: "城南"}}},
+		{Chapter: 2, Characters: []novel.CharacterState{{Name: "陈巽", Location: "省城", Realm: "筑基"}}},
+	}}
+	lb, _ := json.Marshal(ledger)
+	if err := os.WriteFile(filepath.Join(base, novel.CharacterStateFile), lb, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := chatServer(t, `{"characters":[{"name":"陈巽","state":"名动省城"}],"events":[],"facts":["旧宅已焚"]}`)
+	cfg.OpenAI.BaseURL, cfg.OpenAI.Model, cfg.OpenAI.MaxRetries = srv.URL, "m", 1
+
+	p, err := m.ConcludeBook(cfg, pid, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []novel.CharacterState{{Name: "陈巽", Location: "省城", Realm: "筑基"}}; !reflect.DeepEqual(p.States, want) {
+		t.Errorf("project states = %+v, want %+v", p.States, want)
+	}
+	if len(p.Characters) != 1 || p.Characters[0].State != "名动省城" || !p.Books[0].Concluded {
+		t.Errorf("project = %+v", p)
+	}
+
+	// the next book starts from the carried states
+	next := t.TempDir()
+	if err := persistSeries(next, p.Series); err != nil {
+		t.Fatal(err)
+	}
+	carried, err := novel.LoadCharacterStates(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := carried.Before(1)["陈巽"]; st.Location != "省城" || st.Realm != "筑基" {
+		t.Errorf("book 2 starts with %+v", st)
+	}
+	b, _ := os.ReadFile(filepath.Join(next, novel.CharacterStateFile))
+	if _, err := normalizeArtifact(next, novel.StageCharacterState, b); err != nil {
+		t.Errorf("carried ledger cannot be saved back: %v", err)
+	}
+}
+
+func TestDeleteLeavesProject(t *testing.T) {
+	cases := []struct {
+		name     string
+		inMemory bool
+		busy     bool
+	}{
+		{"book on disk", false, false},
+		{"book in memory", true, false},
+		{"book being concluded", false, true},
+	}
+	for _, c := range cases {
+		m := NewManager()
+		cfg, pid, id := projectBook(t, m)
+		if c.inMemory {
+			if _, err := m.LoadJobFromDisk(cfg, id); err != nil {
+				t.Fatal(err)
+			}
+			// only the job in memory knows its project
+			if err := os.Remove(filepath.Join(cfg.Output.Dir, "jobs", id, "spec.json")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if c.busy {
+			m.concluding[id] = true
+		}
+		err := m.Delete(cfg, id)
+		if c.busy {
+			if !errors.Is(err, ErrJobBusy) {
+				t.Errorf("%s: err = %v", c.name, err)
+			}
+			if got := projectBooks(t, cfg, pid); !reflect.DeepEqual(got, []string{id}) {
+				t.Errorf("%s: books = %v", c.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got := projectBooks(t, cfg, pid); len(got) != 0 {
+			t.Errorf("%s: deleted book still listed: %v", c.name, got)
+		}
+	}
+}
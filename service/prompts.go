@@ -0,0 +1,108 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+// Prompts lists every prompt template as a job renders it, with whether it
+// comes from the job, its preset or the built-in set
+func (m *Manager) Prompts(cfg config.Config, id string) ([]novel.PromptTemplate, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return nil, err
+	}
+	js := jobSpec(base)
+	return novel.JobPrompts(base, js.Preset, js.Language), nil
+}
+
+// SavePrompt overrides template name for one job; the chapters generated
+// from then on are rendered from body. The body is checked against the
+// common partials of the job's language
+func (m *Manager) SavePrompt(cfg config.Config, id, name, body string) (novel.PromptTemplate, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return novel.PromptTemplate{}, err
+	}
+	if err := novel.CheckPrompt(jobSpec(base).Language, name, body); err != nil {
+		return novel.PromptTemplate{}, invalidf("%s", err.Error())
+	}
+	// busy is checked under artMu so no chapter task or planning starts
+	// between the check and the write
+	m.artMu.Lock()
+	defer m.artMu.Unlock()
+	if m.busy(id) {
+		return novel.PromptTemplate{}, ErrJobBusy
+	}
+	dir := filepath.Join(base, novel.PromptDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return novel.PromptTemplate{}, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".tmpl"), []byte(body), 0o644); err != nil {
+		return novel.PromptTemplate{}, err
+	}
+	return jobPrompt(base, name), nil
+}
+
+// DeletePrompt drops a job's override of template name, so the preset's or
+// the built-in template applies again
+func (m *Manager) DeletePrompt(cfg config.Config, id, name string) (novel.PromptTemplate, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return novel.PromptTemplate{}, err
+	}
+	m.artMu.Lock()
+	defer m.artMu.Unlock()
+	if m.busy(id) {
+		return novel.PromptTemplate{}, ErrJobBusy
+	}
+	if err := os.Remove(filepath.Join(base, novel.PromptDir, filepath.Base(name)+".tmpl")); err != nil {
+		return novel.PromptTemplate{}, err
+	}
+	return jobPrompt(base, name), nil
+}
+
+func jobPrompt(base, name string) novel.PromptTemplate {
+	js := jobSpec(base)
+	for _, t := range novel.JobPrompts(base, js.Preset, js.Language) {
+		if t.Name == name {
+			return t
+		}
+	}
+	return novel.PromptTemplate{Name: name}
+}
+
+// jobSpec is the spec a job was started with, empty for jobs that
+// predate spec.json
+func jobSpec(base string) novel.Spec {
+	var spec novel.Spec
+	if b, err := os.ReadFile(filepath.Join(base, "spec.json")); err == nil {
+		_ = json.Unmarshal(b, &spec)
+	}
+	return spec
+}
+
+// copyPrompts copies a job's prompt overrides into dst
+func copyPrompts(src, dst string) error {
+	files, _ := filepath.Glob(filepath.Join(src, novel.PromptDir, "*.tmpl"))
+	if len(files) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(dst, novel.PromptDir), 0o755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dst, novel.PromptDir, filepath.Base(f)), b, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
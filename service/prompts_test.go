@@ -0,0 +1,66 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+func TestSavePrompt(t *testing.T) {
+	cases := []struct {
+		name string
+		busy bool
+		body string
+		err  func(error) bool
+	}{
+		{"saved", false, `{{define "user"}}{{template "states" .}}{{end}}`, nil},
+		{"invalid body", false, `{{define "user"}}{{.Plan}{{end}}`, isValidation},
+		{"job planning", true, `{{define "user"}}write{{end}}`, func(err error) bool { return errors.Is(err, ErrJobBusy) }},
+	}
+	for _, c := range cases {
+		cfg, id := chapterJob(t, "a")
+		base := filepath.Join(cfg.Output.Dir, "jobs", id)
+		if err := persistJobSpec(base, novel.Spec{Topic: "书", Language: novel.LangEnglish}); err != nil {
+			t.Fatal(err)
+		}
+		m := NewManager()
+		if c.busy {
+			m.startPlanning(id)
+		}
+		p, err := m.SavePrompt(cfg, id, "chapter", c.body)
+		saved := fileExists(filepath.Join(base, novel.PromptDir, "chapter.tmpl"))
+		if c.err != nil {
+			if !c.err(err) || saved {
+				t.Errorf("%s: err = %v, saved = %v", c.name, err, saved)
+			}
+			continue
+		}
+		if err != nil || p.Origin != novel.PromptJob || !saved {
+			t.Errorf("%s: %+v, %v", c.name, p, err)
+		}
+	}
+}
+
+func TestDeletePrompt(t *testing.T) {
+	cfg, id := chapterJob(t, "a")
+	base := filepath.Join(cfg.Output.Dir, "jobs", id)
+	m := NewManager()
+	if _, err := m.SavePrompt(cfg, id, "chapter", `{{define "user"}}写{{end}}`); err != nil {
+		t.Fatal(err)
+	}
+	m.startPlanning(id)
+	if _, err := m.DeletePrompt(cfg, id, "chapter"); !errors.Is(err, ErrJobBusy) {
+		t.Fatalf("busy job: err = %v", err)
+	}
+	m.endPlanning(id)
+	p, err := m.DeletePrompt(cfg, id, "chapter")
+	if err != nil || p.Origin == novel.PromptJob {
+		t.Fatalf("deleted override: %+v, %v", p, err)
+	}
+	if _, err := os.Stat(filepath.Join(base, novel.PromptDir, "chapter.tmpl")); !os.IsNotExist(err) {
+		t.Fatalf("override left behind: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobPaused    JobStatus = "paused"
+	JobDone      JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+
+	JobAwaitingApproval JobStatus = "awaiting_approval"
+)
+
+// transitions lists the allowed target states for every state; states
+// without an entry are terminal
+var transitions = map[JobStatus][]JobStatus{
+	JobPending: {JobQueued, JobFailed, JobCancelled},
+	JobQueued:  {JobRunning, JobFailed, JobCancelled},
+	JobRunning: {JobAwaitingApproval, JobPaused, JobDone, JobFailed, JobCancelled},
+	JobPaused:  {JobQueued, JobFailed, JobCancelled},
+
+	JobAwaitingApproval: {JobQueued, JobFailed, JobCancelled},
+}
+
+func (s JobStatus) Terminal() bool {
+	_, ok := transitions[s]
+	return !ok
+}
+
+func canTransition(from, to JobStatus) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+type Transition struct {
+	From   JobStatus `json:"from"`
+	To     JobStatus `json:"to"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason"`
+}
+
+type TransitionError struct {
+	From JobStatus
+	To   JobStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("invalid transition %s -> %s", e.From, e.To)
+}
+
+// state is the guarded status shared by jobs and chapter tasks; callers
+// hold the owner's lock
+type state struct {
+	status    JobStatus
+	updatedAt time.Time
+	history   []Transition
+}
+
+func newState(initial JobStatus, reason string) state {
+	now := time.Now()
+	return state{status: initial, updatedAt: now, history: []Transition{{To: initial, At: now, Reason: reason}}}
+}
+
+func (s *state) move(to JobStatus, reason string) error {
+	if !canTransition(s.status, to) {
+		return &TransitionError{From: s.status, To: to}
+	}
+	now := time.Now()
+	s.history = append(s.history, Transition{From: s.status, To: to, At: now, Reason: reason})
+	s.status = to
+	s.updatedAt = now
+	return nil
+}
+
+func (s *state) touch() {
+	s.updatedAt = time.Now()
+}
+
+func (s *state) historyCopy() []Transition {
+	out := make([]Transition, len(s.history))
+	copy(out, s.history)
+	return out
+}
@@ -0,0 +1,421 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+func TestJobTransitions(t *testing.T) {
+	j := newJob("job-1", 3)
+	steps := []JobStatus{JobQueued, JobRunning, JobDone}
+	for _, s := range steps {
+		if err := j.transition(s, "step"); err != nil {
+			t.Fatalf("transition to %s: %v", s, err)
+		}
+	}
+	snap := j.Snapshot()
+	if snap.Status != JobDone {
+		t.Fatalf("status = %s, want %s", snap.Status, JobDone)
+	}
+	if len(snap.History) != len(steps)+1 {
+		t.Fatalf("history len = %d, want %d", len(snap.History), len(steps)+1)
+	}
+	if snap.History[0].To != JobPending || snap.History[0].From != "" {
+		t.Fatalf("first transition = %+v", snap.History[0])
+	}
+	for i := 1; i < len(snap.History); i++ {
+		if snap.History[i].From != snap.History[i-1].To {
+			t.Fatalf("history broken at %d: %+v", i, snap.History[i])
+		}
+		if snap.History[i].At.Before(snap.History[i-1].At) {
+			t.Fatalf("history out of order at %d", i)
+		}
+	}
+}
+
+func TestJobInvalidTransition(t *testing.T) {
+	cases := []struct {
+		path []JobStatus
+		to   JobStatus
+	}{
+		{nil, JobRunning},
+		{nil, JobDone},
+		{[]JobStatus{JobQueued}, JobDone},
+		{[]JobStatus{JobQueued, JobRunning, JobDone}, JobRunning},
+		{[]JobStatus{JobCancelled}, JobQueued},
+		{[]JobStatus{JobQueued, JobFailed}, JobDone},
+	}
+	for _, c := range cases {
+		j := newJob("job", 0)
+		for _, s := range c.path {
+			if err := j.transition(s, ""); err != nil {
+				t.Fatalf("setup %v: %v", c.path, err)
+			}
+		}
+		before := j.Snapshot()
+		err := j.transition(c.to, "")
+		var te *TransitionError
+		if !errors.As(err, &te) {
+			t.Fatalf("%v -> %s: err = %v, want TransitionError", c.path, c.to, err)
+		}
+		after := j.Snapshot()
+		if after.Status != before.Status || len(after.History) != len(before.History) {
+			t.Fatalf("%v -> %s: rejected transition changed state", c.path, c.to)
+		}
+	}
+}
+
+func TestJobFailureRecordsError(t *testing.T) {
+	j := newJob("job", 0)
+	_ = j.transition(JobQueued, "")
+	_ = j.transition(JobRunning, "")
+	if err := j.transition(JobFailed, "boom"); err != nil {
+		t.Fatal(err)
+	}
+	snap := j.Snapshot()
+	if snap.Error != "boom" || snap.History[len(snap.History)-1].Reason != "boom" {
+		t.Fatalf("error not recorded: %+v", snap)
+	}
+	if !snap.Status.Terminal() {
+		t.Fatalf("%s should be terminal", snap.Status)
+	}
+}
+
+func TestSnapshotIsImmutable(t *testing.T) {
+	j := newJob("job", 0)
+	_ = j.transition(JobQueued, "dispatched")
+	snap := j.Snapshot()
+	snap.History[0].Reason = "tampered"
+	snap.History = append(snap.History, Transition{To: JobDone})
+	again := j.Snapshot()
+	if again.History[0].Reason != "created" || len(again.History) != 2 {
+		t.Fatalf("snapshot aliases job state: %+v", again.History)
+	}
+}
+
+func TestManagerCancel(t *testing.T) {
+	m := NewManager()
+	j := newJob("job-c", 0)
+	m.jobs[j.ID] = j
+	_ = j.transition(JobQueued, "")
+	cancelled := false
+	j.setCancel(func() { cancelled = true })
+	if err := m.Cancel(j.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !cancelled || j.Status() != JobCancelled {
+		t.Fatalf("cancel not applied: status=%s called=%v", j.Status(), cancelled)
+	}
+	if err := m.Cancel(j.ID, ""); err == nil {
+		t.Fatal("second cancel should fail")
+	}
+}
+
+func TestConcurrentJobAccess(t *testing.T) {
+	j := newJob("job-race", 100)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = j.transition(JobQueued, "")
+		_ = j.transition(JobRunning, "")
+		for i := 0; i < 100; i++ {
+			j.setProgress(i, 100)
+		}
+		j.setWorkDir("work")
+		j.setLogPath("log")
+		_ = j.transition(JobDone, "")
+	}()
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				snap := j.Snapshot()
+				if snap.Completed > snap.Total {
+					t.Errorf("completed %d > total %d", snap.Completed, snap.Total)
+				}
+				_ = j.Status()
+			}
+		}()
+	}
+	wg.Wait()
+	if j.Status() != JobDone {
+		t.Fatalf("status = %s", j.Status())
+	}
+}
+
+func TestConcurrentChapterTaskAccess(t *testing.T) {
+	task := &ChapterTask{ID: "chap", JobID: "job", Chapter: 1, st: newState(ChapterPending, "created")}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_ = task.transition(ChapterQueued, "")
+		_ = task.transition(ChapterRunning, "")
+		_ = task.complete("chapters/01_x.md")
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			_ = task.Snapshot()
+		}
+	}()
+	wg.Wait()
+	snap := task.Snapshot()
+	if snap.Status != ChapterDone || snap.Path == "" {
+		t.Fatalf("unexpected task state: %+v", snap)
+	}
+}
+
+func TestJobAwaitApproval(t *testing.T) {
+	m := NewManager()
+	j := newJob("job-gate", 0)
+	m.jobs[j.ID] = j
+	_ = j.transition(JobQueued, "")
+	_ = j.transition(JobRunning, "")
+	if err := j.await("outline"); err != nil {
+		t.Fatal(err)
+	}
+	snap := j.Snapshot()
+	if snap.Status != JobAwaitingApproval || snap.Awaiting != "outline" {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+	var ve *ValidationError
+	if err := m.Approve(config.Config{}, j.ID, "plans"); !errors.As(err, &ve) {
+		t.Fatalf("approving the wrong stage: err = %v", err)
+	}
+	if j.Status() != JobAwaitingApproval {
+		t.Fatalf("wrong-stage approval changed status to %s", j.Status())
+	}
+	if _, err := m.StartChapterTask(config.Config{}, j, 1, 0, ""); !errors.Is(err, ErrAwaitingApproval) {
+		t.Fatalf("chapter task while awaiting: err = %v", err)
+	}
+	if err := m.Cancel(j.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJobPause(t *testing.T) {
+	m := NewManager()
+	j := newJob("job-pause", 0)
+	m.jobs[j.ID] = j
+	var te *TransitionError
+	if err := m.Pause(j.ID); !errors.As(err, &te) {
+		t.Fatalf("pausing a pending job: err = %v", err)
+	}
+	_ = j.transition(JobQueued, "")
+	_ = j.transition(JobRunning, "")
+	if err := m.Pause(j.ID); err != nil {
+		t.Fatal(err)
+	}
+	// the job keeps running until the current stage is persisted
+	if snap := j.Snapshot(); snap.Status != JobRunning || !snap.Pausing || !j.pauseRequested() {
+		t.Fatalf("pause request not recorded: %+v", snap)
+	}
+	if err := j.pause("characters"); err != nil {
+		t.Fatal(err)
+	}
+	snap := j.Snapshot()
+	if snap.Status != JobPaused || snap.Awaiting != "characters" || snap.Pausing {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+	if _, err := m.StartChapterTask(config.Config{}, j, 1, 0, ""); !errors.Is(err, ErrJobNotFinished) {
+		t.Fatalf("chapter task while paused: err = %v", err)
+	}
+	if err := m.Approve(config.Config{}, j.ID, "characters"); !errors.As(err, &te) {
+		t.Fatalf("approving a paused job: err = %v", err)
+	}
+	if !m.startPlanning(j.ID) {
+		t.Fatal("planning refused")
+	}
+	if err := m.Resume(config.Config{}, j.ID); !errors.Is(err, ErrJobBusy) {
+		t.Fatalf("resume while planning: err = %v", err)
+	}
+	m.endPlanning(j.ID)
+	stage, err := j.resume()
+	if err != nil || stage != "characters" {
+		t.Fatalf("resume = %q, %v", stage, err)
+	}
+	if snap := j.Snapshot(); snap.Status != JobQueued || snap.Awaiting != "" {
+		t.Fatalf("resumed job: %+v", snap)
+	}
+	if _, err := j.resume(); !errors.As(err, &te) {
+		t.Fatalf("resuming a queued job: err = %v", err)
+	}
+	if err := m.Pause("job-missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("pausing a missing job: err = %v", err)
+	}
+}
+
+func TestPlanningBlocksJob(t *testing.T) {
+	m := NewManager()
+	gate := newJob("job-plan-gate", 0)
+	done := newJob("job-plan-done", 0)
+	for _, j := range []*Job{gate, done} {
+		m.jobs[j.ID] = j
+		_ = j.transition(JobQueued, "")
+		_ = j.transition(JobRunning, "")
+	}
+	_ = gate.await("outline")
+	_ = done.transition(JobDone, "")
+	for _, j := range []*Job{gate, done} {
+		if !m.startPlanning(j.ID) {
+			t.Fatalf("%s: planning refused", j.ID)
+		}
+	}
+	if err := m.Approve(config.Config{}, gate.ID, "outline"); !errors.Is(err, ErrJobBusy) {
+		t.Fatalf("approve while planning: err = %v", err)
+	}
+	if gate.Status() != JobAwaitingApproval {
+		t.Fatalf("approve while planning changed status to %s", gate.Status())
+	}
+	if _, err := m.StartChapterTask(config.Config{}, done, 1, 0, ""); !errors.Is(err, ErrJobBusy) {
+		t.Fatalf("chapter task while planning: err = %v", err)
+	}
+	if m.startPlanning(done.ID) {
+		t.Fatal("a job was planned twice")
+	}
+	m.endPlanning(done.ID)
+	if m.busy(done.ID) {
+		t.Fatal("job still busy after planning ended")
+	}
+}
+
+func TestJobStateSurvivesRestart(t *testing.T) {
+	cfg := config.Config{}
+	cfg.Output.Dir = t.TempDir()
+	cases := []struct {
+		name     string
+		path     []JobStatus
+		gate     string
+		pause    string
+		want     JobStatus
+		awaiting string
+	}{
+		{"gate", []JobStatus{JobQueued, JobRunning}, "outline", "", JobAwaitingApproval, "outline"},
+		{"paused", []JobStatus{JobQueued, JobRunning}, "", "characters", JobPaused, "characters"},
+		{"failed", []JobStatus{JobQueued, JobRunning, JobFailed}, "", "", JobFailed, ""},
+		{"cancelled", []JobStatus{JobQueued, JobCancelled}, "", "", JobCancelled, ""},
+		{"interrupted", []JobStatus{JobQueued, JobRunning}, "", "", JobFailed, ""},
+		{"done", []JobStatus{JobQueued, JobRunning, JobDone}, "", "", JobDone, ""},
+	}
+	for _, c := range cases {
+		id := "job-" + c.name
+		dir := filepath.Join(cfg.Output.Dir, "jobs", id)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "outline.json"), []byte(`{"title":"t","chapters":[]}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		j := newJob(id, 0)
+		j.workDir = dir
+		for _, s := range c.path {
+			if err := j.transition(s, "step"); err != nil {
+				t.Fatalf("%s: transition to %s: %v", c.name, s, err)
+			}
+		}
+		if c.gate != "" {
+			if err := j.await(c.gate); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if c.pause != "" {
+			if err := j.pause(c.pause); err != nil {
+				t.Fatal(err)
+			}
+		}
+		restored, err := NewManager().LoadJobFromDisk(cfg, id)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		snap := restored.Snapshot()
+		if snap.Status != c.want || snap.Awaiting != c.awaiting {
+			t.Fatalf("%s: restored as %s awaiting %q, want %s awaiting %q", c.name, snap.Status, snap.Awaiting, c.want, c.awaiting)
+		}
+	}
+	// a fresh manager finds the gated job on disk when it is approved
+	m := NewManager()
+	var ve *ValidationError
+	if err := m.Approve(cfg, "job-gate", "plans"); !errors.As(err, &ve) {
+		t.Fatalf("approving the wrong stage after a restart: err = %v", err)
+	}
+	if j := m.Get("job-gate"); j == nil || j.Status() != JobAwaitingApproval {
+		t.Fatal("gated job not restored by approve")
+	}
+	var te *TransitionError
+	if err := m.Resume(cfg, "job-done"); !errors.As(err, &te) {
+		t.Fatalf("resuming a finished job after a restart: err = %v", err)
+	}
+}
+
+func TestStartRejectsInvalidSpec(t *testing.T) {
+	cfg := config.Config{}
+	cfg.Output.Dir = t.TempDir()
+	hot := 2.5
+	cases := []struct {
+		name string
+		spec novel.Spec
+	}{
+		{"unknown preset", novel.Spec{Topic: "t", Preset: "写一部悬疑小说"}},
+		{"unknown gate", novel.Spec{Topic: "t", Gates: []string{"nope"}}},
+		{"unknown language", novel.Spec{Topic: "t", Language: "xx"}},
+		{"temperature", novel.Spec{Topic: "t", Temperature: &hot}},
+	}
+	m := NewManager()
+	for _, c := range cases {
+		var ve *ValidationError
+		if _, err := m.Start(cfg, c.spec); !errors.As(err, &ve) {
+			t.Errorf("%s: err = %v, want a validation error", c.name, err)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Join(cfg.Output.Dir, "jobs")); len(entries) != 0 {
+		t.Fatalf("rejected specs left %d job dirs", len(entries))
+	}
+}
+
+func TestJobTemperature(t *testing.T) {
+	cfg := config.Config{}
+	cfg.OpenAI.Temperature = 0.9
+	cold, zero := 0.2, 0.0
+	cases := []struct {
+		name string
+		spec *float64
+		want float64
+	}{
+		{"config default", nil, 0.9},
+		{"job override", &cold, 0.2},
+		{"zero is an override", &zero, 0},
+	}
+	for _, c := range cases {
+		spec := mergeSpecDefaults(cfg, novel.Spec{Topic: "t", Temperature: c.spec})
+		if spec.Temperature == nil || *spec.Temperature != c.want {
+			t.Errorf("%s: merged temperature = %v, want %v", c.name, spec.Temperature, c.want)
+			continue
+		}
+		// the spec is persisted and read back by chapter tasks and planners
+		dir := t.TempDir()
+		if err := persistJobSpec(dir, spec); err != nil {
+			t.Fatal(err)
+		}
+		// a later change to the config does not move the job
+		later := cfg
+		later.OpenAI.Temperature = 1.5
+		if got := newClient(later, jobSpec(dir)).Temperature(); got != c.want {
+			t.Errorf("%s: client temperature = %v, want %v", c.name, got, c.want)
+		}
+		if got := loadJobSpec(later, dir, novel.Outline{}); got.Temperature == nil || *got.Temperature != c.want {
+			t.Errorf("%s: chapter spec temperature = %v, want %v", c.name, got.Temperature, c.want)
+		}
+	}
+	if got := newClient(cfg, novel.Spec{}).Temperature(); got != 0.9 {
+		t.Errorf("job without a spec samples at %v, want the config's 0.9", got)
+	}
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+// staleThreadChapters is how long a thread may stay open without a planned
+// payoff before it is flagged
+const staleThreadChapters = 30
+
+// finaleChapters is the stretch before the end of the plans in which every
+// open thread without a planned payoff is flagged
+const finaleChapters = 5
+
+// ThreadInfo is a plot thread with how long it has been open
+type ThreadInfo struct {
+	novel.PlotThread
+	// Age counts the chapters since the thread was opened, up to its
+	// payoff or the latest written chapter
+	Age int `json:"age"`
+	// PayoffIn is the next unwritten chapter whose plan pays the thread off
+	PayoffIn int  `json:"payoff_in,omitempty"`
+	Stale    bool `json:"stale"`
+}
+
+// ThreadReport is the plot thread ledger of a job measured against its
+// writing progress
+type ThreadReport struct {
+	Written int          `json:"written"`
+	Total   int          `json:"total"`
+	Open    int          `json:"open"`
+	Stale   int          `json:"stale"`
+	Threads []ThreadInfo `json:"threads"`
+}
+
+// Threads lists the open plot threads of a job, or every thread when all is
+// set. Open threads are stale when they have no payoff planned and are
+// either old or the book is close to its end
+func (m *Manager) Threads(cfg config.Config, id string, all bool) (ThreadReport, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return ThreadReport{}, err
+	}
+	ledger, err := novel.LoadThreads(base)
+	if err != nil {
+		return ThreadReport{}, err
+	}
+	rep := ThreadReport{Threads: []ThreadInfo{}}
+	for idx := range novel.ChapterFiles(filepath.Join(base, "chapters")) {
+		if idx > rep.Written {
+			rep.Written = idx
+		}
+	}
+	var plans []novel.Chapter
+	if outline, _, p, err := loadArtifacts(base); err == nil {
+		plans = p
+		rep.Total = len(plans)
+		if len(outline.Volumes) > 0 {
+			rep.Total = outline.TotalChapters()
+		}
+	}
+	for _, t := range ledger.Threads {
+		info := ThreadInfo{PlotThread: t}
+		if t.Resolved > 0 {
+			info.Age = t.Resolved - t.Opened
+			if all {
+				rep.Threads = append(rep.Threads, info)
+			}
+			continue
+		}
+		rep.Open++
+		if rep.Written > t.Opened {
+			info.Age = rep.Written - t.Opened
+		}
+		for _, p := range plans {
+			if p.Index > rep.Written && hasPayoff(p, t.ID) && (info.PayoffIn == 0 || p.Index < info.PayoffIn) {
+				info.PayoffIn = p.Index
+			}
+		}
+		if info.PayoffIn == 0 && (info.Age >= staleThreadChapters || (rep.Total > 0 && rep.Total-rep.Written <= finaleChapters)) {
+			info.Stale = true
+			rep.Stale++
+		}
+		rep.Threads = append(rep.Threads, info)
+	}
+	return rep, nil
+}
+
+func hasPayoff(p novel.Chapter, id string) bool {
+	for _, x := range p.Payoffs {
+		if strings.EqualFold(strings.TrimSpace(x), id) {
+			return true
+		}
+	}
+	return false
+}
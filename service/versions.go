@@ -0,0 +1,191 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+// ChapterVersionDoc is the stored text of one chapter version
+type ChapterVersionDoc struct {
+	novel.ChapterVersion
+	Chapter int    `json:"chapter"`
+	Current bool   `json:"current"`
+	Content string `json:"content"`
+}
+
+// ChapterDiff compares two versions of a chapter
+type ChapterDiff struct {
+	Chapter    int                   `json:"chapter"`
+	From       int                   `json:"from"`
+	To         int                   `json:"to"`
+	OldTitle   string                `json:"old_title"`
+	NewTitle   string                `json:"new_title"`
+	Stats      novel.DiffStats       `json:"stats"`
+	Paragraphs []novel.ParagraphDiff `json:"paragraphs"`
+}
+
+// ChapterHistory lists the stored versions of a chapter; a chapter written
+// before versioning existed has an empty list
+func (m *Manager) ChapterHistory(cfg config.Config, id string, index int) (novel.ChapterHistory, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return novel.ChapterHistory{}, err
+	}
+	h, err := novel.ReadChapterHistory(base, index)
+	if err != nil {
+		return h, err
+	}
+	if len(h.Versions) == 0 {
+		if _, ok := novel.FindChapterFile(filepath.Join(base, "chapters"), index); !ok {
+			return h, os.ErrNotExist
+		}
+		h.Versions = []novel.ChapterVersion{}
+	}
+	return h, nil
+}
+
+func (m *Manager) ChapterVersion(cfg config.Config, id string, index, version int) (ChapterVersionDoc, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return ChapterVersionDoc{}, err
+	}
+	c, v, err := novel.ReadChapterVersion(base, index, version)
+	if err != nil {
+		return ChapterVersionDoc{}, err
+	}
+	h, _ := novel.ReadChapterHistory(base, index)
+	return ChapterVersionDoc{ChapterVersion: v, Chapter: index, Current: h.Current == version, Content: c.Content}, nil
+}
+
+// DiffChapter compares two versions of a chapter; to defaults to the current
+// version and from to the version stored before to
+func (m *Manager) DiffChapter(cfg config.Config, id string, index, from, to int) (ChapterDiff, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return ChapterDiff{}, err
+	}
+	h, err := novel.ReadChapterHistory(base, index)
+	if err != nil {
+		return ChapterDiff{}, err
+	}
+	if len(h.Versions) == 0 {
+		return ChapterDiff{}, os.ErrNotExist
+	}
+	if to == 0 {
+		to = h.Current
+	}
+	if from == 0 {
+		for _, v := range h.Versions {
+			if v.Version < to && v.Version > from {
+				from = v.Version
+			}
+		}
+		if from == 0 {
+			return ChapterDiff{}, invalidf("chapter %d has no version before %d", index, to)
+		}
+	}
+	a, _, err := novel.ReadChapterVersion(base, index, from)
+	if err != nil {
+		return ChapterDiff{}, err
+	}
+	b, _, err := novel.ReadChapterVersion(base, index, to)
+	if err != nil {
+		return ChapterDiff{}, err
+	}
+	d := ChapterDiff{Chapter: index, From: from, To: to, OldTitle: a.Title, NewTitle: b.Title}
+	d.Paragraphs, d.Stats = novel.DiffText(a.Content, b.Content)
+	return d, nil
+}
+
+// RollbackChapter makes an earlier version the current chapter text
+func (m *Manager) RollbackChapter(cfg config.Config, id string, index, version int) (novel.ChapterVersion, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return novel.ChapterVersion{}, err
+	}
+	m.artMu.Lock()
+	defer m.artMu.Unlock()
+	if m.busy(id) {
+		return novel.ChapterVersion{}, ErrJobBusy
+	}
+	v, err := novel.RollbackChapter(base, index, version)
+	if err != nil {
+		return v, err
+	}
+	mirrorChapter(cfg, base, index)
+	_ = refreshProgress(base)
+	return v, nil
+}
+
+// PutChapter stores a hand-edited chapter as a new manual version; ifMatch
+// must carry the ETag of the current chapter, or "*" to create it
+func (m *Manager) PutChapter(cfg config.Config, id string, index int, title, content, ifMatch string) (ChapterDoc, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return ChapterDoc{}, err
+	}
+	if ifMatch == "" {
+		return ChapterDoc{}, ErrPreconditionRequired
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return ChapterDoc{}, invalidf("chapter content is required")
+	}
+	m.artMu.Lock()
+	defer m.artMu.Unlock()
+	if m.busy(id) {
+		return ChapterDoc{}, ErrJobBusy
+	}
+	var cur []byte
+	if path, ok := novel.FindChapterFile(filepath.Join(base, "chapters"), index); ok {
+		if cur, err = os.ReadFile(path); err != nil {
+			return ChapterDoc{}, err
+		}
+	}
+	if ifMatch == "*" && cur != nil || ifMatch != "*" && (cur == nil || ifMatch != ETag(cur)) {
+		return ChapterDoc{}, ErrPreconditionFailed
+	}
+	if title = strings.TrimSpace(title); title == "" {
+		title, _ = novel.ParseChapterFile(cur)
+	}
+	if title == "" {
+		_, _, plans, _ := loadArtifacts(base)
+		for _, p := range plans {
+			if p.Index == index {
+				title = p.Title
+			}
+		}
+	}
+	if title == "" {
+		return ChapterDoc{}, invalidf("chapter title is required")
+	}
+	c := novel.ChapterContent{Index: index, Title: title, Content: content}
+	if _, err := novel.WriteChapter(base, c, novel.VersionManual, novel.Provenance{Stage: novel.StageChapter, Origin: novel.OriginEdited}); err != nil {
+		return ChapterDoc{}, err
+	}
+	mirrorChapter(cfg, base, index)
+	_ = refreshProgress(base)
+	return readChapterDoc(base, index)
+}
+
+// mirrorChapter copies the current chapter text into the final output dir
+func mirrorChapter(cfg config.Config, base string, index int) {
+	fd := finalDir(cfg, base)
+	if fd == "" || !fileExists(fd) {
+		return
+	}
+	path, ok := novel.FindChapterFile(filepath.Join(base, "chapters"), index)
+	if !ok {
+		return
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	title, body := novel.ParseChapterFile(b)
+	_, _, _ = novel.ReplaceChapterFile(fd, novel.ChapterContent{Index: index, Title: title, Content: body})
+}
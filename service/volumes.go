@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ibreez3/ai-reader/config"
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+// VolumeInfo is one volume of a long serial with its writing progress
+type VolumeInfo struct {
+	novel.Volume
+	Written int `json:"written"`
+}
+
+// ListVolumes reports the volumes of a job; flat outlines have none
+func (m *Manager) ListVolumes(cfg config.Config, id string) ([]VolumeInfo, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(base, "outline.json"))
+	if err != nil {
+		return nil, err
+	}
+	var outline novel.Outline
+	if err := json.Unmarshal(b, &outline); err != nil {
+		return nil, err
+	}
+	novel.SyncVolumes(&outline)
+	files := novel.ChapterFiles(filepath.Join(base, "chapters"))
+	out := make([]VolumeInfo, 0, len(outline.Volumes))
+	for _, v := range outline.Volumes {
+		info := VolumeInfo{Volume: v}
+		for idx := v.Start; idx <= v.End; idx++ {
+			if _, ok := files[idx]; ok {
+				info.Written++
+			}
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+// PlanVolume plans the chapter outline and chapter plans of one volume on
+// demand. Volumes are planned in order; the last planned volume may be
+// planned again as long as none of its chapters is written
+func (m *Manager) PlanVolume(cfg config.Config, id string, volume int) (novel.Outline, []novel.Chapter, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return novel.Outline{}, nil, err
+	}
+	if !m.startPlanning(id) {
+		return novel.Outline{}, nil, ErrJobBusy
+	}
+	defer m.endPlanning(id)
+	outline, _, plans, err := loadArtifacts(base)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return novel.Outline{}, nil, invalidf("job %s has no outline, characters and plans yet", id)
+		}
+		return novel.Outline{}, nil, err
+	}
+	novel.SyncVolumes(&outline)
+	if len(outline.Volumes) == 0 {
+		return novel.Outline{}, nil, invalidf("job %s has a flat outline without volumes", id)
+	}
+	if volume < 1 || volume > len(outline.Volumes) {
+		return novel.Outline{}, nil, invalidf("volume must be between 1 and %d", len(outline.Volumes))
+	}
+	if volume > 1 && !outline.Volumes[volume-2].Planned {
+		return novel.Outline{}, nil, invalidf("volume %d must be planned first", volume-1)
+	}
+	if volume < len(outline.Volumes) && outline.Volumes[volume].Planned {
+		return novel.Outline{}, nil, invalidf("volume %d is followed by planned volumes", volume)
+	}
+	files := novel.ChapterFiles(filepath.Join(base, "chapters"))
+	v := outline.Volumes[volume-1]
+	for idx := v.Start; idx <= v.End; idx++ {
+		if _, ok := files[idx]; ok {
+			return novel.Outline{}, nil, invalidf("volume %d already has written chapters", volume)
+		}
+	}
+
+	spec := loadJobSpec(cfg, base, outline)
+	gen, ctx, cancel := planner(cfg, id, base)
+	defer cancel()
+	outline, plans, err = gen.ExpandVolume(ctx, spec, outline, plans, volume)
+	if err != nil {
+		return novel.Outline{}, nil, err
+	}
+	if j := m.Get(id); j != nil {
+		j.setProgress(len(files), outline.TotalChapters())
+	}
+	_ = refreshProgress(base)
+	return outline, plans, nil
+}
+
+// loadJobSpec reads the spec a job was started with, falling back to the
+// server defaults for jobs that predate spec.json
+func loadJobSpec(cfg config.Config, base string, outline novel.Outline) novel.Spec {
+	spec := novel.Spec{Topic: outline.Title, Language: "zh", Model: cfg.OpenAI.Model}
+	if b, err := os.ReadFile(filepath.Join(base, "spec.json")); err == nil {
+		_ = json.Unmarshal(b, &spec)
+	}
+	return spec
+}
+
+// planner returns a generator that writes into the job dir and logs to
+// the job log, with the job timeout
+func planner(cfg config.Config, id, base string) (*novel.Generator, context.Context, context.CancelFunc) {
+	gen := novel.NewGenerator(newClient(cfg, jobSpec(base))).WithPersistDir(base).WithSeries(loadSeries(base))
+	gen.WithRequestPolicy(cfg.OpenAI.RequestTimeoutSec, cfg.OpenAI.MaxRetries, cfg.OpenAI.RetryBackoffMs)
+	if jl, err := NewJobLogger(cfg.Output.Dir, id); err == nil {
+		gen.WithLogger(jl.Log)
+	}
+	timeoutMin := cfg.Server.JobTimeoutMin
+	if timeoutMin <= 0 {
+		timeoutMin = 60
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutMin)*time.Minute)
+	return gen, ctx, cancel
+}
+
+// startPlanning claims a job for planning; chapter tasks and approvals
+// check for it under artMu so they cannot start alongside
+func (m *Manager) startPlanning(id string) bool {
+	m.artMu.Lock()
+	defer m.artMu.Unlock()
+	if m.busy(id) {
+		return false
+	}
+	m.planMu.Lock()
+	defer m.planMu.Unlock()
+	if m.planning[id] {
+		return false
+	}
+	m.planning[id] = true
+	return true
+}
+
+func (m *Manager) isPlanning(id string) bool {
+	m.planMu.Lock()
+	defer m.planMu.Unlock()
+	return m.planning[id]
+}
+
+func (m *Manager) endPlanning(id string) {
+	m.planMu.Lock()
+	delete(m.planning, id)
+	m.planMu.Unlock()
+}
+
+// maxExtendChapters caps one extension; longer runs are extended repeatedly
+const maxExtendChapters = 100
+
+// Extend appends count chapters to a job's outline and plans, continuing
+// from the latest chapters; chapters already planned or written are kept
+func (m *Manager) Extend(cfg config.Config, id string, count int, direction string) (novel.Outline, novel.Extension, error) {
+	if count < 1 || count > maxExtendChapters {
+		return novel.Outline{}, novel.Extension{}, invalidf("count must be between 1 and %d", maxExtendChapters)
+	}
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return novel.Outline{}, novel.Extension{}, err
+	}
+	if !m.startPlanning(id) {
+		return novel.Outline{}, novel.Extension{}, ErrJobBusy
+	}
+	defer m.endPlanning(id)
+	outline, _, plans, err := loadArtifacts(base)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return novel.Outline{}, novel.Extension{}, invalidf("job %s has no outline, characters and plans yet", id)
+		}
+		return novel.Outline{}, novel.Extension{}, err
+	}
+	novel.SyncVolumes(&outline)
+	for _, v := range outline.Volumes {
+		if !v.Planned {
+			return novel.Outline{}, novel.Extension{}, invalidf("volume %d is not planned yet; plan it before extending", v.Index)
+		}
+	}
+	files := novel.ChapterFiles(filepath.Join(base, "chapters"))
+	var latest *novel.ChapterContent
+	for idx, name := range files {
+		if latest != nil && idx < latest.Index {
+			continue
+		}
+		if b, err := os.ReadFile(filepath.Join(base, "chapters", name)); err == nil {
+			title, body := novel.ParseChapterFile(b)
+			latest = &novel.ChapterContent{Index: idx, Title: title, Content: body}
+		}
+	}
+
+	spec := loadJobSpec(cfg, base, outline)
+	gen, ctx, cancel := planner(cfg, id, base)
+	defer cancel()
+	outline, plans, ext, err := gen.ExtendOutline(ctx, spec, outline, plans, latest, count, direction)
+	if err != nil {
+		return novel.Outline{}, novel.Extension{}, err
+	}
+	total := len(plans)
+	if len(outline.Volumes) > 0 {
+		total = outline.TotalChapters()
+	}
+	if j := m.Get(id); j != nil {
+		j.setProgress(len(files), total)
+	}
+	_ = refreshProgress(base)
+	return outline, ext, nil
+}
+
+// PlanScenes splits the plan of one chapter into scenes and stores them in
+// plans.json, replacing scenes planned before; the chapter is written scene
+// by scene from then on
+func (m *Manager) PlanScenes(cfg config.Config, id string, index int) (novel.Chapter, error) {
+	base, err := m.jobDir(cfg, id)
+	if err != nil {
+		return novel.Chapter{}, err
+	}
+	if !m.startPlanning(id) {
+		return novel.Chapter{}, ErrJobBusy
+	}
+	defer m.endPlanning(id)
+	outline, characters, plans, err := loadArtifacts(base)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return novel.Chapter{}, invalidf("job %s has no outline, characters and plans yet", id)
+		}
+		return novel.Chapter{}, err
+	}
+	var plan novel.Chapter
+	for _, p := range plans {
+		if p.Index == index {
+			plan = p
+		}
+	}
+	if plan.Index == 0 {
+		return novel.Chapter{}, os.ErrNotExist
+	}
+	spec := loadJobSpec(cfg, base, outline)
+	gen, ctx, cancel := planner(cfg, id, base)
+	defer cancel()
+	canon := novel.BuildCanon(spec, outline, characters, loadSettings(base)).WithSeries(loadSeries(base))
+	if plan.Scenes, err = gen.PlanScenes(ctx, spec, canon, plan); err != nil {
+		return novel.Chapter{}, err
+	}
+	return plan, nil
+}
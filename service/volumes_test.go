@@ -0,0 +1,187 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/ibreez3/ai-reader/novel"
+)
+
+// chatServer is an OpenAI-compatible endpoint answering chat completions
+// with replies in order; it counts the requests it served
+func chatServer(t *testing.T, replies ...string) (*httptest.Server, func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	served := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if served >= len(replies) {
+			http.Error(w, "no more replies", http.StatusBadRequest)
+			return
+		}
+		reply := replies[served]
+		served++
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": "chat", "object": "chat.completion", "model": "m",
+			"choices": []map[string]interface{}{{
+				"index": 0, "finish_reason": "stop",
+				"message": map[string]string{"role": "assistant", "content": reply},
+			}},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return served
+	}
+}
+
+func TestExtend(t *testing.T) {
+	extension := `{"threads":[],"chapters":[{"title":"c"},{"title":"d"}]}`
+	plans := `[{"title":"c"},{"title":"d"}]`
+	cases := []struct {
+		name    string
+		count   int
+		prepare func(t *testing.T, m *Manager, base string)
+		titles  []string
+		err     func(error) bool
+	}{
+		{"appends chapters", 2, nil, []string{"a", "b", "c", "d"}, nil},
+		{"count out of range", maxExtendChapters + 1, nil, nil, isValidation},
+		{"no outline yet", 2, func(t *testing.T, m *Manager, base string) {
+			if err := os.Remove(filepath.Join(base, "outline.json")); err != nil {
+				t.Fatal(err)
+			}
+		}, nil, isValidation},
+		{"unplanned volume", 2, func(t *testing.T, m *Manager, base string) {
+			o := novel.Outline{Title: "书", Volumes: []novel.Volume{{Chapters: 2}, {Chapters: 5}},
+				Chapters: []novel.Chapter{{Index: 1, Title: "a", Volume: 1}, {Index: 2, Title: "b", Volume: 1}}}
+			b, _ := json.Marshal(o)
+			if err := os.WriteFile(filepath.Join(base, "outline.json"), b, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}, nil, isValidation},
+		{"busy planning", 2, func(t *testing.T, m *Manager, base string) {
+			m.startPlanning("job-edit")
+		}, nil, func(err error) bool { return errors.Is(err, ErrJobBusy) }},
+	}
+	for _, c := range cases {
+		cfg, id := chapterJob(t, "a", "b")
+		base := filepath.Join(cfg.Output.Dir, "jobs", id)
+		if err := os.WriteFile(filepath.Join(base, "characters.json"), []byte("[]"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		srv, served := chatServer(t, extension, plans)
+		cfg.OpenAI.BaseURL, cfg.OpenAI.Model, cfg.OpenAI.MaxRetries = srv.URL, "m", 1
+		m := NewManager()
+		if c.prepare != nil {
+			c.prepare(t, m, base)
+		}
+		outline, ext, err := m.Extend(cfg, id, c.count, "")
+		if c.err != nil {
+			if !c.err(err) {
+				t.Errorf("%s: err = %v", c.name, err)
+			}
+			if served() > 0 {
+				t.Errorf("%s: rejected extension called the model", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		var titles []string
+		for _, ch := range outline.Chapters {
+			titles = append(titles, ch.Title)
+		}
+		if !reflect.DeepEqual(titles, c.titles) || len(ext.Plans) != c.count {
+			t.Errorf("%s: outline %v with %d new plans, want %v", c.name, titles, len(ext.Plans), c.titles)
+		}
+		if got := chapterState(t, cfg, id)["chapters"]; !reflect.DeepEqual(got, []string{"text a", "text b"}) {
+			t.Errorf("%s: written chapters changed to %v", c.name, got)
+		}
+		if m.isPlanning(id) {
+			t.Errorf("%s: job still marked planning", c.name)
+		}
+	}
+}
+
+func isValidation(err error) bool {
+	var ve *ValidationError
+	return errors.As(err, &ve)
+}
+
+func TestPlanVolume(t *testing.T) {
+	volumes := func(t *testing.T, base string) {
+		o := novel.Outline{Title: "书", Volumes: []novel.Volume{{Chapters: 2}, {Chapters: 3}},
+			Chapters: []novel.Chapter{{Index: 1, Title: "a", Volume: 1}, {Index: 2, Title: "b", Volume: 1}}}
+		b, _ := json.Marshal(o)
+		if err := os.WriteFile(filepath.Join(base, "outline.json"), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chapters := `[{"title":"c"},{"title":"d"},{"title":"e"}]`
+	cases := []struct {
+		name    string
+		volume  int
+		prepare func(t *testing.T, base string)
+		titles  []string
+		err     func(error) bool
+	}{
+		{"next volume", 2, volumes, []string{"a", "b", "c", "d", "e"}, nil},
+		{"flat outline", 1, nil, nil, isValidation},
+		{"out of range", 3, volumes, nil, isValidation},
+		{"volume already written", 1, volumes, nil, isValidation},
+	}
+	for _, c := range cases {
+		cfg, id := chapterJob(t, "a", "b")
+		base := filepath.Join(cfg.Output.Dir, "jobs", id)
+		if err := os.WriteFile(filepath.Join(base, "characters.json"), []byte("[]"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if c.prepare != nil {
+			c.prepare(t, base)
+		}
+		srv, served := chatServer(t, chapters, chapters)
+		cfg.OpenAI.BaseURL, cfg.OpenAI.Model, cfg.OpenAI.MaxRetries = srv.URL, "m", 1
+		m := NewManager()
+		outline, plans, err := m.PlanVolume(cfg, id, c.volume)
+		if c.err != nil {
+			if !c.err(err) {
+				t.Errorf("%s: err = %v", c.name, err)
+			}
+			if served() > 0 {
+				t.Errorf("%s: rejected plan called the model", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		var titles, planned []string
+		for _, ch := range outline.Chapters {
+			titles = append(titles, ch.Title)
+		}
+		for _, p := range plans {
+			planned = append(planned, p.Title)
+		}
+		if !reflect.DeepEqual(titles, c.titles) || !reflect.DeepEqual(planned, c.titles) {
+			t.Errorf("%s: outline %v, plans %v, want %v", c.name, titles, planned, c.titles)
+		}
+		if vols, err := m.ListVolumes(cfg, id); err != nil || len(vols) != 2 || !vols[1].Planned || vols[0].Written != 2 {
+			t.Errorf("%s: volumes = %+v, %v", c.name, vols, err)
+		}
+	}
+}